@@ -18,6 +18,7 @@ type Config struct {
 	Auth     AuthConfig     `mapstructure:"auth"`
 	Email    EmailConfig    `mapstructure:"email"`
 	External ExternalConfig `mapstructure:"external"`
+	Storage  StorageConfig  `mapstructure:"storage"`
 }
 
 type ServerConfig struct {
@@ -33,7 +34,8 @@ type DatabaseConfig struct {
 }
 
 type AuthConfig struct {
-	AdminPassword string `mapstructure:"admin_password"`
+	AdminPassword         string `mapstructure:"admin_password"`
+	ArtifactSigningSecret string `mapstructure:"artifact_signing_secret"` // 制品下载链接签名密钥，为空时使用管理员密码
 }
 
 type EmailConfig struct {
@@ -48,6 +50,11 @@ type ExternalConfig struct {
 	TestBlockingEnabled bool   `mapstructure:"test_blocking_enabled"`
 }
 
+type StorageConfig struct {
+	DownloadDir  string `mapstructure:"download_dir"`   // 包下载及缓存目录
+	CacheQuotaMB int64  `mapstructure:"cache_quota_mb"` // 包缓存磁盘配额
+}
+
 var AppConfig *Config
 
 func LoadConfig() {
@@ -63,6 +70,8 @@ func LoadConfig() {
 	viper.SetDefault("SQL_MAX_LIFETIME", 60)
 	viper.SetDefault("EMAIL_SEND_SERVER_PORT", 465)
 	viper.SetDefault("TEST_BLOCKING_ENABLED", false)
+	viper.SetDefault("DOWNLOAD_DIR", "/tmp/crat/packages")
+	viper.SetDefault("PACKAGE_CACHE_QUOTA_MB", 10240)
 
 	if err := viper.ReadInConfig(); err != nil {
 		log.Printf("Warning: Could not read config file: %v", err)
@@ -80,7 +89,8 @@ func LoadConfig() {
 			MaxLifetime: viper.GetInt("SQL_MAX_LIFETIME"),
 		},
 		Auth: AuthConfig{
-			AdminPassword: viper.GetString("ADMIN_PASSWD"),
+			AdminPassword:         viper.GetString("ADMIN_PASSWD"),
+			ArtifactSigningSecret: viper.GetString("ARTIFACT_SIGNING_SECRET"),
 		},
 		Email: EmailConfig{
 			Username: viper.GetString("EMAIL_SEND_USERNAME"),
@@ -92,6 +102,10 @@ func LoadConfig() {
 			TestServerURL:       viper.GetString("EXTERNAL_TEST_SERVER_URL"),
 			TestBlockingEnabled: viper.GetBool("TEST_BLOCKING_ENABLED"),
 		},
+		Storage: StorageConfig{
+			DownloadDir:  viper.GetString("DOWNLOAD_DIR"),
+			CacheQuotaMB: viper.GetInt64("PACKAGE_CACHE_QUOTA_MB"),
+		},
 	}
 
 	log.Println("Configuration loaded successfully")
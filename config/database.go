@@ -43,6 +43,15 @@ func InitDatabase() {
 		&models.ParameterSet{},
 		&models.DeployTestRun{},
 		&models.JobVersionSelection{}, // 新增的模型
+		&models.PackageCacheEntry{},
+		&models.JobArtifactSource{},
+		&models.TestServer{},
+		&models.DeployTestRunLog{},
+		&models.DeployTestStage{},
+		&models.RunGroup{},
+		&models.TriggerRule{},
+		&models.Schedule{},
+		&models.ScheduleFire{},
 	)
 	if err != nil {
 		log.Fatalf("Failed to migrate database: %v", err)
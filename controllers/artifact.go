@@ -0,0 +1,119 @@
+package controllers
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"crat/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ArtifactController struct {
+	deployTestService *services.DeployTestService
+}
+
+func NewArtifactController() *ArtifactController {
+	return &ArtifactController{
+		deployTestService: services.NewDeployTestService(),
+	}
+}
+
+// DownloadRunPackage 通过签名链接下载运行使用的包文件，支持 Range 和 HEAD 请求
+func (a *ArtifactController) DownloadRunPackage(c *gin.Context) {
+	runId, err := strconv.ParseUint(c.Param("deploy_run_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid deploy run ID"})
+		return
+	}
+
+	expires, err := strconv.ParseInt(c.Query("expires"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": services.ErrArtifactLinkInvalid.Error()})
+		return
+	}
+	if err := services.VerifyArtifactSignature(uint(runId), expires, c.Query("signature")); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+
+	run, err := a.deployTestService.GetRunPackage(uint(runId))
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrDeployTestRunNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrArtifactNotAvailable):
+			c.JSON(http.StatusGone, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	file, err := os.Open(run.DownloadPath)
+	if err != nil {
+		c.JSON(http.StatusGone, gin.H{"error": services.ErrArtifactNotAvailable.Error()})
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 校验和响应头，ETag 同时用于 If-None-Match / If-Range
+	if run.PackageSHA256 != "" {
+		c.Header("X-Checksum-Sha256", run.PackageSHA256)
+		if digest, err := hex.DecodeString(run.PackageSHA256); err == nil {
+			c.Header("Digest", "sha-256="+base64.StdEncoding.EncodeToString(digest))
+		}
+		c.Header("ETag", `"`+run.PackageSHA256+`"`)
+	}
+	fileName := filepath.Base(run.DownloadPath)
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": fileName}))
+
+	// ServeContent 处理 Range、HEAD 和条件请求
+	http.ServeContent(c.Writer, c.Request, fileName, info.ModTime(), file)
+}
+
+// CreateArtifactLink 为运行包生成签名下载链接，供开发人员下载实际测试的包
+func (a *ArtifactController) CreateArtifactLink(c *gin.Context) {
+	runId, err := strconv.ParseUint(c.Param("deploy_run_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid deploy run ID"})
+		return
+	}
+
+	// 请求体可选
+	var req struct {
+		TTLMinutes int `json:"ttl_minutes" binding:"min=0"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	link, err := a.deployTestService.IssueArtifactLink(uint(runId), req.TTLMinutes)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrDeployTestRunNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrArtifactNotAvailable):
+			c.JSON(http.StatusGone, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": link})
+}
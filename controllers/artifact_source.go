@@ -0,0 +1,94 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"crat/config"
+	"crat/models"
+	"crat/services"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type ArtifactSourceController struct{}
+
+func NewArtifactSourceController() *ArtifactSourceController {
+	return &ArtifactSourceController{}
+}
+
+// GetArtifactSources 获取各 Job 配置的制品来源，密钥以占位符显示
+func (a *ArtifactSourceController) GetArtifactSources(c *gin.Context) {
+	var sources []models.JobArtifactSource
+	if err := config.DB.Order("job_name ASC").Find(&sources).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	for i := range sources {
+		sources[i].Config = services.RedactArtifactSourceConfig(sources[i].Config)
+	}
+	c.JSON(http.StatusOK, gin.H{"data": sources})
+}
+
+// SetArtifactSource 设置 Job 的制品来源
+func (a *ArtifactSourceController) SetArtifactSource(c *gin.Context) {
+	jobName := c.Param("job_name")
+
+	var req struct {
+		Type   string          `json:"type" binding:"required"`
+		Config json.RawMessage `json:"config"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var source models.JobArtifactSource
+	err := config.DB.Where("job_name = ?", jobName).First(&source).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 提交占位符的密钥保留原值
+	if err == nil && source.Type == req.Type && len(req.Config) > 0 {
+		req.Config = services.MergeArtifactSourceSecrets(req.Config, source.Config)
+	}
+	if err := services.ValidateArtifactSource(req.Type, req.Config); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	source.JobName = jobName
+	source.Type = req.Type
+	source.Config = req.Config
+	if err := config.DB.Save(&source).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	source.Config = services.RedactArtifactSourceConfig(source.Config)
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Artifact source saved successfully",
+		"data":    source,
+	})
+}
+
+// DeleteArtifactSource 删除 Job 的制品来源，恢复使用 HTTP 目录
+func (a *ArtifactSourceController) DeleteArtifactSource(c *gin.Context) {
+	jobName := c.Param("job_name")
+
+	result := config.DB.Where("job_name = ?", jobName).Delete(&models.JobArtifactSource{})
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Artifact source not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Artifact source deleted successfully"})
+}
@@ -15,12 +15,14 @@ import (
 )
 
 type BuildInfoController struct {
-	buildService *services.BuildService
+	buildService       *services.BuildService
+	triggerRuleService *services.TriggerRuleService
 }
 
 func NewBuildInfoController() *BuildInfoController {
 	return &BuildInfoController{
-		buildService: services.NewBuildService(),
+		buildService:       services.NewBuildService(),
+		triggerRuleService: services.NewTriggerRuleService(),
 	}
 }
 
@@ -32,12 +34,20 @@ func (b *BuildInfoController) CreateBuildInfo(c *gin.Context) {
 		return
 	}
 
-	if err := b.buildService.ProcessJenkinsWebhook(data); err != nil {
+	buildInfo, err := b.buildService.ProcessJenkinsWebhook(data)
+	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Build info created successfully"})
+	// 匹配自动触发规则，运行在防抖时长后异步触发
+	matched := b.triggerRuleService.HandleBuild(buildInfo)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":       "Build info created successfully",
+		"build_info_id": buildInfo.ID,
+		"matched_rules": matched,
+	})
 }
 
 // GetBuildInfoList 获取构建信息列表
@@ -0,0 +1,123 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"crat/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// eventHeartbeatInterval 事件流的心跳间隔，防止代理断开空闲连接
+const eventHeartbeatInterval = 15 * time.Second
+
+type EventController struct{}
+
+func NewEventController() *EventController {
+	return &EventController{}
+}
+
+// StreamEvents 以 Server-Sent Events 推送运行状态、步骤和队列变化
+//
+// test_item_id、run_id、types 用于过滤事件，请求头 Last-Event-ID (或查询参数 last_event_id) 用于断线续传。
+func (e *EventController) StreamEvents(c *gin.Context) {
+	var filter services.EventFilter
+	if idStr := c.Query("test_item_id"); idStr != "" {
+		id, err := strconv.ParseUint(idStr, 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid test item ID"})
+			return
+		}
+		filter.TestItemID = uint(id)
+	}
+	if idStr := c.Query("run_id"); idStr != "" {
+		id, err := strconv.ParseUint(idStr, 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid deploy test run ID"})
+			return
+		}
+		filter.RunID = uint(id)
+	}
+	if typesStr := c.Query("types"); typesStr != "" {
+		for _, eventType := range strings.Split(typesStr, ",") {
+			eventType = strings.TrimSpace(eventType)
+			if !services.IsEventType(eventType) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid event type: %s", eventType)})
+				return
+			}
+			filter.Types = append(filter.Types, eventType)
+		}
+	}
+
+	lastEventIDStr := c.GetHeader("Last-Event-ID")
+	if lastEventIDStr == "" {
+		lastEventIDStr = c.Query("last_event_id")
+	}
+	var lastEventID uint64
+	if lastEventIDStr != "" {
+		id, err := strconv.ParseUint(lastEventIDStr, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Last-Event-ID"})
+			return
+		}
+		lastEventID = id
+	}
+
+	hub := services.Events()
+	sub, missed, resumed := hub.Subscribe(filter, lastEventID)
+	defer hub.Unsubscribe(sub)
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	// 无法续传时通知客户端重新获取完整状态
+	if !resumed {
+		writeEvent(c, services.Event{ID: hub.LastEventID(), Type: services.EventReset, Time: time.Now()})
+	}
+	for _, event := range missed {
+		writeEvent(c, event)
+	}
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(eventHeartbeatInterval)
+	defer heartbeat.Stop()
+	ctx := c.Request.Context()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-sub.C:
+			if !ok {
+				// 订阅因积压被断开，客户端重连后从 Last-Event-ID 续传
+				return
+			}
+			if writeEvent(c, event) != nil {
+				return
+			}
+			c.Writer.Flush()
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(c.Writer, ": keepalive\n\n"); err != nil {
+				return
+			}
+			c.Writer.Flush()
+		}
+	}
+}
+
+// writeEvent 按 SSE 格式写入一个事件
+func writeEvent(c *gin.Context, event services.Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(c.Writer, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+	return err
+}
@@ -0,0 +1,47 @@
+package controllers
+
+import (
+	"net/http"
+
+	"crat/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type PackageCacheController struct {
+	packageCache *services.PackageCacheService
+}
+
+func NewPackageCacheController() *PackageCacheController {
+	return &PackageCacheController{
+		packageCache: services.NewPackageCacheService(),
+	}
+}
+
+// GetPackageCache 获取包缓存使用情况
+func (p *PackageCacheController) GetPackageCache(c *gin.Context) {
+	usage, err := p.packageCache.Usage()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": usage})
+}
+
+// PurgePackageCache 清除所有未被运行使用的缓存包
+func (p *PackageCacheController) PurgePackageCache(c *gin.Context) {
+	removed, freed, err := p.packageCache.Purge()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Package cache purged successfully",
+		"data": gin.H{
+			"removed_count": removed,
+			"freed_bytes":   freed,
+		},
+	})
+}
@@ -1,11 +1,13 @@
 package controllers
 
 import (
+	"encoding/json"
 	"net/http"
 	"strconv"
 
 	"crat/config"
 	"crat/models"
+	"crat/services"
 
 	"github.com/gin-gonic/gin"
 )
@@ -19,9 +21,10 @@ func NewParameterSetController() *ParameterSetController {
 // CreateParameterSet 创建参数集
 func (p *ParameterSetController) CreateParameterSet(c *gin.Context) {
 	var req struct {
-		Name        string                   `json:"name" binding:"required"`
-		Description string                   `json:"description"`
-		Parameters  *models.TestParameters   `json:"parameters" binding:"required"`
+		Name            string                 `json:"name" binding:"required"`
+		Description     string                 `json:"description"`
+		Parameters      *models.TestParameters `json:"parameters" binding:"required"`
+		MonitorSettings json.RawMessage        `json:"monitor_settings"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -29,9 +32,15 @@ func (p *ParameterSetController) CreateParameterSet(c *gin.Context) {
 		return
 	}
 
+	if err := services.ValidateMonitorSettings(req.MonitorSettings); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	parameterSet := &models.ParameterSet{
-		Name:        req.Name,
-		Description: req.Description,
+		Name:            req.Name,
+		Description:     req.Description,
+		MonitorSettings: req.MonitorSettings,
 	}
 
 	if err := parameterSet.SetParameters(req.Parameters); err != nil {
@@ -91,9 +100,10 @@ func (p *ParameterSetController) UpdateParameterSet(c *gin.Context) {
 	}
 
 	var req struct {
-		Name        string                   `json:"name"`
-		Description string                   `json:"description"`
-		Parameters  *models.TestParameters   `json:"parameters"`
+		Name            string                 `json:"name"`
+		Description     string                 `json:"description"`
+		Parameters      *models.TestParameters `json:"parameters"`
+		MonitorSettings json.RawMessage        `json:"monitor_settings"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -121,6 +131,13 @@ func (p *ParameterSetController) UpdateParameterSet(c *gin.Context) {
 		}
 		updates["parameters"] = parameterSet.Parameters
 	}
+	if req.MonitorSettings != nil {
+		if err := services.ValidateMonitorSettings(req.MonitorSettings); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		updates["monitor_settings"] = req.MonitorSettings
+	}
 
 	if err := config.DB.Model(&parameterSet).Updates(updates).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -20,10 +20,8 @@ func (p *ProcessingCountController) GetProcessingCount(c *gin.Context) {
 	var count int64
 
 	// 统计处于运行中状态的测试数量
-	runningStates := []string{"PENDING", "DOWNLOADING", "DEPLOYING", "TESTING", "MONITORING"}
-
 	if err := config.DB.Model(&models.DeployTestRun{}).
-		Where("status IN ?", runningStates).
+		Where("status IN ?", models.ActiveDeployTestStatuses).
 		Count(&count).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
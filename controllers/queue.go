@@ -0,0 +1,158 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"crat/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type QueueController struct {
+	deployTestService *services.DeployTestService
+}
+
+func NewQueueController() *QueueController {
+	return &QueueController{
+		deployTestService: services.NewDeployTestService(),
+	}
+}
+
+// GetQueue 获取队列中的测试及实时位置和预计开始时间
+func (q *QueueController) GetQueue(c *gin.Context) {
+	queue, err := q.deployTestService.GetQueue()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": queue})
+}
+
+// MoveQueuedRun 调整排队测试的位置
+func (q *QueueController) MoveQueuedRun(c *gin.Context) {
+	runId, ok := parseQueueRunID(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Position int `json:"position" binding:"required,min=1"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	q.moveQueuedRun(c, runId, req.Position)
+}
+
+// MoveQueuedRunToFront 将排队测试移到队首
+func (q *QueueController) MoveQueuedRunToFront(c *gin.Context) {
+	runId, ok := parseQueueRunID(c)
+	if !ok {
+		return
+	}
+
+	q.moveQueuedRun(c, runId, 1)
+}
+
+func (q *QueueController) moveQueuedRun(c *gin.Context, runId uint, position int) {
+	if err := q.deployTestService.MoveQueuedRun(runId, position); err != nil {
+		respondQueueError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Queued run moved successfully"})
+}
+
+// RemoveQueuedRun 将测试移出队列
+func (q *QueueController) RemoveQueuedRun(c *gin.Context) {
+	runId, ok := parseQueueRunID(c)
+	if !ok {
+		return
+	}
+
+	userEmail, exists := c.Get("user_email")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+	isAdmin, _ := c.Get("is_admin")
+
+	deployTestRun, err := q.deployTestService.GetDeployTestRunByID(runId)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deploy test run not found"})
+		return
+	}
+
+	// 普通用户只能移除自己触发的测试
+	if admin, _ := isAdmin.(bool); !admin && deployTestRun.TriggeredBy != userEmail.(string) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the user who triggered this run or an admin can remove it"})
+		return
+	}
+
+	removedRun, err := q.deployTestService.RemoveQueuedRun(runId, userEmail.(string))
+	if err != nil {
+		respondQueueError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Queued run removed successfully",
+		"data":    removedRun,
+	})
+}
+
+// PauseQueue 暂停队列调度
+func (q *QueueController) PauseQueue(c *gin.Context) {
+	q.setQueuePaused(c, true)
+}
+
+// ResumeQueue 恢复队列调度
+func (q *QueueController) ResumeQueue(c *gin.Context) {
+	q.setQueuePaused(c, false)
+}
+
+func (q *QueueController) setQueuePaused(c *gin.Context, paused bool) {
+	userEmail, _ := c.Get("user_email")
+	changedBy, _ := userEmail.(string)
+
+	if err := q.deployTestService.SetQueuePaused(paused, changedBy); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	message := "Queue resumed successfully"
+	if paused {
+		message = "Queue paused successfully"
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": message,
+		"data":    gin.H{"paused": paused},
+	})
+}
+
+// parseQueueRunID 解析路径中的运行ID
+func parseQueueRunID(c *gin.Context) (uint, bool) {
+	runId, err := strconv.ParseUint(c.Param("deploy_run_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid deploy test run ID"})
+		return 0, false
+	}
+	return uint(runId), true
+}
+
+// respondQueueError 将队列操作的错误映射为HTTP状态码
+func respondQueueError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrDeployTestRunNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrDeployTestRunNotQueued), errors.Is(err, services.ErrDeployTestRunNotCancellable):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,204 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"crat/models"
+	"crat/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type RunGroupController struct {
+	deployTestService *services.DeployTestService
+}
+
+func NewRunGroupController() *RunGroupController {
+	return &RunGroupController{
+		deployTestService: services.NewDeployTestService(),
+	}
+}
+
+// parseRunGroupID 解析路径中的运行组ID，失败时写入 400 响应
+func parseRunGroupID(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid run group ID"})
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// CreateRunGroup 批量触发: 在同一构建上执行多个测试项 × 参数集的组合
+func (r *RunGroupController) CreateRunGroup(c *gin.Context) {
+	var req struct {
+		BuildInfoID     uint   `json:"build_info_id" binding:"required"`
+		TestItemIDs     []uint `json:"test_item_ids" binding:"required"`
+		ParameterSetIDs []uint `json:"parameter_set_ids"` // 为空时每个测试项使用关联的参数集或默认参数集
+		Priority        int    `json:"priority"`
+
+		// 覆盖测试项和参数集中的监控配置
+		MonitorSettings *models.MonitorSettings `json:"monitor_settings"`
+		// 全部运行结束后发送汇总通知，默认 true
+		NotificationEnabled *bool `json:"notification_enabled"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Priority < 0 || req.Priority > services.MaxQueuePriority {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("priority must be between 0 and %d", services.MaxQueuePriority)})
+		return
+	}
+
+	if err := r.deployTestService.ValidateMonitorOverrides(req.MonitorSettings); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userEmail, exists := c.Get("user_email")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	notificationEnabled := true
+	if req.NotificationEnabled != nil {
+		notificationEnabled = *req.NotificationEnabled
+	}
+
+	result, err := r.deployTestService.TriggerRunGroup(&services.RunGroupRequest{
+		BuildInfoID:         req.BuildInfoID,
+		TestItemIDs:         req.TestItemIDs,
+		ParameterSetIDs:     req.ParameterSetIDs,
+		TriggeredBy:         userEmail.(string),
+		Priority:            req.Priority,
+		Monitor:             req.MonitorSettings,
+		NotificationEnabled: notificationEnabled,
+	})
+	if err != nil {
+		if errors.Is(err, services.ErrRunGroupInvalid) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Run group triggered successfully",
+		"data": gin.H{
+			"run_group": result.Group,
+			"runs":      result.Runs,
+		},
+	})
+}
+
+// GetRunGroups 获取运行组列表
+func (r *RunGroupController) GetRunGroups(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if err != nil || limit <= 0 || limit > 100 {
+		limit = 20
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+
+	groups, total, err := r.deployTestService.GetRunGroups(limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":   groups,
+		"total":  total,
+		"limit":  limit,
+		"offset": offset,
+	})
+}
+
+// GetRunGroup 获取运行组详情及其所有运行
+func (r *RunGroupController) GetRunGroup(c *gin.Context) {
+	id, ok := parseRunGroupID(c)
+	if !ok {
+		return
+	}
+
+	group, err := r.deployTestService.GetRunGroupByID(id)
+	if err != nil {
+		if errors.Is(err, services.ErrRunGroupNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": group})
+}
+
+// CancelRunGroup 取消运行组中所有未结束的运行
+func (r *RunGroupController) CancelRunGroup(c *gin.Context) {
+	id, ok := parseRunGroupID(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	// 请求体可选
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userEmail, exists := c.Get("user_email")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+	isAdmin, _ := c.Get("is_admin")
+
+	group, err := r.deployTestService.GetRunGroupByID(id)
+	if err != nil {
+		if errors.Is(err, services.ErrRunGroupNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	// 普通用户只能取消自己触发的运行组
+	if admin, _ := isAdmin.(bool); !admin && group.TriggeredBy != userEmail.(string) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the user who triggered this run group or an admin can cancel it"})
+		return
+	}
+
+	cancelled, err := r.deployTestService.CancelRunGroup(id, userEmail.(string), req.Reason)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrRunGroupNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrDeployTestRunNotCancellable):
+			c.JSON(http.StatusConflict, gin.H{"error": "run group is already finished"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Run group cancelled successfully",
+		"data":    cancelled,
+	})
+}
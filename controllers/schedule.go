@@ -0,0 +1,302 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"crat/models"
+	"crat/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ScheduleController struct {
+	scheduleService *services.ScheduleService
+}
+
+func NewScheduleController() *ScheduleController {
+	return &ScheduleController{
+		scheduleService: services.NewScheduleService(),
+	}
+}
+
+// scheduleRequest 创建和更新定时运行的请求，未提供的字段保持不变
+type scheduleRequest struct {
+	Name                *string         `json:"name"`
+	CronExpression      *string         `json:"cron_expression"`
+	Timezone            *string         `json:"timezone"`
+	TestItemIDs         json.RawMessage `json:"test_item_ids"`
+	ParameterSetID      *uint           `json:"parameter_set_id"`
+	ClearParameterSet   bool            `json:"clear_parameter_set"` // 更新时清除参数集
+	Priority            *int            `json:"priority"`
+	NotificationEnabled *bool           `json:"notification_enabled"`
+	BuildSelector       *string         `json:"build_selector"`
+	JobName             *string         `json:"job_name"`
+	BuildInfoID         *uint           `json:"build_info_id"`
+	Owner               *string         `json:"owner"`
+	Paused              *bool           `json:"paused"`
+}
+
+// apply 把请求中提供的字段写入定时运行
+func (req *scheduleRequest) apply(schedule *models.Schedule) {
+	if req.Name != nil {
+		schedule.Name = strings.TrimSpace(*req.Name)
+	}
+	if req.CronExpression != nil {
+		schedule.CronExpression = strings.TrimSpace(*req.CronExpression)
+	}
+	if req.Timezone != nil {
+		schedule.Timezone = strings.TrimSpace(*req.Timezone)
+	}
+	if req.TestItemIDs != nil {
+		schedule.TestItemIDs = req.TestItemIDs
+	}
+	if req.ParameterSetID != nil {
+		schedule.ParameterSetID = req.ParameterSetID
+	} else if req.ClearParameterSet {
+		schedule.ParameterSetID = nil
+	}
+	if req.Priority != nil {
+		schedule.Priority = *req.Priority
+	}
+	if req.NotificationEnabled != nil {
+		schedule.NotificationEnabled = *req.NotificationEnabled
+	}
+	if req.BuildSelector != nil {
+		schedule.BuildSelector = *req.BuildSelector
+	}
+	if req.JobName != nil {
+		schedule.JobName = strings.TrimSpace(*req.JobName)
+	}
+	if req.BuildInfoID != nil {
+		schedule.BuildInfoID = req.BuildInfoID
+	}
+	if req.Owner != nil {
+		schedule.Owner = strings.TrimSpace(*req.Owner)
+	}
+	if req.Paused != nil {
+		schedule.Paused = *req.Paused
+	}
+}
+
+// parseScheduleID 解析路径中的定时运行ID，失败时写入 400 响应
+func parseScheduleID(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid schedule ID"})
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// respondScheduleError 写入定时运行相关的错误响应
+func respondScheduleError(c *gin.Context, err error) {
+	if errors.Is(err, services.ErrScheduleNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	} else {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// GetSchedules 获取定时运行列表
+func (s *ScheduleController) GetSchedules(c *gin.Context) {
+	schedules, err := s.scheduleService.GetSchedules()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": schedules})
+}
+
+// GetSchedule 获取定时运行
+func (s *ScheduleController) GetSchedule(c *gin.Context) {
+	id, ok := parseScheduleID(c)
+	if !ok {
+		return
+	}
+
+	schedule, err := s.scheduleService.GetScheduleByID(id)
+	if err != nil {
+		respondScheduleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": schedule})
+}
+
+// GetScheduleFires 获取定时运行的触发记录，包括错过的触发
+func (s *ScheduleController) GetScheduleFires(c *gin.Context) {
+	id, ok := parseScheduleID(c)
+	if !ok {
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if err != nil || limit <= 0 || limit > 100 {
+		limit = 20
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+
+	fires, total, err := s.scheduleService.GetScheduleFires(id, limit, offset)
+	if err != nil {
+		respondScheduleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":   fires,
+		"total":  total,
+		"limit":  limit,
+		"offset": offset,
+	})
+}
+
+// CreateSchedule 创建定时运行
+func (s *ScheduleController) CreateSchedule(c *gin.Context) {
+	var req scheduleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userEmail, _ := c.Get("user_email")
+	createdBy, _ := userEmail.(string)
+
+	// 新定时运行默认使用 Job 版本选择中选定的构建并发送汇总通知
+	schedule := models.Schedule{
+		BuildSelector:       models.ScheduleBuildSelected,
+		NotificationEnabled: true,
+		CreatedBy:           createdBy,
+	}
+	req.apply(&schedule)
+	if err := services.ValidateSchedule(&schedule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := s.scheduleService.SaveSchedule(&schedule); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Schedule created successfully",
+		"data":    schedule,
+	})
+}
+
+// UpdateSchedule 更新定时运行，下一次触发时间从当前时间重新计算
+func (s *ScheduleController) UpdateSchedule(c *gin.Context) {
+	id, ok := parseScheduleID(c)
+	if !ok {
+		return
+	}
+
+	var req scheduleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	schedule, err := s.scheduleService.GetScheduleByID(id)
+	if err != nil {
+		respondScheduleError(c, err)
+		return
+	}
+
+	req.apply(schedule)
+	if err := services.ValidateSchedule(schedule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := s.scheduleService.SaveSchedule(schedule); err != nil {
+		respondScheduleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Schedule updated successfully",
+		"data":    schedule,
+	})
+}
+
+// DeleteSchedule 删除定时运行
+func (s *ScheduleController) DeleteSchedule(c *gin.Context) {
+	id, ok := parseScheduleID(c)
+	if !ok {
+		return
+	}
+
+	if err := s.scheduleService.DeleteSchedule(id); err != nil {
+		respondScheduleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Schedule deleted successfully"})
+}
+
+// PauseSchedule 暂停定时运行
+func (s *ScheduleController) PauseSchedule(c *gin.Context) {
+	s.setPaused(c, true)
+}
+
+// ResumeSchedule 恢复定时运行
+func (s *ScheduleController) ResumeSchedule(c *gin.Context) {
+	s.setPaused(c, false)
+}
+
+func (s *ScheduleController) setPaused(c *gin.Context, paused bool) {
+	id, ok := parseScheduleID(c)
+	if !ok {
+		return
+	}
+
+	schedule, err := s.scheduleService.SetPaused(id, paused)
+	if err != nil {
+		respondScheduleError(c, err)
+		return
+	}
+
+	message := "Schedule resumed successfully"
+	if paused {
+		message = "Schedule paused successfully"
+	}
+	c.JSON(http.StatusOK, gin.H{"message": message, "data": schedule})
+}
+
+// RunSchedule 立即触发一次定时运行，运行归属当前管理员
+func (s *ScheduleController) RunSchedule(c *gin.Context) {
+	id, ok := parseScheduleID(c)
+	if !ok {
+		return
+	}
+
+	userEmail, exists := c.Get("user_email")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	fire, err := s.scheduleService.RunNow(id, userEmail.(string))
+	if err != nil {
+		respondScheduleError(c, err)
+		return
+	}
+	if fire.Status == models.ScheduleFireFailed {
+		c.JSON(http.StatusConflict, gin.H{"error": fire.Message, "data": fire})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Schedule triggered successfully",
+		"data":    fire,
+	})
+}
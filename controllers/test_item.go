@@ -1,8 +1,13 @@
 package controllers
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"strconv"
+	"time"
 
 	"crat/config"
 	"crat/models"
@@ -29,6 +34,39 @@ func (t *TestItemController) CreateTestItem(c *gin.Context) {
 		return
 	}
 
+	if err := services.ValidatePipeline(testItem.Pipeline); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := services.ValidateRetryPolicy(testItem.RetryPolicy); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := services.ValidateMonitorSettings(testItem.MonitorSettings); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := services.ValidatePackageSelection(testItem.PackageSelection); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := services.ValidatePackageInspection(testItem.PackageInspection); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := services.ValidateTestRunner(testItem.TestRunner); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := services.ValidateTestServerLabels(testItem.TestServerLabels); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := services.ValidateTestStages(testItem.TestStages); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	if err := config.DB.Create(&testItem).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -86,6 +124,34 @@ func (t *TestItemController) UpdateTestItem(c *gin.Context) {
 		return
 	}
 
+	// jsonb配置字段需要校验并序列化为JSON后再写入
+	jsonFields := map[string]func(json.RawMessage) error{
+		"pipeline":           services.ValidatePipeline,
+		"retry_policy":       services.ValidateRetryPolicy,
+		"monitor_settings":   services.ValidateMonitorSettings,
+		"package_selection":  services.ValidatePackageSelection,
+		"package_inspection": services.ValidatePackageInspection,
+		"test_runner":        services.ValidateTestRunner,
+		"test_server_labels": services.ValidateTestServerLabels,
+		"test_stages":        services.ValidateTestStages,
+	}
+	for field, validate := range jsonFields {
+		value, ok := updates[field]
+		if !ok {
+			continue
+		}
+		raw, err := json.Marshal(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + field + " format"})
+			return
+		}
+		if err := validate(raw); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		updates[field] = json.RawMessage(raw)
+	}
+
 	if err := config.DB.Model(&models.TestItem{}).Where("id = ?", id).Updates(updates).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -111,6 +177,51 @@ func (t *TestItemController) DeleteTestItem(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Test item deleted successfully"})
 }
 
+// GetPipelineSteps 获取可用于流水线配置的步骤列表
+func (t *TestItemController) GetPipelineSteps(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"data": services.AvailableSteps()})
+}
+
+// PreviewPackageSelection 预览测试项在指定构建中会选择的包文件
+func (t *TestItemController) PreviewPackageSelection(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid test item ID"})
+		return
+	}
+
+	var req struct {
+		BuildInfoID uint `json:"build_info_id" binding:"required"`
+		// 未保存的包选择规则，为空时使用测试项已保存的规则
+		PackageSelection json.RawMessage `json:"package_selection"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if len(req.PackageSelection) > 0 {
+		if err := services.ValidatePackageSelection(req.PackageSelection); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	result, err := t.deployTestService.PreviewPackageSelection(c.Request.Context(), uint(id), req.BuildInfoID, req.PackageSelection)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrTestItemNotFound), errors.Is(err, services.ErrBuildInfoNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": result})
+}
+
 // TriggerDeployTest 触发部署测试
 func (t *TestItemController) TriggerDeployTest(c *gin.Context) {
 	idStr := c.Param("id")
@@ -123,6 +234,10 @@ func (t *TestItemController) TriggerDeployTest(c *gin.Context) {
 	var req struct {
 		BuildInfoID    uint  `json:"build_info_id" binding:"required"`
 		ParameterSetID *uint `json:"parameter_set_id"`
+		Priority       int   `json:"priority"` // 排队优先级 0-100，数值越大越先执行
+
+		// 覆盖测试项和参数集中的监控配置
+		MonitorSettings *models.MonitorSettings `json:"monitor_settings"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -130,6 +245,16 @@ func (t *TestItemController) TriggerDeployTest(c *gin.Context) {
 		return
 	}
 
+	if req.Priority < 0 || req.Priority > services.MaxQueuePriority {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("priority must be between 0 and %d", services.MaxQueuePriority)})
+		return
+	}
+
+	if err := t.deployTestService.ValidateMonitorOverrides(req.MonitorSettings); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	// 获取当前用户邮箱
 	userEmail, exists := c.Get("user_email")
 	if !exists {
@@ -137,7 +262,14 @@ func (t *TestItemController) TriggerDeployTest(c *gin.Context) {
 		return
 	}
 
-	result, err := t.deployTestService.TriggerDeployTestWithBlocking(uint(id), req.BuildInfoID, userEmail.(string), req.ParameterSetID)
+	result, err := t.deployTestService.TriggerRun(&services.TriggerRequest{
+		TestItemID:     uint(id),
+		BuildInfoID:    req.BuildInfoID,
+		TriggeredBy:    userEmail.(string),
+		ParameterSetID: req.ParameterSetID,
+		Priority:       req.Priority,
+		Monitor:        req.MonitorSettings,
+	})
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -216,6 +348,210 @@ func (t *TestItemController) GetDeployTestRun(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"data": deployTestRun})
 }
 
+// runLogPageBytes 单次返回的日志上限
+const runLogPageBytes = 1 << 20
+
+// GetDeployTestRunLogs 获取部署测试运行的日志
+//
+// tail=N 返回最后 N 行，offset=N 从第 N 字节开始读取；follow=true 时以 text/plain 持续输出新日志直到运行结束。
+func (t *TestItemController) GetDeployTestRunLogs(c *gin.Context) {
+	runIdStr := c.Param("deploy_run_id")
+	runId, err := strconv.ParseUint(runIdStr, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid deploy test run ID"})
+		return
+	}
+
+	offset, err := strconv.ParseInt(c.DefaultQuery("offset", "0"), 10, 64)
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid offset"})
+		return
+	}
+	tail, err := strconv.Atoi(c.DefaultQuery("tail", "0"))
+	if err != nil || tail < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid tail"})
+		return
+	}
+	follow := c.Query("follow") == "true" || c.Query("follow") == "1"
+
+	logs, err := t.deployTestService.GetRunLogs(uint(runId), offset, tail, runLogPageBytes)
+	if err != nil {
+		if errors.Is(err, services.ErrDeployTestRunNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	if !follow {
+		c.JSON(http.StatusOK, gin.H{"data": logs})
+		return
+	}
+
+	c.Header("Content-Type", "text/plain; charset=utf-8")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Header("X-Log-Offset", strconv.FormatInt(logs.Offset, 10))
+	c.Status(http.StatusOK)
+
+	ctx := c.Request.Context()
+	for {
+		if logs.Content != "" {
+			if _, err := c.Writer.WriteString(logs.Content); err != nil {
+				return
+			}
+			c.Writer.Flush()
+		} else if logs.Complete {
+			return
+		} else {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Second):
+			}
+		}
+
+		logs, err = t.deployTestService.GetRunLogs(uint(runId), logs.NextOffset, 0, runLogPageBytes)
+		if err != nil {
+			return
+		}
+	}
+}
+
+// CancelDeployTestRun 取消运行中或排队中的部署测试
+func (t *TestItemController) CancelDeployTestRun(c *gin.Context) {
+	runIdStr := c.Param("deploy_run_id")
+	runId, err := strconv.ParseUint(runIdStr, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid deploy test run ID"})
+		return
+	}
+
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	// 请求体可选
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userEmail, exists := c.Get("user_email")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+	isAdmin, _ := c.Get("is_admin")
+
+	deployTestRun, err := t.deployTestService.GetDeployTestRunByID(uint(runId))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deploy test run not found"})
+		return
+	}
+
+	// 普通用户只能取消自己触发的测试
+	if admin, _ := isAdmin.(bool); !admin && deployTestRun.TriggeredBy != userEmail.(string) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the user who triggered this run or an admin can cancel it"})
+		return
+	}
+
+	cancelledRun, err := t.deployTestService.CancelDeployTestRun(uint(runId), userEmail.(string), req.Reason)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrDeployTestRunNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrDeployTestRunNotCancellable):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Deploy test run cancelled successfully",
+		"data":    cancelledRun,
+	})
+}
+
+// RerunDeployTestRun 使用相同配置重新执行已结束的部署测试
+func (t *TestItemController) RerunDeployTestRun(c *gin.Context) {
+	runIdStr := c.Param("deploy_run_id")
+	runId, err := strconv.ParseUint(runIdStr, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid deploy test run ID"})
+		return
+	}
+
+	userEmail, exists := c.Get("user_email")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	result, err := t.deployTestService.RerunDeployTestRun(uint(runId), userEmail.(string))
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrDeployTestRunNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrDeployTestRunInProgress):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Deploy test rerun triggered successfully",
+		"data": gin.H{
+			"queued":         result.Queued,
+			"queue_position": result.QueuePosition,
+			"run_id":         result.RunID,
+			"rerun_of_id":    runId,
+		},
+	})
+}
+
+// TestCallback 接收测试服务器推送的任务状态，请求头 X-Crat-Signature 为时间戳 X-Crat-Timestamp 和请求体的签名
+func (t *TestItemController) TestCallback(c *gin.Context) {
+	runIdStr := c.Param("deploy_run_id")
+	runId, err := strconv.ParseUint(runIdStr, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid deploy test run ID"})
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, 1<<20))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	delivered, err := t.deployTestService.HandleTestCallback(uint(runId), body, c.GetHeader("X-Crat-Timestamp"), c.GetHeader("X-Crat-Signature"))
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrCallbackSignatureInvalid):
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrDeployTestRunNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrCallbackInvalid):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrCallbackTaskMismatch):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":   "Callback accepted",
+		"delivered": delivered,
+	})
+}
+
 // ClearDeployTestHistory 清理部署测试历史
 func (t *TestItemController) ClearDeployTestHistory(c *gin.Context) {
 	idStr := c.Param("id")
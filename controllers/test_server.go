@@ -0,0 +1,163 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"crat/config"
+	"crat/models"
+	"crat/services"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type TestServerController struct {
+	testServerService *services.TestServerService
+}
+
+func NewTestServerController() *TestServerController {
+	return &TestServerController{
+		testServerService: services.NewTestServerService(),
+	}
+}
+
+// testServerRequest 创建和更新测试服务器的请求，未提供的字段保持不变
+type testServerRequest struct {
+	Name         *string         `json:"name"`
+	URL          *string         `json:"url"`
+	Labels       json.RawMessage `json:"labels"`
+	Capacity     *int            `json:"capacity"`
+	Enabled      *bool           `json:"enabled"`
+	Maintenance  *bool           `json:"maintenance"`
+	TransferMode *string         `json:"transfer_mode"`
+	HealthPath   *string         `json:"health_path"`
+}
+
+// apply 把请求中提供的字段写入服务器配置
+func (req *testServerRequest) apply(server *models.TestServer) {
+	if req.Name != nil {
+		server.Name = *req.Name
+	}
+	if req.URL != nil {
+		server.URL = services.NormalizeTestServerURL(*req.URL)
+	}
+	if req.Labels != nil {
+		server.Labels = req.Labels
+	}
+	if req.Capacity != nil {
+		server.Capacity = *req.Capacity
+	}
+	if req.Enabled != nil {
+		server.Enabled = *req.Enabled
+	}
+	if req.Maintenance != nil {
+		server.Maintenance = *req.Maintenance
+	}
+	if req.TransferMode != nil {
+		server.TransferMode = *req.TransferMode
+	}
+	if req.HealthPath != nil {
+		server.HealthPath = *req.HealthPath
+	}
+}
+
+// GetTestServers 获取测试服务器列表及各服务器执行中的测试数
+func (t *TestServerController) GetTestServers(c *gin.Context) {
+	servers, err := t.testServerService.List()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": servers})
+}
+
+// CreateTestServer 添加测试服务器
+func (t *TestServerController) CreateTestServer(c *gin.Context) {
+	var req testServerRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 新服务器默认启用，在首次健康检查前视为健康
+	server := models.TestServer{Capacity: 1, Enabled: true, Healthy: true}
+	req.apply(&server)
+	if err := services.ValidateTestServer(&server); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := config.DB.Create(&server).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Test server created successfully",
+		"data":    server,
+	})
+}
+
+// UpdateTestServer 更新测试服务器，可用于停用服务器或切换维护模式
+func (t *TestServerController) UpdateTestServer(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid test server ID"})
+		return
+	}
+
+	var req testServerRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var server models.TestServer
+	if err := config.DB.First(&server, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Test server not found"})
+		return
+	}
+
+	req.apply(&server)
+	if err := services.ValidateTestServer(&server); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := config.DB.Save(&server).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Test server updated successfully",
+		"data":    server,
+	})
+}
+
+// DeleteTestServer 删除测试服务器，服务器上仍有执行中的测试时拒绝删除
+func (t *TestServerController) DeleteTestServer(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid test server ID"})
+		return
+	}
+
+	if err := t.testServerService.Delete(uint(id)); err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Test server not found"})
+		case errors.Is(err, services.ErrTestServerInUse):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Test server deleted successfully"})
+}
@@ -0,0 +1,210 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"crat/models"
+	"crat/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type TriggerRuleController struct {
+	triggerRuleService *services.TriggerRuleService
+}
+
+func NewTriggerRuleController() *TriggerRuleController {
+	return &TriggerRuleController{
+		triggerRuleService: services.NewTriggerRuleService(),
+	}
+}
+
+// triggerRuleRequest 创建和更新自动触发规则的请求，未提供的字段保持不变
+type triggerRuleRequest struct {
+	Name                *string         `json:"name"`
+	Enabled             *bool           `json:"enabled"`
+	JobName             *string         `json:"job_name"`
+	RawDataMatch        json.RawMessage `json:"raw_data_match"`
+	PackagePathPattern  *string         `json:"package_path_pattern"`
+	TestItemIDs         json.RawMessage `json:"test_item_ids"`
+	ParameterSetIDs     json.RawMessage `json:"parameter_set_ids"`
+	Priority            *int            `json:"priority"`
+	NotificationEnabled *bool           `json:"notification_enabled"`
+	DebounceSeconds     *int            `json:"debounce_seconds"`
+	Owner               *string         `json:"owner"`
+}
+
+// apply 把请求中提供的字段写入规则
+func (req *triggerRuleRequest) apply(rule *models.TriggerRule) {
+	if req.Name != nil {
+		rule.Name = strings.TrimSpace(*req.Name)
+	}
+	if req.Enabled != nil {
+		rule.Enabled = *req.Enabled
+	}
+	if req.JobName != nil {
+		rule.JobName = strings.TrimSpace(*req.JobName)
+	}
+	if req.RawDataMatch != nil {
+		rule.RawDataMatch = req.RawDataMatch
+	}
+	if req.PackagePathPattern != nil {
+		rule.PackagePathPattern = *req.PackagePathPattern
+	}
+	if req.TestItemIDs != nil {
+		rule.TestItemIDs = req.TestItemIDs
+	}
+	if req.ParameterSetIDs != nil {
+		rule.ParameterSetIDs = req.ParameterSetIDs
+	}
+	if req.Priority != nil {
+		rule.Priority = *req.Priority
+	}
+	if req.NotificationEnabled != nil {
+		rule.NotificationEnabled = *req.NotificationEnabled
+	}
+	if req.DebounceSeconds != nil {
+		rule.DebounceSeconds = *req.DebounceSeconds
+	}
+	if req.Owner != nil {
+		rule.Owner = strings.TrimSpace(*req.Owner)
+	}
+}
+
+// parseTriggerRuleID 解析路径中的规则ID，失败时写入 400 响应
+func parseTriggerRuleID(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid trigger rule ID"})
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// GetTriggerRules 获取自动触发规则列表
+func (t *TriggerRuleController) GetTriggerRules(c *gin.Context) {
+	rules, err := t.triggerRuleService.GetTriggerRules()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": rules})
+}
+
+// GetTriggerRule 获取自动触发规则
+func (t *TriggerRuleController) GetTriggerRule(c *gin.Context) {
+	id, ok := parseTriggerRuleID(c)
+	if !ok {
+		return
+	}
+
+	rule, err := t.triggerRuleService.GetTriggerRuleByID(id)
+	if err != nil {
+		if errors.Is(err, services.ErrTriggerRuleNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": rule})
+}
+
+// CreateTriggerRule 创建自动触发规则
+func (t *TriggerRuleController) CreateTriggerRule(c *gin.Context) {
+	var req triggerRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userEmail, _ := c.Get("user_email")
+	createdBy, _ := userEmail.(string)
+
+	// 新规则默认启用并发送汇总通知
+	rule := models.TriggerRule{Enabled: true, NotificationEnabled: true, CreatedBy: createdBy}
+	req.apply(&rule)
+	if err := services.ValidateTriggerRule(&rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := t.triggerRuleService.SaveTriggerRule(&rule); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Trigger rule created successfully",
+		"data":    rule,
+	})
+}
+
+// UpdateTriggerRule 更新自动触发规则，可用于启用或停用规则
+func (t *TriggerRuleController) UpdateTriggerRule(c *gin.Context) {
+	id, ok := parseTriggerRuleID(c)
+	if !ok {
+		return
+	}
+
+	var req triggerRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	rule, err := t.triggerRuleService.GetTriggerRuleByID(id)
+	if err != nil {
+		if errors.Is(err, services.ErrTriggerRuleNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	req.apply(rule)
+	if err := services.ValidateTriggerRule(rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := t.triggerRuleService.SaveTriggerRule(rule); err != nil {
+		if errors.Is(err, services.ErrTriggerRuleNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Trigger rule updated successfully",
+		"data":    rule,
+	})
+}
+
+// DeleteTriggerRule 删除自动触发规则
+func (t *TriggerRuleController) DeleteTriggerRule(c *gin.Context) {
+	id, ok := parseTriggerRuleID(c)
+	if !ok {
+		return
+	}
+
+	if err := t.triggerRuleService.DeleteTriggerRule(id); err != nil {
+		if errors.Is(err, services.ErrTriggerRuleNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Trigger rule deleted successfully"})
+}
@@ -24,7 +24,15 @@ CREATE TABLE test_items (
     notification_enabled BOOLEAN DEFAULT false,
     created_at TIMESTAMPTZ DEFAULT NOW(),
     updated_at TIMESTAMPTZ DEFAULT NOW(),
-    associated_parameter_set_id BIGINT REFERENCES parameter_sets(id) ON DELETE SET NULL
+    associated_parameter_set_id BIGINT REFERENCES parameter_sets(id) ON DELETE SET NULL,
+    pipeline JSONB,
+    retry_policy JSONB,
+    monitor_settings JSONB,
+    package_selection JSONB,
+    package_inspection JSONB,
+    test_runner JSONB,
+    test_server_labels JSONB,
+    test_stages JSONB
 );
 
 -- 创建索引
@@ -65,6 +73,7 @@ CREATE TABLE parameter_sets (
     name VARCHAR(255) UNIQUE NOT NULL,
     description TEXT,
     parameters JSONB NOT NULL DEFAULT '{}',
+    monitor_settings JSONB,
     created_at TIMESTAMPTZ DEFAULT NOW(),
     updated_at TIMESTAMPTZ DEFAULT NOW()
 );
@@ -81,17 +90,40 @@ CREATE TABLE deploy_test_runs (
     status VARCHAR(50) DEFAULT 'PENDING',
     download_url TEXT,
     download_path TEXT,
+    package_sha256 VARCHAR(64),
+    cache_entry_id BIGINT,
+    package_version VARCHAR(255),
+    package_files JSONB,
+    test_server_id BIGINT,
+    test_server_url TEXT,
     task_id VARCHAR(255),
     report_url TEXT,
+    log_size BIGINT DEFAULT 0,
+    log_remote_offset BIGINT DEFAULT 0,
+    log_complete BOOLEAN DEFAULT false,
     steps JSONB DEFAULT '[]',
     max_query_hours INTEGER DEFAULT 3,
     query_interval INTEGER DEFAULT 60,
     query_timeout INTEGER DEFAULT 30,
+    download_timeout INTEGER DEFAULT 10,
     started_at TIMESTAMPTZ DEFAULT NOW(),
     finished_at TIMESTAMPTZ,
     error_message TEXT,
     response_raw_data JSONB,
-    parameter_set_id BIGINT REFERENCES parameter_sets(id) ON DELETE SET NULL
+    parameter_set_id BIGINT REFERENCES parameter_sets(id) ON DELETE SET NULL,
+    cancelled_by VARCHAR(255),
+    cancel_reason TEXT,
+    cancelled_at TIMESTAMPTZ,
+    rerun_of_id BIGINT REFERENCES deploy_test_runs(id) ON DELETE SET NULL,
+    attempt INTEGER DEFAULT 1,
+    failure_class VARCHAR(50),
+    next_retry_at TIMESTAMPTZ,
+    priority INTEGER DEFAULT 0,
+    environment VARCHAR(500),
+    queue_seq DOUBLE PRECISION DEFAULT 0,
+    queued_at TIMESTAMPTZ,
+    dispatched_at TIMESTAMPTZ,
+    run_group_id BIGINT
 );
 
 -- 创建索引
@@ -99,6 +131,11 @@ CREATE INDEX idx_deploy_test_runs_test_item_id ON deploy_test_runs(test_item_id)
 CREATE INDEX idx_deploy_test_runs_build_info_id ON deploy_test_runs(build_info_id);
 CREATE INDEX idx_deploy_test_runs_status ON deploy_test_runs(status);
 CREATE INDEX idx_deploy_test_runs_started_at ON deploy_test_runs(started_at DESC);
+CREATE INDEX idx_deploy_test_runs_rerun_of_id ON deploy_test_runs(rerun_of_id);
+CREATE INDEX idx_deploy_test_runs_priority ON deploy_test_runs(priority);
+CREATE INDEX idx_deploy_test_runs_environment ON deploy_test_runs(environment);
+CREATE INDEX idx_deploy_test_runs_test_server_id ON deploy_test_runs(test_server_id);
+CREATE INDEX idx_deploy_test_runs_run_group_id ON deploy_test_runs(run_group_id);
 
 -- 7. Job版本选择表
 CREATE TABLE IF NOT EXISTS job_version_selections (
@@ -113,6 +150,184 @@ CREATE TABLE IF NOT EXISTS job_version_selections (
 -- 创建索引
 CREATE INDEX IF NOT EXISTS idx_job_version_selections_job_name ON job_version_selections(job_name);
 
+-- 8. 包缓存表
+CREATE TABLE IF NOT EXISTS package_cache_entries (
+    id BIGSERIAL PRIMARY KEY,
+    cache_key VARCHAR(64) UNIQUE NOT NULL,
+    download_url TEXT NOT NULL,
+    file_name VARCHAR(500) NOT NULL,
+    path TEXT NOT NULL,
+    size BIGINT,
+    sha256 VARCHAR(64),
+    ref_count INTEGER DEFAULT 0,
+    stale BOOLEAN DEFAULT FALSE,
+    last_used_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+-- 创建索引
+CREATE INDEX IF NOT EXISTS idx_package_cache_entries_last_used_at ON package_cache_entries(last_used_at);
+
+-- 9. Job制品来源表
+CREATE TABLE IF NOT EXISTS job_artifact_sources (
+    id BIGSERIAL PRIMARY KEY,
+    job_name VARCHAR(255) UNIQUE NOT NULL,
+    type VARCHAR(50) NOT NULL,
+    config JSONB,
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    updated_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+-- 10. 测试服务器表
+CREATE TABLE IF NOT EXISTS test_servers (
+    id BIGSERIAL PRIMARY KEY,
+    name VARCHAR(255) UNIQUE NOT NULL,
+    url VARCHAR(500) UNIQUE NOT NULL,
+    labels JSONB,
+    capacity INTEGER NOT NULL DEFAULT 1,
+    enabled BOOLEAN NOT NULL DEFAULT true,
+    maintenance BOOLEAN NOT NULL DEFAULT false,
+    transfer_mode VARCHAR(50),
+    health_path TEXT,
+    healthy BOOLEAN NOT NULL DEFAULT true,
+    last_error TEXT,
+    checked_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    updated_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+-- 11. 部署测试运行日志表
+CREATE TABLE IF NOT EXISTS deploy_test_run_logs (
+    id BIGSERIAL PRIMARY KEY,
+    deploy_test_run_id BIGINT NOT NULL REFERENCES deploy_test_runs(id) ON DELETE CASCADE,
+    start_offset BIGINT NOT NULL,
+    content TEXT,
+    created_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+-- 创建索引
+CREATE INDEX IF NOT EXISTS idx_deploy_test_run_logs_run_start ON deploy_test_run_logs(deploy_test_run_id, start_offset);
+
+-- 12. 部署测试阶段表
+CREATE TABLE IF NOT EXISTS deploy_test_stages (
+    id BIGSERIAL PRIMARY KEY,
+    deploy_test_run_id BIGINT NOT NULL REFERENCES deploy_test_runs(id) ON DELETE CASCADE,
+    seq INTEGER NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    test_path VARCHAR(500),
+    status VARCHAR(50) NOT NULL, -- PENDING, RUNNING, COMPLETED, FAILED, SKIPPED, CANCELLED
+    task_id VARCHAR(255),
+    report_url TEXT,
+    summary JSONB,
+    error_message TEXT,
+    log_remote_offset BIGINT DEFAULT 0,
+    started_at TIMESTAMPTZ,
+    finished_at TIMESTAMPTZ
+);
+
+-- 创建索引
+CREATE INDEX IF NOT EXISTS idx_deploy_test_stages_deploy_test_run_id ON deploy_test_stages(deploy_test_run_id);
+
+-- 13. 运行组表
+CREATE TABLE IF NOT EXISTS run_groups (
+    id BIGSERIAL PRIMARY KEY,
+    build_info_id BIGINT NOT NULL REFERENCES build_info(id) ON DELETE CASCADE,
+    triggered_by VARCHAR(255) NOT NULL,
+    status VARCHAR(50) NOT NULL, -- RUNNING, COMPLETED, FAILED, CANCELLED
+    notification_enabled BOOLEAN NOT NULL DEFAULT true,
+    trigger_rule_id BIGINT, -- 由自动触发规则创建时记录规则
+    schedule_id BIGINT, -- 由定时运行创建时记录定时运行
+    total INTEGER DEFAULT 0,
+    active INTEGER DEFAULT 0,
+    completed INTEGER DEFAULT 0,
+    failed INTEGER DEFAULT 0,
+    cancelled INTEGER DEFAULT 0,
+    notified_at TIMESTAMPTZ,
+    finished_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    updated_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+-- 创建索引
+CREATE INDEX IF NOT EXISTS idx_run_groups_build_info_id ON run_groups(build_info_id);
+CREATE INDEX IF NOT EXISTS idx_run_groups_status ON run_groups(status);
+CREATE INDEX IF NOT EXISTS idx_run_groups_created_at ON run_groups(created_at);
+CREATE INDEX IF NOT EXISTS idx_run_groups_trigger_rule_id ON run_groups(trigger_rule_id);
+CREATE INDEX IF NOT EXISTS idx_run_groups_schedule_id ON run_groups(schedule_id);
+
+-- 14. 自动触发规则表
+CREATE TABLE IF NOT EXISTS trigger_rules (
+    id BIGSERIAL PRIMARY KEY,
+    name VARCHAR(255) UNIQUE NOT NULL,
+    enabled BOOLEAN NOT NULL DEFAULT true,
+    job_name VARCHAR(255), -- 完全匹配，为空时不限制
+    raw_data_match JSONB, -- 原始数据字段 -> 正则
+    package_path_pattern TEXT, -- 包路径正则
+    test_item_ids JSONB NOT NULL,
+    parameter_set_ids JSONB,
+    priority INTEGER DEFAULT 0,
+    notification_enabled BOOLEAN NOT NULL DEFAULT true,
+    debounce_seconds INTEGER DEFAULT 0,
+    owner VARCHAR(255), -- 运行的触发人邮箱，为空时使用构建用户邮箱
+    created_by VARCHAR(255),
+    pending_build_info_id BIGINT, -- 防抖等待中的构建
+    pending_fire_at TIMESTAMPTZ,
+    last_build_info_id BIGINT,
+    last_run_group_id BIGINT,
+    last_triggered_at TIMESTAMPTZ,
+    last_error TEXT,
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    updated_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+-- 创建索引
+CREATE INDEX IF NOT EXISTS idx_trigger_rules_job_name ON trigger_rules(job_name);
+
+-- 15. 定时运行表
+CREATE TABLE IF NOT EXISTS schedules (
+    id BIGSERIAL PRIMARY KEY,
+    name VARCHAR(255) UNIQUE NOT NULL,
+    cron_expression VARCHAR(255) NOT NULL, -- 分 时 日 月 星期
+    timezone VARCHAR(100), -- IANA 时区，为空时使用服务器时区
+    test_item_ids JSONB NOT NULL,
+    parameter_set_id BIGINT,
+    priority INTEGER DEFAULT 0,
+    notification_enabled BOOLEAN NOT NULL DEFAULT true,
+    build_selector VARCHAR(20) NOT NULL, -- selected, latest, fixed
+    job_name VARCHAR(255),
+    build_info_id BIGINT,
+    owner VARCHAR(255), -- 运行的触发人，为空时为创建人
+    created_by VARCHAR(255),
+    paused BOOLEAN NOT NULL DEFAULT false,
+    next_fire_at TIMESTAMPTZ,
+    last_fire_at TIMESTAMPTZ,
+    last_run_group_id BIGINT,
+    last_error TEXT,
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    updated_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+-- 创建索引
+CREATE INDEX IF NOT EXISTS idx_schedules_next_fire_at ON schedules(next_fire_at);
+
+-- 16. 定时运行触发记录表
+CREATE TABLE IF NOT EXISTS schedule_fires (
+    id BIGSERIAL PRIMARY KEY,
+    schedule_id BIGINT NOT NULL REFERENCES schedules(id) ON DELETE CASCADE,
+    scheduled_at TIMESTAMPTZ NOT NULL,
+    fired_at TIMESTAMPTZ NOT NULL,
+    status VARCHAR(20) NOT NULL, -- TRIGGERED, FAILED, MISSED
+    manual BOOLEAN NOT NULL DEFAULT false,
+    missed_count INTEGER DEFAULT 0,
+    build_info_id BIGINT,
+    run_group_id BIGINT,
+    triggered_by VARCHAR(255),
+    message TEXT
+);
+
+-- 创建索引
+CREATE INDEX IF NOT EXISTS idx_schedule_fires_schedule_id ON schedule_fires(schedule_id);
+
 -- 插入示例数据
 
 -- 示例构建信息
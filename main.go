@@ -6,6 +6,7 @@ import (
 	"crat/config"
 	"crat/middleware"
 	"crat/router"
+	"crat/services"
 
 	"github.com/gin-gonic/gin"
 )
@@ -18,6 +19,18 @@ func main() {
 	config.InitDatabase()
 	defer config.CloseDatabase()
 
+	// 恢复服务重启前未完成的部署测试
+	services.NewDeployTestService().RecoverInFlightRuns()
+
+	// 恢复重启前等待防抖的自动触发
+	services.NewTriggerRuleService().RecoverPendingTriggers()
+
+	// 启动测试服务器健康检查
+	services.NewTestServerService().StartHealthChecks()
+
+	// 启动定时运行调度，停机期间错过的触发记录为 MISSED
+	services.NewScheduleService().StartScheduler()
+
 	// 设置Gin模式
 	if !config.AppConfig.Server.Debug {
 		gin.SetMode(gin.ReleaseMode)
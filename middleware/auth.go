@@ -94,6 +94,21 @@ func AuthMiddleware() gin.HandlerFunc {
 	}
 }
 
+// EventStreamAuth 事件流的认证中间件
+//
+// 浏览器的 EventSource 无法设置请求头，没有 Authorization 头时使用查询参数 token。
+func EventStreamAuth() gin.HandlerFunc {
+	auth := AuthMiddleware()
+	return func(c *gin.Context) {
+		if c.GetHeader("Authorization") == "" {
+			if token := c.Query("token"); token != "" {
+				c.Request.Header.Set("Authorization", "Bearer "+token)
+			}
+		}
+		auth(c)
+	}
+}
+
 // AdminRequired 需要管理员权限的中间件
 func AdminRequired() gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -19,15 +19,32 @@ type DeployTestRun struct {
 	ParameterSet   *ParameterSet `gorm:"foreignKey:ParameterSetID" json:"parameter_set,omitempty"`
 
 	// 状态字段
-	Status string `gorm:"default:PENDING;index" json:"status"` // PENDING, DOWNLOADING, DOWNLOADED, DEPLOYING, TESTING, MONITORING, COMPLETED, FAILED
+	Status string `gorm:"default:PENDING;index" json:"status"` // PENDING, DOWNLOADING, DOWNLOADED, DEPLOYING, TESTING, MONITORING, COMPLETED, FAILED, CANCELLED
 
 	// 下载相关
 	DownloadURL  string `json:"download_url"`
 	DownloadPath string `json:"download_path"`
+	// 下载文件的 sha256，校验通过后写入
+	PackageSHA256 string `json:"package_sha256,omitempty"`
+	// 使用中的包缓存，运行结束后释放
+	CacheEntryID *uint `gorm:"index" json:"cache_entry_id,omitempty"`
+	// 包内容检查结果: 版本文件中的版本号和包内文件列表
+	PackageVersion string          `json:"package_version,omitempty"`
+	PackageFiles   json.RawMessage `gorm:"type:jsonb" json:"package_files,omitempty"`
 
 	// 外部测试服务器相关
-	TaskID    string `json:"task_id"`    // 外部测试服务器返回的task_id
-	ReportURL string `json:"report_url"` // 最终的测试报告URL
+	TestServerID  *uint  `gorm:"index" json:"test_server_id,omitempty"` // 分配的测试服务器，未配置服务器池时为空
+	TestServerURL string `json:"test_server_url,omitempty"`             // 提交测试时使用的服务器地址
+	TaskID        string `json:"task_id"`                               // 外部测试服务器返回的task_id
+	ReportURL     string `json:"report_url"`                            // 最终的测试报告URL
+
+	// 测试日志，内容保存在 deploy_test_run_logs
+	LogSize         int64 `gorm:"default:0" json:"log_size"`                   // 已保存的日志字节数
+	LogRemoteOffset int64 `gorm:"default:0" json:"-"`                          // 下次向测试服务器请求日志的 offset
+	LogComplete     bool  `gorm:"default:false" json:"log_complete,omitempty"` // 日志已全部拉取
+
+	// 测试阶段，测试项配置了 test_stages 时部署完成后执行
+	Stages []DeployTestStage `gorm:"foreignKey:DeployTestRunID" json:"stages,omitempty"`
 
 	// 步骤详情 - JSON格式存储各个步骤的详细信息
 	Steps json.RawMessage `gorm:"type:jsonb" json:"steps,omitempty"`
@@ -36,9 +53,10 @@ type DeployTestRun struct {
 	ResponseRawData json.RawMessage `gorm:"type:jsonb" json:"response_raw_data,omitempty"`
 
 	// 配置信息
-	MaxQueryHours int `gorm:"default:3" json:"max_query_hours"` // 最大查询时间（小时）
-	QueryInterval int `gorm:"default:60" json:"query_interval"` // 查询间隔（秒）
-	QueryTimeout  int `gorm:"default:30" json:"query_timeout"`  // 查询超时（秒）
+	MaxQueryHours   int `gorm:"default:3" json:"max_query_hours"`   // 最大查询时间（小时）
+	QueryInterval   int `gorm:"default:60" json:"query_interval"`   // 查询间隔（秒）
+	QueryTimeout    int `gorm:"default:30" json:"query_timeout"`    // 查询超时（秒）
+	DownloadTimeout int `gorm:"default:10" json:"download_timeout"` // 包下载超时（分钟）
 
 	// 时间记录
 	StartedAt  time.Time  `gorm:"autoCreateTime;index" json:"started_at"`
@@ -46,6 +64,27 @@ type DeployTestRun struct {
 
 	// 错误信息
 	ErrorMessage string `json:"error_message"`
+
+	// 批量触发的运行组，自动重试沿用原运行的运行组
+	RunGroupID *uint `gorm:"index" json:"run_group_id,omitempty"`
+
+	// 重跑/重试信息
+	RerunOfID    *uint      `gorm:"index" json:"rerun_of_id"` // 重跑或自动重试的来源运行
+	Attempt      int        `gorm:"default:1" json:"attempt"` // 自动重试次数，从1开始
+	FailureClass string     `json:"failure_class,omitempty"`  // 失败分类，用于判断是否可重试
+	NextRetryAt  *time.Time `json:"next_retry_at,omitempty"`  // 计划自动重试的时间
+
+	// 取消信息
+	CancelledBy  string     `json:"cancelled_by,omitempty"`
+	CancelReason string     `json:"cancel_reason,omitempty"`
+	CancelledAt  *time.Time `json:"cancelled_at,omitempty"`
+
+	// 调度信息
+	Priority     int        `gorm:"default:0;index" json:"priority"`      // 优先级，数值越大越先执行
+	Environment  string     `gorm:"index" json:"environment,omitempty"`   // 目标环境，用于环境级并发限制
+	QueueSeq     float64    `gorm:"default:0" json:"queue_seq,omitempty"` // 同优先级内的排队顺序
+	QueuedAt     *time.Time `json:"queued_at,omitempty"`                  // 进入队列的时间
+	DispatchedAt *time.Time `json:"dispatched_at,omitempty"`              // 开始执行的时间
 }
 
 // TableName 指定表名
@@ -75,11 +114,45 @@ const (
 	DeployTestStatusCompleted      = "COMPLETED"
 	DeployTestStatusDeployComplete = "DEPLOY_COMPLETE"
 	DeployTestStatusFailed         = "FAILED"
+	DeployTestStatusCancelled      = "CANCELLED"
+	DeployTestStatusQueued         = "QUEUED"
 
 	// 步骤名称常量
 	StepDownload = "download"
+	StepInspect  = "inspect"
 	StepDeploy   = "deploy"
 	StepTest     = "test"
 	StepMonitor  = "monitor"
 	StepNotify   = "notify"
+
+	// 扩展步骤名称常量
+	StepHealthCheck = "health_check"
+	StepCleanup     = "cleanup"
+	StepCancel      = "cancel"
+	StepRecovery    = "recovery"
+)
+
+// ActiveDeployTestStatuses 执行中的状态 (不含排队中的运行)
+var ActiveDeployTestStatuses = []string{
+	DeployTestStatusPending,
+	DeployTestStatusDownloading,
+	DeployTestStatusDownloaded,
+	DeployTestStatusDeploying,
+	DeployTestStatusTesting,
+	DeployTestStatusMonitoring,
+}
+
+// 失败分类常量
+const (
+	FailureClassDownload              = "download"                // 包下载失败
+	FailureClassIntegrity             = "integrity"               // 包校验和或签名校验失败
+	FailureClassPackageContent        = "package_content"         // 包无法解压或缺少必需文件
+	FailureClassTestServerUnavailable = "test_server_unavailable" // 无法连接外部测试服务器
+	FailureClassTestServer5xx         = "test_server_5xx"         // 外部测试服务器返回5xx
+	FailureClassTrigger               = "trigger"                 // 触发外部测试的其他错误
+	FailureClassTestFailed            = "test_failed"             // 外部测试执行失败
+	FailureClassMonitorTimeout        = "monitor_timeout"         // 监控超时
+	FailureClassMonitor               = "monitor"                 // 监控过程的其他错误
+	FailureClassStep                  = "step"                    // 其他步骤失败
+	FailureClassInternal              = "internal"                // CRAT内部错误
 )
@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// DeployTestRunLog 从外部测试任务拉取的一段日志，按 StartOffset 顺序拼接即为运行的完整日志
+type DeployTestRunLog struct {
+	ID              uint           `gorm:"primaryKey" json:"id"`
+	DeployTestRunID uint           `gorm:"index:idx_deploy_test_run_logs_run_start,priority:1;not null" json:"deploy_test_run_id"`
+	DeployTestRun   *DeployTestRun `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+	StartOffset     int64          `gorm:"index:idx_deploy_test_run_logs_run_start,priority:2;not null" json:"start_offset"` // 本段在运行日志中的起始字节
+	Content         string         `gorm:"type:text" json:"content"`
+	CreatedAt       time.Time      `gorm:"autoCreateTime" json:"created_at"`
+}
+
+// TableName 指定表名
+func (DeployTestRunLog) TableName() string {
+	return "deploy_test_run_logs"
+}
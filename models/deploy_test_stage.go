@@ -0,0 +1,42 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// DeployTestStage 运行中的一个测试阶段，部署完成后在同一测试服务器上执行
+type DeployTestStage struct {
+	ID              uint            `gorm:"primaryKey" json:"id"`
+	DeployTestRunID uint            `gorm:"index;not null" json:"deploy_test_run_id"`
+	DeployTestRun   *DeployTestRun  `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+	Seq             int             `gorm:"not null" json:"seq"` // 配置中的顺序，从0开始
+	Name            string          `gorm:"not null" json:"name"`
+	TestPath        string          `json:"test_path"`
+	Status          string          `gorm:"not null" json:"status"` // PENDING, RUNNING, COMPLETED, FAILED, SKIPPED, CANCELLED
+	TaskID          string          `json:"task_id,omitempty"`
+	ReportURL       string          `json:"report_url,omitempty"`
+	Summary         json.RawMessage `gorm:"type:jsonb" json:"summary,omitempty"` // 测试服务器返回的结果摘要
+	ErrorMessage    string          `json:"error_message,omitempty"`
+	LogRemoteOffset int64           `gorm:"default:0" json:"-"` // 下次向测试服务器请求日志的 offset
+	StartedAt       *time.Time      `json:"started_at,omitempty"`
+	FinishedAt      *time.Time      `json:"finished_at,omitempty"`
+}
+
+// TableName 指定表名
+func (DeployTestStage) TableName() string {
+	return "deploy_test_stages"
+}
+
+// 测试阶段状态
+const (
+	StageStatusPending   = "PENDING"
+	StageStatusRunning   = "RUNNING"
+	StageStatusCompleted = "COMPLETED"
+	StageStatusFailed    = "FAILED"
+	StageStatusSkipped   = "SKIPPED"
+	StageStatusCancelled = "CANCELLED"
+)
+
+// StepStagePrefix 测试阶段在运行步骤中的名称前缀，例如 stage:smoke
+const StepStagePrefix = "stage:"
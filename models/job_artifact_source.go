@@ -0,0 +1,29 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// 制品来源类型
+const (
+	ArtifactSourceHTTP       = "http"       // 包下载基础URL + package_path 的 HTTP 目录 (默认)
+	ArtifactSourceJenkins    = "jenkins"    // Jenkins 构建归档的制品
+	ArtifactSourceFilesystem = "filesystem" // 本地或 NFS 挂载目录
+	ArtifactSourceS3         = "s3"         // S3 兼容的存储桶
+)
+
+// JobArtifactSource 指定 Jenkins Job 的包从哪里获取，未配置的 Job 使用 HTTP 目录
+type JobArtifactSource struct {
+	ID        uint            `gorm:"primaryKey" json:"id"`
+	JobName   string          `gorm:"uniqueIndex;not null" json:"job_name"`
+	Type      string          `gorm:"not null" json:"type"`
+	Config    json.RawMessage `gorm:"type:jsonb" json:"config,omitempty"` // 各类型的配置，见 services.ArtifactSource 的实现
+	CreatedAt time.Time       `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt time.Time       `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// TableName 指定表名
+func (JobArtifactSource) TableName() string {
+	return "job_artifact_sources"
+}
@@ -0,0 +1,50 @@
+package models
+
+import "encoding/json"
+
+// MonitorSettings 运行的监控与下载时限配置，未设置的字段沿用上一级配置
+type MonitorSettings struct {
+	MaxQueryHours          *int `json:"max_query_hours,omitempty"`          // 最大查询时间（小时）
+	QueryInterval          *int `json:"query_interval,omitempty"`           // 查询间隔（秒）
+	QueryTimeout           *int `json:"query_timeout,omitempty"`            // 查询超时（秒）
+	DownloadTimeoutMinutes *int `json:"download_timeout_minutes,omitempty"` // 包下载超时（分钟）
+}
+
+// 监控配置默认值
+const (
+	DefaultMaxQueryHours          = 3
+	DefaultQueryInterval          = 60
+	DefaultQueryTimeout           = 30
+	DefaultDownloadTimeoutMinutes = 10
+)
+
+// ParseMonitorSettings 解析 jsonb 中存储的监控配置，未配置时返回nil
+func ParseMonitorSettings(raw json.RawMessage) (*MonitorSettings, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	var settings MonitorSettings
+	if err := json.Unmarshal(raw, &settings); err != nil {
+		return nil, err
+	}
+	return &settings, nil
+}
+
+// Merge 用 override 中已设置的字段覆盖当前配置
+func (m *MonitorSettings) Merge(override *MonitorSettings) {
+	if override == nil {
+		return
+	}
+	if override.MaxQueryHours != nil {
+		m.MaxQueryHours = override.MaxQueryHours
+	}
+	if override.QueryInterval != nil {
+		m.QueryInterval = override.QueryInterval
+	}
+	if override.QueryTimeout != nil {
+		m.QueryTimeout = override.QueryTimeout
+	}
+	if override.DownloadTimeoutMinutes != nil {
+		m.DownloadTimeoutMinutes = override.DownloadTimeoutMinutes
+	}
+}
@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+// PackageCacheEntry 共享包缓存中的一个文件
+type PackageCacheEntry struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	CacheKey    string    `gorm:"uniqueIndex;not null" json:"cache_key"` // 由下载URL和大小或校验和计算
+	DownloadURL string    `gorm:"not null" json:"download_url"`
+	FileName    string    `gorm:"not null" json:"file_name"`
+	Path        string    `gorm:"not null" json:"path"`
+	Size        int64     `json:"size"`
+	SHA256      string    `json:"sha256"`
+	RefCount    int       `gorm:"default:0" json:"ref_count"` // 正在使用该文件的运行数
+	Stale       bool      `gorm:"default:false" json:"stale"` // 已被重新下载的条目替换，最后一个引用释放时删除
+	LastUsedAt  time.Time `gorm:"index" json:"last_used_at"`
+	CreatedAt   time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
+
+// TableName 指定表名
+func (PackageCacheEntry) TableName() string {
+	return "package_cache_entries"
+}
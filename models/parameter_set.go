@@ -13,6 +13,9 @@ type ParameterSet struct {
 	Parameters  json.RawMessage `gorm:"type:jsonb;not null;default:'{}'" json:"parameters"`
 	CreatedAt   time.Time       `gorm:"autoCreateTime" json:"created_at"`
 	UpdatedAt   time.Time       `gorm:"autoUpdateTime" json:"updated_at"`
+
+	// 监控与下载时限配置，覆盖测试项中的配置
+	MonitorSettings json.RawMessage `gorm:"type:jsonb" json:"monitor_settings,omitempty"`
 }
 
 // TableName 指定表名
@@ -0,0 +1,42 @@
+package models
+
+import "time"
+
+// RunGroup 一次批量触发: 同一构建在多个测试项 × 参数集上的运行
+type RunGroup struct {
+	ID                  uint       `gorm:"primaryKey" json:"id"`
+	BuildInfoID         uint       `gorm:"index;not null" json:"build_info_id"`
+	BuildInfo           *BuildInfo `gorm:"foreignKey:BuildInfoID" json:"build_info,omitempty"`
+	TriggeredBy         string     `gorm:"not null" json:"triggered_by"`
+	Status              string     `gorm:"not null;index" json:"status"`           // RUNNING, COMPLETED, FAILED, CANCELLED
+	NotificationEnabled bool       `gorm:"not null" json:"notification_enabled"`   // 全部运行结束后发送一封汇总通知
+	TriggerRuleID       *uint      `gorm:"index" json:"trigger_rule_id,omitempty"` // 由自动触发规则创建时记录规则
+	ScheduleID          *uint      `gorm:"index" json:"schedule_id,omitempty"`     // 由定时运行创建时记录定时运行
+
+	// 按每个组合的最新一次运行统计，自动重试替换原运行
+	Total     int `gorm:"default:0" json:"total"`
+	Active    int `gorm:"default:0" json:"active"` // 排队、执行中或等待自动重试
+	Completed int `gorm:"default:0" json:"completed"`
+	Failed    int `gorm:"default:0" json:"failed"`
+	Cancelled int `gorm:"default:0" json:"cancelled"`
+
+	NotifiedAt *time.Time `json:"notified_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	CreatedAt  time.Time  `gorm:"autoCreateTime;index" json:"created_at"`
+	UpdatedAt  time.Time  `gorm:"autoUpdateTime" json:"updated_at"`
+
+	Runs []DeployTestRun `gorm:"foreignKey:RunGroupID" json:"runs,omitempty"`
+}
+
+// TableName 指定表名
+func (RunGroup) TableName() string {
+	return "run_groups"
+}
+
+// 运行组状态
+const (
+	RunGroupStatusRunning   = "RUNNING"
+	RunGroupStatusCompleted = "COMPLETED"
+	RunGroupStatusFailed    = "FAILED"
+	RunGroupStatusCancelled = "CANCELLED"
+)
@@ -0,0 +1,79 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Schedule 定时运行: 按 cron 表达式定期在选定的构建上触发测试项
+type Schedule struct {
+	ID             uint   `gorm:"primaryKey" json:"id"`
+	Name           string `gorm:"uniqueIndex;not null" json:"name"`
+	CronExpression string `gorm:"not null" json:"cron_expression"` // 分 时 日 月 星期，例如 "0 2 * * 1-5"
+	Timezone       string `json:"timezone,omitempty"`              // IANA 时区，例如 Asia/Shanghai，为空时使用服务器时区
+
+	// 触发内容
+	TestItemIDs         json.RawMessage `gorm:"type:jsonb" json:"test_item_ids"`
+	ParameterSetID      *uint           `json:"parameter_set_id,omitempty"` // 为空时每个测试项使用关联的参数集或默认参数集
+	Priority            int             `gorm:"default:0" json:"priority"`
+	NotificationEnabled bool            `gorm:"not null" json:"notification_enabled"`
+
+	// 构建选择
+	BuildSelector string `gorm:"not null" json:"build_selector"` // selected, latest, fixed
+	JobName       string `json:"job_name,omitempty"`             // selected 和 latest 使用
+	BuildInfoID   *uint  `json:"build_info_id,omitempty"`        // fixed 使用
+	Owner         string `json:"owner,omitempty"`                // 运行的触发人，为空时为创建人
+	CreatedBy     string `json:"created_by"`
+	Paused        bool   `gorm:"not null" json:"paused"` // 暂停的定时运行不再触发，恢复后从当前时间重新计算
+
+	NextFireAt     *time.Time `gorm:"index" json:"next_fire_at,omitempty"`
+	LastFireAt     *time.Time `json:"last_fire_at,omitempty"`
+	LastRunGroupID *uint      `json:"last_run_group_id,omitempty"`
+	LastError      string     `json:"last_error,omitempty"`
+	CreatedAt      time.Time  `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt      time.Time  `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// TableName 指定表名
+func (Schedule) TableName() string {
+	return "schedules"
+}
+
+// GetTestItemIDs 获取解析后的测试项ID列表
+func (s *Schedule) GetTestItemIDs() ([]uint, error) {
+	return parseIDList(s.TestItemIDs)
+}
+
+// 构建选择方式
+const (
+	ScheduleBuildSelected = "selected" // Job版本选择中当前选定的构建
+	ScheduleBuildLatest   = "latest"   // Job的最新构建
+	ScheduleBuildFixed    = "fixed"    // 固定构建
+)
+
+// ScheduleFire 定时运行的一次触发记录
+type ScheduleFire struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	ScheduleID  uint      `gorm:"index;not null" json:"schedule_id"`
+	ScheduledAt time.Time `gorm:"not null" json:"scheduled_at"` // 计划触发时间，错过的触发为第一次错过的时间
+	FiredAt     time.Time `gorm:"not null" json:"fired_at"`
+	Status      string    `gorm:"not null" json:"status"` // TRIGGERED, FAILED, MISSED
+	Manual      bool      `gorm:"not null" json:"manual"` // 管理员立即运行
+	MissedCount int       `gorm:"default:0" json:"missed_count,omitempty"`
+	BuildInfoID *uint     `json:"build_info_id,omitempty"`
+	RunGroupID  *uint     `json:"run_group_id,omitempty"`
+	TriggeredBy string    `json:"triggered_by,omitempty"`
+	Message     string    `json:"message,omitempty"`
+}
+
+// TableName 指定表名
+func (ScheduleFire) TableName() string {
+	return "schedule_fires"
+}
+
+// 触发记录状态
+const (
+	ScheduleFireTriggered = "TRIGGERED"
+	ScheduleFireFailed    = "FAILED"
+	ScheduleFireMissed    = "MISSED"
+)
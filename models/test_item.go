@@ -1,6 +1,7 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
 )
 
@@ -14,6 +15,30 @@ type TestItem struct {
 	CreatedAt                 time.Time `gorm:"autoCreateTime" json:"created_at"`
 	UpdatedAt                 time.Time `gorm:"autoUpdateTime" json:"updated_at"`
 
+	// 自定义步骤流水线 - 为空时使用默认流水线 (download, inspect, test, monitor, notify)
+	Pipeline json.RawMessage `gorm:"type:jsonb" json:"pipeline,omitempty"`
+
+	// 失败自动重试策略 - 为空时不自动重试
+	RetryPolicy json.RawMessage `gorm:"type:jsonb" json:"retry_policy,omitempty"`
+
+	// 监控与下载时限配置 - 参数集和触发请求中的配置优先
+	MonitorSettings json.RawMessage `gorm:"type:jsonb" json:"monitor_settings,omitempty"`
+
+	// 包文件选择规则 - 为空时按测试项名称匹配并优先选择 release 包
+	PackageSelection json.RawMessage `gorm:"type:jsonb" json:"package_selection,omitempty"`
+
+	// 包内容检查规则 - 为空时只查找 VERSION 文件，不检查必需文件
+	PackageInspection json.RawMessage `gorm:"type:jsonb" json:"package_inspection,omitempty"`
+
+	// 外部测试执行后端 - 为空时使用 HTTP 测试服务器协议
+	TestRunner json.RawMessage `gorm:"type:jsonb" json:"test_runner,omitempty"`
+
+	// 测试服务器需要具备的标签 - 为空时可使用任意测试服务器
+	TestServerLabels json.RawMessage `gorm:"type:jsonb" json:"test_server_labels,omitempty"`
+
+	// 测试阶段 - 为空时部署和测试在一个任务中完成，见 GetTestStages
+	TestStages json.RawMessage `gorm:"type:jsonb" json:"test_stages,omitempty"`
+
 	// 关联的部署测试执行历史
 	DeployTestRuns []DeployTestRun `gorm:"foreignKey:TestItemID" json:"deploy_test_runs,omitempty"`
 	// 关联的参数集
@@ -24,3 +49,225 @@ type TestItem struct {
 func (TestItem) TableName() string {
 	return "test_items"
 }
+
+// PipelineStepConfig 流水线中单个步骤的配置
+type PipelineStepConfig struct {
+	Name      string          `json:"name"`                 // 步骤名称，需为已注册的步骤
+	Options   json.RawMessage `json:"options,omitempty"`    // 步骤自定义选项
+	AlwaysRun bool            `json:"always_run,omitempty"` // 前序步骤失败时仍然执行 (如清理步骤)
+}
+
+// GetPipeline 获取解析后的流水线配置，未配置时返回nil
+func (t *TestItem) GetPipeline() ([]PipelineStepConfig, error) {
+	if len(t.Pipeline) == 0 || string(t.Pipeline) == "null" {
+		return nil, nil
+	}
+	var steps []PipelineStepConfig
+	if err := json.Unmarshal(t.Pipeline, &steps); err != nil {
+		return nil, err
+	}
+	return steps, nil
+}
+
+// RetryPolicy 失败自动重试策略
+type RetryPolicy struct {
+	MaxAttempts       int      `json:"max_attempts"`        // 最大执行次数 (包含首次执行)
+	BackoffSeconds    int      `json:"backoff_seconds"`     // 首次重试前的等待时间
+	BackoffMultiplier float64  `json:"backoff_multiplier"`  // 每次重试等待时间的倍数
+	MaxBackoffSeconds int      `json:"max_backoff_seconds"` // 等待时间上限，0表示不限制
+	RetryOn           []string `json:"retry_on"`            // 可重试的失败分类，为空时使用默认分类
+}
+
+// DefaultRetryableFailureClasses 默认可重试的失败分类 (瞬时错误)
+var DefaultRetryableFailureClasses = []string{
+	FailureClassDownload,
+	FailureClassTestServerUnavailable,
+	FailureClassTestServer5xx,
+}
+
+// GetRetryPolicy 获取解析后的重试策略，未配置时返回nil
+func (t *TestItem) GetRetryPolicy() (*RetryPolicy, error) {
+	if len(t.RetryPolicy) == 0 || string(t.RetryPolicy) == "null" {
+		return nil, nil
+	}
+	var policy RetryPolicy
+	if err := json.Unmarshal(t.RetryPolicy, &policy); err != nil {
+		return nil, err
+	}
+	if len(policy.RetryOn) == 0 {
+		policy.RetryOn = DefaultRetryableFailureClasses
+	}
+	if policy.BackoffMultiplier <= 0 {
+		policy.BackoffMultiplier = 1
+	}
+	return &policy, nil
+}
+
+// ShouldRetry 判断指定失败分类和已执行次数是否需要重试
+func (p *RetryPolicy) ShouldRetry(failureClass string, attempt int) bool {
+	if attempt >= p.MaxAttempts {
+		return false
+	}
+	for _, class := range p.RetryOn {
+		if class == failureClass {
+			return true
+		}
+	}
+	return false
+}
+
+// Backoff 计算第 attempt 次执行失败后的重试等待时间
+func (p *RetryPolicy) Backoff(attempt int) time.Duration {
+	backoff := float64(p.BackoffSeconds)
+	for i := 1; i < attempt; i++ {
+		backoff *= p.BackoffMultiplier
+	}
+	if p.MaxBackoffSeconds > 0 && backoff > float64(p.MaxBackoffSeconds) {
+		backoff = float64(p.MaxBackoffSeconds)
+	}
+	return time.Duration(backoff) * time.Second
+}
+
+// 包文件候选的优选策略
+const (
+	PackageTieBreakRelease = "release" // 优先选择文件名包含 release 的包，其次按目录顺序 (默认)
+	PackageTieBreakFirst   = "first"   // 按目录顺序选择第一个
+	PackageTieBreakNewest  = "newest"  // 选择修改时间最新的包
+	PackageTieBreakLargest = "largest" // 选择最大的包
+	PackageTieBreakExact   = "exact"   // 只选择去掉扩展名后与 exact_name 完全相同的包
+)
+
+// PackageSelectionRules 从构建目录中选择包文件的规则
+type PackageSelectionRules struct {
+	Include    []string `json:"include,omitempty"`    // 文件名需匹配其中任一正则，为空时匹配包含测试项名称的文件 (不区分大小写)
+	Exclude    []string `json:"exclude,omitempty"`    // 文件名匹配其中任一正则时排除
+	Extensions []string `json:"extensions,omitempty"` // 允许的扩展名，为空时为 .tar.gz 和 .tgz
+	TieBreak   string   `json:"tie_break,omitempty"`  // 多个候选时的优选策略，为空时为 release
+	ExactName  string   `json:"exact_name,omitempty"` // exact 策略比较的名称，为空时为测试项名称
+}
+
+// DefaultPackageExtensions 默认允许的包文件扩展名
+var DefaultPackageExtensions = []string{".tar.gz", ".tgz"}
+
+// GetPackageSelection 获取解析后的包选择规则，未配置的字段使用默认值
+func (t *TestItem) GetPackageSelection() (*PackageSelectionRules, error) {
+	rules := &PackageSelectionRules{}
+	if len(t.PackageSelection) > 0 && string(t.PackageSelection) != "null" {
+		if err := json.Unmarshal(t.PackageSelection, rules); err != nil {
+			return nil, err
+		}
+	}
+	if len(rules.Extensions) == 0 {
+		rules.Extensions = DefaultPackageExtensions
+	}
+	if rules.TieBreak == "" {
+		rules.TieBreak = PackageTieBreakRelease
+	}
+	if rules.ExactName == "" {
+		rules.ExactName = t.Name
+	}
+	return rules, nil
+}
+
+// PackageInspectionRules 部署前检查包内容的规则
+//
+// 匹配使用 path.Match 通配符，模式可以匹配条目的完整路径，也可以匹配去掉顶层目录后的路径。
+type PackageInspectionRules struct {
+	VersionFile     string   `json:"version_file,omitempty"`     // 版本文件的匹配模式，为空时为 VERSION
+	VersionRequired bool     `json:"version_required,omitempty"` // 找不到版本文件时检查失败
+	RequiredFiles   []string `json:"required_files,omitempty"`   // 包中必须存在的文件，每个模式至少匹配一个条目
+}
+
+// DefaultVersionFile 默认的版本文件匹配模式
+const DefaultVersionFile = "VERSION"
+
+// GetPackageInspection 获取解析后的包内容检查规则，未配置的字段使用默认值
+func (t *TestItem) GetPackageInspection() (*PackageInspectionRules, error) {
+	rules := &PackageInspectionRules{}
+	if len(t.PackageInspection) > 0 && string(t.PackageInspection) != "null" {
+		if err := json.Unmarshal(t.PackageInspection, rules); err != nil {
+			return nil, err
+		}
+	}
+	if rules.VersionFile == "" {
+		rules.VersionFile = DefaultVersionFile
+	}
+	return rules, nil
+}
+
+// 外部测试执行后端类型
+const (
+	TestRunnerHTTP = "http" // 测试服务器的 api/deploy_and_test 和 api/tasks 协议 (默认)
+	TestRunnerREST = "rest" // 通过字段路径映射对接任意 REST 接口
+	TestRunnerFake = "fake" // 内存中模拟的测试任务，用于调试流水线
+)
+
+// TestRunnerConfig 测试项使用的执行后端及其配置
+type TestRunnerConfig struct {
+	Type   string          `json:"type"`
+	Config json.RawMessage `json:"config,omitempty"` // 各类型的配置，见 services.TestRunner 的实现
+}
+
+// GetTestRunner 获取解析后的执行后端配置，未配置时为 http
+func (t *TestItem) GetTestRunner() (*TestRunnerConfig, error) {
+	runner := &TestRunnerConfig{}
+	if len(t.TestRunner) > 0 && string(t.TestRunner) != "null" {
+		if err := json.Unmarshal(t.TestRunner, runner); err != nil {
+			return nil, err
+		}
+	}
+	if runner.Type == "" {
+		runner.Type = TestRunnerHTTP
+	}
+	return runner, nil
+}
+
+// GetTestServerLabels 获取测试服务器需要具备的标签
+func (t *TestItem) GetTestServerLabels() ([]string, error) {
+	if len(t.TestServerLabels) == 0 || string(t.TestServerLabels) == "null" {
+		return nil, nil
+	}
+	var labels []string
+	if err := json.Unmarshal(t.TestServerLabels, &labels); err != nil {
+		return nil, err
+	}
+	return labels, nil
+}
+
+// 测试阶段的执行方式
+const (
+	TestStageModeSequential = "sequential" // 按顺序执行 (默认)
+	TestStageModeParallel   = "parallel"   // 同时执行
+)
+
+// DeployOnlyTestPath 测试服务器的仅部署模式使用的 test_path
+const DeployOnlyTestPath = "deploy"
+
+// TestStageConfig 单个测试阶段
+type TestStageConfig struct {
+	Name          string `json:"name"`
+	TestPath      string `json:"test_path"`
+	ReportKeyword string `json:"report_keyword,omitempty"` // 为空时使用参数集中的 report_keyword
+}
+
+// TestStagesConfig 部署完成后依次或同时执行的测试阶段，stages 为空表示只部署
+type TestStagesConfig struct {
+	Mode              string            `json:"mode,omitempty"`
+	ContinueOnFailure bool              `json:"continue_on_failure,omitempty"` // 顺序执行时某阶段失败后继续执行后续阶段
+	Stages            []TestStageConfig `json:"stages"`
+}
+
+// GetTestStages 获取解析后的测试阶段配置，未配置时返回nil
+func (t *TestItem) GetTestStages() (*TestStagesConfig, error) {
+	if len(t.TestStages) == 0 || string(t.TestStages) == "null" {
+		return nil, nil
+	}
+	stages := &TestStagesConfig{}
+	if err := json.Unmarshal(t.TestStages, stages); err != nil {
+		return nil, err
+	}
+	if stages.Mode == "" {
+		stages.Mode = TestStageModeSequential
+	}
+	return stages, nil
+}
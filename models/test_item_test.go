@@ -0,0 +1,94 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  RetryPolicy
+		attempt int
+		want    time.Duration
+	}{
+		{"first retry uses base backoff", RetryPolicy{BackoffSeconds: 30, BackoffMultiplier: 2}, 1, 30 * time.Second},
+		{"second retry doubles", RetryPolicy{BackoffSeconds: 30, BackoffMultiplier: 2}, 2, 60 * time.Second},
+		{"third retry doubles again", RetryPolicy{BackoffSeconds: 30, BackoffMultiplier: 2}, 3, 120 * time.Second},
+		{"constant backoff", RetryPolicy{BackoffSeconds: 10, BackoffMultiplier: 1}, 5, 10 * time.Second},
+		{"fractional multiplier", RetryPolicy{BackoffSeconds: 10, BackoffMultiplier: 1.5}, 3, 22 * time.Second},
+		{"capped by max backoff", RetryPolicy{BackoffSeconds: 30, BackoffMultiplier: 2, MaxBackoffSeconds: 100}, 4, 100 * time.Second},
+		{"below max backoff", RetryPolicy{BackoffSeconds: 30, BackoffMultiplier: 2, MaxBackoffSeconds: 100}, 2, 60 * time.Second},
+		{"no backoff", RetryPolicy{BackoffMultiplier: 2}, 3, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.Backoff(tt.attempt); got != tt.want {
+				t.Errorf("Backoff(%d) = %v, want %v", tt.attempt, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyShouldRetry(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3, RetryOn: DefaultRetryableFailureClasses}
+	tests := []struct {
+		failureClass string
+		attempt      int
+		want         bool
+	}{
+		{FailureClassDownload, 1, true},
+		{FailureClassTestServer5xx, 2, true},
+		{FailureClassDownload, 3, false},
+		{FailureClassStep, 1, false},
+		{"", 1, false},
+	}
+	for _, tt := range tests {
+		if got := policy.ShouldRetry(tt.failureClass, tt.attempt); got != tt.want {
+			t.Errorf("ShouldRetry(%q, %d) = %v, want %v", tt.failureClass, tt.attempt, got, tt.want)
+		}
+	}
+}
+
+func TestGetRetryPolicy(t *testing.T) {
+	tests := []struct {
+		name           string
+		raw            string
+		wantNil        bool
+		wantErr        bool
+		wantMultiplier float64
+		wantRetryOn    int
+	}{
+		{name: "not configured", raw: "", wantNil: true},
+		{name: "null", raw: "null", wantNil: true},
+		{name: "defaults", raw: `{"max_attempts":3}`, wantMultiplier: 1, wantRetryOn: len(DefaultRetryableFailureClasses)},
+		{name: "negative multiplier", raw: `{"max_attempts":3,"backoff_multiplier":-2}`, wantMultiplier: 1, wantRetryOn: len(DefaultRetryableFailureClasses)},
+		{name: "configured", raw: `{"max_attempts":3,"backoff_multiplier":2,"retry_on":["monitor"]}`, wantMultiplier: 2, wantRetryOn: 1},
+		{name: "invalid", raw: `{"max_attempts":"3"}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testItem := TestItem{RetryPolicy: json.RawMessage(tt.raw)}
+			policy, err := testItem.GetRetryPolicy()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetRetryPolicy error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if (policy == nil) != tt.wantNil {
+				t.Fatalf("GetRetryPolicy = %+v, want nil %v", policy, tt.wantNil)
+			}
+			if policy == nil {
+				return
+			}
+			if policy.BackoffMultiplier != tt.wantMultiplier {
+				t.Errorf("BackoffMultiplier = %v, want %v", policy.BackoffMultiplier, tt.wantMultiplier)
+			}
+			if len(policy.RetryOn) != tt.wantRetryOn {
+				t.Errorf("RetryOn = %v, want %d classes", policy.RetryOn, tt.wantRetryOn)
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// TestServer 外部测试服务器池中的一台服务器
+type TestServer struct {
+	ID           uint            `gorm:"primaryKey" json:"id"`
+	Name         string          `gorm:"uniqueIndex;not null" json:"name"`
+	URL          string          `gorm:"uniqueIndex;not null" json:"url"`
+	Labels       json.RawMessage `gorm:"type:jsonb" json:"labels,omitempty"` // 标签列表，例如 ["region=bj", "cds"]
+	Capacity     int             `gorm:"not null" json:"capacity"`           // 同时执行的测试数上限
+	Enabled      bool            `gorm:"not null" json:"enabled"`            // 停用的服务器不参与调度
+	Maintenance  bool            `gorm:"not null" json:"maintenance"`        // 维护中的服务器不接收新测试，已分配的测试继续执行
+	TransferMode string          `json:"transfer_mode,omitempty"`            // 包传递方式，为空时使用系统设置
+	HealthPath   string          `json:"health_path,omitempty"`              // 健康检查路径，为空时请求服务器根地址
+	Healthy      bool            `gorm:"not null" json:"healthy"`            // 最近一次健康检查的结果
+	LastError    string          `json:"last_error,omitempty"`               // 最近一次健康检查或提交失败的原因
+	CheckedAt    *time.Time      `json:"checked_at,omitempty"`               // 最近一次健康检查时间
+	CreatedAt    time.Time       `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt    time.Time       `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// TableName 指定表名
+func (TestServer) TableName() string {
+	return "test_servers"
+}
+
+// GetLabels 获取解析后的标签列表
+func (t *TestServer) GetLabels() ([]string, error) {
+	if len(t.Labels) == 0 || string(t.Labels) == "null" {
+		return nil, nil
+	}
+	var labels []string
+	if err := json.Unmarshal(t.Labels, &labels); err != nil {
+		return nil, err
+	}
+	return labels, nil
+}
+
+// Schedulable 判断服务器是否可以接收新测试
+func (t *TestServer) Schedulable() bool {
+	return t.Enabled && !t.Maintenance && t.Healthy
+}
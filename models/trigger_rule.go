@@ -0,0 +1,80 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// TriggerRule 自动触发规则: 匹配的 Jenkins 构建到达后自动触发部署测试
+type TriggerRule struct {
+	ID      uint   `gorm:"primaryKey" json:"id"`
+	Name    string `gorm:"uniqueIndex;not null" json:"name"`
+	Enabled bool   `gorm:"not null" json:"enabled"`
+
+	// 匹配条件，配置的条件全部满足时匹配，至少配置一个
+	JobName            string          `gorm:"index" json:"job_name,omitempty"`            // Job名称，完全匹配
+	RawDataMatch       json.RawMessage `gorm:"type:jsonb" json:"raw_data_match,omitempty"` // 原始数据字段 -> 正则，例如 {"BRANCH": "^release/"}
+	PackagePathPattern string          `json:"package_path_pattern,omitempty"`             // 包路径正则
+
+	// 触发内容，与批量触发相同
+	TestItemIDs         json.RawMessage `gorm:"type:jsonb" json:"test_item_ids"`
+	ParameterSetIDs     json.RawMessage `gorm:"type:jsonb" json:"parameter_set_ids,omitempty"`
+	Priority            int             `gorm:"default:0" json:"priority"`
+	NotificationEnabled bool            `gorm:"not null" json:"notification_enabled"`
+
+	DebounceSeconds int    `gorm:"default:0" json:"debounce_seconds"` // 防抖时长，期间到达的新构建替换等待中的构建
+	Owner           string `json:"owner,omitempty"`                   // 运行的触发人邮箱，为空时使用构建用户邮箱
+	CreatedBy       string `json:"created_by"`                        // 构建用户未知时作为触发人
+
+	// 防抖等待中的构建
+	PendingBuildInfoID *uint      `json:"pending_build_info_id,omitempty"`
+	PendingFireAt      *time.Time `json:"pending_fire_at,omitempty"`
+
+	// 最近一次触发
+	LastBuildInfoID *uint      `json:"last_build_info_id,omitempty"`
+	LastRunGroupID  *uint      `json:"last_run_group_id,omitempty"`
+	LastTriggeredAt *time.Time `json:"last_triggered_at,omitempty"`
+	LastError       string     `json:"last_error,omitempty"`
+
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// TableName 指定表名
+func (TriggerRule) TableName() string {
+	return "trigger_rules"
+}
+
+// GetRawDataMatch 获取解析后的原始数据匹配条件
+func (t *TriggerRule) GetRawDataMatch() (map[string]string, error) {
+	if len(t.RawDataMatch) == 0 || string(t.RawDataMatch) == "null" {
+		return nil, nil
+	}
+	var match map[string]string
+	if err := json.Unmarshal(t.RawDataMatch, &match); err != nil {
+		return nil, err
+	}
+	return match, nil
+}
+
+// GetTestItemIDs 获取解析后的测试项ID列表
+func (t *TriggerRule) GetTestItemIDs() ([]uint, error) {
+	return parseIDList(t.TestItemIDs)
+}
+
+// GetParameterSetIDs 获取解析后的参数集ID列表
+func (t *TriggerRule) GetParameterSetIDs() ([]uint, error) {
+	return parseIDList(t.ParameterSetIDs)
+}
+
+// parseIDList 解析 jsonb 中的ID列表
+func parseIDList(raw json.RawMessage) ([]uint, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	var ids []uint
+	if err := json.Unmarshal(raw, &ids); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
@@ -18,6 +18,15 @@ func SetupAPIRoutes(router *gin.Engine) {
 	systemSettingController := controllers.NewSystemSettingController()
 	parameterSetController := controllers.NewParameterSetController()
 	processingCountController := controllers.NewProcessingCountController()
+	queueController := controllers.NewQueueController()
+	packageCacheController := controllers.NewPackageCacheController()
+	artifactSourceController := controllers.NewArtifactSourceController()
+	artifactController := controllers.NewArtifactController()
+	testServerController := controllers.NewTestServerController()
+	eventController := controllers.NewEventController()
+	runGroupController := controllers.NewRunGroupController()
+	triggerRuleController := controllers.NewTriggerRuleController()
+	scheduleController := controllers.NewScheduleController()
 	versionController := controllers.NewVersionController()
 
 	// API路由组
@@ -32,6 +41,16 @@ func SetupAPIRoutes(router *gin.Engine) {
 	// 版本信息 (无需认证)
 	api.GET("/version", versionController.GetVersion)
 
+	// 运行包下载 (通过签名链接认证，供测试服务器和开发人员下载)
+	api.GET("/artifacts/:deploy_run_id/package", artifactController.DownloadRunPackage)
+	api.HEAD("/artifacts/:deploy_run_id/package", artifactController.DownloadRunPackage)
+
+	// 测试服务器推送任务状态 (通过回调令牌签名认证)
+	api.POST("/deploy-test-runs/:deploy_run_id/callback", testItemController.TestCallback)
+
+	// 事件流 (EventSource 无法设置请求头，也可通过查询参数 token 认证)
+	api.GET("/events", middleware.EventStreamAuth(), eventController.StreamEvents)
+
 	// 需要认证的路由
 	authenticated := api.Group("/")
 	authenticated.Use(middleware.AuthMiddleware())
@@ -57,9 +76,21 @@ func SetupAPIRoutes(router *gin.Engine) {
 		// 测试项相关
 		authenticated.GET("/test-items", testItemController.GetTestItems)
 		authenticated.GET("/test-items/:id", testItemController.GetTestItem)
+		authenticated.GET("/pipeline-steps", testItemController.GetPipelineSteps)
 		authenticated.POST("/test-items/:id/deploy-test", testItemController.TriggerDeployTest)
+		authenticated.POST("/test-items/:id/package-preview", testItemController.PreviewPackageSelection)
 		authenticated.GET("/test-items/:id/deploy-runs", testItemController.GetDeployTestRuns)
 		authenticated.GET("/deploy-test-runs/:deploy_run_id", testItemController.GetDeployTestRun)
+		authenticated.GET("/deploy-test-runs/:deploy_run_id/logs", testItemController.GetDeployTestRunLogs)
+		authenticated.POST("/deploy-test-runs/:deploy_run_id/cancel", testItemController.CancelDeployTestRun)
+		authenticated.POST("/deploy-test-runs/:deploy_run_id/rerun", testItemController.RerunDeployTestRun)
+		authenticated.POST("/artifacts/:deploy_run_id/link", artifactController.CreateArtifactLink)
+
+		// 批量触发 (普通用户只能取消自己触发的运行组)
+		authenticated.POST("/run-groups", runGroupController.CreateRunGroup)
+		authenticated.GET("/run-groups", runGroupController.GetRunGroups)
+		authenticated.GET("/run-groups/:id", runGroupController.GetRunGroup)
+		authenticated.POST("/run-groups/:id/cancel", runGroupController.CancelRunGroup)
 
 		// 系统设置读取（所有认证用户可访问）
 		authenticated.GET("/settings", systemSettingController.GetSettings)
@@ -72,6 +103,22 @@ func SetupAPIRoutes(router *gin.Engine) {
 		// 处理计数（所有认证用户可访问）
 		authenticated.GET("/processing-count", processingCountController.GetProcessingCount)
 
+		// 测试队列（所有认证用户可查看，普通用户只能移除自己触发的测试）
+		authenticated.GET("/queue", queueController.GetQueue)
+		authenticated.DELETE("/queue/:deploy_run_id", queueController.RemoveQueuedRun)
+
+		// 测试服务器池（所有认证用户可查看）
+		authenticated.GET("/test-servers", testServerController.GetTestServers)
+
+		// 自动触发规则（所有认证用户可查看）
+		authenticated.GET("/trigger-rules", triggerRuleController.GetTriggerRules)
+		authenticated.GET("/trigger-rules/:id", triggerRuleController.GetTriggerRule)
+
+		// 定时运行（所有认证用户可查看）
+		authenticated.GET("/schedules", scheduleController.GetSchedules)
+		authenticated.GET("/schedules/:id", scheduleController.GetSchedule)
+		authenticated.GET("/schedules/:id/fires", scheduleController.GetScheduleFires)
+
 		// 需要管理员权限的路由
 		admin := authenticated.Group("/")
 		admin.Use(middleware.AdminRequired())
@@ -98,6 +145,39 @@ func SetupAPIRoutes(router *gin.Engine) {
 			admin.POST("/parameter-sets", parameterSetController.CreateParameterSet)
 			admin.PUT("/parameter-sets/:id", parameterSetController.UpdateParameterSet)
 			admin.DELETE("/parameter-sets/:id", parameterSetController.DeleteParameterSet)
+
+			// 队列管理（仅管理员可访问）
+			admin.POST("/queue/pause", queueController.PauseQueue)
+			admin.POST("/queue/resume", queueController.ResumeQueue)
+			admin.POST("/queue/:deploy_run_id/move", queueController.MoveQueuedRun)
+			admin.POST("/queue/:deploy_run_id/front", queueController.MoveQueuedRunToFront)
+
+			// 包缓存管理（仅管理员可访问）
+			admin.GET("/package-cache", packageCacheController.GetPackageCache)
+			admin.DELETE("/package-cache", packageCacheController.PurgePackageCache)
+
+			// Job制品来源管理（仅管理员可访问）
+			admin.GET("/artifact-sources", artifactSourceController.GetArtifactSources)
+			admin.PUT("/artifact-sources/:job_name", artifactSourceController.SetArtifactSource)
+			admin.DELETE("/artifact-sources/:job_name", artifactSourceController.DeleteArtifactSource)
+
+			// 测试服务器管理（仅管理员可访问）
+			admin.POST("/test-servers", testServerController.CreateTestServer)
+			admin.PUT("/test-servers/:id", testServerController.UpdateTestServer)
+			admin.DELETE("/test-servers/:id", testServerController.DeleteTestServer)
+
+			// 自动触发规则管理（仅管理员可访问）
+			admin.POST("/trigger-rules", triggerRuleController.CreateTriggerRule)
+			admin.PUT("/trigger-rules/:id", triggerRuleController.UpdateTriggerRule)
+			admin.DELETE("/trigger-rules/:id", triggerRuleController.DeleteTriggerRule)
+
+			// 定时运行管理（仅管理员可访问）
+			admin.POST("/schedules", scheduleController.CreateSchedule)
+			admin.PUT("/schedules/:id", scheduleController.UpdateSchedule)
+			admin.DELETE("/schedules/:id", scheduleController.DeleteSchedule)
+			admin.POST("/schedules/:id/pause", scheduleController.PauseSchedule)
+			admin.POST("/schedules/:id/resume", scheduleController.ResumeSchedule)
+			admin.POST("/schedules/:id/run", scheduleController.RunSchedule)
 		}
 	}
 
@@ -0,0 +1,144 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"crat/config"
+	"crat/models"
+)
+
+var (
+	// ErrArtifactLinkInvalid 制品下载链接签名错误或已过期
+	ErrArtifactLinkInvalid = errors.New("invalid or expired artifact link")
+	// ErrArtifactNotAvailable 运行没有可下载的包文件
+	ErrArtifactNotAvailable = errors.New("package is not available for this run")
+)
+
+// 下载链接有效期，可通过系统设置 artifact_link_ttl_minutes / artifact_link_max_ttl_minutes 调整
+const (
+	defaultArtifactLinkTTL    = 24 * time.Hour
+	defaultArtifactLinkMaxTTL = 7 * 24 * time.Hour
+)
+
+// ArtifactLink 运行包的签名下载链接
+type ArtifactLink struct {
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expires_at"`
+	FileName  string    `json:"file_name"`
+	Size      int64     `json:"size"`
+	SHA256    string    `json:"sha256,omitempty"`
+}
+
+// artifactSigningKey 获取下载链接签名密钥
+func artifactSigningKey() []byte {
+	if config.AppConfig.Auth.ArtifactSigningSecret != "" {
+		return []byte(config.AppConfig.Auth.ArtifactSigningSecret)
+	}
+	return []byte(config.AppConfig.Auth.AdminPassword)
+}
+
+// signArtifact 计算运行包下载链接的签名
+func signArtifact(runID uint, expires int64) string {
+	mac := hmac.New(sha256.New, artifactSigningKey())
+	fmt.Fprintf(mac, "run:%d:expires:%d", runID, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyArtifactSignature 校验下载链接的签名和有效期
+func VerifyArtifactSignature(runID uint, expires int64, signature string) error {
+	if time.Now().Unix() > expires {
+		return ErrArtifactLinkInvalid
+	}
+	if !hmac.Equal([]byte(signArtifact(runID, expires)), []byte(strings.ToLower(signature))) {
+		return ErrArtifactLinkInvalid
+	}
+	return nil
+}
+
+// artifactURL 生成运行包的签名下载链接，需要配置 crat_public_base_url
+func artifactURL(runID uint, ttl time.Duration, settings map[string]string) (string, time.Time, error) {
+	baseURL := strings.TrimSuffix(settings["crat_public_base_url"], "/")
+	if baseURL == "" {
+		return "", time.Time{}, fmt.Errorf("crat_public_base_url is not configured")
+	}
+	expiresAt := time.Now().Add(ttl)
+	expires := expiresAt.Unix()
+	return fmt.Sprintf("%s/api/v1/artifacts/%d/package?expires=%d&signature=%s",
+		baseURL, runID, expires, signArtifact(runID, expires)), expiresAt, nil
+}
+
+// GetRunPackage 获取运行已下载的包文件
+func (s *DeployTestService) GetRunPackage(runID uint) (*models.DeployTestRun, error) {
+	var run models.DeployTestRun
+	if err := config.DB.First(&run, runID).Error; err != nil {
+		return nil, ErrDeployTestRunNotFound
+	}
+	if run.DownloadPath == "" {
+		return nil, ErrArtifactNotAvailable
+	}
+	// 运行结束后缓存的包可能已被淘汰
+	if _, err := os.Stat(run.DownloadPath); err != nil {
+		return nil, ErrArtifactNotAvailable
+	}
+	return &run, nil
+}
+
+// artifactLinkTTL 从系统设置读取下载链接的默认有效期和最长有效期
+func artifactLinkTTL(settings map[string]string) (time.Duration, time.Duration) {
+	ttl, maxTTL := defaultArtifactLinkTTL, defaultArtifactLinkMaxTTL
+	if minutes, err := strconv.Atoi(settings["artifact_link_ttl_minutes"]); err == nil && minutes > 0 {
+		ttl = time.Duration(minutes) * time.Minute
+	}
+	if minutes, err := strconv.Atoi(settings["artifact_link_max_ttl_minutes"]); err == nil && minutes > 0 {
+		maxTTL = time.Duration(minutes) * time.Minute
+	}
+	if ttl > maxTTL {
+		ttl = maxTTL
+	}
+	return ttl, maxTTL
+}
+
+// IssueArtifactLink 为运行包生成签名下载链接，ttlMinutes 为0时使用默认有效期，超过上限时按上限
+func (s *DeployTestService) IssueArtifactLink(runID uint, ttlMinutes int) (*ArtifactLink, error) {
+	run, err := s.GetRunPackage(runID)
+	if err != nil {
+		return nil, err
+	}
+
+	settings, err := s.systemUtils.GetSystemSettings()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get system settings: %v", err)
+	}
+	ttl, maxTTL := artifactLinkTTL(settings)
+	if ttlMinutes > 0 {
+		ttl = time.Duration(ttlMinutes) * time.Minute
+		if ttl > maxTTL {
+			ttl = maxTTL
+		}
+	}
+
+	link, expiresAt, err := artifactURL(run.ID, ttl, settings)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ArtifactLink{
+		URL:       link,
+		ExpiresAt: expiresAt,
+		FileName:  filepath.Base(run.DownloadPath),
+		SHA256:    run.PackageSHA256,
+	}
+	if info, err := os.Stat(run.DownloadPath); err == nil {
+		result.Size = info.Size()
+	}
+	return result, nil
+}
@@ -0,0 +1,549 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"crat/config"
+	"crat/models"
+
+	"gorm.io/gorm"
+)
+
+// redactedSecret 接口返回制品来源配置时代替密钥的占位符，更新时提交占位符表示保留原值
+const redactedSecret = "******"
+
+// artifactSecretFields 制品来源配置中的密钥字段
+var artifactSecretFields = []string{"token", "password", "secret_key"}
+
+// ArtifactSource 构建包的来源
+//
+// 来源中的文件都以URL表示，下载、校验和缓存流程不区分来源类型；本地文件使用 file:// 地址。
+type ArtifactSource interface {
+	// Type 返回来源类型
+	Type() string
+	// Location 返回构建的包所在位置，用于日志和预览
+	Location(buildInfo *models.BuildInfo) string
+	// List 列出构建的候选包文件
+	List(ctx context.Context, buildInfo *models.BuildInfo) ([]ArtifactFile, error)
+	// Authorize 为访问来源中文件的请求附加认证信息
+	Authorize(req *http.Request) error
+}
+
+// newArtifactRequest 创建访问制品的请求并附加来源的认证信息
+func newArtifactRequest(ctx context.Context, source ArtifactSource, method, rawURL string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if source != nil {
+		if err := source.Authorize(req); err != nil {
+			return nil, fmt.Errorf("failed to authorize request: %v", err)
+		}
+	}
+	return req, nil
+}
+
+// localArtifactPath 检查制品地址，返回 file:// 地址对应的本地路径，HTTP(S) 地址时第二个返回值为 false
+//
+// 只有文件系统来源可以使用 file:// 地址，且路径 (包括符号链接指向的位置) 必须位于来源的 root 下；
+// 其他来源和其他协议的地址返回错误，防止列表或构建信息中的链接读取主机上的任意文件。
+func localArtifactPath(source ArtifactSource, rawURL string) (string, bool, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return "", false, fmt.Errorf("invalid artifact URL %s: %v", rawURL, err)
+	}
+	switch parsed.Scheme {
+	case "http", "https":
+		return "", false, nil
+	case "file":
+		fsSource, ok := source.(*filesystemArtifactSource)
+		if !ok {
+			return "", false, fmt.Errorf("artifact URL %s is not allowed for this artifact source", rawURL)
+		}
+		localPath, err := fsSource.resolve(parsed.Path)
+		if err != nil {
+			return "", false, err
+		}
+		return localPath, true, nil
+	}
+	return "", false, fmt.Errorf("unsupported artifact URL scheme: %s", rawURL)
+}
+
+// newArtifactSource 根据类型和配置创建制品来源，settings 提供未配置项的默认值
+func newArtifactSource(sourceType string, raw json.RawMessage, settings map[string]string) (ArtifactSource, error) {
+	decode := func(target interface{}) error {
+		if len(raw) == 0 || string(raw) == "null" {
+			return nil
+		}
+		if err := json.Unmarshal(raw, target); err != nil {
+			return fmt.Errorf("invalid %s artifact source config: %v", sourceType, err)
+		}
+		return nil
+	}
+
+	switch sourceType {
+	case "", models.ArtifactSourceHTTP:
+		source := &httpArtifactSource{}
+		if err := decode(source); err != nil {
+			return nil, err
+		}
+		if source.BaseURL == "" {
+			source.BaseURL = settings["package_download_base_url"]
+		}
+		if source.ListingFormat == "" {
+			source.ListingFormat = settings["package_listing_format"]
+		}
+		if source.ListingFormat == "" {
+			source.ListingFormat = ListingFormatAuto
+		}
+		if err := ValidateListingFormat(source.ListingFormat); err != nil {
+			return nil, err
+		}
+		source.BaseURL = withTrailingSlash(source.BaseURL)
+		return source, nil
+
+	case models.ArtifactSourceJenkins:
+		source := &jenkinsArtifactSource{}
+		if err := decode(source); err != nil {
+			return nil, err
+		}
+		if source.BaseURL == "" {
+			// package_build_info_base_url 形如 http://jenkins:8080/job/
+			source.BaseURL = strings.TrimSuffix(withTrailingSlash(settings["package_build_info_base_url"]), "job/")
+		}
+		if source.BaseURL == "" || source.BaseURL == "/" {
+			return nil, fmt.Errorf("jenkins artifact source requires base_url")
+		}
+		source.BaseURL = withTrailingSlash(source.BaseURL)
+		return source, nil
+
+	case models.ArtifactSourceFilesystem:
+		source := &filesystemArtifactSource{}
+		if err := decode(source); err != nil {
+			return nil, err
+		}
+		if !filepath.IsAbs(source.Root) {
+			return nil, fmt.Errorf("filesystem artifact source requires an absolute root")
+		}
+		source.Root = filepath.Clean(source.Root)
+		return source, nil
+
+	case models.ArtifactSourceS3:
+		source := &s3ArtifactSource{}
+		if err := decode(source); err != nil {
+			return nil, err
+		}
+		if source.Endpoint == "" || source.Bucket == "" {
+			return nil, fmt.Errorf("s3 artifact source requires endpoint and bucket")
+		}
+		if _, err := url.Parse(source.Endpoint); err != nil {
+			return nil, fmt.Errorf("invalid s3 endpoint: %v", err)
+		}
+		if (source.AccessKey == "") != (source.SecretKey == "") {
+			return nil, fmt.Errorf("s3 artifact source requires both access_key and secret_key")
+		}
+		if source.Region == "" {
+			source.Region = "us-east-1"
+		}
+		return source, nil
+	}
+
+	return nil, fmt.Errorf("unknown artifact source type: %s", sourceType)
+}
+
+// withTrailingSlash 确保URL以 / 结尾
+func withTrailingSlash(value string) string {
+	if !strings.HasSuffix(value, "/") {
+		value += "/"
+	}
+	return value
+}
+
+// ValidateArtifactSource 校验制品来源配置
+func ValidateArtifactSource(sourceType string, raw json.RawMessage) error {
+	settings, err := NewSystemUtils().GetSystemSettings()
+	if err != nil {
+		settings = map[string]string{}
+	}
+	_, err = newArtifactSource(sourceType, raw, settings)
+	return err
+}
+
+// artifactSourceFor 获取构建所属 Job 配置的制品来源，未配置时使用 HTTP 目录
+func (s *DeployTestService) artifactSourceFor(buildInfo *models.BuildInfo) (ArtifactSource, error) {
+	settings, err := s.systemUtils.GetSystemSettings()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get system settings: %v", err)
+	}
+
+	var jobSource models.JobArtifactSource
+	err = config.DB.Where("job_name = ?", buildInfo.JobName).First(&jobSource).Error
+	if err == gorm.ErrRecordNotFound {
+		return newArtifactSource(models.ArtifactSourceHTTP, nil, settings)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get artifact source for job %s: %v", buildInfo.JobName, err)
+	}
+	return newArtifactSource(jobSource.Type, jobSource.Config, settings)
+}
+
+// RedactArtifactSourceConfig 隐藏配置中的密钥
+func RedactArtifactSourceConfig(raw json.RawMessage) json.RawMessage {
+	var fields map[string]interface{}
+	if len(raw) == 0 || json.Unmarshal(raw, &fields) != nil {
+		return raw
+	}
+	for _, key := range artifactSecretFields {
+		if value, ok := fields[key].(string); ok && value != "" {
+			fields[key] = redactedSecret
+		}
+	}
+	redacted, err := json.Marshal(fields)
+	if err != nil {
+		return raw
+	}
+	return redacted
+}
+
+// MergeArtifactSourceSecrets 更新配置时，值为占位符的密钥保留原值
+func MergeArtifactSourceSecrets(updated, previous json.RawMessage) json.RawMessage {
+	var updatedFields, previousFields map[string]interface{}
+	if json.Unmarshal(updated, &updatedFields) != nil || json.Unmarshal(previous, &previousFields) != nil {
+		return updated
+	}
+	for _, key := range artifactSecretFields {
+		if updatedFields[key] == redactedSecret {
+			updatedFields[key] = previousFields[key]
+		}
+	}
+	merged, err := json.Marshal(updatedFields)
+	if err != nil {
+		return updated
+	}
+	return merged
+}
+
+// httpArtifactSource 包下载基础URL + package_path 的 HTTP 目录
+type httpArtifactSource struct {
+	BaseURL       string `json:"base_url"`       // 为空时使用系统设置 package_download_base_url
+	ListingFormat string `json:"listing_format"` // 为空时使用系统设置 package_listing_format
+}
+
+func (h *httpArtifactSource) Type() string {
+	return models.ArtifactSourceHTTP
+}
+
+func (h *httpArtifactSource) Location(buildInfo *models.BuildInfo) string {
+	// 例如: package_path = "CDN/Core/20240626-story_container-c54239edb10ebf9266c4b97df0418e3b181e6fd6/"
+	return h.BaseURL + buildInfo.PackagePath
+}
+
+// List S3 格式按 ListObjectsV2 请求存储桶，其他格式直接请求目录URL
+func (h *httpArtifactSource) List(ctx context.Context, buildInfo *models.BuildInfo) ([]ArtifactFile, error) {
+	if h.ListingFormat == ListingFormatS3 {
+		listingURL, err := s3ListingURL(h.BaseURL, buildInfo.PackagePath)
+		if err != nil {
+			return nil, err
+		}
+		return fetchDirectoryListing(ctx, h, listingURL, h.ListingFormat)
+	}
+
+	listingURL, err := url.Parse(h.Location(buildInfo))
+	if err != nil {
+		return nil, fmt.Errorf("invalid package directory URL: %v", err)
+	}
+	return fetchDirectoryListing(ctx, h, listingURL, h.ListingFormat)
+}
+
+func (h *httpArtifactSource) Authorize(req *http.Request) error {
+	return nil
+}
+
+// jenkinsArtifactSource Jenkins 构建归档的制品，通过 /job/<name>/<n>/api/json 列出
+type jenkinsArtifactSource struct {
+	BaseURL  string `json:"base_url"` // Jenkins 地址，为空时根据 package_build_info_base_url 推断
+	Username string `json:"username"`
+	Token    string `json:"token"` // API Token
+}
+
+func (j *jenkinsArtifactSource) Type() string {
+	return models.ArtifactSourceJenkins
+}
+
+// buildURL 获取构建的地址，文件夹中的 Job (a/b) 转换为 job/a/job/b
+func (j *jenkinsArtifactSource) buildURL(buildInfo *models.BuildInfo) string {
+	var segments []string
+	for _, name := range strings.Split(strings.Trim(buildInfo.JobName, "/"), "/") {
+		segments = append(segments, "job", url.PathEscape(name))
+	}
+	return fmt.Sprintf("%s%s/%d/", j.BaseURL, strings.Join(segments, "/"), buildInfo.BuildNumber)
+}
+
+func (j *jenkinsArtifactSource) Location(buildInfo *models.BuildInfo) string {
+	return j.buildURL(buildInfo) + "artifact/"
+}
+
+func (j *jenkinsArtifactSource) List(ctx context.Context, buildInfo *models.BuildInfo) ([]ArtifactFile, error) {
+	apiURL := j.buildURL(buildInfo) + "api/json?tree=timestamp,artifacts[fileName,relativePath]"
+	body, _, err := fetchListingPage(ctx, j, apiURL)
+	if err != nil {
+		return nil, err
+	}
+
+	var build struct {
+		Timestamp int64 `json:"timestamp"`
+		Artifacts []struct {
+			FileName     string `json:"fileName"`
+			RelativePath string `json:"relativePath"`
+		} `json:"artifacts"`
+	}
+	if err := json.Unmarshal(body, &build); err != nil {
+		return nil, fmt.Errorf("failed to parse jenkins build artifacts: %v", err)
+	}
+
+	// Jenkins 不返回制品大小，修改时间使用构建开始时间
+	var modTime *time.Time
+	if build.Timestamp > 0 {
+		t := time.UnixMilli(build.Timestamp)
+		modTime = &t
+	}
+
+	artifactURL, err := url.Parse(j.Location(buildInfo))
+	if err != nil {
+		return nil, fmt.Errorf("invalid jenkins artifact URL: %v", err)
+	}
+	var files []ArtifactFile
+	for _, artifact := range build.Artifacts {
+		files = append(files, ArtifactFile{
+			Name:    artifact.FileName,
+			URL:     artifactURL.ResolveReference(&url.URL{Path: artifact.RelativePath}).String(),
+			Size:    -1,
+			ModTime: modTime,
+		})
+	}
+	return files, nil
+}
+
+// Authorize 配置了用户名和 Token 时使用 Basic 认证
+func (j *jenkinsArtifactSource) Authorize(req *http.Request) error {
+	if j.Username != "" {
+		req.SetBasicAuth(j.Username, j.Token)
+	}
+	return nil
+}
+
+// filesystemArtifactSource 本地或 NFS 挂载目录，包路径为 root/package_path
+type filesystemArtifactSource struct {
+	Root string `json:"root"`
+}
+
+func (f *filesystemArtifactSource) Type() string {
+	return models.ArtifactSourceFilesystem
+}
+
+// dir 获取构建的包目录，package_path 不允许跳出 root
+func (f *filesystemArtifactSource) dir(buildInfo *models.BuildInfo) (string, error) {
+	dir := filepath.Join(f.Root, filepath.FromSlash(buildInfo.PackagePath))
+	if !pathWithin(f.Root, dir) {
+		return "", fmt.Errorf("package path %s is outside of %s", buildInfo.PackagePath, f.Root)
+	}
+	return dir, nil
+}
+
+// pathWithin 判断路径是否位于 root 下
+func pathWithin(root, path string) bool {
+	return path == root || strings.HasPrefix(path, root+string(filepath.Separator))
+}
+
+// resolve 检查 file:// 地址中的路径位于 root 下，符号链接按实际指向的位置检查
+func (f *filesystemArtifactSource) resolve(path string) (string, error) {
+	path = filepath.Clean(filepath.FromSlash(path))
+	if !pathWithin(f.Root, path) {
+		return "", fmt.Errorf("artifact path %s is outside of %s", path, f.Root)
+	}
+	realRoot, err := filepath.EvalSymlinks(f.Root)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s: %v", f.Root, err)
+	}
+	realPath, err := filepath.EvalSymlinks(path)
+	if os.IsNotExist(err) {
+		// 不存在的文件 (例如缺少的校验和文件) 由调用方按文件不存在处理
+		return path, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s: %v", path, err)
+	}
+	if !pathWithin(realRoot, realPath) {
+		return "", fmt.Errorf("artifact path %s is outside of %s", path, f.Root)
+	}
+	return path, nil
+}
+
+func (f *filesystemArtifactSource) Location(buildInfo *models.BuildInfo) string {
+	dir, err := f.dir(buildInfo)
+	if err != nil {
+		return f.Root
+	}
+	return dir
+}
+
+func (f *filesystemArtifactSource) List(ctx context.Context, buildInfo *models.BuildInfo) ([]ArtifactFile, error) {
+	dir, err := f.dir(buildInfo)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read package directory: %v", err)
+	}
+
+	var files []ArtifactFile
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		modTime := info.ModTime()
+		files = append(files, ArtifactFile{
+			Name:    entry.Name(),
+			URL:     (&url.URL{Scheme: "file", Path: filepath.ToSlash(filepath.Join(dir, entry.Name()))}).String(),
+			Size:    info.Size(),
+			ModTime: &modTime,
+		})
+	}
+	return files, nil
+}
+
+func (f *filesystemArtifactSource) Authorize(req *http.Request) error {
+	return nil
+}
+
+// s3ArtifactSource S3 兼容的存储桶 (路径风格访问)，对象键为 prefix + package_path
+type s3ArtifactSource struct {
+	Endpoint  string `json:"endpoint"` // 例如 http://minio:9000
+	Bucket    string `json:"bucket"`
+	Prefix    string `json:"prefix"`
+	Region    string `json:"region"`     // 默认 us-east-1
+	AccessKey string `json:"access_key"` // 为空时匿名访问
+	SecretKey string `json:"secret_key"`
+}
+
+func (b *s3ArtifactSource) Type() string {
+	return models.ArtifactSourceS3
+}
+
+func (b *s3ArtifactSource) bucketURL() string {
+	return withTrailingSlash(b.Endpoint) + url.PathEscape(b.Bucket) + "/"
+}
+
+func (b *s3ArtifactSource) Location(buildInfo *models.BuildInfo) string {
+	return b.bucketURL() + b.Prefix + buildInfo.PackagePath
+}
+
+func (b *s3ArtifactSource) List(ctx context.Context, buildInfo *models.BuildInfo) ([]ArtifactFile, error) {
+	listingURL, err := s3ListingURL(b.bucketURL(), b.Prefix+buildInfo.PackagePath)
+	if err != nil {
+		return nil, err
+	}
+	return fetchDirectoryListing(ctx, b, listingURL, ListingFormatS3)
+}
+
+// Authorize 配置了访问密钥时使用 AWS Signature Version 4 签名，只签名发往该存储端点的请求
+func (b *s3ArtifactSource) Authorize(req *http.Request) error {
+	if b.AccessKey == "" {
+		return nil
+	}
+	endpoint, err := url.Parse(b.Endpoint)
+	if err != nil || endpoint.Host != req.URL.Host {
+		return nil
+	}
+
+	now := time.Now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	const payloadHash = "UNSIGNED-PAYLOAD"
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+
+	// 规范请求
+	query := req.URL.Query()
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var canonicalQuery []string
+	for _, key := range keys {
+		values := query[key]
+		sort.Strings(values)
+		for _, value := range values {
+			canonicalQuery = append(canonicalQuery, awsURIEscape(key, true)+"="+awsURIEscape(value, true))
+		}
+	}
+	canonicalPath := awsURIEscape(req.URL.Path, false)
+	if canonicalPath == "" {
+		canonicalPath = "/"
+	}
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		canonicalPath,
+		strings.Join(canonicalQuery, "&"),
+		"host:" + req.URL.Host + "\nx-amz-content-sha256:" + payloadHash + "\nx-amz-date:" + amzDate + "\n",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	// 待签名字符串和签名
+	scope := date + "/" + b.Region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+b.SecretKey), date)
+	key = hmacSHA256(key, b.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		b.AccessKey, scope, signedHeaders, signature))
+	return nil
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// awsURIEscape 按 SigV4 规则编码，只保留 A-Z a-z 0-9 - _ . ~
+func awsURIEscape(value string, encodeSlash bool) string {
+	var builder strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			builder.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			builder.WriteByte(c)
+		default:
+			builder.WriteString(fmt.Sprintf("%%%02X", c))
+		}
+	}
+	return builder.String()
+}
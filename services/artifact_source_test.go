@@ -0,0 +1,64 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLocalArtifactPath(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	for _, dir := range []string{filepath.Join(root, "builds"), outside} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pkg := filepath.Join(root, "builds", "agent.tgz")
+	secret := filepath.Join(outside, "secret")
+	for _, file := range []string{pkg, secret} {
+		if err := os.WriteFile(file, []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(secret, filepath.Join(root, "builds", "escape.tgz")); err != nil {
+		t.Fatal(err)
+	}
+
+	fsSource := &filesystemArtifactSource{Root: root}
+	httpSource := &httpArtifactSource{BaseURL: "http://pkg.example.com/"}
+	s3Source := &s3ArtifactSource{Endpoint: "http://minio:9000", Bucket: "builds"}
+
+	tests := []struct {
+		name      string
+		source    ArtifactSource
+		rawURL    string
+		wantPath  string
+		wantLocal bool
+		wantErr   bool
+	}{
+		{"http url", httpSource, "http://pkg.example.com/a.tgz", "", false, false},
+		{"https url for filesystem source", fsSource, "https://pkg.example.com/a.tgz", "", false, false},
+		{"file inside root", fsSource, "file://" + pkg, pkg, true, false},
+		{"missing sidecar inside root", fsSource, "file://" + pkg + ".sha256", pkg + ".sha256", true, false},
+		{"file outside root", fsSource, "file://" + secret, "", false, true},
+		{"traversal out of root", fsSource, "file://" + root + "/builds/../../etc/passwd", "", false, true},
+		{"symlink out of root", fsSource, "file://" + filepath.Join(root, "builds", "escape.tgz"), "", false, true},
+		{"file for http source", httpSource, "file://" + pkg, "", false, true},
+		{"file for s3 source", s3Source, "file:///etc/passwd", "", false, true},
+		{"file without source", nil, "file:///etc/passwd", "", false, true},
+		{"ftp url", httpSource, "ftp://pkg.example.com/a.tgz", "", false, true},
+		{"relative url", httpSource, "a.tgz", "", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, isLocal, err := localArtifactPath(tt.source, tt.rawURL)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("localArtifactPath(%s) error = %v, wantErr %v", tt.rawURL, err, tt.wantErr)
+			}
+			if path != tt.wantPath || isLocal != tt.wantLocal {
+				t.Errorf("localArtifactPath(%s) = %q, %v, want %q, %v", tt.rawURL, path, isLocal, tt.wantPath, tt.wantLocal)
+			}
+		})
+	}
+}
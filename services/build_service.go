@@ -67,12 +67,12 @@ func (s *BuildService) GetBuildInfoByID(id uint) (*models.BuildInfo, error) {
 	return &build, nil
 }
 
-// ProcessJenkinsWebhook 处理Jenkins Webhook数据
-func (s *BuildService) ProcessJenkinsWebhook(data map[string]interface{}) error {
+// ProcessJenkinsWebhook 处理Jenkins Webhook数据，返回创建的构建信息
+func (s *BuildService) ProcessJenkinsWebhook(data map[string]interface{}) (*models.BuildInfo, error) {
 	// 提取必要字段
 	jobName, ok := data["JOB_NAME"].(string)
 	if !ok || jobName == "" {
-		return fmt.Errorf("JOB_NAME is required")
+		return nil, fmt.Errorf("JOB_NAME is required")
 	}
 	var buildNumber int
 	if buildNumberFloat, ok := data["BUILD_NUMBER"].(float64); ok {
@@ -81,15 +81,15 @@ func (s *BuildService) ProcessJenkinsWebhook(data map[string]interface{}) error
 		var err error
 		buildNumber, err = strconv.Atoi(buildNumberStr)
 		if err != nil {
-			return fmt.Errorf("invalid BUILD_NUMBER format: %v", err)
+			return nil, fmt.Errorf("invalid BUILD_NUMBER format: %v", err)
 		}
 	} else {
-		return fmt.Errorf("BUILD_NUMBER is required")
+		return nil, fmt.Errorf("BUILD_NUMBER is required")
 	}
 
 	packagePath, ok := data["PACKAGE_PATH"].(string)
 	if !ok || packagePath == "" {
-		return fmt.Errorf("PACKAGE_PATH is required")
+		return nil, fmt.Errorf("PACKAGE_PATH is required")
 	}
 
 	buildUser, _ := data["BUILD_USER"].(string)
@@ -100,7 +100,7 @@ func (s *BuildService) ProcessJenkinsWebhook(data map[string]interface{}) error
 	// 将原始数据序列化为JSON
 	rawDataBytes, err := json.Marshal(data)
 	if err != nil {
-		return fmt.Errorf("failed to marshal raw data: %v", err)
+		return nil, fmt.Errorf("failed to marshal raw data: %v", err)
 	}
 
 	// 创建构建信息记录
@@ -113,7 +113,10 @@ func (s *BuildService) ProcessJenkinsWebhook(data map[string]interface{}) error
 		RawData:     rawDataBytes,
 	}
 
-	return s.CreateBuildInfo(buildInfo)
+	if err := s.CreateBuildInfo(buildInfo); err != nil {
+		return nil, err
+	}
+	return buildInfo, nil
 }
 
 // GetJobNames 获取所有Job名称列表
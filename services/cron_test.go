@@ -0,0 +1,132 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCronField(t *testing.T) {
+	tests := []struct {
+		name  string
+		field string
+		spec  cronField
+		want  []int
+	}{
+		{"every 15 minutes", "*/15", cronMinute, []int{0, 15, 30, 45}},
+		{"start with step", "5/10", cronMinute, []int{5, 15, 25, 35, 45, 55}},
+		{"range with step", "1-5/2", cronHour, []int{1, 3, 5}},
+		{"list", "1,15,30", cronDom, []int{1, 15, 30}},
+		{"month names", "jan-mar", cronMonth, []int{1, 2, 3}},
+		{"weekday name", "sun", cronDow, []int{0}},
+		{"weekday 7", "7", cronDow, []int{7}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseCronField(tt.field, tt.spec)
+			if err != nil {
+				t.Fatalf("parseCronField(%q) error: %v", tt.field, err)
+			}
+			var want uint64
+			for _, value := range tt.want {
+				want |= 1 << uint(value)
+			}
+			if got != want {
+				t.Errorf("parseCronField(%q) = %b, want %b", tt.field, got, want)
+			}
+		})
+	}
+}
+
+func TestParseCronSundayAliases(t *testing.T) {
+	for _, expr := range []string{"0 0 * * 0", "0 0 * * 7", "0 0 * * sun", "0 0 * * SUN"} {
+		schedule, err := parseCron(expr)
+		if err != nil {
+			t.Fatalf("parseCron(%q) error: %v", expr, err)
+		}
+		if schedule.dow&1 == 0 {
+			t.Errorf("parseCron(%q) does not match Sunday", expr)
+		}
+	}
+}
+
+func TestParseCronInvalid(t *testing.T) {
+	tests := []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"* * * foo *",
+		"@every 5m",
+	}
+	for _, expr := range tests {
+		if _, err := parseCron(expr); err == nil {
+			t.Errorf("parseCron(%q) expected error", expr)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	tests := []struct {
+		name     string
+		expr     string
+		timezone string
+		after    string
+		want     string // 为空表示永不触发
+	}{
+		{"every 15 minutes", "*/15 * * * *", "UTC", "2026-10-18T10:07:00Z", "2026-10-18T10:15:00Z"},
+		{"exact minute is excluded", "*/15 * * * *", "UTC", "2026-10-18T10:15:00Z", "2026-10-18T10:30:00Z"},
+		{"start with step wraps hour", "5/10 * * * *", "UTC", "2026-10-18T10:56:00Z", "2026-10-18T11:05:00Z"},
+		{"range with step", "0 1-5/2 * * *", "UTC", "2026-10-18T03:00:00Z", "2026-10-18T05:00:00Z"},
+		{"sunday by name", "0 9 * * sun", "UTC", "2026-10-18T10:00:00Z", "2026-10-25T09:00:00Z"},
+		{"sunday as 7", "0 9 * * 7", "UTC", "2026-10-18T10:00:00Z", "2026-10-25T09:00:00Z"},
+		{"weekdays in timezone", "0 2 * * 1-5", "Asia/Shanghai", "2026-10-16T03:00:00+08:00", "2026-10-19T02:00:00+08:00"},
+		{"day of month only", "0 0 13 * *", "UTC", "2026-10-18T00:00:00Z", "2026-11-13T00:00:00Z"},
+		{"day of month or weekday", "0 0 13 * fri", "UTC", "2026-10-18T00:00:00Z", "2026-10-23T00:00:00Z"},
+		{"day of month or weekday picks earlier day", "0 0 20 * fri", "UTC", "2026-10-18T00:00:00Z", "2026-10-20T00:00:00Z"},
+		{"leap day", "0 0 29 2 *", "UTC", "2026-03-01T00:00:00Z", "2028-02-29T00:00:00Z"},
+		{"february 31 never fires", "0 0 31 2 *", "UTC", "2026-10-18T00:00:00Z", ""},
+		{"macro", "@daily", "Asia/Shanghai", "2026-10-18T12:00:00+08:00", "2026-10-19T00:00:00+08:00"},
+		{"spring forward skips missing time", "30 2 * * *", "America/New_York", "2026-03-08T00:00:00-05:00", "2026-03-09T02:30:00-04:00"},
+		{"spring forward hourly", "0 * * * *", "America/New_York", "2026-03-08T01:30:00-05:00", "2026-03-08T03:00:00-04:00"},
+		{"fall back fires first occurrence", "30 1 * * *", "America/New_York", "2026-11-01T00:00:00-04:00", "2026-11-01T01:30:00-04:00"},
+		{"fall back fires only once", "30 1 * * *", "America/New_York", "2026-11-01T01:30:00-04:00", "2026-11-02T01:30:00-05:00"},
+		{"fall back hourly skips repeated hour", "0 * * * *", "America/New_York", "2026-11-01T01:00:00-04:00", "2026-11-01T02:00:00-05:00"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loc, err := time.LoadLocation(tt.timezone)
+			if err != nil {
+				t.Skipf("timezone %s not available: %v", tt.timezone, err)
+			}
+			schedule, err := parseCron(tt.expr)
+			if err != nil {
+				t.Fatalf("parseCron(%q) error: %v", tt.expr, err)
+			}
+			after, err := time.Parse(time.RFC3339, tt.after)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := schedule.next(after, loc)
+			if tt.want == "" {
+				if !got.IsZero() {
+					t.Errorf("next(%s) = %s, want never", tt.after, got)
+				}
+				return
+			}
+			want, err := time.Parse(time.RFC3339, tt.want)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !got.Equal(want) {
+				t.Errorf("next(%s) = %s, want %s", tt.after, got, want)
+			}
+		})
+	}
+}
@@ -1,20 +1,24 @@
 package services
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
-	"regexp"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"crat/config"
 	"crat/models"
+
+	"gorm.io/gorm"
 )
 
 type DeployTestService struct {
@@ -22,7 +26,7 @@ type DeployTestService struct {
 	httpClient          *HTTPClient
 	notificationService *NotificationService
 	systemUtils         *SystemUtils
-	queueMutex          sync.Mutex
+	packageCache        *PackageCacheService
 }
 
 // TriggerResult 触发结果
@@ -38,39 +42,83 @@ func NewDeployTestService() *DeployTestService {
 		httpClient:          NewHTTPClient(),
 		notificationService: NewNotificationService(),
 		systemUtils:         NewSystemUtils(),
+		packageCache:        NewPackageCacheService(),
+	}
+}
+
+// TriggerRequest 触发部署测试的参数
+type TriggerRequest struct {
+	TestItemID     uint
+	BuildInfoID    uint
+	TriggeredBy    string
+	ParameterSetID *uint
+	RerunOfID      *uint // 重跑或自动重试的来源运行
+	Attempt        int   // 自动重试次数，0视为1
+	Priority       int   // 排队优先级，数值越大越先执行
+	RunGroupID     *uint // 批量触发的运行组
+
+	Monitor *models.MonitorSettings // 覆盖测试项和参数集中的监控配置
+}
+
+// newDeployTestRun 根据触发参数构建部署测试运行记录
+func newDeployTestRun(req *TriggerRequest, status string) *models.DeployTestRun {
+	attempt := req.Attempt
+	if attempt <= 0 {
+		attempt = 1
+	}
+	return &models.DeployTestRun{
+		TestItemID:      req.TestItemID,
+		BuildInfoID:     req.BuildInfoID,
+		TriggeredBy:     req.TriggeredBy,
+		ParameterSetID:  req.ParameterSetID,
+		RerunOfID:       req.RerunOfID,
+		RunGroupID:      req.RunGroupID,
+		Attempt:         attempt,
+		Priority:        req.Priority,
+		Status:          status,
+		MaxQueryHours:   models.DefaultMaxQueryHours,
+		QueryInterval:   models.DefaultQueryInterval,
+		QueryTimeout:    models.DefaultQueryTimeout,
+		DownloadTimeout: models.DefaultDownloadTimeoutMinutes,
+		StartedAt:       time.Now(),
+		Steps:           json.RawMessage("[]"),
 	}
 }
 
 // TriggerDeployTest 触发部署测试
 func (s *DeployTestService) TriggerDeployTest(testItemID, buildInfoID uint, triggeredBy string, parameterSetID *uint) (*models.DeployTestRun, error) {
+	return s.startDeployTest(&TriggerRequest{
+		TestItemID:     testItemID,
+		BuildInfoID:    buildInfoID,
+		TriggeredBy:    triggeredBy,
+		ParameterSetID: parameterSetID,
+	})
+}
+
+// startDeployTest 创建运行记录并立即执行
+func (s *DeployTestService) startDeployTest(req *TriggerRequest) (*models.DeployTestRun, error) {
 	// 获取测试项和构建信息
 	var testItem models.TestItem
-	if err := config.DB.First(&testItem, testItemID).Error; err != nil {
+	if err := config.DB.First(&testItem, req.TestItemID).Error; err != nil {
 		return nil, fmt.Errorf("failed to get test item: %v", err)
 	}
 
-	buildInfo, err := s.buildService.GetBuildInfoByID(buildInfoID)
+	buildInfo, err := s.buildService.GetBuildInfoByID(req.BuildInfoID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get build info: %v", err)
 	}
 
 	// 创建部署测试运行记录
-	deployTestRun := &models.DeployTestRun{
-		TestItemID:     testItemID,
-		BuildInfoID:    buildInfoID,
-		TriggeredBy:    triggeredBy,
-		ParameterSetID: parameterSetID,
-		Status:         models.DeployTestStatusPending,
-		MaxQueryHours:  3,  // 默认3小时
-		QueryInterval:  60, // 默认60秒
-		QueryTimeout:   30, // 默认30秒
-		StartedAt:      time.Now(),
-		Steps:          json.RawMessage("[]"),
-	}
+	deployTestRun := newDeployTestRun(req, models.DeployTestStatusPending)
+	now := time.Now()
+	deployTestRun.Environment = s.resolveEnvironment(&testItem, req.ParameterSetID)
+	s.applyMonitorSettings(deployTestRun, &testItem, req.Monitor)
+	deployTestRun.DispatchedAt = &now
 
 	if err := config.DB.Create(deployTestRun).Error; err != nil {
 		return nil, fmt.Errorf("failed to create deploy test run: %v", err)
 	}
+	publishRunStatus(deployTestRun.ID)
 	// 异步执行部署测试流程
 	go s.executeDeployTest(deployTestRun, &testItem, buildInfo)
 
@@ -79,151 +127,177 @@ func (s *DeployTestService) TriggerDeployTest(testItemID, buildInfoID uint, trig
 
 // TriggerDeployTestWithBlocking 带阻塞逻辑的触发部署测试
 func (s *DeployTestService) TriggerDeployTestWithBlocking(testItemID, buildInfoID uint, triggeredBy string, parameterSetID *uint) (*TriggerResult, error) {
-	s.queueMutex.Lock()
-	defer s.queueMutex.Unlock()
-
-	// 检查是否启用阻塞模式
-	settings, err := s.systemUtils.GetSystemSettings()
-	if err != nil {
-		log.Printf("Failed to get system settings: %v", err)
-		// 如果无法获取设置，默认不阻塞
-		return s.triggerImmediately(testItemID, buildInfoID, triggeredBy, parameterSetID)
-	}
-
-	blockingEnabled := settings["test_blocking_enabled"] == "true"
-
-	if !blockingEnabled {
-		// 阻塞模式未启用，直接执行
-		return s.triggerImmediately(testItemID, buildInfoID, triggeredBy, parameterSetID)
-	}
-
-	// 检查当前运行中的测试数量
-	var runningCount int64
-	runningStates := []string{"PENDING", "DOWNLOADING", "DEPLOYING", "TESTING", "MONITORING"}
-
-	if err := config.DB.Model(&models.DeployTestRun{}).
-		Where("status IN ?", runningStates).
-		Count(&runningCount).Error; err != nil {
-		log.Printf("Failed to count running tests: %v", err)
-		// 如果查询失败，默认不阻塞
-		return s.triggerImmediately(testItemID, buildInfoID, triggeredBy, parameterSetID)
-	}
-
-	if runningCount >= 1 {
-		// 有测试正在运行，加入队列
-		return s.addToQueue(testItemID, buildInfoID, triggeredBy, parameterSetID)
-	} else {
-		// 没有测试运行，直接执行
-		return s.triggerImmediately(testItemID, buildInfoID, triggeredBy, parameterSetID)
-	}
+	return s.TriggerRun(&TriggerRequest{
+		TestItemID:     testItemID,
+		BuildInfoID:    buildInfoID,
+		TriggeredBy:    triggeredBy,
+		ParameterSetID: parameterSetID,
+	})
 }
 
-// triggerImmediately 立即触发测试
-func (s *DeployTestService) triggerImmediately(testItemID, buildInfoID uint, triggeredBy string, parameterSetID *uint) (*TriggerResult, error) {
-	deployTestRun, err := s.TriggerDeployTest(testItemID, buildInfoID, triggeredBy, parameterSetID)
-	if err != nil {
-		return nil, err
-	}
+// TriggerRun 触发部署测试，所有运行都先进入队列，由调度器按阻塞模式设置决定是否立即执行
+//
+// 阻塞模式未启用时调度器不限制并发，运行入队后立即开始；无法读取系统设置时运行保持排队，
+// 由队列监控在设置恢复后调度，不会绕过并发限制。
+func (s *DeployTestService) TriggerRun(req *TriggerRequest) (*TriggerResult, error) {
+	dispatchMutex.Lock()
+	defer dispatchMutex.Unlock()
 
-	return &TriggerResult{
-		RunID:  deployTestRun.ID,
-		Queued: false,
-	}, nil
+	return s.addToQueue(req)
 }
 
-// addToQueue 加入队列
-func (s *DeployTestService) addToQueue(testItemID, buildInfoID uint, triggeredBy string, parameterSetID *uint) (*TriggerResult, error) {
+// addToQueue 加入队列并尝试调度，调用方需持有 dispatchMutex
+func (s *DeployTestService) addToQueue(req *TriggerRequest) (*TriggerResult, error) {
 	// 验证测试项存在
 	var testItem models.TestItem
-	if err := config.DB.First(&testItem, testItemID).Error; err != nil {
+	if err := config.DB.First(&testItem, req.TestItemID).Error; err != nil {
 		return nil, fmt.Errorf("failed to get test item: %v", err)
 	}
 
 	// 验证构建信息存在
-	if _, err := s.buildService.GetBuildInfoByID(buildInfoID); err != nil {
+	if _, err := s.buildService.GetBuildInfoByID(req.BuildInfoID); err != nil {
 		return nil, fmt.Errorf("failed to get build info: %v", err)
 	}
 
 	// 创建队列状态的部署测试运行记录
-	deployTestRun := &models.DeployTestRun{
-		TestItemID:     testItemID,
-		BuildInfoID:    buildInfoID,
-		TriggeredBy:    triggeredBy,
-		ParameterSetID: parameterSetID,
-		Status:         "QUEUED", // 队列状态
-		MaxQueryHours:  3,
-		QueryInterval:  60,
-		QueryTimeout:   30,
-		StartedAt:      time.Now(),
-		Steps:          json.RawMessage("[]"),
-	}
+	deployTestRun := newDeployTestRun(req, models.DeployTestStatusQueued)
+	now := time.Now()
+	deployTestRun.Environment = s.resolveEnvironment(&testItem, req.ParameterSetID)
+	s.applyMonitorSettings(deployTestRun, &testItem, req.Monitor)
+	deployTestRun.QueueSeq = nextQueueSeq(req.Priority, req.TriggeredBy)
+	deployTestRun.QueuedAt = &now
 
 	if err := config.DB.Create(deployTestRun).Error; err != nil {
 		return nil, fmt.Errorf("failed to create queued deploy test run: %v", err)
 	}
+	publishRunStatus(deployTestRun.ID)
+	publishQueueEvent("queued", deployTestRun.ID)
 
-	// 计算队列位置
-	var queuePosition int64
-	if err := config.DB.Model(&models.DeployTestRun{}).
-		Where("status = ? AND id < ?", "QUEUED", deployTestRun.ID).
-		Count(&queuePosition).Error; err != nil {
-		queuePosition = 0
+	// 并发限制允许时立即开始执行
+	s.dispatchQueueLocked()
+
+	var current models.DeployTestRun
+	if err := config.DB.Select("id", "status").First(&current, deployTestRun.ID).Error; err == nil &&
+		current.Status != models.DeployTestStatusQueued {
+		return &TriggerResult{
+			RunID:  deployTestRun.ID,
+			Queued: false,
+		}, nil
 	}
 
+	// 计算队列位置
+	queuePosition := queuePositionOf(deployTestRun)
+
 	// 启动队列监控（如果还没有启动）
 	go s.monitorQueue()
 
-	log.Printf("Test added to queue: run_id=%d, position=%d", deployTestRun.ID, queuePosition+1)
+	log.Printf("Test added to queue: run_id=%d, priority=%d, environment=%s, position=%d",
+		deployTestRun.ID, deployTestRun.Priority, deployTestRun.Environment, queuePosition)
 
 	return &TriggerResult{
 		RunID:         deployTestRun.ID,
 		Queued:        true,
-		QueuePosition: int(queuePosition + 1),
+		QueuePosition: queuePosition,
 	}, nil
 }
 
 // executeDeployTest 执行完整的部署测试流程
 func (s *DeployTestService) executeDeployTest(deployTestRun *models.DeployTestRun, testItem *models.TestItem, buildInfo *models.BuildInfo) {
+	s.executePipeline(deployTestRun, testItem, buildInfo, "")
+}
+
+// executePipeline 从指定步骤开始执行流水线，fromStep为空时从第一个步骤开始
+func (s *DeployTestService) executePipeline(deployTestRun *models.DeployTestRun, testItem *models.TestItem, buildInfo *models.BuildInfo, fromStep string) {
 	log.Printf("Starting deploy test execution for run ID %d", deployTestRun.ID)
 
+	// 注册取消函数，以便通过取消接口终止运行中的流程
+	ctx, cancel := context.WithCancel(context.Background())
+	registerRunCancel(deployTestRun.ID, cancel)
+	defer func() {
+		unregisterRunCancel(deployTestRun.ID)
+		cancel()
+	}()
+
+	// 流程结束后释放包缓存引用
+	defer s.packageCache.Release(deployTestRun.ID)
+
+	// 流程结束后 (包括安排自动重试之后) 更新所属运行组
+	defer s.refreshRunGroupOf(deployTestRun.ID)
+
 	defer func() {
 		if r := recover(); r != nil {
 			log.Printf("Deploy test execution panic: %v", r)
 			s.updateDeployTestStatus(deployTestRun.ID, models.DeployTestStatusFailed, fmt.Sprintf("Panic occurred: %v", r))
+			config.DB.Model(&models.DeployTestRun{}).Where("id = ?", deployTestRun.ID).Update("failure_class", models.FailureClassInternal)
 		}
 	}()
 
-	// 步骤1: 下载文件
-	if err := s.downloadPackage(deployTestRun, testItem, buildInfo); err != nil {
-		log.Printf("Download failed for run ID %d: %v", deployTestRun.ID, err)
-		s.updateDeployTestStatus(deployTestRun.ID, models.DeployTestStatusFailed, fmt.Sprintf("Download failed: %v", err))
+	pipeline, err := resolvePipeline(testItem)
+	if err != nil {
+		log.Printf("Invalid pipeline for run ID %d: %v", deployTestRun.ID, err)
+		s.updateDeployTestStatus(deployTestRun.ID, models.DeployTestStatusFailed, fmt.Sprintf("Invalid pipeline: %v", err))
 		return
 	}
 
-	// 步骤2: 发送部署测试请求
-	if err := s.triggerExternalTest(deployTestRun, testItem, buildInfo); err != nil {
-		log.Printf("Trigger test failed for run ID %d: %v", deployTestRun.ID, err)
-		s.updateDeployTestStatus(deployTestRun.ID, models.DeployTestStatusFailed, fmt.Sprintf("Trigger test failed: %v", err))
-		return
+	// 跳过恢复点之前已完成的步骤
+	if fromStep != "" {
+		for i, entry := range pipeline {
+			if entry.step.Name() == fromStep {
+				pipeline = pipeline[i:]
+				break
+			}
+		}
+	}
+
+	stepCtx := &StepContext{
+		Ctx:       ctx,
+		Service:   s,
+		Run:       deployTestRun,
+		TestItem:  testItem,
+		BuildInfo: buildInfo,
 	}
 
-	// 步骤3: 监控测试状态
-	if err := s.monitorTestProgress(deployTestRun); err != nil {
-		s.updateDeployTestStatus(deployTestRun.ID, models.DeployTestStatusFailed, fmt.Sprintf("Monitor failed: %v", err))
+	// 按顺序执行流水线步骤，失败后仅执行标记为 always_run 的步骤
+	var failedErr error
+	var failureClass string
+	for _, entry := range pipeline {
+		if failedErr == nil && ctx.Err() != nil {
+			failedErr = ctx.Err()
+		}
+		if failedErr != nil && !entry.alwaysRun {
+			continue
+		}
+		if err := entry.step.Run(stepCtx); err != nil {
+			log.Printf("Step %s failed for run ID %d: %v", entry.step.Name(), deployTestRun.ID, err)
+			if failedErr == nil && (ctx.Err() != nil || errors.Is(err, errRunCancelled)) {
+				// 运行已被取消，状态由取消接口写入
+				failedErr = err
+			} else if failedErr == nil {
+				failedErr = err
+				s.updateDeployTestStatus(deployTestRun.ID, models.DeployTestStatusFailed, stepFailureMessage(entry.step.Name(), err))
+				failureClass = failureClassOf(entry.step.Name(), err)
+				config.DB.Model(&models.DeployTestRun{}).Where("id = ?", deployTestRun.ID).Update("failure_class", failureClass)
+			}
+		}
+	}
+	if failedErr != nil {
+		if failureClass != "" {
+			s.scheduleRetry(deployTestRun, testItem, failureClass)
+		}
 		return
 	}
 
-	// 步骤4: 发送通知
-	s.sendNotification(deployTestRun, testItem, buildInfo)
+	// 流水线中没有写入最终状态的步骤时，标记为完成
+	s.finalizeRun(deployTestRun.ID)
 
-	// 步骤5: 处理队列中的下一个测试
+	// 处理队列中的下一个测试
 	go s.processNextInQueue()
 
 	log.Printf("Deploy test execution completed for run ID %d", deployTestRun.ID)
 }
 
 // downloadPackage 下载包文件到本地
-func (s *DeployTestService) downloadPackage(deployTestRun *models.DeployTestRun, testItem *models.TestItem, buildInfo *models.BuildInfo) error {
+func (s *DeployTestService) downloadPackage(ctx context.Context, deployTestRun *models.DeployTestRun, testItem *models.TestItem, buildInfo *models.BuildInfo) error {
 	s.updateDeployTestStatus(deployTestRun.ID, models.DeployTestStatusDownloading, "")
 	s.addStep(deployTestRun.ID, models.StepDownload, "RUNNING", "Starting package download", "")
 	// 获取系统设置
@@ -233,35 +307,67 @@ func (s *DeployTestService) downloadPackage(deployTestRun *models.DeployTestRun,
 		return err
 	}
 
-	// 构建下载URL
-	downloadBaseURL := settings["package_download_base_url"]
-	if !strings.HasSuffix(downloadBaseURL, "/") {
-		downloadBaseURL += "/"
+	// 获取 Job 配置的制品来源
+	source, err := s.artifactSourceFor(buildInfo)
+	if err != nil {
+		s.addStep(deployTestRun.ID, models.StepDownload, "FAILED", "", err.Error())
+		return err
 	}
 
-	// 从 package_path 构建完整的下载URL
-	// 例如: package_path = "CDN/Core/20240626-story_container-c54239edb10ebf9266c4b97df0418e3b181e6fd6/"
-	packageDirURL := downloadBaseURL + buildInfo.PackagePath
-
-	// 获取目录下的文件列表，找到对应的包文件
-	packageFileName, err := s.findPackageFile(packageDirURL, testItem.Name)
+	// 列出构建的文件，按测试项的选择规则找到对应的包文件
+	selection, err := s.resolvePackageSelection(ctx, source, buildInfo, testItem)
+	if err == nil && selection.Selected == "" {
+		err = fmt.Errorf("%s in %s", selection.Error, source.Location(buildInfo))
+	}
 	if err != nil {
 		s.addStep(deployTestRun.ID, models.StepDownload, "FAILED", "", fmt.Sprintf("Failed to find package file: %v", err))
 		return err
 	}
 
-	downloadURL := packageDirURL + packageFileName
+	packageFileName := selection.Selected
+	downloadURL := selection.DownloadURL
 
-	// 设置本地下载路径
-	downloadDir := "/tmp"
-	if _, err := os.Stat(downloadDir); os.IsNotExist(err) {
-		if err := os.MkdirAll(downloadDir, 0755); err != nil {
-			s.addStep(deployTestRun.ID, models.StepDownload, "FAILED", "", fmt.Sprintf("Failed to create download directory: %v", err))
-			return err
-		}
+	// 获取期望的校验和，同时用于计算缓存键
+	expected, err := s.expectedPackageChecksum(ctx, source, downloadURL, buildInfo)
+	if err != nil {
+		s.addStep(deployTestRun.ID, models.StepDownload, "FAILED", "", fmt.Sprintf("Failed to get package checksum: %v", err))
+		return err
+	}
+	var cacheKey string
+	if expected != nil {
+		cacheKey = packageCacheKey(downloadURL, 0, expected)
+	} else {
+		cacheKey = packageCacheKey(downloadURL, remoteSize(ctx, source, downloadURL), nil)
 	}
 
-	downloadPath := filepath.Join(downloadDir, packageFileName)
+	// 同一个包同时只下载一次，其他运行等待后直接复用缓存
+	unlock := lockPackageKey(cacheKey)
+	defer unlock()
+
+	// 重新执行下载步骤时先释放之前持有的缓存引用
+	s.packageCache.Release(deployTestRun.ID)
+
+	if entry, err := s.packageCache.AcquireCached(cacheKey, deployTestRun.ID); err != nil {
+		log.Printf("Failed to look up package cache for run ID %d: %v", deployTestRun.ID, err)
+	} else if entry != nil {
+		log.Printf("Reusing cached package for run ID %d: %s", deployTestRun.ID, entry.Path)
+		config.DB.Model(&models.DeployTestRun{}).Where("id = ?", deployTestRun.ID).Updates(map[string]interface{}{
+			"download_url":   downloadURL,
+			"download_path":  entry.Path,
+			"package_sha256": entry.SHA256,
+			"status":         models.DeployTestStatusDownloaded,
+		})
+		publishRunStatus(deployTestRun.ID)
+		s.addStep(deployTestRun.ID, models.StepDownload, "COMPLETED", fmt.Sprintf("Reused cached package %s", entry.Path), "")
+		return nil
+	}
+
+	// 设置本地下载路径
+	downloadPath := s.packageCache.EntryPath(cacheKey, packageFileName)
+	if err := os.MkdirAll(filepath.Dir(downloadPath), 0755); err != nil {
+		s.addStep(deployTestRun.ID, models.StepDownload, "FAILED", "", fmt.Sprintf("Failed to create download directory: %v", err))
+		return err
+	}
 
 	// 记录下载开始
 	log.Printf("Starting download for run ID %d:", deployTestRun.ID)
@@ -269,7 +375,14 @@ func (s *DeployTestService) downloadPackage(deployTestRun *models.DeployTestRun,
 	log.Printf("  Download Path: %s", downloadPath)
 
 	// 下载文件
-	if err := s.downloadFile(downloadURL, downloadPath); err != nil {
+	maxRetries := defaultDownloadMaxRetries
+	if value, err := strconv.Atoi(settings["download_max_retries"]); err == nil && value >= 0 {
+		maxRetries = value
+	}
+	reportProgress := func(progress downloadProgress) {
+		s.addStep(deployTestRun.ID, models.StepDownload, "RUNNING", progress.String(), "")
+	}
+	if err := s.downloadFile(ctx, source, downloadURL, downloadPath, deployTestRun.DownloadTimeout, maxRetries, reportProgress); err != nil {
 		log.Printf("Download failed for run ID %d: %v", deployTestRun.ID, err)
 		s.addStep(deployTestRun.ID, models.StepDownload, "FAILED", "", fmt.Sprintf("Failed to download file: %v", err))
 		return err
@@ -297,122 +410,241 @@ func (s *DeployTestService) downloadPackage(deployTestRun *models.DeployTestRun,
 
 	log.Printf("Download successful for run ID %d: %s (size: %d bytes)", deployTestRun.ID, downloadPath, fileInfo.Size())
 
+	// 校验包文件完整性
+	verification, err := s.verifyPackage(ctx, source, downloadURL, downloadPath, expected, settings)
+	if err != nil {
+		log.Printf("Package verification failed for run ID %d: %v", deployTestRun.ID, err)
+		os.Remove(downloadPath)
+		s.addStep(deployTestRun.ID, models.StepDownload, "FAILED", "", fmt.Sprintf("Package verification failed: %v", err))
+		return err
+	}
+
+	// 登记到共享缓存并持有引用，运行结束后释放
+	if _, err := s.packageCache.StoreAndAcquire(cacheKey, downloadURL, packageFileName, downloadPath, fileInfo.Size(), verification.SHA256, deployTestRun.ID); err != nil {
+		log.Printf("Failed to register cached package for run ID %d: %v", deployTestRun.ID, err)
+	}
+	go s.packageCache.Evict()
+
 	// 更新记录
 	config.DB.Model(&models.DeployTestRun{}).Where("id = ?", deployTestRun.ID).Updates(map[string]interface{}{
-		"download_url":  downloadURL,
-		"download_path": downloadPath,
-		"status":        models.DeployTestStatusDownloaded,
+		"download_url":   downloadURL,
+		"download_path":  downloadPath,
+		"package_sha256": verification.SHA256,
+		"status":         models.DeployTestStatusDownloaded,
 	})
+	publishRunStatus(deployTestRun.ID)
 
-	s.addStep(deployTestRun.ID, models.StepDownload, "COMPLETED", fmt.Sprintf("Package downloaded to %s", downloadPath), "")
+	details := fmt.Sprintf("Package downloaded to %s", downloadPath)
+	if len(verification.Details) > 0 {
+		details += " (" + strings.Join(verification.Details, ", ") + ")"
+	}
+	s.addStep(deployTestRun.ID, models.StepDownload, "COMPLETED", details, "")
 	return nil
 }
 
-// findPackageFile 从目录URL中找到对应的包文件
-func (s *DeployTestService) findPackageFile(dirURL, testItemName string) (string, error) {
-	// 发送HTTP请求获取目录列表
-	resp, err := http.Get(dirURL)
-	if err != nil {
-		return "", fmt.Errorf("failed to get directory listing: %v", err)
+// downloadFile 下载文件到本地
+//
+// 先写入 <文件名>.part，网络中断时按 HTTP Range 从已下载的位置续传，最多重试 maxRetries 次，
+// 下载完成后重命名为目标文件。onProgress 定期接收下载进度。本地制品 (file://) 直接复制。
+func (s *DeployTestService) downloadFile(ctx context.Context, source ArtifactSource, url, filepath string, timeoutMinutes, maxRetries int, onProgress func(downloadProgress)) error {
+	if timeoutMinutes <= 0 {
+		timeoutMinutes = models.DefaultDownloadTimeoutMinutes
 	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("failed to read directory listing: %v", err)
+	if maxRetries < 0 {
+		maxRetries = 0
 	}
 
-	content := string(body)
-
-	// 根据需求实现新的包文件查找逻辑
-	// 使用正则表达式匹配包含测试项名称的href链接
-	testItemLower := strings.ToLower(testItemName)
-	pattern := fmt.Sprintf(`href="([^"]*%s[^"]*)"`, testItemLower)
-	re := regexp.MustCompile(pattern)
-	matches := re.FindAllStringSubmatch(content, -1)
+	// 创建HTTP客户端，设置单次尝试的超时
+	client := &http.Client{
+		Timeout: time.Duration(timeoutMinutes) * time.Minute,
+	}
 
-	var candidates []string
-	for _, match := range matches {
-		if len(match) > 1 {
-			candidates = append(candidates, match[1])
+	partPath := filepath + ".part"
+	// 来源不允许的地址不会在重试后变为可用
+	localPath, isLocal, err := localArtifactPath(source, url)
+	if err != nil {
+		return classifyError(models.FailureClassIntegrity, err)
+	}
+	if isLocal {
+		if err := copyLocalArtifact(ctx, localPath, partPath, onProgress); err != nil {
+			return err
 		}
+		if err := os.Rename(partPath, filepath); err != nil {
+			return fmt.Errorf("failed to move downloaded file into place: %v", err)
+		}
+		return nil
 	}
 
-	if len(candidates) == 0 {
-		return "", fmt.Errorf("no package files found for test item: %s", testItemName)
-	}
+	var lastErr error
+	for attempt := 1; attempt <= maxRetries+1; attempt++ {
+		if attempt > 1 {
+			// 指数退避: 5s, 10s, 20s ...
+			backoff := time.Duration(1<<(attempt-2)) * 5 * time.Second
+			log.Printf("Retrying download of %s in %v (attempt %d/%d): %v", url, backoff, attempt, maxRetries+1, lastErr)
+			if err := sleepWithContext(ctx, backoff); err != nil {
+				return err
+			}
+		}
 
-	// 如果找到多个候选文件，优先选择包含"release"的文件
-	var selectedFile string
-	for _, candidate := range candidates {
-		if strings.Contains(strings.ToLower(candidate), "release") {
-			selectedFile = candidate
+		retryable, err := s.downloadAttempt(ctx, client, source, url, partPath, attempt, onProgress)
+		if err == nil {
+			if err := os.Rename(partPath, filepath); err != nil {
+				return fmt.Errorf("failed to move downloaded file into place: %v", err)
+			}
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		lastErr = err
+		if !retryable {
 			break
 		}
 	}
 
-	// 如果没有找到包含"release"的文件，选择第一个候选文件
-	if selectedFile == "" {
-		selectedFile = candidates[0]
+	return lastErr
+}
+
+// downloadAttempt 执行一次下载，已有部分文件时请求剩余部分
+//
+// 返回的 retryable 表示失败后是否值得重试。
+func (s *DeployTestService) downloadAttempt(ctx context.Context, client *http.Client, source ArtifactSource, url, partPath string, attempt int, onProgress func(downloadProgress)) (bool, error) {
+	var offset int64
+	if info, err := os.Stat(partPath); err == nil {
+		offset = info.Size()
 	}
 
-	// 验证文件是否以.tar.gz或.tgz结尾
-	selectedFileLower := strings.ToLower(selectedFile)
-	if !strings.HasSuffix(selectedFileLower, ".tar.gz") && !strings.HasSuffix(selectedFileLower, ".tgz") {
-		return "", fmt.Errorf("selected file is not a tar.gz or tgz file: %s", selectedFile)
+	req, err := newArtifactRequest(ctx, source, "GET", url)
+	if err != nil {
+		return false, fmt.Errorf("failed to create request: %v", err)
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
 	}
 
-	return selectedFile, nil
-}
+	resp, err := client.Do(req)
+	if err != nil {
+		return true, fmt.Errorf("HTTP request failed: %v", err)
+	}
+	defer resp.Body.Close()
 
-// downloadFile 下载文件到本地
-func (s *DeployTestService) downloadFile(url, filepath string) error {
-	// 创建HTTP客户端，设置超时
-	client := &http.Client{
-		Timeout: 10 * time.Minute, // 10分钟超时
+	flags := os.O_CREATE | os.O_WRONLY
+	total := resp.ContentLength
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		flags |= os.O_APPEND
+		if total >= 0 {
+			total += offset
+		}
+	case http.StatusOK:
+		// 服务器不支持 Range 时从头下载
+		offset = 0
+		flags |= os.O_TRUNC
+		// 检查Content-Length
+		if resp.ContentLength == 0 {
+			return false, fmt.Errorf("server returned empty content (Content-Length: 0)")
+		}
+	case http.StatusRequestedRangeNotSatisfiable:
+		// 部分文件与远程文件不一致，删除后重新下载
+		os.Remove(partPath)
+		return true, fmt.Errorf("range not satisfiable for partial download at %d bytes", offset)
+	default:
+		retryable := resp.StatusCode >= http.StatusInternalServerError || resp.StatusCode == http.StatusTooManyRequests
+		return retryable, fmt.Errorf("failed to download file, status code: %d, status: %s", resp.StatusCode, resp.Status)
 	}
 
-	resp, err := client.Get(url)
+	out, err := os.OpenFile(partPath, flags, 0644)
 	if err != nil {
-		return fmt.Errorf("HTTP request failed: %v", err)
+		return false, fmt.Errorf("failed to create file: %v", err)
+	}
+	defer out.Close()
+
+	progress := newProgressWriter(downloadProgress{
+		FileName:    filepath.Base(strings.TrimSuffix(partPath, ".part")),
+		Downloaded:  offset,
+		Total:       total,
+		Attempt:     attempt,
+		ResumedFrom: offset,
+	}, onProgress)
+
+	// 复制内容并检查写入的字节数
+	written, err := io.Copy(io.MultiWriter(out, progress), resp.Body)
+	progress.report()
+	if err != nil {
+		return true, fmt.Errorf("failed to write file content after %d bytes: %v", offset+written, err)
+	}
+
+	if offset+written == 0 {
+		return false, fmt.Errorf("no data written to file")
+	}
+	if total > 0 && offset+written != total {
+		return true, fmt.Errorf("incomplete download: got %d of %d bytes", offset+written, total)
+	}
+
+	// 确保文件内容被写入磁盘
+	if err := out.Sync(); err != nil {
+		return false, fmt.Errorf("failed to sync file to disk: %v", err)
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("failed to download file, status code: %d, status: %s", resp.StatusCode, resp.Status)
+	return false, nil
+}
+
+// copyLocalArtifact 复制本地或 NFS 上的包文件，context 取消时中止
+func copyLocalArtifact(ctx context.Context, localPath, partPath string, onProgress func(downloadProgress)) error {
+	in, err := os.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to open package file: %v", err)
 	}
+	defer in.Close()
 
-	// 检查Content-Length
-	if resp.ContentLength == 0 {
-		return fmt.Errorf("server returned empty content (Content-Length: 0)")
+	info, err := in.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat package file: %v", err)
 	}
 
-	out, err := os.Create(filepath)
+	out, err := os.Create(partPath)
 	if err != nil {
 		return fmt.Errorf("failed to create file: %v", err)
 	}
 	defer out.Close()
 
-	// 复制内容并检查写入的字节数
-	written, err := io.Copy(out, resp.Body)
+	progress := newProgressWriter(downloadProgress{
+		FileName: filepath.Base(localPath),
+		Total:    info.Size(),
+		Attempt:  1,
+	}, onProgress)
+
+	written, err := io.Copy(io.MultiWriter(out, progress), &contextReader{ctx: ctx, reader: in})
+	progress.report()
 	if err != nil {
-		return fmt.Errorf("failed to write file content: %v", err)
+		return fmt.Errorf("failed to copy file content: %v", err)
 	}
-
-	if written == 0 {
-		return fmt.Errorf("no data written to file")
+	if written != info.Size() {
+		return fmt.Errorf("incomplete copy: got %d of %d bytes", written, info.Size())
 	}
 
 	// 确保文件内容被写入磁盘
 	if err := out.Sync(); err != nil {
 		return fmt.Errorf("failed to sync file to disk: %v", err)
 	}
-
 	return nil
 }
 
+// contextReader context 取消后读取返回错误
+type contextReader struct {
+	ctx    context.Context
+	reader io.Reader
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.reader.Read(p)
+}
+
 // triggerExternalTest 触发外部测试服务器的测试
-func (s *DeployTestService) triggerExternalTest(deployTestRun *models.DeployTestRun, testItem *models.TestItem, buildInfo *models.BuildInfo) error {
+func (s *DeployTestService) triggerExternalTest(ctx context.Context, deployTestRun *models.DeployTestRun, testItem *models.TestItem, buildInfo *models.BuildInfo) error {
 	s.updateDeployTestStatus(deployTestRun.ID, models.DeployTestStatusTesting, "")
 	s.addStep(deployTestRun.ID, models.StepTest, "RUNNING", "Triggering external test", "")
 
@@ -450,68 +682,65 @@ func (s *DeployTestService) triggerExternalTest(deployTestRun *models.DeployTest
 		return err
 	}
 
-	// 构建请求URL
-	testServerURL := settings["external_test_server_url"]
-	if !strings.HasSuffix(testServerURL, "/") {
-		testServerURL += "/"
-	}
-	// requestURL := testServerURL + "api/deploy_and_test_mock"
-	requestURL := testServerURL + "api/deploy_and_test"
-
-	// 构建请求体
-	requestBody := map[string]interface{}{
-		"service_name":   params.ServiceName,
-		"package_path":   deployTestRun.DownloadPath,
-		"install_dir":    params.InstallDir,
-		"upgrade_type":   params.UpgradeType,
-		"test_path":      params.TestPath,
-		"base_url":       params.BaseURL,
-		"report_keyword": params.ReportKeyword,
+	runner, err := s.testRunnerFor(testItem)
+	if err != nil {
+		s.addStep(deployTestRun.ID, models.StepTest, "FAILED", "", err.Error())
+		return err
 	}
 
-	// 记录发送的请求体
-	log.Printf("Sending test request for run ID %d:", deployTestRun.ID)
-	log.Printf("  service_name: %s", params.ServiceName)
-	log.Printf("  package_path: %s", deployTestRun.DownloadPath)
-	log.Printf("  install_dir: %s", params.InstallDir)
-
-	// 发送请求
-	response, err := s.httpClient.SendRequest("POST", requestURL, map[string]string{
-		"Content-Type": "application/json",
-	}, requestBody, 300)
-
+	// 从服务器池中分配测试服务器
+	server, err := s.acquireTestServer(ctx, deployTestRun.ID, testItem, settings)
 	if err != nil {
-		s.addStep(deployTestRun.ID, models.StepTest, "FAILED", "", fmt.Sprintf("HTTP request failed: %v", err))
+		s.addStep(deployTestRun.ID, models.StepTest, "FAILED", "", fmt.Sprintf("Failed to assign test server: %v", err))
 		return err
 	}
-
-	if response.StatusCode != 200 {
-		s.addStep(deployTestRun.ID, models.StepTest, "FAILED", "", fmt.Sprintf("HTTP status code: %d, body: %s", response.StatusCode, response.Body))
-		return fmt.Errorf("unexpected status code: %d", response.StatusCode)
+	transferMode := server.TransferMode
+	if transferMode == "" {
+		transferMode = transferModeFor(settings, server.URL)
 	}
 
-	// 解析响应获取task_id
-	var respData map[string]interface{}
-	if err := json.Unmarshal([]byte(response.Body), &respData); err != nil {
-		s.addStep(deployTestRun.ID, models.StepTest, "FAILED", "", fmt.Sprintf("Failed to parse response: %v", err))
+	// 配置了测试阶段时部署任务只部署，测试在部署完成后分阶段提交
+	stages, err := resolveTestStages(testItem, params)
+	if err != nil {
+		s.addStep(deployTestRun.ID, models.StepTest, "FAILED", "", err.Error())
 		return err
 	}
-
-	taskID, ok := respData["task_id"].(string)
-	if !ok {
-		s.addStep(deployTestRun.ID, models.StepTest, "FAILED", "", "No task_id in response")
-		return fmt.Errorf("no task_id in response")
+	if stages != nil {
+		params.TestPath = models.DeployOnlyTestPath
+	}
+
+	// 按测试项配置的执行后端提交测试，提交期间运行被取消时不再写入任务ID
+	result, err := submitTestTask(ctx, runner, &TestSubmission{
+		Run:          deployTestRun,
+		Params:       params,
+		ServerURL:    server.URL,
+		TransferMode: transferMode,
+		Settings:     settings,
+	}, func(taskID string) (bool, error) {
+		updated := config.DB.Model(&models.DeployTestRun{}).
+			Where("id = ? AND status <> ?", deployTestRun.ID, models.DeployTestStatusCancelled).
+			Update("task_id", taskID)
+		return updated.RowsAffected > 0, updated.Error
+	})
+	if errors.Is(err, errRunCancelled) {
+		return err
+	}
+	if err != nil {
+		if server.ID != nil && failureClassOf(models.StepTest, err) == models.FailureClassTestServerUnavailable {
+			markTestServerUnhealthy(*server.ID, err.Error())
+		}
+		s.addStep(deployTestRun.ID, models.StepTest, "FAILED", "", err.Error())
+		return err
 	}
 
-	// 更新记录
-	config.DB.Model(&models.DeployTestRun{}).Where("id = ?", deployTestRun.ID).Update("task_id", taskID)
-
-	s.addStep(deployTestRun.ID, models.StepTest, "COMPLETED", fmt.Sprintf("Test triggered, task_id: %s", taskID), "")
+	s.addStep(deployTestRun.ID, models.StepTest, "COMPLETED", fmt.Sprintf("Test triggered on %s via %s runner, task_id: %s, %s", server.URL, runner.Type(), result.TaskID, result.Details), "")
 	return nil
 }
 
 // monitorTestProgress 监控测试进度
-func (s *DeployTestService) monitorTestProgress(deployTestRun *models.DeployTestRun) error {
+//
+// 测试项配置了测试阶段时，部署任务完成后依次或同时执行各阶段，见 runTestStages。
+func (s *DeployTestService) monitorTestProgress(ctx context.Context, deployTestRun *models.DeployTestRun) error {
 	s.updateDeployTestStatus(deployTestRun.ID, models.DeployTestStatusMonitoring, "")
 	s.addStep(deployTestRun.ID, models.StepMonitor, "RUNNING", "Monitoring test progress", "")
 
@@ -526,7 +755,7 @@ func (s *DeployTestService) monitorTestProgress(deployTestRun *models.DeployTest
 		return err
 	}
 
-	// 获取测试项信息以检查test_path
+	// 获取测试项信息以检查测试阶段
 	var testItem models.TestItem
 	if err := config.DB.First(&testItem, deployTestRun.TestItemID).Error; err != nil {
 		return err
@@ -538,6 +767,11 @@ func (s *DeployTestService) monitorTestProgress(deployTestRun *models.DeployTest
 		s.addStep(deployTestRun.ID, models.StepMonitor, "FAILED", "", fmt.Sprintf("Failed to get test parameters: %v", err))
 		return err
 	}
+	stages, err := resolveTestStages(&testItem, params)
+	if err != nil {
+		s.addStep(deployTestRun.ID, models.StepMonitor, "FAILED", "", err.Error())
+		return err
+	}
 	// 获取系统设置
 	settings, err := s.systemUtils.GetSystemSettings()
 	if err != nil {
@@ -545,118 +779,93 @@ func (s *DeployTestService) monitorTestProgress(deployTestRun *models.DeployTest
 		return err
 	}
 
-	testServerURL := settings["external_test_server_url"]
-	if !strings.HasSuffix(testServerURL, "/") {
-		testServerURL += "/"
+	runner, err := s.testRunnerFor(&testItem)
+	if err != nil {
+		s.addStep(deployTestRun.ID, models.StepMonitor, "FAILED", "", err.Error())
+		return err
 	}
-	statusURL := fmt.Sprintf("%sapi/tasks/%s", testServerURL, deployTestRun.TaskID)
 
-	// 设置监控参数
-	maxDuration := time.Duration(deployTestRun.MaxQueryHours) * time.Hour
-	queryInterval := time.Duration(deployTestRun.QueryInterval) * time.Second
-	queryTimeout := deployTestRun.QueryTimeout
+	// 设置监控参数，超时时间包含部署和所有测试阶段
+	mc := &monitorContext{
+		ctx:       ctx,
+		run:       deployTestRun,
+		params:    params,
+		runner:    runner,
+		settings:  settings,
+		serverURL: runTestServerURL(deployTestRun, settings),
+		deadline:  time.Now().Add(time.Duration(deployTestRun.MaxQueryHours) * time.Hour),
+		stages:    stages,
+	}
 
-	startTime := time.Now()
+	// 恢复运行时已有测试阶段记录说明部署已完成
+	var stageCount int64
+	if stages != nil {
+		config.DB.Model(&models.DeployTestStage{}).Where("deploy_test_run_id = ?", deployTestRun.ID).Count(&stageCount)
+	}
+	if stageCount > 0 {
+		return s.runTestStages(mc)
+	}
 
-	for {
-		// 检查是否超时
-		if time.Since(startTime) > maxDuration {
-			err := fmt.Errorf("monitoring timeout after %d hours", deployTestRun.MaxQueryHours)
+	taskStatus, err := s.waitForTask(mc, TestTask{
+		ServerURL:      mc.serverURL,
+		TaskID:         deployTestRun.TaskID,
+		TimeoutSeconds: deployTestRun.QueryTimeout,
+	}, nil)
+	if err != nil {
+		if ctx.Err() == nil {
 			s.addStep(deployTestRun.ID, models.StepMonitor, "FAILED", "", err.Error())
-			return err
-		}
-
-		// 查询任务状态
-		response, err := s.httpClient.SendRequest("GET", statusURL, nil, nil, queryTimeout)
-		if err != nil {
-			log.Printf("Failed to query task status: %v", err)
-			time.Sleep(queryInterval)
-			continue
 		}
+		return err
+	}
+	if stages != nil && len(stages.Stages) > 0 && taskStatus.State == TaskStateCompleted {
+		return s.runTestStages(mc)
+	}
+	return s.applyTaskStatus(deployTestRun, stages != nil, taskStatus)
+}
 
-		if response.StatusCode != 200 {
-			log.Printf("Unexpected status code when querying task: %d", response.StatusCode)
-			time.Sleep(queryInterval)
-			continue
+// applyTaskStatus 根据已结束的外部任务写入运行的最终状态，deployOnly 为 true 时完成状态为 DEPLOY_COMPLETE
+func (s *DeployTestService) applyTaskStatus(deployTestRun *models.DeployTestRun, deployOnly bool, taskStatus *TaskStatus) error {
+	if taskStatus.State == TaskStateCompleted {
+		reportURL := taskStatus.ReportURL
+
+		// 根据是否为仅部署模式选择不同的状态
+		var finalStatus string
+		var stepMessage string
+		if deployOnly {
+			finalStatus = models.DeployTestStatusDeployComplete
+			stepMessage = "Deploy completed successfully"
+		} else {
+			finalStatus = models.DeployTestStatusCompleted
+			stepMessage = fmt.Sprintf("Test completed, report URL: %s", reportURL)
 		}
 
-		// 解析响应
-		var taskStatus map[string]interface{}
-		if err := json.Unmarshal([]byte(response.Body), &taskStatus); err != nil {
-			log.Printf("Failed to parse task status response: %v", err)
-			time.Sleep(queryInterval)
-			continue
+		// 更新记录
+		updates := map[string]interface{}{
+			"status":            finalStatus,
+			"report_url":        reportURL,
+			"response_raw_data": taskStatus.Raw,
+			"finished_at":       time.Now(),
 		}
+		config.DB.Model(&models.DeployTestRun{}).Where("id = ? AND status <> ?", deployTestRun.ID, models.DeployTestStatusCancelled).Updates(updates)
+		publishRunStatus(deployTestRun.ID)
 
-		status, _ := taskStatus["status"].(string)
-		log.Printf("Task %s status: %s", deployTestRun.TaskID, status)
-
-		switch status {
-		case "completed":
-			// 检查是否为仅部署模式 (test_path == "deploy")
-			isDeployOnly := params.TestPath == "deploy"
-
-			var reportURL string
-			if result, ok := taskStatus["result"].(map[string]interface{}); ok {
-				if testResult, ok := result["test"].(map[string]interface{}); ok {
-					if url, ok := testResult["report_url"].(string); ok && url != "None" {
-						reportURL = url
-					}
-				}
-			}
-
-			// 存储原始响应数据
-			responseRawData, _ := json.Marshal(taskStatus)
-
-			// 根据是否为仅部署模式选择不同的状态
-			var finalStatus string
-			var stepMessage string
-			if isDeployOnly {
-				finalStatus = models.DeployTestStatusDeployComplete
-				stepMessage = "Deploy completed successfully"
-			} else {
-				finalStatus = models.DeployTestStatusCompleted
-				stepMessage = fmt.Sprintf("Test completed, report URL: %s", reportURL)
-			}
-
-			// 更新记录
-			updates := map[string]interface{}{
-				"status":            finalStatus,
-				"report_url":        reportURL,
-				"response_raw_data": responseRawData,
-				"finished_at":       time.Now(),
-			}
-			config.DB.Model(&models.DeployTestRun{}).Where("id = ?", deployTestRun.ID).Updates(updates)
-
-			s.addStep(deployTestRun.ID, models.StepMonitor, "COMPLETED", stepMessage, "")
-			return nil
-
-		case "failed":
-			errorMsg := ""
-			if errField, ok := taskStatus["error"].(string); ok {
-				errorMsg = errField
-			}
-
-			updates := map[string]interface{}{
-				"status":        models.DeployTestStatusFailed,
-				"error_message": errorMsg,
-				"finished_at":   time.Now(),
-			}
-			config.DB.Model(&models.DeployTestRun{}).Where("id = ?", deployTestRun.ID).Updates(updates)
-
-			err := fmt.Errorf("external test failed: %s", errorMsg)
-			s.addStep(deployTestRun.ID, models.StepMonitor, "FAILED", "", err.Error())
-			return err
+		s.addStep(deployTestRun.ID, models.StepMonitor, "COMPLETED", stepMessage, "")
+		return nil
+	}
 
-		case "pending", "running":
-			// 继续等待
-			time.Sleep(queryInterval)
+	errorMsg := taskStatus.Error
 
-		default:
-			log.Printf("Unknown task status: %s", status)
-			time.Sleep(queryInterval)
-		}
+	updates := map[string]interface{}{
+		"status":        models.DeployTestStatusFailed,
+		"error_message": errorMsg,
+		"finished_at":   time.Now(),
 	}
+	config.DB.Model(&models.DeployTestRun{}).Where("id = ? AND status <> ?", deployTestRun.ID, models.DeployTestStatusCancelled).Updates(updates)
+	publishRunStatus(deployTestRun.ID)
+
+	err := fmt.Errorf("external test failed: %s", errorMsg)
+	s.addStep(deployTestRun.ID, models.StepMonitor, "FAILED", "", err.Error())
+	return classifyError(models.FailureClassTestFailed, err)
 }
 
 // sendNotification 发送通知
@@ -669,6 +878,12 @@ func (s *DeployTestService) sendNotification(deployTestRun *models.DeployTestRun
 		return
 	}
 
+	// 运行组中的运行由运行组在全部结束后发送一封汇总通知
+	if deployTestRun.RunGroupID != nil {
+		s.addStep(deployTestRun.ID, models.StepNotify, "COMPLETED", fmt.Sprintf("Notification deferred to run group %d", *deployTestRun.RunGroupID), "")
+		return
+	}
+
 	// 检查是否为仅部署模式，仅部署模式不发送邮件通知
 	if deployTestRun.Status == models.DeployTestStatusDeployComplete {
 		s.addStep(deployTestRun.ID, models.StepNotify, "COMPLETED", "Deploy complete - no notification required", "")
@@ -698,6 +913,31 @@ func (s *DeployTestService) sendNotification(deployTestRun *models.DeployTestRun
 
 // getTestParameters 获取测试参数配置
 func (s *DeployTestService) getTestParameters(parameterSetID *uint, testItem *models.TestItem) (*models.TestParameters, error) {
+	parameterSet, err := s.resolveParameterSet(parameterSetID, testItem)
+	if err != nil {
+		return nil, err
+	}
+
+	params, err := parameterSet.GetParameters()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse parameters: %v", err)
+	}
+
+	// 如果参数中的service_name为空，使用测试项名称
+	if params.ServiceName == "" {
+		params.ServiceName = testItem.Name
+	}
+
+	// 如果report_keyword为空，使用测试项名称
+	if params.ReportKeyword == "" {
+		params.ReportKeyword = testItem.Name
+	}
+
+	return params, nil
+}
+
+// resolveParameterSet 获取运行使用的参数集：指定的参数集、测试项关联的参数集或默认参数集
+func (s *DeployTestService) resolveParameterSet(parameterSetID *uint, testItem *models.TestItem) (*models.ParameterSet, error) {
 	var parameterSet models.ParameterSet
 
 	if parameterSetID != nil {
@@ -717,22 +957,7 @@ func (s *DeployTestService) getTestParameters(parameterSetID *uint, testItem *mo
 		}
 	}
 
-	params, err := parameterSet.GetParameters()
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse parameters: %v", err)
-	}
-
-	// 如果参数中的service_name为空，使用测试项名称
-	if params.ServiceName == "" {
-		params.ServiceName = testItem.Name
-	}
-
-	// 如果report_keyword为空，使用测试项名称
-	if params.ReportKeyword == "" {
-		params.ReportKeyword = testItem.Name
-	}
-
-	return params, nil
+	return &parameterSet, nil
 }
 
 // getInstallDir 根据服务名称获取安装目录
@@ -767,11 +992,33 @@ func (s *DeployTestService) updateDeployTestStatus(runID uint, status, errorMsg
 		updates["error_message"] = errorMsg
 	}
 
-	config.DB.Model(&models.DeployTestRun{}).Where("id = ?", runID).Updates(updates)
+	// 已取消的运行不再更新状态
+	config.DB.Model(&models.DeployTestRun{}).Where("id = ? AND status <> ?", runID, models.DeployTestStatusCancelled).Updates(updates)
+	publishRunStatus(runID)
 }
 
+// finalizeRun 将仍处于执行中状态的运行标记为完成
+func (s *DeployTestService) finalizeRun(runID uint) {
+	now := time.Now()
+	result := config.DB.Model(&models.DeployTestRun{}).
+		Where("id = ? AND status IN ?", runID, models.ActiveDeployTestStatuses).
+		Updates(map[string]interface{}{
+			"status":      models.DeployTestStatusCompleted,
+			"finished_at": &now,
+		})
+	if result.RowsAffected > 0 {
+		publishRunStatus(runID)
+	}
+}
+
+// addStepMutex 串行化步骤记录的读取和写入，并行执行的测试阶段会同时更新步骤
+var addStepMutex sync.Mutex
+
 // addStep 添加步骤记录
 func (s *DeployTestService) addStep(runID uint, stepName, status, details, errorMsg string) {
+	addStepMutex.Lock()
+	defer addStepMutex.Unlock()
+
 	var deployTestRun models.DeployTestRun
 	if err := config.DB.First(&deployTestRun, runID).Error; err != nil {
 		log.Printf("Failed to load deploy test run: %v", err)
@@ -836,6 +1083,13 @@ func (s *DeployTestService) addStep(runID uint, stepName, status, details, error
 	}
 
 	config.DB.Model(&models.DeployTestRun{}).Where("id = ?", runID).Update("steps", stepsJSON)
+
+	for i := range steps {
+		if steps[i].Name == stepName {
+			eventHub.Publish(EventRunStep, runID, deployTestRun.TestItemID, steps[i])
+			break
+		}
+	}
 }
 
 // GetDeployTestRuns 获取部署测试运行列表
@@ -856,8 +1110,8 @@ func (s *DeployTestService) GetDeployTestRuns(testItemID uint, limit, offset int
 		return nil, 0, err
 	}
 
-	// 获取分页数据
-	err := query.Order("started_at DESC").
+	// 获取分页数据，文件列表只在详情中返回
+	err := query.Omit("package_files").Order("started_at DESC").
 		Limit(limit).
 		Offset(offset).
 		Find(&runs).Error
@@ -868,7 +1122,9 @@ func (s *DeployTestService) GetDeployTestRuns(testItemID uint, limit, offset int
 // GetDeployTestRunByID 根据ID获取部署测试运行
 func (s *DeployTestService) GetDeployTestRunByID(id uint) (*models.DeployTestRun, error) {
 	var run models.DeployTestRun
-	err := config.DB.Preload("TestItem").Preload("BuildInfo").First(&run, id).Error
+	err := config.DB.Preload("TestItem").Preload("BuildInfo").Preload("Stages", func(db *gorm.DB) *gorm.DB {
+		return db.Order("seq ASC")
+	}).First(&run, id).Error
 	if err != nil {
 		return nil, err
 	}
@@ -940,56 +1196,54 @@ func (s *DeployTestService) monitorQueue() {
 	}()
 }
 
-// processNextInQueue 处理队列中的下一个测试
+// processNextInQueue 按优先级和并发限制处理队列中的测试
 func (s *DeployTestService) processNextInQueue() {
-	s.queueMutex.Lock()
-	defer s.queueMutex.Unlock()
-
-	// 检查当前是否有运行中的测试
-	var runningCount int64
-	runningStates := []string{"PENDING", "DOWNLOADING", "DEPLOYING", "TESTING", "MONITORING"}
-
-	if err := config.DB.Model(&models.DeployTestRun{}).
-		Where("status IN ?", runningStates).
-		Count(&runningCount).Error; err != nil {
-		log.Printf("Failed to count running tests: %v", err)
-		return
-	}
-
-	if runningCount >= 1 {
-		// 还有测试在运行，等待
-		return
-	}
+	dispatchMutex.Lock()
+	defer dispatchMutex.Unlock()
 
-	// 获取队列中的第一个测试
-	var queuedRun models.DeployTestRun
-	if err := config.DB.Where("status = ?", "QUEUED").
-		Order("id ASC").
-		First(&queuedRun).Error; err != nil {
-		// 队列为空或查询失败
-		return
-	}
+	s.dispatchQueueLocked()
+}
 
-	log.Printf("Processing queued test: run_id=%d", queuedRun.ID)
+// dispatchRun 启动一个排队中的测试，返回是否已开始执行
+func (s *DeployTestService) dispatchRun(queuedRun *models.DeployTestRun) bool {
+	log.Printf("Processing queued test: run_id=%d, priority=%d, environment=%s", queuedRun.ID, queuedRun.Priority, queuedRun.Environment)
 
 	// 获取测试项和构建信息
 	var testItem models.TestItem
 	if err := config.DB.First(&testItem, queuedRun.TestItemID).Error; err != nil {
 		log.Printf("Failed to get test item for queued run %d: %v", queuedRun.ID, err)
 		s.updateDeployTestStatus(queuedRun.ID, models.DeployTestStatusFailed, fmt.Sprintf("Failed to get test item: %v", err))
-		return
+		return false
 	}
 
 	buildInfo, err := s.buildService.GetBuildInfoByID(queuedRun.BuildInfoID)
 	if err != nil {
 		log.Printf("Failed to get build info for queued run %d: %v", queuedRun.ID, err)
 		s.updateDeployTestStatus(queuedRun.ID, models.DeployTestStatusFailed, fmt.Sprintf("Failed to get build info: %v", err))
-		return
+		return false
 	}
 
-	// 更新状态为 PENDING 并开始执行
-	s.updateDeployTestStatus(queuedRun.ID, models.DeployTestStatusPending, "")
+	// 更新状态为 PENDING 并开始执行，排队期间被取消的运行不再启动
+	now := time.Now()
+	result := config.DB.Model(&models.DeployTestRun{}).
+		Where("id = ? AND status = ?", queuedRun.ID, models.DeployTestStatusQueued).
+		Updates(map[string]interface{}{
+			"status":        models.DeployTestStatusPending,
+			"dispatched_at": &now,
+		})
+	if result.Error != nil {
+		log.Printf("Failed to dispatch queued run %d: %v", queuedRun.ID, result.Error)
+		return false
+	}
+	if result.RowsAffected == 0 {
+		return false
+	}
+	queuedRun.Status = models.DeployTestStatusPending
+	queuedRun.DispatchedAt = &now
+	publishRunStatus(queuedRun.ID)
+	publishQueueEvent("dispatched", queuedRun.ID)
 
 	// 异步执行部署测试流程
-	go s.executeDeployTest(&queuedRun, &testItem, buildInfo)
+	go s.executeDeployTest(queuedRun, &testItem, buildInfo)
+	return true
 }
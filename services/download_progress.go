@@ -0,0 +1,104 @@
+package services
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// downloadProgressInterval 下载进度写入步骤详情的最小间隔
+const downloadProgressInterval = 3 * time.Second
+
+// defaultDownloadMaxRetries 下载失败后默认的最大重试次数
+const defaultDownloadMaxRetries = 3
+
+// downloadProgress 下载进度
+type downloadProgress struct {
+	FileName       string
+	Downloaded     int64   // 已下载字节数 (含续传前已有的部分)
+	Total          int64   // 文件总大小，未知时为-1
+	BytesPerSecond float64 // 本次尝试的平均速度
+	Attempt        int     // 第几次尝试，从1开始
+	ResumedFrom    int64   // 续传起始位置，0表示从头下载
+}
+
+// String 格式化为步骤详情
+func (p downloadProgress) String() string {
+	text := fmt.Sprintf("Downloading %s: %s", p.FileName, formatBytes(p.Downloaded))
+	if p.Total > 0 {
+		text = fmt.Sprintf("Downloading %s: %.1f%% (%s / %s)", p.FileName,
+			float64(p.Downloaded)*100/float64(p.Total), formatBytes(p.Downloaded), formatBytes(p.Total))
+	}
+	text += fmt.Sprintf(" at %s/s", formatBytes(int64(p.BytesPerSecond)))
+	if p.Attempt > 1 {
+		text += fmt.Sprintf(", attempt %d", p.Attempt)
+	}
+	if p.ResumedFrom > 0 {
+		text += fmt.Sprintf(", resumed from %s", formatBytes(p.ResumedFrom))
+	}
+	return text
+}
+
+// formatBytes 以可读单位显示字节数
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// progressWriter 统计写入的字节数并定期回调下载进度
+type progressWriter struct {
+	mutex      sync.Mutex
+	progress   downloadProgress
+	written    int64 // 本次尝试写入的字节数
+	startedAt  time.Time
+	reportedAt time.Time
+	onProgress func(downloadProgress)
+}
+
+func newProgressWriter(progress downloadProgress, onProgress func(downloadProgress)) *progressWriter {
+	now := time.Now()
+	return &progressWriter{
+		progress:   progress,
+		startedAt:  now,
+		reportedAt: now,
+		onProgress: onProgress,
+	}
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	w.mutex.Lock()
+	w.written += int64(len(p))
+	w.progress.Downloaded += int64(len(p))
+	due := time.Since(w.reportedAt) >= downloadProgressInterval
+	w.mutex.Unlock()
+
+	if due {
+		w.report()
+	}
+	return len(p), nil
+}
+
+// report 立即回调当前进度
+func (w *progressWriter) report() {
+	if w.onProgress == nil {
+		return
+	}
+
+	w.mutex.Lock()
+	w.reportedAt = time.Now()
+	if elapsed := w.reportedAt.Sub(w.startedAt).Seconds(); elapsed > 0 {
+		w.progress.BytesPerSecond = float64(w.written) / elapsed
+	}
+	progress := w.progress
+	w.mutex.Unlock()
+
+	w.onProgress(progress)
+}
@@ -0,0 +1,230 @@
+package services
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"crat/config"
+	"crat/models"
+)
+
+// 事件类型
+const (
+	EventRunStatus = "run_status" // 运行状态变化
+	EventRunStep   = "run_step"   // 步骤新增或更新
+	EventQueue     = "queue"      // 队列变化: 加入、调整位置、暂停、恢复
+	EventRunGroup  = "run_group"  // 运行组统计或状态变化
+	EventReset     = "reset"      // 无法从 Last-Event-ID 续传，客户端需要重新获取完整状态
+)
+
+const (
+	// eventBufferSize 保留最近的事件数，用于 Last-Event-ID 续传
+	eventBufferSize = 1000
+	// eventSubscriberBuffer 每个订阅者未发送事件的上限，超出时断开订阅，由客户端续传
+	eventSubscriberBuffer = 256
+)
+
+// Event 推送给客户端的事件
+type Event struct {
+	ID         uint64      `json:"id"`
+	Type       string      `json:"type"`
+	RunID      uint        `json:"run_id,omitempty"`
+	TestItemID uint        `json:"test_item_id,omitempty"` // 与 RunID 都为 0 的事件 (例如队列变化) 不属于某个运行
+	Time       time.Time   `json:"time"`
+	Data       interface{} `json:"data"`
+}
+
+// EventFilter 订阅条件，为 0 或为空的字段不过滤
+type EventFilter struct {
+	TestItemID uint
+	RunID      uint
+	Types      []string // 只推送这些类型的事件
+}
+
+// IsEventType 判断是否为可以订阅的事件类型
+func IsEventType(eventType string) bool {
+	switch eventType {
+	case EventRunStatus, EventRunStep, EventQueue, EventRunGroup:
+		return true
+	}
+	return false
+}
+
+// requested 判断事件类型是否在订阅条件中显式指定
+func (f EventFilter) requested(eventType string) bool {
+	for _, t := range f.Types {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// matches 判断事件是否符合订阅条件
+//
+// 不属于某个运行的事件 (队列和运行组变化) 在按测试项或运行过滤时只推送给显式指定了该类型的订阅者。
+func (f EventFilter) matches(event *Event) bool {
+	if len(f.Types) > 0 && !f.requested(event.Type) {
+		return false
+	}
+	if event.TestItemID == 0 && event.RunID == 0 {
+		return (f.TestItemID == 0 && f.RunID == 0) || f.requested(event.Type)
+	}
+	if f.TestItemID != 0 && event.TestItemID != f.TestItemID {
+		return false
+	}
+	if f.RunID != 0 && event.RunID != f.RunID {
+		return false
+	}
+	return true
+}
+
+// EventSubscription 事件订阅，C 关闭表示订阅因积压被断开
+type EventSubscription struct {
+	C      <-chan Event
+	ch     chan Event
+	filter EventFilter
+}
+
+// EventHub 进程内的事件发布订阅中心
+type EventHub struct {
+	mutex       sync.Mutex
+	nextID      uint64
+	buffer      []Event // 最近的事件，按 ID 递增
+	subscribers map[*EventSubscription]struct{}
+}
+
+// eventHub 所有 DeployTestService 实例共享的事件中心
+//
+// 事件 ID 从启动时间开始递增，重启后客户端的 Last-Event-ID 早于缓冲区时收到 reset 事件。
+var eventHub = &EventHub{
+	nextID:      uint64(time.Now().UnixMicro()),
+	subscribers: make(map[*EventSubscription]struct{}),
+}
+
+// Events 获取事件中心
+func Events() *EventHub {
+	return eventHub
+}
+
+// Publish 发布事件
+func (h *EventHub) Publish(eventType string, runID, testItemID uint, data interface{}) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.nextID++
+	event := Event{
+		ID:         h.nextID,
+		Type:       eventType,
+		RunID:      runID,
+		TestItemID: testItemID,
+		Time:       time.Now(),
+		Data:       data,
+	}
+	h.buffer = append(h.buffer, event)
+	if len(h.buffer) > eventBufferSize {
+		h.buffer = append([]Event(nil), h.buffer[len(h.buffer)-eventBufferSize:]...)
+	}
+
+	for sub := range h.subscribers {
+		if !sub.filter.matches(&event) {
+			continue
+		}
+		select {
+		case sub.ch <- event:
+		default:
+			// 订阅者处理过慢，断开后由客户端通过 Last-Event-ID 续传
+			delete(h.subscribers, sub)
+			close(sub.ch)
+		}
+	}
+}
+
+// Subscribe 订阅事件，lastEventID 不为 0 时同时返回其后错过的事件
+//
+// 错过的事件已不在缓冲区时 resumed 为 false，客户端需要重新获取完整状态。
+func (h *EventHub) Subscribe(filter EventFilter, lastEventID uint64) (sub *EventSubscription, missed []Event, resumed bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	ch := make(chan Event, eventSubscriberBuffer)
+	sub = &EventSubscription{C: ch, ch: ch, filter: filter}
+	h.subscribers[sub] = struct{}{}
+
+	if lastEventID == 0 {
+		return sub, nil, true
+	}
+	if lastEventID > h.nextID || (len(h.buffer) > 0 && lastEventID+1 < h.buffer[0].ID) || (len(h.buffer) == 0 && lastEventID != h.nextID) {
+		return sub, nil, false
+	}
+	for i := range h.buffer {
+		if h.buffer[i].ID > lastEventID && filter.matches(&h.buffer[i]) {
+			missed = append(missed, h.buffer[i])
+		}
+	}
+	return sub, missed, true
+}
+
+// LastEventID 最近发布的事件 ID
+func (h *EventHub) LastEventID() uint64 {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.nextID
+}
+
+// Unsubscribe 取消订阅
+func (h *EventHub) Unsubscribe(sub *EventSubscription) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if _, ok := h.subscribers[sub]; ok {
+		delete(h.subscribers, sub)
+		close(sub.ch)
+	}
+}
+
+// publishRunStatus 发布运行的当前状态
+func publishRunStatus(runID uint) {
+	var run models.DeployTestRun
+	if err := config.DB.Select("id", "test_item_id", "status", "error_message", "failure_class", "report_url", "finished_at").
+		First(&run, runID).Error; err != nil {
+		log.Printf("Failed to load deploy test run %d for status event: %v", runID, err)
+		return
+	}
+	eventHub.Publish(EventRunStatus, run.ID, run.TestItemID, map[string]interface{}{
+		"status":        run.Status,
+		"error_message": run.ErrorMessage,
+		"failure_class": run.FailureClass,
+		"report_url":    run.ReportURL,
+		"finished_at":   run.FinishedAt,
+	})
+}
+
+// publishQueueEvent 发布队列变化
+func publishQueueEvent(action string, runID uint) {
+	data := map[string]interface{}{"action": action}
+	if runID != 0 {
+		data["run_id"] = runID
+	}
+	eventHub.Publish(EventQueue, 0, 0, data)
+}
+
+// publishRunGroupEvent 发布运行组的当前统计和状态
+func publishRunGroupEvent(groupID uint) {
+	var group models.RunGroup
+	if err := config.DB.First(&group, groupID).Error; err != nil {
+		log.Printf("Failed to load run group %d for event: %v", groupID, err)
+		return
+	}
+	eventHub.Publish(EventRunGroup, 0, 0, map[string]interface{}{
+		"run_group_id": group.ID,
+		"status":       group.Status,
+		"total":        group.Total,
+		"active":       group.Active,
+		"completed":    group.Completed,
+		"failed":       group.Failed,
+		"cancelled":    group.Cancelled,
+		"finished_at":  group.FinishedAt,
+	})
+}
@@ -0,0 +1,53 @@
+package services
+
+import "testing"
+
+func TestEventFilterMatches(t *testing.T) {
+	runStatus := &Event{Type: EventRunStatus, RunID: 42, TestItemID: 1}
+	otherRun := &Event{Type: EventRunStep, RunID: 43, TestItemID: 2}
+	queue := &Event{Type: EventQueue}
+	runGroup := &Event{Type: EventRunGroup}
+
+	tests := []struct {
+		name   string
+		filter EventFilter
+		event  *Event
+		want   bool
+	}{
+		{"no filter run event", EventFilter{}, runStatus, true},
+		{"no filter queue event", EventFilter{}, queue, true},
+		{"run filter matches run", EventFilter{RunID: 42}, runStatus, true},
+		{"run filter rejects other run", EventFilter{RunID: 42}, otherRun, false},
+		{"run filter rejects queue", EventFilter{RunID: 42}, queue, false},
+		{"run filter rejects run group", EventFilter{RunID: 42}, runGroup, false},
+		{"test item filter matches run", EventFilter{TestItemID: 1}, runStatus, true},
+		{"test item filter rejects other item", EventFilter{TestItemID: 1}, otherRun, false},
+		{"test item filter rejects queue", EventFilter{TestItemID: 1}, queue, false},
+		{"run filter with requested queue", EventFilter{RunID: 42, Types: []string{EventRunStatus, EventQueue}}, queue, true},
+		{"run filter with requested queue keeps run status", EventFilter{RunID: 42, Types: []string{EventRunStatus, EventQueue}}, runStatus, true},
+		{"run filter with requested queue rejects run group", EventFilter{RunID: 42, Types: []string{EventQueue}}, runGroup, false},
+		{"types filter rejects other types", EventFilter{Types: []string{EventQueue}}, runStatus, false},
+		{"types filter matches", EventFilter{Types: []string{EventRunGroup}}, runGroup, true},
+		{"requested type still filtered by run", EventFilter{RunID: 42, Types: []string{EventRunStep}}, otherRun, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.matches(tt.event); got != tt.want {
+				t.Errorf("matches = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsEventType(t *testing.T) {
+	for _, eventType := range []string{EventRunStatus, EventRunStep, EventQueue, EventRunGroup} {
+		if !IsEventType(eventType) {
+			t.Errorf("IsEventType(%q) = false", eventType)
+		}
+	}
+	for _, eventType := range []string{"", EventReset, "run"} {
+		if IsEventType(eventType) {
+			t.Errorf("IsEventType(%q) = true", eventType)
+		}
+	}
+}
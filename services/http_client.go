@@ -2,6 +2,7 @@ package services
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -10,19 +11,24 @@ import (
 )
 
 type HTTPClient struct {
-	client *http.Client
+	client  *http.Client
+	timeout time.Duration // 未指定超时的请求使用的默认超时
 }
 
 func NewHTTPClient() *HTTPClient {
 	return &HTTPClient{
-		client: &http.Client{
-			Timeout: 30 * time.Second,
-		},
+		client:  &http.Client{},
+		timeout: 30 * time.Second,
 	}
 }
 
 // SendRequest 发送HTTP请求
 func (h *HTTPClient) SendRequest(method, url string, headers map[string]string, body interface{}, timeoutSeconds int) (*HTTPResponse, error) {
+	return h.SendRequestWithContext(context.Background(), method, url, headers, body, timeoutSeconds)
+}
+
+// SendRequestWithContext 发送可通过context取消的HTTP请求
+func (h *HTTPClient) SendRequestWithContext(ctx context.Context, method, url string, headers map[string]string, body interface{}, timeoutSeconds int) (*HTTPResponse, error) {
 	var reqBody io.Reader
 	
 	if body != nil {
@@ -33,7 +39,15 @@ func (h *HTTPClient) SendRequest(method, url string, headers map[string]string,
 		reqBody = bytes.NewBuffer(jsonBody)
 	}
 
-	req, err := http.NewRequest(method, url, reqBody)
+	// 设置超时，client 被并发的请求共享，超时只作用于本次请求
+	timeout := h.timeout
+	if timeoutSeconds > 0 {
+		timeout = time.Duration(timeoutSeconds) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, method, url, reqBody)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %v", err)
 	}
@@ -48,11 +62,6 @@ func (h *HTTPClient) SendRequest(method, url string, headers map[string]string,
 		req.Header.Set("Content-Type", "application/json")
 	}
 
-	// 设置超时
-	if timeoutSeconds > 0 {
-		h.client.Timeout = time.Duration(timeoutSeconds) * time.Second
-	}
-
 	startTime := time.Now()
 	resp, err := h.client.Do(req)
 	duration := time.Since(startTime)
@@ -0,0 +1,49 @@
+package services
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSendRequestTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	defer close(release)
+
+	client := NewHTTPClient()
+	// 并发请求的超时互不影响，也不修改共享的 client
+	var wg sync.WaitGroup
+	var slowErr, fastErr error
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		_, slowErr = client.SendRequest("GET", server.URL+"/slow", nil, nil, 1)
+	}()
+	go func() {
+		defer wg.Done()
+		_, fastErr = client.SendRequest("GET", server.URL+"/fast", nil, nil, 60)
+	}()
+	wg.Wait()
+
+	if slowErr == nil {
+		t.Error("slow request succeeded, want timeout")
+	}
+	if fastErr != nil {
+		t.Errorf("fast request error = %v", fastErr)
+	}
+	if client.client.Timeout != 0 || client.timeout != 30*time.Second {
+		t.Errorf("shared client modified: client timeout %v, default %v", client.client.Timeout, client.timeout)
+	}
+}
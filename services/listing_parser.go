@@ -0,0 +1,343 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 目录列表格式，对应系统设置 package_listing_format 和 HTTP 制品来源的 listing_format
+const (
+	ListingFormatAuto      = "auto"       // 根据 Content-Type 和内容自动识别 (默认)
+	ListingFormatHTML      = "html"       // Apache / nginx autoindex HTML 页面
+	ListingFormatNginxJSON = "nginx_json" // nginx autoindex_format json
+	ListingFormatS3        = "s3"         // S3 / MinIO ListObjectsV2 XML
+)
+
+// maxListingPages S3 列表分页的最大页数
+const maxListingPages = 50
+
+// ArtifactFile 制品来源中的一个文件
+type ArtifactFile struct {
+	Name    string     // 解码后的文件名
+	URL     string     // 文件的完整下载URL，本地文件为 file:// 地址
+	Size    int64      // 文件大小，未知时为-1
+	ModTime *time.Time // 修改时间，未知时为nil
+}
+
+// directoryListing 解析后的一页目录列表
+type directoryListing struct {
+	Entries       []ArtifactFile
+	NextPageToken string // 还有下一页时的分页标记，目前只有 S3 使用
+}
+
+// ListingParser 解析包服务器返回的目录列表
+type ListingParser interface {
+	// Format 返回解析器对应的列表格式
+	Format() string
+	// Parse 解析目录列表，listingURL 为请求的地址，用于拼接文件的下载URL
+	Parse(body []byte, listingURL *url.URL) (*directoryListing, error)
+}
+
+// listingParsers 按格式注册的目录列表解析器
+var listingParsers = map[string]ListingParser{
+	ListingFormatHTML:      htmlListingParser{},
+	ListingFormatNginxJSON: nginxJSONListingParser{},
+	ListingFormatS3:        s3ListingParser{},
+}
+
+// ValidateListingFormat 校验目录列表格式设置
+func ValidateListingFormat(format string) error {
+	if format == "" || format == ListingFormatAuto {
+		return nil
+	}
+	if _, ok := listingParsers[format]; !ok {
+		return fmt.Errorf("unknown package listing format: %s", format)
+	}
+	return nil
+}
+
+// detectListingParser 根据 Content-Type 选择解析器，类型不明确时根据内容判断
+func detectListingParser(contentType string, body []byte) ListingParser {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		return listingParsers[ListingFormatNginxJSON]
+	case mediaType == "application/xml" || mediaType == "text/xml":
+		return listingParsers[ListingFormatS3]
+	case mediaType == "text/html":
+		return listingParsers[ListingFormatHTML]
+	}
+
+	trimmed := bytes.TrimSpace(body)
+	switch {
+	case bytes.HasPrefix(trimmed, []byte("[")):
+		return listingParsers[ListingFormatNginxJSON]
+	case bytes.HasPrefix(trimmed, []byte("<?xml")) || bytes.HasPrefix(trimmed, []byte("<ListBucketResult")):
+		return listingParsers[ListingFormatS3]
+	}
+	return listingParsers[ListingFormatHTML]
+}
+
+// fetchDirectoryListing 获取目录列表并按格式解析，format 为 auto 时自动识别
+//
+// 按 ListObjectsV2 (list-type=2) 请求时自动翻页，请求经 source 附加认证信息。
+func fetchDirectoryListing(ctx context.Context, source ArtifactSource, listingURL *url.URL, format string) ([]ArtifactFile, error) {
+	var entries []ArtifactFile
+	for page := 0; page < maxListingPages; page++ {
+		body, contentType, err := fetchListingPage(ctx, source, listingURL.String())
+		if err != nil {
+			return nil, err
+		}
+
+		parser, ok := listingParsers[format]
+		if !ok {
+			parser = detectListingParser(contentType, body)
+		}
+		listing, err := parser.Parse(body, listingURL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s directory listing: %v", parser.Format(), err)
+		}
+		entries = append(entries, listing.Entries...)
+
+		if listing.NextPageToken == "" {
+			return entries, nil
+		}
+		query := listingURL.Query()
+		if query.Get("list-type") != "2" {
+			log.Printf("Directory listing %s is truncated; set the listing format to s3 to read all pages", listingURL)
+			return entries, nil
+		}
+		query.Set("continuation-token", listing.NextPageToken)
+		listingURL.RawQuery = query.Encode()
+	}
+
+	log.Printf("Directory listing %s has more than %d pages, remaining pages ignored", listingURL, maxListingPages)
+	return entries, nil
+}
+
+// s3ListingURL 构建 ListObjectsV2 请求地址
+func s3ListingURL(bucketURL, prefix string) (*url.URL, error) {
+	listingURL, err := url.Parse(bucketURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid bucket URL: %v", err)
+	}
+	query := listingURL.Query()
+	query.Set("list-type", "2")
+	query.Set("prefix", prefix)
+	query.Set("delimiter", "/")
+	listingURL.RawQuery = query.Encode()
+	return listingURL, nil
+}
+
+// fetchListingPage 请求一页目录列表，返回内容和 Content-Type
+func fetchListingPage(ctx context.Context, source ArtifactSource, listingURL string) ([]byte, string, error) {
+	// 发送HTTP请求获取目录列表
+	req, err := newArtifactRequest(ctx, source, "GET", listingURL)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create directory listing request: %v", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get directory listing: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("failed to get directory listing, status code: %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read directory listing: %v", err)
+	}
+	return body, resp.Header.Get("Content-Type"), nil
+}
+
+// htmlListingParser 解析 Apache / nginx autoindex 生成的 HTML 页面
+type htmlListingParser struct{}
+
+var (
+	listingLinkPattern = regexp.MustCompile(`href="([^"]+)"`)
+	// nginx autoindex: 26-Jun-2024 10:20    123456
+	// Apache: 2024-06-26 10:20  117M
+	listingMetaPattern = regexp.MustCompile(`(\d{2}-[A-Za-z]{3}-\d{4} \d{2}:\d{2}|\d{4}-\d{2}-\d{2} \d{2}:\d{2})\D+?(\d+(?:\.\d+)?[KMGT]?)\b`)
+)
+
+func (htmlListingParser) Format() string {
+	return ListingFormatHTML
+}
+
+// Parse 读取页面中的链接，尽量从链接后的文字中读取修改时间和大小
+func (htmlListingParser) Parse(body []byte, listingURL *url.URL) (*directoryListing, error) {
+	content := string(body)
+	listing := &directoryListing{}
+	seen := make(map[string]bool)
+
+	matches := listingLinkPattern.FindAllStringSubmatchIndex(content, -1)
+	for i, match := range matches {
+		href, err := url.Parse(content[match[2]:match[3]])
+		if err != nil {
+			continue
+		}
+		// 支持相对链接和绝对链接，跳过上级目录、子目录、排序链接和 HTTP(S) 以外的链接 (例如 file:、javascript:)
+		fileURL := listingURL.ResolveReference(href)
+		if fileURL.Scheme != "http" && fileURL.Scheme != "https" {
+			continue
+		}
+		if fileURL.RawQuery != "" || fileURL.Path == "" || strings.HasSuffix(fileURL.Path, "/") {
+			continue
+		}
+		if seen[fileURL.String()] {
+			continue
+		}
+		seen[fileURL.String()] = true
+
+		entry := ArtifactFile{Name: path.Base(fileURL.Path), URL: fileURL.String(), Size: -1}
+
+		// 修改时间和大小位于当前链接和下一个链接之间
+		tailEnd := len(content)
+		if i+1 < len(matches) {
+			tailEnd = matches[i+1][0]
+		}
+		if meta := listingMetaPattern.FindStringSubmatch(content[match[1]:tailEnd]); meta != nil {
+			for _, layout := range []string{"02-Jan-2006 15:04", "2006-01-02 15:04"} {
+				if modTime, err := time.Parse(layout, meta[1]); err == nil {
+					entry.ModTime = &modTime
+					break
+				}
+			}
+			entry.Size = parseListingSize(meta[2])
+		}
+		listing.Entries = append(listing.Entries, entry)
+	}
+	return listing, nil
+}
+
+// parseListingSize 解析目录页中的文件大小，支持 117M 这类近似值
+func parseListingSize(value string) int64 {
+	multiplier := float64(1)
+	if suffix := value[len(value)-1]; suffix < '0' || suffix > '9' {
+		multiplier = map[byte]float64{'K': 1 << 10, 'M': 1 << 20, 'G': 1 << 30, 'T': 1 << 40}[suffix]
+		value = value[:len(value)-1]
+	}
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return -1
+	}
+	return int64(number * multiplier)
+}
+
+// nginxJSONListingParser 解析 nginx autoindex_format json 的输出
+type nginxJSONListingParser struct{}
+
+type nginxJSONEntry struct {
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	MTime string `json:"mtime"`
+	Size  *int64 `json:"size"`
+}
+
+func (nginxJSONListingParser) Format() string {
+	return ListingFormatNginxJSON
+}
+
+func (nginxJSONListingParser) Parse(body []byte, listingURL *url.URL) (*directoryListing, error) {
+	var items []nginxJSONEntry
+	if err := json.Unmarshal(body, &items); err != nil {
+		return nil, err
+	}
+
+	listing := &directoryListing{}
+	for _, item := range items {
+		if item.Type != "file" {
+			continue
+		}
+		entry := ArtifactFile{
+			Name: item.Name,
+			URL:  listingURL.ResolveReference(&url.URL{Path: item.Name}).String(),
+			Size: -1,
+		}
+		if item.Size != nil {
+			entry.Size = *item.Size
+		}
+		// nginx 使用 HTTP 日期格式: Wed, 26 Jun 2024 10:20:00 GMT
+		if modTime, err := http.ParseTime(item.MTime); err == nil {
+			entry.ModTime = &modTime
+		}
+		listing.Entries = append(listing.Entries, entry)
+	}
+	return listing, nil
+}
+
+// s3ListingParser 解析 S3 / MinIO ListObjectsV2 的 XML 响应
+type s3ListingParser struct{}
+
+type s3ListBucketResult struct {
+	XMLName               xml.Name `xml:"ListBucketResult"`
+	Prefix                string   `xml:"Prefix"`
+	IsTruncated           bool     `xml:"IsTruncated"`
+	NextContinuationToken string   `xml:"NextContinuationToken"`
+	Contents              []struct {
+		Key          string    `xml:"Key"`
+		LastModified time.Time `xml:"LastModified"`
+		Size         int64     `xml:"Size"`
+	} `xml:"Contents"`
+}
+
+func (s3ListingParser) Format() string {
+	return ListingFormatS3
+}
+
+// Parse 只返回前缀下的直接文件，下载URL为存储桶地址加对象键
+func (s3ListingParser) Parse(body []byte, listingURL *url.URL) (*directoryListing, error) {
+	var result s3ListBucketResult
+	if err := xml.Unmarshal(body, &result); err != nil {
+		return nil, err
+	}
+
+	// 按 list-type=2 查询时请求地址即存储桶地址，否则从目录地址中去掉前缀
+	bucketURL := *listingURL
+	bucketURL.RawQuery = ""
+	if result.Prefix != "" && strings.HasSuffix(bucketURL.Path, result.Prefix) {
+		bucketURL.Path = strings.TrimSuffix(bucketURL.Path, result.Prefix)
+		bucketURL.RawPath = ""
+	}
+	if !strings.HasSuffix(bucketURL.Path, "/") {
+		bucketURL.Path += "/"
+	}
+
+	listing := &directoryListing{}
+	for _, object := range result.Contents {
+		name := strings.TrimPrefix(object.Key, result.Prefix)
+		if name == "" || strings.Contains(name, "/") {
+			continue
+		}
+		modTime := object.LastModified
+		entry := ArtifactFile{
+			Name: name,
+			URL:  bucketURL.ResolveReference(&url.URL{Path: object.Key}).String(),
+			Size: object.Size,
+		}
+		if !modTime.IsZero() {
+			entry.ModTime = &modTime
+		}
+		listing.Entries = append(listing.Entries, entry)
+	}
+	if result.IsTruncated {
+		listing.NextPageToken = result.NextContinuationToken
+	}
+	return listing, nil
+}
@@ -0,0 +1,230 @@
+package services
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+func mustParseURL(t *testing.T, raw string) *url.URL {
+	t.Helper()
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		t.Fatalf("url.Parse(%q) error: %v", raw, err)
+	}
+	return parsed
+}
+
+// checkListingEntries 比较解析结果的文件名、下载URL和大小
+func checkListingEntries(t *testing.T, got []ArtifactFile, want []ArtifactFile) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %d entries %+v, want %d", len(got), got, len(want))
+	}
+	for i := range want {
+		if got[i].Name != want[i].Name || got[i].URL != want[i].URL || got[i].Size != want[i].Size {
+			t.Errorf("entry %d = {%s %s %d}, want {%s %s %d}", i,
+				got[i].Name, got[i].URL, got[i].Size, want[i].Name, want[i].URL, want[i].Size)
+		}
+	}
+}
+
+func TestDetectListingParser(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		want        string
+	}{
+		{"json content type", "application/json; charset=utf-8", "", ListingFormatNginxJSON},
+		{"xml content type", "application/xml", "", ListingFormatS3},
+		{"text xml content type", "text/xml", "", ListingFormatS3},
+		{"html content type", "text/html", "[]", ListingFormatHTML},
+		{"json body", "text/plain", " [{\"name\":\"a\"}]", ListingFormatNginxJSON},
+		{"xml declaration body", "", "<?xml version=\"1.0\"?><ListBucketResult/>", ListingFormatS3},
+		{"bucket result body", "application/octet-stream", "<ListBucketResult/>", ListingFormatS3},
+		{"unknown falls back to html", "", "<html></html>", ListingFormatHTML},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := detectListingParser(tt.contentType, []byte(tt.body)).Format(); got != tt.want {
+				t.Errorf("detectListingParser(%q) = %s, want %s", tt.contentType, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseListingSize(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int64
+	}{
+		{"123456", 123456},
+		{"0", 0},
+		{"1.5K", 1536},
+		{"117M", 117 << 20},
+		{"2G", 2 << 30},
+		{"1T", 1 << 40},
+		{"abc", -1},
+	}
+	for _, tt := range tests {
+		if got := parseListingSize(tt.value); got != tt.want {
+			t.Errorf("parseListingSize(%q) = %d, want %d", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestHTMLListingParser(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want []ArtifactFile
+	}{
+		{
+			name: "nginx autoindex",
+			body: `<html><body><h1>Index of /builds/42/</h1><hr><pre><a href="../">../</a>
+<a href="sub/">sub/</a>                                               26-Jun-2024 10:20                   -
+<a href="app-release.apk">app-release.apk</a>                          26-Jun-2024 10:20              123456
+<a href="app%20debug.apk">app debug.apk</a>                            26-Jun-2024 10:21                7890
+</pre><hr></body></html>`,
+			want: []ArtifactFile{
+				{Name: "app-release.apk", URL: "http://pkg.example.com/builds/42/app-release.apk", Size: 123456},
+				{Name: "app debug.apk", URL: "http://pkg.example.com/builds/42/app%20debug.apk", Size: 7890},
+			},
+		},
+		{
+			name: "apache autoindex",
+			body: `<table><tr><th><a href="?C=N;O=D">Name</a></th></tr>
+<tr><td><a href="/builds/">Parent Directory</a></td><td>&nbsp;</td><td align="right">  - </td></tr>
+<tr><td><a href="build.zip">build.zip</a></td><td align="right">2024-06-26 10:20  </td><td align="right">117M</td></tr>
+<tr><td><a href="build.zip">build.zip</a></td></tr>
+</table>`,
+			want: []ArtifactFile{
+				{Name: "build.zip", URL: "http://pkg.example.com/builds/42/build.zip", Size: 117 << 20},
+			},
+		},
+		{
+			name: "absolute links without metadata",
+			body: `<a href="http://mirror.example.com/files/app.ipa">app.ipa</a>
+<a href="https://mirror.example.com/files/app.apk">app.apk</a>
+<a href="file:///etc/passwd">passwd</a>
+<a href="ftp://mirror.example.com/files/app.tgz">app.tgz</a>
+<a href="javascript:alert(1)">app.zip</a>`,
+			want: []ArtifactFile{
+				{Name: "app.ipa", URL: "http://mirror.example.com/files/app.ipa", Size: -1},
+				{Name: "app.apk", URL: "https://mirror.example.com/files/app.apk", Size: -1},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			listing, err := htmlListingParser{}.Parse([]byte(tt.body), mustParseURL(t, "http://pkg.example.com/builds/42/"))
+			if err != nil {
+				t.Fatalf("Parse error: %v", err)
+			}
+			checkListingEntries(t, listing.Entries, tt.want)
+		})
+	}
+}
+
+func TestHTMLListingParserModTime(t *testing.T) {
+	body := `<a href="app.apk">app.apk</a>   26-Jun-2024 10:20   123456`
+	listing, err := htmlListingParser{}.Parse([]byte(body), mustParseURL(t, "http://pkg.example.com/"))
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	want := time.Date(2024, 6, 26, 10, 20, 0, 0, time.UTC)
+	if len(listing.Entries) != 1 || listing.Entries[0].ModTime == nil || !listing.Entries[0].ModTime.Equal(want) {
+		t.Errorf("unexpected entries %+v, want mod time %s", listing.Entries, want)
+	}
+}
+
+func TestNginxJSONListingParser(t *testing.T) {
+	body := `[
+		{"name":"sub","type":"directory","mtime":"Wed, 26 Jun 2024 10:20:00 GMT"},
+		{"name":"app.apk","type":"file","mtime":"Wed, 26 Jun 2024 10:20:00 GMT","size":123},
+		{"name":"app debug.apk","type":"file","mtime":"bad date"}
+	]`
+	listing, err := nginxJSONListingParser{}.Parse([]byte(body), mustParseURL(t, "http://pkg.example.com/builds/42/"))
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	checkListingEntries(t, listing.Entries, []ArtifactFile{
+		{Name: "app.apk", URL: "http://pkg.example.com/builds/42/app.apk", Size: 123},
+		{Name: "app debug.apk", URL: "http://pkg.example.com/builds/42/app%20debug.apk", Size: -1},
+	})
+	if listing.Entries[0].ModTime == nil || listing.Entries[1].ModTime != nil {
+		t.Errorf("unexpected mod times: %v, %v", listing.Entries[0].ModTime, listing.Entries[1].ModTime)
+	}
+
+	if _, err := (nginxJSONListingParser{}).Parse([]byte("<html>"), mustParseURL(t, "http://pkg.example.com/")); err == nil {
+		t.Error("expected error for invalid JSON")
+	}
+}
+
+func TestS3ListingParser(t *testing.T) {
+	body := `<?xml version="1.0" encoding="UTF-8"?>
+<ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/">
+  <Name>bucket</Name>
+  <Prefix>builds/42/</Prefix>
+  <IsTruncated>true</IsTruncated>
+  <NextContinuationToken>next-page</NextContinuationToken>
+  <Contents><Key>builds/42/</Key><Size>0</Size></Contents>
+  <Contents><Key>builds/42/app.apk</Key><LastModified>2024-06-26T10:20:00.000Z</LastModified><Size>123</Size></Contents>
+  <Contents><Key>builds/42/sub/other.apk</Key><Size>456</Size></Contents>
+</ListBucketResult>`
+	tests := []struct {
+		name       string
+		listingURL string
+	}{
+		{"list objects v2 request", "http://minio.example.com:9000/bucket?delimiter=%2F&list-type=2&prefix=builds%2F42%2F"},
+		{"directory style request", "http://minio.example.com:9000/bucket/builds/42/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			listing, err := s3ListingParser{}.Parse([]byte(body), mustParseURL(t, tt.listingURL))
+			if err != nil {
+				t.Fatalf("Parse error: %v", err)
+			}
+			checkListingEntries(t, listing.Entries, []ArtifactFile{
+				{Name: "app.apk", URL: "http://minio.example.com:9000/bucket/builds/42/app.apk", Size: 123},
+			})
+			if listing.Entries[0].ModTime == nil {
+				t.Error("expected mod time")
+			}
+			if listing.NextPageToken != "next-page" {
+				t.Errorf("NextPageToken = %q, want next-page", listing.NextPageToken)
+			}
+		})
+	}
+}
+
+func TestS3ListingURL(t *testing.T) {
+	got, err := s3ListingURL("http://minio.example.com:9000/bucket", "builds/42/")
+	if err != nil {
+		t.Fatalf("s3ListingURL error: %v", err)
+	}
+	want := "http://minio.example.com:9000/bucket?delimiter=%2F&list-type=2&prefix=builds%2F42%2F"
+	if got.String() != want {
+		t.Errorf("s3ListingURL = %s, want %s", got, want)
+	}
+}
+
+func TestValidateListingFormat(t *testing.T) {
+	tests := []struct {
+		format  string
+		wantErr bool
+	}{
+		{"", false},
+		{ListingFormatAuto, false},
+		{ListingFormatHTML, false},
+		{ListingFormatNginxJSON, false},
+		{ListingFormatS3, false},
+		{"xml", true},
+	}
+	for _, tt := range tests {
+		if err := ValidateListingFormat(tt.format); (err != nil) != tt.wantErr {
+			t.Errorf("ValidateListingFormat(%q) error = %v, wantErr %v", tt.format, err, tt.wantErr)
+		}
+	}
+}
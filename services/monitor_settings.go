@@ -0,0 +1,163 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+
+	"crat/models"
+)
+
+// monitorBounds 管理员设置的监控配置上限
+type monitorBounds struct {
+	MaxQueryHours          int
+	QueryInterval          int
+	QueryTimeout           int
+	DownloadTimeoutMinutes int
+}
+
+// loadMonitorBounds 从系统设置读取监控配置上限
+func loadMonitorBounds(settings map[string]string) *monitorBounds {
+	bounds := &monitorBounds{
+		MaxQueryHours:          72,
+		QueryInterval:          3600,
+		QueryTimeout:           300,
+		DownloadTimeoutMinutes: 120,
+	}
+
+	for key, target := range map[string]*int{
+		"monitor_max_query_hours_limit":  &bounds.MaxQueryHours,
+		"monitor_query_interval_limit":   &bounds.QueryInterval,
+		"monitor_query_timeout_limit":    &bounds.QueryTimeout,
+		"download_timeout_minutes_limit": &bounds.DownloadTimeoutMinutes,
+	} {
+		if value, err := strconv.Atoi(settings[key]); err == nil && value > 0 {
+			*target = value
+		}
+	}
+	return bounds
+}
+
+// checkMonitorField 校验单个监控配置字段
+func checkMonitorField(name string, value *int, limit int) error {
+	if value == nil {
+		return nil
+	}
+	if *value <= 0 {
+		return fmt.Errorf("%s must be positive", name)
+	}
+	if limit > 0 && *value > limit {
+		return fmt.Errorf("%s must not exceed %d", name, limit)
+	}
+	return nil
+}
+
+// validate 校验监控配置，bounds为nil时只检查取值是否为正数
+func (b *monitorBounds) validate(settings *models.MonitorSettings) error {
+	if settings == nil {
+		return nil
+	}
+	limits := monitorBounds{}
+	if b != nil {
+		limits = *b
+	}
+
+	if err := checkMonitorField("max_query_hours", settings.MaxQueryHours, limits.MaxQueryHours); err != nil {
+		return err
+	}
+	if err := checkMonitorField("query_interval", settings.QueryInterval, limits.QueryInterval); err != nil {
+		return err
+	}
+	if err := checkMonitorField("query_timeout", settings.QueryTimeout, limits.QueryTimeout); err != nil {
+		return err
+	}
+	return checkMonitorField("download_timeout_minutes", settings.DownloadTimeoutMinutes, limits.DownloadTimeoutMinutes)
+}
+
+// ValidateMonitorSettings 校验测试项或参数集中的监控配置
+func ValidateMonitorSettings(raw json.RawMessage) error {
+	settings, err := models.ParseMonitorSettings(raw)
+	if err != nil {
+		return fmt.Errorf("invalid monitor settings format: %v", err)
+	}
+	var bounds *monitorBounds
+	if systemSettings, err := NewSystemUtils().GetSystemSettings(); err == nil {
+		bounds = loadMonitorBounds(systemSettings)
+	}
+	return bounds.validate(settings)
+}
+
+// ValidateMonitorOverrides 校验触发请求中的监控配置是否在管理员设置的上限内
+func (s *DeployTestService) ValidateMonitorOverrides(overrides *models.MonitorSettings) error {
+	settings, err := s.systemUtils.GetSystemSettings()
+	if err != nil {
+		return fmt.Errorf("failed to get system settings: %v", err)
+	}
+	return loadMonitorBounds(settings).validate(overrides)
+}
+
+// applyMonitorSettings 按 测试项 < 参数集 < 触发请求 的顺序合并监控配置并写入运行记录
+//
+// 已保存的配置超出当前上限时按上限截断。
+func (s *DeployTestService) applyMonitorSettings(run *models.DeployTestRun, testItem *models.TestItem, overrides *models.MonitorSettings) {
+	merged := &models.MonitorSettings{}
+
+	if itemSettings, err := models.ParseMonitorSettings(testItem.MonitorSettings); err != nil {
+		log.Printf("Invalid monitor settings for test item %d: %v", testItem.ID, err)
+	} else {
+		merged.Merge(itemSettings)
+	}
+
+	if parameterSet, err := s.resolveParameterSet(run.ParameterSetID, testItem); err == nil {
+		if setSettings, err := models.ParseMonitorSettings(parameterSet.MonitorSettings); err != nil {
+			log.Printf("Invalid monitor settings for parameter set %d: %v", parameterSet.ID, err)
+		} else {
+			merged.Merge(setSettings)
+		}
+	}
+
+	merged.Merge(overrides)
+
+	settings, err := s.systemUtils.GetSystemSettings()
+	if err != nil {
+		log.Printf("Failed to get system settings: %v", err)
+		settings = map[string]string{}
+	}
+	bounds := loadMonitorBounds(settings)
+
+	run.MaxQueryHours = boundedValue(merged.MaxQueryHours, models.DefaultMaxQueryHours, bounds.MaxQueryHours)
+	run.QueryInterval = boundedValue(merged.QueryInterval, models.DefaultQueryInterval, bounds.QueryInterval)
+	run.QueryTimeout = boundedValue(merged.QueryTimeout, models.DefaultQueryTimeout, bounds.QueryTimeout)
+	run.DownloadTimeout = boundedValue(merged.DownloadTimeoutMinutes, models.DefaultDownloadTimeoutMinutes, bounds.DownloadTimeoutMinutes)
+}
+
+// boundedValue 取配置值，未设置或非法时使用默认值，超出上限时截断
+func boundedValue(value *int, defaultValue, limit int) int {
+	result := defaultValue
+	if value != nil && *value > 0 {
+		result = *value
+	}
+	if limit > 0 && result > limit {
+		result = limit
+	}
+	return result
+}
+
+// monitorSettingsOf 获取运行实际使用的监控配置，自动重试时沿用
+func monitorSettingsOf(run *models.DeployTestRun) *models.MonitorSettings {
+	settings := &models.MonitorSettings{}
+	if run.MaxQueryHours > 0 {
+		settings.MaxQueryHours = &run.MaxQueryHours
+	}
+	if run.QueryInterval > 0 {
+		settings.QueryInterval = &run.QueryInterval
+	}
+	if run.QueryTimeout > 0 {
+		settings.QueryTimeout = &run.QueryTimeout
+	}
+	if run.DownloadTimeout > 0 {
+		settings.DownloadTimeoutMinutes = &run.DownloadTimeout
+	}
+	return settings
+}
@@ -3,6 +3,7 @@ package services
 import (
 	"encoding/json"
 	"fmt"
+	"html"
 	"net/http"
 	"regexp"
 	"strings"
@@ -37,6 +38,14 @@ type SummaryData struct {
 	} `json:"time"`
 }
 
+// emailPattern 有效邮箱地址的格式
+var emailPattern = regexp.MustCompile(`^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}$`)
+
+// isEmailAddress 判断是否为有效的邮箱地址
+func isEmailAddress(value string) bool {
+	return emailPattern.MatchString(value)
+}
+
 func NewNotificationService() *NotificationService {
 	return &NotificationService{}
 }
@@ -47,7 +56,7 @@ func (n *NotificationService) SendEmailNotification(to, subject, body string) er
 	m.SetHeader("From", config.AppConfig.Email.Username)
 
 	// 如果收件人不是有效的邮箱地址，使用配置的邮箱用户名以保留邮件记录
-	if !regexp.MustCompile(`^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}$`).MatchString(to) {
+	if !isEmailAddress(to) {
 		m.SetHeader("To", config.AppConfig.Email.Username) // 发件人和收件人相同
 	} else {
 		m.SetHeader("To", to)
@@ -185,6 +194,80 @@ func (n *NotificationService) SendTestFailureNotification(email, testName string
 	return n.SendEmailNotification(email, subject, body)
 }
 
+// RunGroupNotificationRow 运行组汇总通知中的一行
+type RunGroupNotificationRow struct {
+	RunID        uint
+	TestName     string
+	ParameterSet string
+	Status       string
+	ReportURL    string
+	ErrorMessage string
+}
+
+// SendRunGroupNotification 发送运行组汇总通知，每个测试项和参数集的组合一行
+func (n *NotificationService) SendRunGroupNotification(email string, group *models.RunGroup, buildInfo *models.BuildInfo, rows []RunGroupNotificationRow) error {
+	icon, title, color := "✅", "批量测试成功", "#28a745"
+	if group.Status != models.RunGroupStatusCompleted {
+		icon, title, color = "❌", "批量测试未全部通过", "#dc3545"
+	}
+	subject := fmt.Sprintf("%s %s - %s #%d (%d/%d 通过)", icon, title, buildInfo.JobName, buildInfo.BuildNumber, group.Completed, len(rows))
+
+	projectName := n.getProjectName()
+	currentTime := time.Now().Format("2006-01-02 15:04:05")
+
+	var table strings.Builder
+	for _, row := range rows {
+		rowColor := "#28a745"
+		if row.Status != models.DeployTestStatusCompleted && row.Status != models.DeployTestStatusDeployComplete {
+			rowColor = "#dc3545"
+		}
+		result := html.EscapeString(row.ErrorMessage)
+		if row.ReportURL != "" {
+			result = fmt.Sprintf(`<a href="%s" target="_blank" style="color: #007bff;">查看报告</a>`, row.ReportURL)
+		}
+		parameterSet := row.ParameterSet
+		if parameterSet == "" {
+			parameterSet = "-"
+		}
+		fmt.Fprintf(&table, `
+					<tr>
+						<td style="padding: 6px; border: 1px solid #dee2e6;">%s</td>
+						<td style="padding: 6px; border: 1px solid #dee2e6;">%s</td>
+						<td style="padding: 6px; border: 1px solid #dee2e6; color: %s;">%s</td>
+						<td style="padding: 6px; border: 1px solid #dee2e6;">%s</td>
+					</tr>`, html.EscapeString(row.TestName), html.EscapeString(parameterSet), rowColor, row.Status, result)
+	}
+
+	body := fmt.Sprintf(`
+		<html>
+		<body style="font-family: Arial, sans-serif; line-height: 1.6; color: #333;">
+			<div style="max-width: 800px; margin: 0 auto; padding: 20px;">
+				<h2 style="color: %s;">%s %s</h2>
+				<div style="background-color: #f8f9fa; padding: 15px; border-radius: 5px; margin: 15px 0;">
+					<p><strong>构建:</strong> %s #%d</p>
+					<p><strong>结果:</strong> %d 成功, %d 失败, %d 取消</p>
+					<p><strong>执行时间:</strong> %s</p>
+				</div>
+				<table style="width: 100%%; border-collapse: collapse; font-size: 14px;">
+					<tr style="background-color: #f8f9fa;">
+						<th style="padding: 6px; border: 1px solid #dee2e6; text-align: left;">测试项目</th>
+						<th style="padding: 6px; border: 1px solid #dee2e6; text-align: left;">参数集</th>
+						<th style="padding: 6px; border: 1px solid #dee2e6; text-align: left;">状态</th>
+						<th style="padding: 6px; border: 1px solid #dee2e6; text-align: left;">报告 / 错误信息</th>
+					</tr>%s
+				</table>
+				<hr style="margin: 20px 0;">
+				<p style="color: #6c757d; font-size: 12px;">
+					本邮件由 %s 自动发送，请勿回复。
+				</p>
+			</div>
+		</body>
+		</html>
+	`, color, icon, title, buildInfo.JobName, buildInfo.BuildNumber, group.Completed, group.Failed, group.Cancelled, currentTime, table.String(), projectName)
+
+	return n.SendEmailNotification(email, subject, body)
+}
+
 func getReportLink(reportURL string) string {
 	if reportURL != "" {
 		return fmt.Sprintf(`
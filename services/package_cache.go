@@ -0,0 +1,404 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"crat/config"
+	"crat/models"
+
+	"gorm.io/gorm"
+)
+
+// defaultDownloadDir 未配置 DOWNLOAD_DIR 时使用的下载目录
+const defaultDownloadDir = "/tmp/crat/packages"
+
+// packageCacheMutex 串行化缓存条目、引用计数和淘汰，所有实例共享
+var packageCacheMutex sync.Mutex
+
+// packageKeyLock 缓存键的下载锁，refs 为持有或等待该锁的运行数
+type packageKeyLock struct {
+	sync.Mutex
+	refs int
+}
+
+// packageKeyLocks 同一缓存键同时只允许一个运行下载，没有运行持有或等待时删除
+var (
+	packageKeyLocks      = make(map[string]*packageKeyLock)
+	packageKeyLocksMutex sync.Mutex
+)
+
+// lockPackageKey 锁定缓存键，返回解锁函数
+func lockPackageKey(key string) func() {
+	packageKeyLocksMutex.Lock()
+	lock, ok := packageKeyLocks[key]
+	if !ok {
+		lock = &packageKeyLock{}
+		packageKeyLocks[key] = lock
+	}
+	lock.refs++
+	packageKeyLocksMutex.Unlock()
+
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+
+		packageKeyLocksMutex.Lock()
+		lock.refs--
+		if lock.refs == 0 {
+			delete(packageKeyLocks, key)
+		}
+		packageKeyLocksMutex.Unlock()
+	}
+}
+
+// PackageCacheService 管理下载包的共享缓存
+type PackageCacheService struct{}
+
+func NewPackageCacheService() *PackageCacheService {
+	return &PackageCacheService{}
+}
+
+// PackageCacheUsage 缓存使用情况
+type PackageCacheUsage struct {
+	Dir        string                     `json:"dir"`
+	QuotaBytes int64                      `json:"quota_bytes"`
+	UsedBytes  int64                      `json:"used_bytes"`
+	EntryCount int                        `json:"entry_count"`
+	InUseCount int                        `json:"in_use_count"`
+	Entries    []models.PackageCacheEntry `json:"entries"`
+}
+
+// DownloadDir 获取下载目录
+func (p *PackageCacheService) DownloadDir() string {
+	if config.AppConfig != nil && config.AppConfig.Storage.DownloadDir != "" {
+		return config.AppConfig.Storage.DownloadDir
+	}
+	return defaultDownloadDir
+}
+
+// QuotaBytes 获取缓存磁盘配额，0表示不限制
+func (p *PackageCacheService) QuotaBytes() int64 {
+	if config.AppConfig == nil {
+		return 0
+	}
+	return config.AppConfig.Storage.CacheQuotaMB * 1024 * 1024
+}
+
+// packageCacheKey 计算缓存键：有校验和时按下载URL和校验和，否则按下载URL和文件大小
+func packageCacheKey(downloadURL string, size int64, expected *expectedChecksum) string {
+	identity := fmt.Sprintf("%s|size:%d", downloadURL, size)
+	if expected != nil {
+		identity = fmt.Sprintf("%s|%s:%s", downloadURL, expected.Algorithm, expected.Value)
+	}
+	sum := sha256.Sum256([]byte(identity))
+	return hex.EncodeToString(sum[:])
+}
+
+// remoteSize 通过 HEAD 请求获取远程文件大小，本地制品读取文件大小，无法获取时返回-1
+func remoteSize(ctx context.Context, source ArtifactSource, url string) int64 {
+	localPath, isLocal, err := localArtifactPath(source, url)
+	if err != nil {
+		return -1
+	}
+	if isLocal {
+		info, err := os.Stat(localPath)
+		if err != nil {
+			return -1
+		}
+		return info.Size()
+	}
+
+	req, err := newArtifactRequest(ctx, source, "HEAD", url)
+	if err != nil {
+		return -1
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return -1
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return -1
+	}
+	return resp.ContentLength
+}
+
+// EntryPath 获取缓存键对应的文件路径，保留原始文件名
+func (p *PackageCacheService) EntryPath(key, fileName string) string {
+	return filepath.Join(p.DownloadDir(), key[:16], fileName)
+}
+
+// AcquireCached 查找可用的缓存条目并为运行增加引用，没有可用条目时返回nil
+//
+// 文件已丢失或大小不一致的条目会被清除。
+func (p *PackageCacheService) AcquireCached(key string, runID uint) (*models.PackageCacheEntry, error) {
+	packageCacheMutex.Lock()
+	defer packageCacheMutex.Unlock()
+
+	var entry models.PackageCacheEntry
+	if err := config.DB.Where("cache_key = ?", key).First(&entry).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	if info, err := os.Stat(entry.Path); err != nil || info.Size() != entry.Size {
+		log.Printf("Cached package %s is missing or changed on disk, dropping cache entry", entry.Path)
+		if entry.RefCount == 0 {
+			config.DB.Delete(&entry)
+		}
+		return nil, nil
+	}
+
+	if err := acquireLocked(&entry, runID); err != nil {
+		return nil, fmt.Errorf("failed to acquire cache entry: %v", err)
+	}
+	return &entry, nil
+}
+
+// StoreAndAcquire 登记已下载并校验通过的文件，并为运行增加引用
+func (p *PackageCacheService) StoreAndAcquire(key, downloadURL, fileName, path string, size int64, sha256Sum string, runID uint) (*models.PackageCacheEntry, error) {
+	packageCacheMutex.Lock()
+	defer packageCacheMutex.Unlock()
+
+	entry := models.PackageCacheEntry{
+		CacheKey:    key,
+		DownloadURL: downloadURL,
+		FileName:    fileName,
+		Path:        path,
+		Size:        size,
+		SHA256:      sha256Sum,
+		LastUsedAt:  time.Now(),
+	}
+	// 文件丢失后重新下载时替换旧条目
+	if err := replaceEntryLocked(key); err != nil {
+		return nil, fmt.Errorf("failed to replace cache entry: %v", err)
+	}
+	if err := config.DB.Create(&entry).Error; err != nil {
+		return nil, fmt.Errorf("failed to create cache entry: %v", err)
+	}
+
+	if err := acquireLocked(&entry, runID); err != nil {
+		return nil, fmt.Errorf("failed to acquire cache entry: %v", err)
+	}
+	return &entry, nil
+}
+
+// replaceEntryLocked 让出缓存键给重新下载的文件，调用方需持有 packageCacheMutex
+//
+// 没有引用的旧条目直接删除；仍被运行引用的旧条目标记为 stale 并改用以ID命名的缓存键，最后一个引用释放时删除。
+func replaceEntryLocked(key string) error {
+	var old models.PackageCacheEntry
+	if err := config.DB.Where("cache_key = ?", key).First(&old).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil
+		}
+		return err
+	}
+	if old.RefCount == 0 {
+		return config.DB.Delete(&old).Error
+	}
+	log.Printf("Cache entry %d is still used by %d runs, marking it stale", old.ID, old.RefCount)
+	return config.DB.Model(&old).Updates(map[string]interface{}{
+		"cache_key": staleCacheKey(old.ID),
+		"stale":     true,
+	}).Error
+}
+
+// staleCacheKey 过期条目的缓存键，不会与十六进制的缓存键冲突
+func staleCacheKey(id uint) string {
+	return fmt.Sprintf("stale-%d", id)
+}
+
+// acquireLocked 为运行增加缓存条目的引用，调用方需持有 packageCacheMutex
+func acquireLocked(entry *models.PackageCacheEntry, runID uint) error {
+	entry.RefCount++
+	return config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.PackageCacheEntry{}).Where("id = ?", entry.ID).Updates(map[string]interface{}{
+			"ref_count":    gorm.Expr("ref_count + 1"),
+			"last_used_at": time.Now(),
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.DeployTestRun{}).Where("id = ?", runID).Update("cache_entry_id", entry.ID).Error
+	})
+}
+
+// Release 释放运行持有的缓存引用，可重复调用
+func (p *PackageCacheService) Release(runID uint) {
+	packageCacheMutex.Lock()
+	defer packageCacheMutex.Unlock()
+
+	var run models.DeployTestRun
+	if err := config.DB.Select("id", "cache_entry_id").First(&run, runID).Error; err != nil || run.CacheEntryID == nil {
+		return
+	}
+
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.DeployTestRun{}).
+			Where("id = ? AND cache_entry_id = ?", runID, *run.CacheEntryID).
+			Update("cache_entry_id", nil)
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		return tx.Model(&models.PackageCacheEntry{}).Where("id = ?", *run.CacheEntryID).Updates(map[string]interface{}{
+			"ref_count":    gorm.Expr("GREATEST(ref_count - 1, 0)"),
+			"last_used_at": time.Now(),
+		}).Error
+	})
+	if err != nil {
+		log.Printf("Failed to release cache entry for run ID %d: %v", runID, err)
+		return
+	}
+
+	// 已被替换的条目在最后一个引用释放后删除
+	var entry models.PackageCacheEntry
+	if err := config.DB.Where("id = ? AND stale = ? AND ref_count = 0", *run.CacheEntryID, true).First(&entry).Error; err != nil {
+		return
+	}
+	if err := p.removeEntryLocked(&entry); err != nil {
+		log.Printf("Failed to remove stale cached package %s: %v", entry.Path, err)
+	}
+}
+
+// Evict 按最近最少使用顺序淘汰未被引用的条目，直到总大小不超过配额
+func (p *PackageCacheService) Evict() {
+	quota := p.QuotaBytes()
+	if quota <= 0 {
+		return
+	}
+
+	packageCacheMutex.Lock()
+	defer packageCacheMutex.Unlock()
+
+	var used int64
+	config.DB.Model(&models.PackageCacheEntry{}).Select("COALESCE(SUM(size), 0)").Scan(&used)
+	if used <= quota {
+		return
+	}
+
+	var candidates []models.PackageCacheEntry
+	if err := config.DB.Where("ref_count = 0").Order("last_used_at ASC").Find(&candidates).Error; err != nil {
+		log.Printf("Failed to load cache entries for eviction: %v", err)
+		return
+	}
+
+	for i := range candidates {
+		if used <= quota {
+			break
+		}
+		if err := p.removeEntryLocked(&candidates[i]); err != nil {
+			log.Printf("Failed to evict cached package %s: %v", candidates[i].Path, err)
+			continue
+		}
+		used -= candidates[i].Size
+		log.Printf("Evicted cached package %s (%d bytes)", candidates[i].Path, candidates[i].Size)
+	}
+
+	if used > quota {
+		log.Printf("Package cache still uses %d bytes over quota %d; remaining entries are in use", used, quota)
+	}
+}
+
+// Usage 获取缓存使用情况
+func (p *PackageCacheService) Usage() (*PackageCacheUsage, error) {
+	var entries []models.PackageCacheEntry
+	if err := config.DB.Order("last_used_at DESC").Find(&entries).Error; err != nil {
+		return nil, fmt.Errorf("failed to get cache entries: %v", err)
+	}
+
+	usage := &PackageCacheUsage{
+		Dir:        p.DownloadDir(),
+		QuotaBytes: p.QuotaBytes(),
+		EntryCount: len(entries),
+		Entries:    entries,
+	}
+	for _, entry := range entries {
+		usage.UsedBytes += entry.Size
+		if entry.RefCount > 0 {
+			usage.InUseCount++
+		}
+	}
+	return usage, nil
+}
+
+// Purge 删除所有未被引用的缓存条目，返回删除的条目数和释放的字节数
+func (p *PackageCacheService) Purge() (int, int64, error) {
+	packageCacheMutex.Lock()
+	defer packageCacheMutex.Unlock()
+
+	var entries []models.PackageCacheEntry
+	if err := config.DB.Where("ref_count = 0").Find(&entries).Error; err != nil {
+		return 0, 0, fmt.Errorf("failed to get cache entries: %v", err)
+	}
+
+	removed, freed := 0, int64(0)
+	for i := range entries {
+		if err := p.removeEntryLocked(&entries[i]); err != nil {
+			log.Printf("Failed to purge cached package %s: %v", entries[i].Path, err)
+			continue
+		}
+		removed++
+		freed += entries[i].Size
+	}
+	return removed, freed, nil
+}
+
+// Reconcile 服务启动时根据运行记录修正引用计数
+func (p *PackageCacheService) Reconcile() {
+	packageCacheMutex.Lock()
+	defer packageCacheMutex.Unlock()
+
+	// 重启前已结束但未释放引用的运行
+	if err := config.DB.Model(&models.DeployTestRun{}).
+		Where("cache_entry_id IS NOT NULL AND status NOT IN ?", models.ActiveDeployTestStatuses).
+		Update("cache_entry_id", nil).Error; err != nil {
+		log.Printf("Failed to release cache references of finished runs: %v", err)
+	}
+
+	if err := config.DB.Exec(`UPDATE package_cache_entries SET ref_count = (
+		SELECT COUNT(*) FROM deploy_test_runs WHERE deploy_test_runs.cache_entry_id = package_cache_entries.id
+	)`).Error; err != nil {
+		log.Printf("Failed to reconcile package cache reference counts: %v", err)
+	}
+
+	// 重启前未删除的已替换条目
+	var staleEntries []models.PackageCacheEntry
+	if err := config.DB.Where("stale = ? AND ref_count = 0", true).Find(&staleEntries).Error; err != nil {
+		log.Printf("Failed to load stale cache entries: %v", err)
+		return
+	}
+	for i := range staleEntries {
+		if err := p.removeEntryLocked(&staleEntries[i]); err != nil {
+			log.Printf("Failed to remove stale cached package %s: %v", staleEntries[i].Path, err)
+		}
+	}
+}
+
+// removeEntryLocked 删除缓存文件及条目，调用方需持有 packageCacheMutex
+//
+// 过期条目与替换它的条目使用相同的路径，路径仍被其他条目使用时只删除条目。
+func (p *PackageCacheService) removeEntryLocked(entry *models.PackageCacheEntry) error {
+	var shared int64
+	if err := config.DB.Model(&models.PackageCacheEntry{}).Where("path = ? AND id <> ?", entry.Path, entry.ID).Count(&shared).Error; err != nil {
+		return err
+	}
+	if shared == 0 {
+		if err := os.RemoveAll(filepath.Dir(entry.Path)); err != nil {
+			return err
+		}
+	}
+	return config.DB.Delete(entry).Error
+}
@@ -0,0 +1,45 @@
+package services
+
+import (
+	"runtime"
+	"sync"
+	"testing"
+)
+
+func TestLockPackageKey(t *testing.T) {
+	const workers = 20
+	keys := []string{"a", "b", "c"}
+
+	var wg sync.WaitGroup
+	var countersMutex sync.Mutex
+	holders := make(map[string]int)
+	for i := 0; i < workers; i++ {
+		for _, key := range keys {
+			wg.Add(1)
+			go func(key string) {
+				defer wg.Done()
+				unlock := lockPackageKey(key)
+				defer unlock()
+
+				countersMutex.Lock()
+				holders[key]++
+				if holders[key] > 1 {
+					t.Errorf("key %s held by %d goroutines", key, holders[key])
+				}
+				countersMutex.Unlock()
+				runtime.Gosched()
+
+				countersMutex.Lock()
+				holders[key]--
+				countersMutex.Unlock()
+			}(key)
+		}
+	}
+	wg.Wait()
+
+	packageKeyLocksMutex.Lock()
+	defer packageKeyLocksMutex.Unlock()
+	if len(packageKeyLocks) != 0 {
+		t.Errorf("packageKeyLocks has %d entries after all locks were released", len(packageKeyLocks))
+	}
+}
@@ -0,0 +1,308 @@
+package services
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"crat/config"
+	"crat/models"
+)
+
+// ErrPackageContentInvalid 包无法解压或缺少必需文件
+var ErrPackageContentInvalid = errors.New("package content check failed")
+
+const (
+	// maxRecordedPackageFiles 记录到运行中的文件列表上限，检查仍针对全部条目
+	maxRecordedPackageFiles = 5000
+	// maxVersionFileSize 读取版本文件内容的上限
+	maxVersionFileSize = 4096
+)
+
+// packageInspection 包内容检查结果
+type packageInspection struct {
+	Format      string   // tar.gz、tar 或 zip
+	Files       []string // 包内的文件 (不含目录)
+	VersionFile string   // 匹配到的版本文件
+	Version     string   // 版本文件的第一行非空内容
+}
+
+// ValidatePackageInspection 校验包内容检查规则，供创建和更新测试项时使用
+func ValidatePackageInspection(raw json.RawMessage) error {
+	testItem := models.TestItem{PackageInspection: raw}
+	rules, err := testItem.GetPackageInspection()
+	if err != nil {
+		return fmt.Errorf("invalid package inspection format: %v", err)
+	}
+	patterns := append([]string{rules.VersionFile}, rules.RequiredFiles...)
+	for _, pattern := range patterns {
+		if strings.TrimSpace(pattern) == "" {
+			return fmt.Errorf("package inspection patterns must not be empty")
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid package inspection pattern %q: %v", pattern, err)
+		}
+	}
+	return nil
+}
+
+// packageArchiveFormat 根据文件名判断包的归档格式，不支持的格式返回空字符串
+func packageArchiveFormat(fileName string) string {
+	lower := strings.ToLower(fileName)
+	switch {
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		return "tar.gz"
+	case strings.HasSuffix(lower, ".tar"):
+		return "tar"
+	case strings.HasSuffix(lower, ".zip"):
+		return "zip"
+	}
+	return ""
+}
+
+// normalizeEntryName 统一归档条目的路径格式，去掉开头的 ./ 和 /
+func normalizeEntryName(name string) string {
+	name = strings.TrimPrefix(path.Clean("/"+strings.ReplaceAll(name, "\\", "/")), "/")
+	if name == "." {
+		return ""
+	}
+	return name
+}
+
+// matchPackageEntry 判断条目是否匹配模式，模式可以匹配完整路径或去掉顶层目录后的路径
+func matchPackageEntry(pattern, name string) bool {
+	if matched, _ := path.Match(pattern, name); matched {
+		return true
+	}
+	if i := strings.Index(name, "/"); i >= 0 {
+		matched, _ := path.Match(pattern, name[i+1:])
+		return matched
+	}
+	return false
+}
+
+// firstLine 获取内容的第一行非空内容
+func firstLine(content []byte) string {
+	scanner := bufio.NewScanner(strings.NewReader(string(content)))
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			return line
+		}
+	}
+	return ""
+}
+
+// inspectPackage 列出包内的文件并读取版本文件
+//
+// 只读取一遍归档，版本文件以第一个匹配的条目为准。
+func inspectPackage(ctx context.Context, localPath, versionPattern string) (*packageInspection, error) {
+	format := packageArchiveFormat(localPath)
+	if format == "" {
+		return nil, nil
+	}
+	result := &packageInspection{Format: format}
+
+	visit := func(name string, open func() (io.Reader, error)) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		name = normalizeEntryName(name)
+		if name == "" {
+			return nil
+		}
+		result.Files = append(result.Files, name)
+		if result.VersionFile == "" && matchPackageEntry(versionPattern, name) {
+			reader, err := open()
+			if err != nil {
+				return err
+			}
+			content, err := io.ReadAll(io.LimitReader(reader, maxVersionFileSize))
+			if err != nil {
+				return fmt.Errorf("failed to read %s: %v", name, err)
+			}
+			result.VersionFile = name
+			result.Version = firstLine(content)
+		}
+		return nil
+	}
+
+	var err error
+	if format == "zip" {
+		err = walkZip(localPath, visit)
+	} else {
+		err = walkTar(localPath, format == "tar.gz", visit)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// walkTar 遍历 tar / tar.gz 中的普通文件
+func walkTar(localPath string, gzipped bool, visit func(name string, open func() (io.Reader, error)) error) error {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var reader io.Reader = bufio.NewReader(file)
+	if gzipped {
+		gz, err := gzip.NewReader(reader)
+		if err != nil {
+			return fmt.Errorf("%w: not a valid gzip file: %v", ErrPackageContentInvalid, err)
+		}
+		defer gz.Close()
+		reader = gz
+	}
+
+	tr := tar.NewReader(reader)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%w: corrupt archive: %v", ErrPackageContentInvalid, err)
+		}
+		if header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeRegA {
+			continue
+		}
+		if err := visit(header.Name, func() (io.Reader, error) { return tr, nil }); err != nil {
+			return err
+		}
+	}
+}
+
+// walkZip 遍历 zip 中的文件
+func walkZip(localPath string, visit func(name string, open func() (io.Reader, error)) error) error {
+	zr, err := zip.OpenReader(localPath)
+	if err != nil {
+		return fmt.Errorf("%w: not a valid zip file: %v", ErrPackageContentInvalid, err)
+	}
+	defer zr.Close()
+
+	for _, entry := range zr.File {
+		if entry.FileInfo().IsDir() {
+			continue
+		}
+		var rc io.ReadCloser
+		err := visit(entry.Name, func() (io.Reader, error) {
+			var err error
+			rc, err = entry.Open()
+			if err != nil {
+				return nil, fmt.Errorf("%w: corrupt archive: %v", ErrPackageContentInvalid, err)
+			}
+			return rc, nil
+		})
+		if rc != nil {
+			rc.Close()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// missingRequiredFiles 获取没有匹配任何条目的必需文件模式
+func missingRequiredFiles(files []string, required []string) []string {
+	var missing []string
+	for _, pattern := range required {
+		found := false
+		for _, name := range files {
+			if matchPackageEntry(pattern, name) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, pattern)
+		}
+	}
+	return missing
+}
+
+// inspectRunPackage 检查运行下载的包内容，记录版本号和文件列表
+func (s *DeployTestService) inspectRunPackage(ctx context.Context, deployTestRun *models.DeployTestRun, testItem *models.TestItem) error {
+	s.addStep(deployTestRun.ID, models.StepInspect, "RUNNING", "Inspecting package content", "")
+
+	rules, err := testItem.GetPackageInspection()
+	if err != nil {
+		s.addStep(deployTestRun.ID, models.StepInspect, "FAILED", "", fmt.Sprintf("Invalid package inspection rules: %v", err))
+		return err
+	}
+
+	// 从数据库重新加载以获取下载路径
+	var run models.DeployTestRun
+	if err := config.DB.First(&run, deployTestRun.ID).Error; err != nil {
+		s.addStep(deployTestRun.ID, models.StepInspect, "FAILED", "", fmt.Sprintf("Failed to reload deploy test run: %v", err))
+		return err
+	}
+	if run.DownloadPath == "" {
+		err := fmt.Errorf("no downloaded package to inspect")
+		s.addStep(deployTestRun.ID, models.StepInspect, "FAILED", "", err.Error())
+		return err
+	}
+
+	inspection, err := inspectPackage(ctx, run.DownloadPath, rules.VersionFile)
+	if err != nil {
+		s.addStep(deployTestRun.ID, models.StepInspect, "FAILED", "", fmt.Sprintf("Failed to read package: %v", err))
+		if errors.Is(err, ErrPackageContentInvalid) {
+			return classifyError(models.FailureClassPackageContent, err)
+		}
+		return err
+	}
+	if inspection == nil {
+		if len(rules.RequiredFiles) > 0 || rules.VersionRequired {
+			err := classifyError(models.FailureClassPackageContent, fmt.Errorf("%w: unsupported archive format: %s", ErrPackageContentInvalid, run.DownloadPath))
+			s.addStep(deployTestRun.ID, models.StepInspect, "FAILED", "", err.Error())
+			return err
+		}
+		s.addStep(deployTestRun.ID, models.StepInspect, "COMPLETED", "Skipped, unsupported archive format", "")
+		return nil
+	}
+
+	// 即使检查失败也记录结果，便于排查
+	recorded := inspection.Files
+	if len(recorded) > maxRecordedPackageFiles {
+		recorded = recorded[:maxRecordedPackageFiles]
+	}
+	filesJSON, _ := json.Marshal(recorded)
+	config.DB.Model(&models.DeployTestRun{}).Where("id = ?", deployTestRun.ID).Updates(map[string]interface{}{
+		"package_version": inspection.Version,
+		"package_files":   filesJSON,
+	})
+
+	if missing := missingRequiredFiles(inspection.Files, rules.RequiredFiles); len(missing) > 0 {
+		err := classifyError(models.FailureClassPackageContent, fmt.Errorf("%w: missing required files: %s", ErrPackageContentInvalid, strings.Join(missing, ", ")))
+		s.addStep(deployTestRun.ID, models.StepInspect, "FAILED", "", err.Error())
+		return err
+	}
+	if inspection.VersionFile == "" && rules.VersionRequired {
+		err := classifyError(models.FailureClassPackageContent, fmt.Errorf("%w: no file matches version pattern %s", ErrPackageContentInvalid, rules.VersionFile))
+		s.addStep(deployTestRun.ID, models.StepInspect, "FAILED", "", err.Error())
+		return err
+	}
+
+	details := fmt.Sprintf("%d files in %s package", len(inspection.Files), inspection.Format)
+	if inspection.VersionFile != "" {
+		details += fmt.Sprintf(", version %q from %s", inspection.Version, inspection.VersionFile)
+	} else {
+		details += ", no version file found"
+	}
+	if len(rules.RequiredFiles) > 0 {
+		details += fmt.Sprintf(", %d required files present", len(rules.RequiredFiles))
+	}
+	s.addStep(deployTestRun.ID, models.StepInspect, "COMPLETED", details, "")
+	return nil
+}
@@ -0,0 +1,250 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"crat/config"
+	"crat/models"
+)
+
+var (
+	// ErrTestItemNotFound 测试项不存在
+	ErrTestItemNotFound = errors.New("test item not found")
+	// ErrBuildInfoNotFound 构建信息不存在
+	ErrBuildInfoNotFound = errors.New("build info not found")
+)
+
+// PackageCandidate 包选择预览中的一个文件
+type PackageCandidate struct {
+	Name     string     `json:"name"`
+	Size     int64      `json:"size"`
+	ModTime  *time.Time `json:"mod_time,omitempty"`
+	Eligible bool       `json:"eligible"`
+	Reason   string     `json:"reason,omitempty"` // 被排除的原因
+}
+
+// PackageSelectionResult 包选择结果
+type PackageSelectionResult struct {
+	SourceType   string                        `json:"source_type"`
+	DirectoryURL string                        `json:"directory_url"`
+	Rules        *models.PackageSelectionRules `json:"rules"`
+	Selected     string                        `json:"selected,omitempty"`
+	DownloadURL  string                        `json:"download_url,omitempty"`
+	Error        string                        `json:"error,omitempty"`
+	Candidates   []PackageCandidate            `json:"candidates"`
+}
+
+// ValidatePackageSelection 校验包选择规则，供创建和更新测试项时使用
+func ValidatePackageSelection(raw json.RawMessage) error {
+	testItem := models.TestItem{PackageSelection: raw}
+	rules, err := testItem.GetPackageSelection()
+	if err != nil {
+		return fmt.Errorf("invalid package selection format: %v", err)
+	}
+	_, err = compilePackageSelection(rules, "")
+	return err
+}
+
+// compiledSelection 编译后的包选择规则
+type compiledSelection struct {
+	rules   *models.PackageSelectionRules
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+}
+
+// compilePackageSelection 编译包选择规则中的正则，未配置 include 时按测试项名称匹配
+func compilePackageSelection(rules *models.PackageSelectionRules, testItemName string) (*compiledSelection, error) {
+	compiled := &compiledSelection{rules: rules}
+
+	switch rules.TieBreak {
+	case models.PackageTieBreakRelease, models.PackageTieBreakFirst, models.PackageTieBreakNewest,
+		models.PackageTieBreakLargest, models.PackageTieBreakExact:
+	default:
+		return nil, fmt.Errorf("unknown package tie_break: %s", rules.TieBreak)
+	}
+
+	for _, extension := range rules.Extensions {
+		if !strings.HasPrefix(extension, ".") {
+			return nil, fmt.Errorf("package extension must start with '.': %s", extension)
+		}
+	}
+
+	includes := rules.Include
+	if len(includes) == 0 && testItemName != "" {
+		includes = []string{"(?i)" + regexp.QuoteMeta(testItemName)}
+	}
+	for _, pattern := range includes {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid package include pattern %q: %v", pattern, err)
+		}
+		compiled.include = append(compiled.include, re)
+	}
+	for _, pattern := range rules.Exclude {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid package exclude pattern %q: %v", pattern, err)
+		}
+		compiled.exclude = append(compiled.exclude, re)
+	}
+	return compiled, nil
+}
+
+// rejectReason 返回文件不符合规则的原因，符合时返回空字符串
+func (c *compiledSelection) rejectReason(name string) string {
+	if packageExtension(name, c.rules.Extensions) == "" {
+		return "extension not allowed"
+	}
+
+	included := len(c.include) == 0
+	for _, re := range c.include {
+		if re.MatchString(name) {
+			included = true
+			break
+		}
+	}
+	if !included {
+		return "does not match include patterns"
+	}
+
+	for _, re := range c.exclude {
+		if re.MatchString(name) {
+			return fmt.Sprintf("matches exclude pattern %q", re.String())
+		}
+	}
+
+	if c.rules.TieBreak == models.PackageTieBreakExact {
+		base := strings.TrimSuffix(name, packageExtension(name, c.rules.Extensions))
+		if !strings.EqualFold(base, c.rules.ExactName) {
+			return fmt.Sprintf("name is not exactly %q", c.rules.ExactName)
+		}
+	}
+	return ""
+}
+
+// packageExtension 返回文件名匹配的扩展名 (不区分大小写)，不匹配时返回空字符串
+func packageExtension(name string, extensions []string) string {
+	lower := strings.ToLower(name)
+	for _, extension := range extensions {
+		if strings.HasSuffix(lower, strings.ToLower(extension)) {
+			return name[len(name)-len(extension):]
+		}
+	}
+	return ""
+}
+
+// selectPackage 按规则从目录列表中选择包文件，结果中包含每个文件的判定
+func selectPackage(entries []ArtifactFile, compiled *compiledSelection) *PackageSelectionResult {
+	result := &PackageSelectionResult{Rules: compiled.rules, Candidates: []PackageCandidate{}}
+
+	var eligible []ArtifactFile
+	for _, entry := range entries {
+		reason := compiled.rejectReason(entry.Name)
+		result.Candidates = append(result.Candidates, PackageCandidate{
+			Name:     entry.Name,
+			Size:     entry.Size,
+			ModTime:  entry.ModTime,
+			Eligible: reason == "",
+			Reason:   reason,
+		})
+		if reason == "" {
+			eligible = append(eligible, entry)
+		}
+	}
+
+	if len(eligible) == 0 {
+		result.Error = "no package file matches the selection rules"
+		return result
+	}
+
+	selected := eligible[0]
+	switch compiled.rules.TieBreak {
+	case models.PackageTieBreakRelease:
+		for _, entry := range eligible {
+			if strings.Contains(strings.ToLower(entry.Name), "release") {
+				selected = entry
+				break
+			}
+		}
+	case models.PackageTieBreakNewest:
+		// 没有修改时间的文件排在最后，时间相同时保持目录顺序
+		sort.SliceStable(eligible, func(i, j int) bool {
+			a, b := eligible[i].ModTime, eligible[j].ModTime
+			if a == nil || b == nil {
+				return a != nil
+			}
+			return a.After(*b)
+		})
+		if eligible[0].ModTime == nil && len(eligible) > 1 {
+			result.Error = "directory listing has no modification times for tie_break newest"
+			return result
+		}
+		selected = eligible[0]
+	case models.PackageTieBreakLargest:
+		sort.SliceStable(eligible, func(i, j int) bool {
+			return eligible[i].Size > eligible[j].Size
+		})
+		if eligible[0].Size < 0 && len(eligible) > 1 {
+			result.Error = "directory listing has no file sizes for tie_break largest"
+			return result
+		}
+		selected = eligible[0]
+	}
+
+	result.Selected = selected.Name
+	result.DownloadURL = selected.URL
+	return result
+}
+
+// resolvePackageSelection 列出制品来源中的文件并按测试项的规则选择包文件
+func (s *DeployTestService) resolvePackageSelection(ctx context.Context, source ArtifactSource, buildInfo *models.BuildInfo, testItem *models.TestItem) (*PackageSelectionResult, error) {
+	rules, err := testItem.GetPackageSelection()
+	if err != nil {
+		return nil, fmt.Errorf("invalid package selection format: %v", err)
+	}
+	compiled, err := compilePackageSelection(rules, testItem.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := source.List(ctx, buildInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	result := selectPackage(entries, compiled)
+	result.SourceType = source.Type()
+	result.DirectoryURL = source.Location(buildInfo)
+	return result, nil
+}
+
+// PreviewPackageSelection 预览测试项在指定构建中会选择的包文件
+//
+// rules 不为空时使用传入的规则代替测试项已保存的规则，用于编辑规则时试验。
+func (s *DeployTestService) PreviewPackageSelection(ctx context.Context, testItemID, buildInfoID uint, rules json.RawMessage) (*PackageSelectionResult, error) {
+	var testItem models.TestItem
+	if err := config.DB.First(&testItem, testItemID).Error; err != nil {
+		return nil, ErrTestItemNotFound
+	}
+	if len(rules) > 0 {
+		testItem.PackageSelection = rules
+	}
+
+	buildInfo, err := s.buildService.GetBuildInfoByID(buildInfoID)
+	if err != nil {
+		return nil, ErrBuildInfoNotFound
+	}
+
+	source, err := s.artifactSourceFor(buildInfo)
+	if err != nil {
+		return nil, err
+	}
+	return s.resolvePackageSelection(ctx, source, buildInfo, &testItem)
+}
@@ -0,0 +1,160 @@
+package services
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"crat/models"
+)
+
+// compileTestSelection 按测试项的方式解析并编译包选择规则
+func compileTestSelection(t *testing.T, testItemName, rules string) *compiledSelection {
+	t.Helper()
+	testItem := models.TestItem{Name: testItemName}
+	if rules != "" {
+		testItem.PackageSelection = json.RawMessage(rules)
+	}
+	parsed, err := testItem.GetPackageSelection()
+	if err != nil {
+		t.Fatalf("GetPackageSelection(%s) error: %v", rules, err)
+	}
+	compiled, err := compilePackageSelection(parsed, testItem.Name)
+	if err != nil {
+		t.Fatalf("compilePackageSelection(%s) error: %v", rules, err)
+	}
+	return compiled
+}
+
+func TestPackageExtension(t *testing.T) {
+	tests := []struct {
+		name       string
+		extensions []string
+		want       string
+	}{
+		{"agent.tar.gz", models.DefaultPackageExtensions, ".tar.gz"},
+		{"agent.TGZ", models.DefaultPackageExtensions, ".TGZ"},
+		{"agent.zip", models.DefaultPackageExtensions, ""},
+		{"agent.zip", []string{".zip"}, ".zip"},
+		{"agent.gz", models.DefaultPackageExtensions, ""},
+	}
+	for _, tt := range tests {
+		if got := packageExtension(tt.name, tt.extensions); got != tt.want {
+			t.Errorf("packageExtension(%q, %v) = %q, want %q", tt.name, tt.extensions, got, tt.want)
+		}
+	}
+}
+
+func TestRejectReason(t *testing.T) {
+	tests := []struct {
+		name      string
+		rules     string
+		file      string
+		wantMatch bool
+	}{
+		{"default matches test item name", "", "Agent-1.0.tar.gz", true},
+		{"default rejects other names", "", "other-1.0.tar.gz", false},
+		{"default rejects extension", "", "agent-1.0.zip", false},
+		{"custom extension", `{"extensions":[".zip"]}`, "agent-1.0.zip", true},
+		{"include pattern replaces name match", `{"include":["^server-"]}`, "server-1.0.tgz", true},
+		{"include pattern rejects name match", `{"include":["^server-"]}`, "agent-1.0.tgz", false},
+		{"exclude pattern", `{"exclude":["debug"]}`, "agent-debug.tgz", false},
+		{"exact name ignores case", `{"tie_break":"exact"}`, "AGENT.tar.gz", true},
+		{"exact name rejects suffix", `{"tie_break":"exact"}`, "agent-1.0.tar.gz", false},
+		{"exact name from rules", `{"tie_break":"exact","exact_name":"agent-1.0"}`, "agent-1.0.tar.gz", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			compiled := compileTestSelection(t, "agent", tt.rules)
+			reason := compiled.rejectReason(tt.file)
+			if (reason == "") != tt.wantMatch {
+				t.Errorf("rejectReason(%q) = %q, want match %v", tt.file, reason, tt.wantMatch)
+			}
+		})
+	}
+}
+
+func TestSelectPackage(t *testing.T) {
+	older := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	newer := time.Date(2024, 6, 2, 0, 0, 0, 0, time.UTC)
+	entries := []ArtifactFile{
+		{Name: "agent-1.0.tar.gz", URL: "http://pkg/agent-1.0.tar.gz", Size: 100, ModTime: &older},
+		{Name: "agent-1.0-release.tar.gz", URL: "http://pkg/agent-1.0-release.tar.gz", Size: 50, ModTime: &older},
+		{Name: "agent-debug.tgz", URL: "http://pkg/agent-debug.tgz", Size: 300, ModTime: &newer},
+		{Name: "other.tar.gz", URL: "http://pkg/other.tar.gz", Size: 900, ModTime: &newer},
+		{Name: "agent.zip", URL: "http://pkg/agent.zip", Size: 900, ModTime: &newer},
+	}
+	noMetadata := []ArtifactFile{
+		{Name: "agent-a.tgz", URL: "http://pkg/agent-a.tgz", Size: -1},
+		{Name: "agent-b.tgz", URL: "http://pkg/agent-b.tgz", Size: -1},
+	}
+
+	tests := []struct {
+		name         string
+		rules        string
+		entries      []ArtifactFile
+		wantSelected string
+		wantEligible int
+	}{
+		{"release preferred", "", entries, "agent-1.0-release.tar.gz", 3},
+		{"release falls back to first", `{"exclude":["release"]}`, entries, "agent-1.0.tar.gz", 2},
+		{"first", `{"tie_break":"first"}`, entries, "agent-1.0.tar.gz", 3},
+		{"newest", `{"tie_break":"newest"}`, entries, "agent-debug.tgz", 3},
+		{"largest", `{"tie_break":"largest"}`, entries, "agent-debug.tgz", 3},
+		{"largest with exclude", `{"tie_break":"largest","exclude":["debug"]}`, entries, "agent-1.0.tar.gz", 2},
+		{"exact", `{"tie_break":"exact","exact_name":"agent-debug"}`, entries, "agent-debug.tgz", 1},
+		{"nothing matches", `{"include":["^server-"]}`, entries, "", 0},
+		{"newest without mod times", `{"tie_break":"newest"}`, noMetadata, "", 2},
+		{"largest without sizes", `{"tie_break":"largest"}`, noMetadata, "", 2},
+		{"single candidate without metadata", `{"tie_break":"newest"}`, noMetadata[:1], "agent-a.tgz", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := selectPackage(tt.entries, compileTestSelection(t, "agent", tt.rules))
+			if result.Selected != tt.wantSelected {
+				t.Errorf("Selected = %q (error %q), want %q", result.Selected, result.Error, tt.wantSelected)
+			}
+			if (result.Error == "") != (tt.wantSelected != "") {
+				t.Errorf("Error = %q with selected %q", result.Error, result.Selected)
+			}
+			if tt.wantSelected != "" && result.DownloadURL != "http://pkg/"+tt.wantSelected {
+				t.Errorf("DownloadURL = %q", result.DownloadURL)
+			}
+			if len(result.Candidates) != len(tt.entries) {
+				t.Fatalf("got %d candidates, want %d", len(result.Candidates), len(tt.entries))
+			}
+			eligible := 0
+			for _, candidate := range result.Candidates {
+				if candidate.Eligible {
+					eligible++
+				} else if candidate.Reason == "" {
+					t.Errorf("candidate %s rejected without reason", candidate.Name)
+				}
+			}
+			if eligible != tt.wantEligible {
+				t.Errorf("got %d eligible candidates, want %d", eligible, tt.wantEligible)
+			}
+		})
+	}
+}
+
+func TestValidatePackageSelection(t *testing.T) {
+	tests := []struct {
+		raw     string
+		wantErr bool
+	}{
+		{"", false},
+		{"null", false},
+		{`{"include":["^agent-"],"exclude":["debug"],"extensions":[".zip"],"tie_break":"newest"}`, false},
+		{`{"tie_break":"random"}`, true},
+		{`{"extensions":["zip"]}`, true},
+		{`{"include":["("]}`, true},
+		{`{"exclude":["["]}`, true},
+		{`{"include":"agent"}`, true},
+	}
+	for _, tt := range tests {
+		if err := ValidatePackageSelection(json.RawMessage(tt.raw)); (err != nil) != tt.wantErr {
+			t.Errorf("ValidatePackageSelection(%s) error = %v, wantErr %v", tt.raw, err, tt.wantErr)
+		}
+	}
+}
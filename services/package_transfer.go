@@ -0,0 +1,208 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"crat/models"
+)
+
+// 包传递给测试服务器的方式
+const (
+	TransferModePath     = "path"      // 传递 CRAT 主机上的本地路径，测试服务器需共享文件系统 (默认)
+	TransferModeUpload   = "upload"    // 以 multipart 上传包文件
+	TransferModeFetchURL = "fetch_url" // 传递 CRAT 提供的签名下载链接，由测试服务器拉取
+)
+
+// ValidateTransferMode 校验包传递方式
+func ValidateTransferMode(mode string) error {
+	switch mode {
+	case "", TransferModePath, TransferModeUpload, TransferModeFetchURL:
+		return nil
+	}
+	return fmt.Errorf("unknown package transfer mode: %s", mode)
+}
+
+// transferModeFor 获取测试服务器使用的包传递方式
+//
+// test_server_transfer_modes 按测试服务器URL单独配置，例如 {"http://10.8.24.59:8000": "upload"}，
+// 未单独配置时使用 test_server_transfer_mode。
+func transferModeFor(settings map[string]string, testServerURL string) string {
+	if raw := settings["test_server_transfer_modes"]; raw != "" {
+		var modes map[string]string
+		if err := json.Unmarshal([]byte(raw), &modes); err != nil {
+			log.Printf("Invalid test_server_transfer_modes setting: %v", err)
+		} else if mode, ok := modes[strings.TrimSuffix(testServerURL, "/")]; ok {
+			return mode
+		} else if mode, ok := modes[testServerURL]; ok {
+			return mode
+		}
+	}
+	if mode := settings["test_server_transfer_mode"]; mode != "" {
+		return mode
+	}
+	return TransferModePath
+}
+
+// packageRequestFields 获取 path 和 fetch_url 方式在 JSON 请求体中传递包的字段
+func packageRequestFields(deployTestRun *models.DeployTestRun, mode string, settings map[string]string) (map[string]interface{}, string, error) {
+	switch mode {
+	case "", TransferModePath:
+		return map[string]interface{}{
+			"package_path": deployTestRun.DownloadPath,
+		}, "local path " + deployTestRun.DownloadPath, nil
+
+	case TransferModeFetchURL:
+		ttl, _ := artifactLinkTTL(settings)
+		packageURL, expiresAt, err := artifactURL(deployTestRun.ID, ttl, settings)
+		if err != nil {
+			return nil, "", err
+		}
+		return map[string]interface{}{
+			"package_url":    packageURL,
+			"package_name":   filepath.Base(deployTestRun.DownloadPath),
+			"package_sha256": deployTestRun.PackageSHA256,
+		}, fmt.Sprintf("fetch URL valid until %s", expiresAt.Format(time.RFC3339)), nil
+	}
+	return nil, "", fmt.Errorf("unknown package transfer mode: %s", mode)
+}
+
+// sendTestRequest 按传递方式把包和测试参数发送给测试服务器
+//
+// path 和 fetch_url 方式发送 JSON，upload 方式把参数作为表单字段，包文件作为 package 字段流式上传。
+func sendTestRequest(ctx context.Context, client *HTTPClient, deployTestRun *models.DeployTestRun, requestURL, mode string, requestBody map[string]interface{}, settings map[string]string) (*HTTPResponse, string, error) {
+	if mode == TransferModeUpload {
+		response, err := uploadPackage(ctx, deployTestRun, requestURL, requestBody)
+		return response, "multipart upload", err
+	}
+
+	fields, details, err := packageRequestFields(deployTestRun, mode, settings)
+	if err != nil {
+		return nil, "", err
+	}
+	for key, value := range fields {
+		requestBody[key] = value
+	}
+	response, err := client.SendRequestWithContext(ctx, "POST", requestURL, map[string]string{
+		"Content-Type": "application/json",
+	}, requestBody, 300)
+	return response, details, err
+}
+
+// formFieldValue 把测试参数转换为表单字段值，与 JSON 请求体中的值保持一致
+//
+// 字符串原样发送，数值和布尔值按 JSON 格式，对象和数组以 JSON 字符串发送，null 时返回 false 不发送该字段。
+func formFieldValue(value interface{}) (string, bool, error) {
+	if text, ok := value.(string); ok {
+		return text, true, nil
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", false, err
+	}
+	if string(data) == "null" {
+		return "", false, nil
+	}
+	// 指向字符串的指针等编码为 JSON 字符串的值发送去掉引号后的内容
+	var text string
+	if json.Unmarshal(data, &text) == nil {
+		return text, true, nil
+	}
+	return string(data), true, nil
+}
+
+// uploadPackage 以 multipart/form-data 流式上传包文件和测试参数
+func uploadPackage(ctx context.Context, deployTestRun *models.DeployTestRun, requestURL string, fields map[string]interface{}) (*HTTPResponse, error) {
+	file, err := os.Open(deployTestRun.DownloadPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open package file: %v", err)
+	}
+	defer file.Close()
+
+	reader, writer := io.Pipe()
+	form := multipart.NewWriter(writer)
+	go func() {
+		err := func() error {
+			keys := make([]string, 0, len(fields))
+			for key := range fields {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				value, ok, err := formFieldValue(fields[key])
+				if err != nil {
+					return fmt.Errorf("failed to encode form field %s: %v", key, err)
+				}
+				if !ok {
+					continue
+				}
+				if err := form.WriteField(key, value); err != nil {
+					return err
+				}
+			}
+			if err := form.WriteField("package_sha256", deployTestRun.PackageSHA256); err != nil {
+				return err
+			}
+			part, err := form.CreateFormFile("package", filepath.Base(deployTestRun.DownloadPath))
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(part, file); err != nil {
+				return err
+			}
+			return form.Close()
+		}()
+		writer.CloseWithError(err)
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", requestURL, reader)
+	if err != nil {
+		reader.Close()
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+
+	// 上传耗时与下载相当，按下载时限再留出测试服务器响应的时间
+	timeoutMinutes := deployTestRun.DownloadTimeout
+	if timeoutMinutes <= 0 {
+		timeoutMinutes = models.DefaultDownloadTimeoutMinutes
+	}
+	client := &http.Client{Timeout: time.Duration(timeoutMinutes)*time.Minute + 5*time.Minute}
+
+	startTime := time.Now()
+	resp, err := client.Do(req)
+	duration := time.Since(startTime)
+	if err != nil {
+		reader.Close()
+		return &HTTPResponse{Error: err.Error(), Duration: duration}, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return &HTTPResponse{StatusCode: resp.StatusCode, Error: err.Error(), Duration: duration}, err
+	}
+
+	response := &HTTPResponse{
+		StatusCode: resp.StatusCode,
+		Headers:    make(map[string]string),
+		Body:       string(body),
+		Duration:   duration,
+	}
+	for key, values := range resp.Header {
+		if len(values) > 0 {
+			response.Headers[key] = values[0]
+		}
+	}
+	return response, nil
+}
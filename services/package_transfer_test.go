@@ -0,0 +1,47 @@
+package services
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestFormFieldValue(t *testing.T) {
+	name := "agent"
+	var missing *string
+	tests := []struct {
+		name   string
+		value  interface{}
+		want   string
+		wantOK bool
+	}{
+		{"string", "http://pkg/a.tgz", "http://pkg/a.tgz", true},
+		{"string with quotes", `say "hi"`, `say "hi"`, true},
+		{"empty string", "", "", true},
+		{"bool", true, "true", true},
+		{"int", 42, "42", true},
+		{"uint", uint(7), "7", true},
+		{"float", 0.5, "0.5", true},
+		{"large float", float64(12345678901), "12345678901", true},
+		{"json number", json.Number("3.14"), "3.14", true},
+		{"string pointer", &name, "agent", true},
+		{"nil", nil, "", false},
+		{"nil pointer", missing, "", false},
+		{"object", map[string]interface{}{"env": "qa", "retries": 2}, `{"env":"qa","retries":2}`, true},
+		{"array", []interface{}{"a", 1}, `["a",1]`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok, err := formFieldValue(tt.value)
+			if err != nil {
+				t.Fatalf("formFieldValue(%v) error: %v", tt.value, err)
+			}
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("formFieldValue(%v) = %q, %v, want %q, %v", tt.value, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+
+	if _, _, err := formFieldValue(func() {}); err == nil {
+		t.Error("expected error for unsupported value")
+	}
+}
@@ -0,0 +1,271 @@
+package services
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"crat/models"
+)
+
+var (
+	// ErrPackageChecksumMismatch 包文件校验和与期望值不一致
+	ErrPackageChecksumMismatch = errors.New("package checksum mismatch")
+	// ErrPackageSignatureInvalid 包文件签名校验失败
+	ErrPackageSignatureInvalid = errors.New("package signature verification failed")
+)
+
+// maxSidecarSize 校验和、签名等附属文件的大小上限
+const maxSidecarSize = 64 * 1024
+
+// expectedChecksum 期望的包文件校验和
+type expectedChecksum struct {
+	Algorithm string // sha256 或 md5
+	Value     string // 小写十六进制
+	Source    string // 来源说明，用于步骤详情
+}
+
+// packageVerification 包文件校验结果
+type packageVerification struct {
+	SHA256  string   // 下载文件的 sha256
+	Details []string // 已完成的校验项
+}
+
+// verifyPackage 校验下载的包文件的校验和与签名
+//
+// expected 为 expectedPackageChecksum 获取的期望校验和，配置了签名公钥时校验 <包名>.sig。
+func (s *DeployTestService) verifyPackage(ctx context.Context, source ArtifactSource, packageURL, localPath string, expected *expectedChecksum, settings map[string]string) (*packageVerification, error) {
+	sha256Sum, md5Sum, err := fileDigests(localPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute package checksum: %v", err)
+	}
+	result := &packageVerification{SHA256: sha256Sum}
+
+	if expected == nil {
+		if settings["package_checksum_required"] == "true" {
+			return nil, classifyError(models.FailureClassIntegrity, fmt.Errorf("%w: no checksum available for %s", ErrPackageChecksumMismatch, packageURL))
+		}
+	} else {
+		actual := sha256Sum
+		if expected.Algorithm == "md5" {
+			actual = md5Sum
+		}
+		if actual != expected.Value {
+			return nil, classifyError(models.FailureClassIntegrity, fmt.Errorf("%w: %s expected %s (from %s), got %s",
+				ErrPackageChecksumMismatch, expected.Algorithm, expected.Value, expected.Source, actual))
+		}
+		result.Details = append(result.Details, fmt.Sprintf("%s verified from %s", expected.Algorithm, expected.Source))
+	}
+
+	publicKeys, err := parseSigningKeys(settings["package_signing_public_keys"])
+	if err != nil {
+		return nil, fmt.Errorf("invalid package_signing_public_keys setting: %v", err)
+	}
+	if len(publicKeys) == 0 {
+		return result, nil
+	}
+
+	signature, found, err := s.fetchSidecar(ctx, source, packageURL+".sig")
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		if settings["package_signature_required"] == "true" {
+			return nil, classifyError(models.FailureClassIntegrity, fmt.Errorf("%w: signature file %s.sig not found", ErrPackageSignatureInvalid, packageURL))
+		}
+		return result, nil
+	}
+
+	if err := verifySignature(sha256Sum, signature, publicKeys); err != nil {
+		return nil, classifyError(models.FailureClassIntegrity, err)
+	}
+	result.Details = append(result.Details, "ed25519 signature verified")
+	return result, nil
+}
+
+// expectedPackageChecksum 获取期望的包文件校验和，没有可用的校验和时返回nil
+//
+// 优先取 Jenkins Webhook 数据中的 PACKAGE_SHA256 / PACKAGE_MD5，其次取同目录下的 <包名>.sha256 / <包名>.md5 文件。
+func (s *DeployTestService) expectedPackageChecksum(ctx context.Context, source ArtifactSource, packageURL string, buildInfo *models.BuildInfo) (*expectedChecksum, error) {
+	// Jenkins Webhook 数据
+	if len(buildInfo.RawData) > 0 {
+		var rawData map[string]interface{}
+		if err := json.Unmarshal(buildInfo.RawData, &rawData); err == nil {
+			for _, candidate := range []struct{ key, algorithm string }{
+				{"PACKAGE_SHA256", "sha256"},
+				{"PACKAGE_MD5", "md5"},
+			} {
+				if value, ok := rawData[candidate.key].(string); ok && strings.TrimSpace(value) != "" {
+					return &expectedChecksum{
+						Algorithm: candidate.algorithm,
+						Value:     strings.ToLower(strings.TrimSpace(value)),
+						Source:    "webhook " + candidate.key,
+					}, nil
+				}
+			}
+		}
+	}
+
+	// 同目录下的校验和文件
+	for _, algorithm := range []string{"sha256", "md5"} {
+		content, found, err := s.fetchSidecar(ctx, source, packageURL+"."+algorithm)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			continue
+		}
+		// 兼容 sha256sum 输出格式: "<hash>  <文件名>"
+		fields := strings.Fields(string(content))
+		if len(fields) == 0 {
+			continue
+		}
+		return &expectedChecksum{
+			Algorithm: algorithm,
+			Value:     strings.ToLower(fields[0]),
+			Source:    "sidecar ." + algorithm,
+		}, nil
+	}
+
+	return nil, nil
+}
+
+// fetchSidecar 获取包文件旁的附属文件，文件不存在时 found 为 false
+func (s *DeployTestService) fetchSidecar(ctx context.Context, source ArtifactSource, url string) ([]byte, bool, error) {
+	localPath, isLocal, err := localArtifactPath(source, url)
+	if err != nil {
+		return nil, false, classifyError(models.FailureClassIntegrity, err)
+	}
+	if isLocal {
+		file, err := os.Open(localPath)
+		if os.IsNotExist(err) {
+			return nil, false, nil
+		}
+		if err != nil {
+			return nil, false, classifyError(models.FailureClassDownload, fmt.Errorf("failed to read %s: %v", localPath, err))
+		}
+		defer file.Close()
+		content, err := readSidecar(file, localPath)
+		if err != nil {
+			return nil, false, err
+		}
+		return content, true, nil
+	}
+
+	req, err := newArtifactRequest(ctx, source, "GET", url)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to create request for %s: %v", url, err)
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, false, classifyError(models.FailureClassDownload, fmt.Errorf("failed to fetch %s: %v", url, err))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, false, classifyError(models.FailureClassDownload, fmt.Errorf("failed to fetch %s, status code: %d", url, resp.StatusCode))
+	}
+	content, err := readSidecar(resp.Body, url)
+	if err != nil {
+		return nil, false, err
+	}
+	return content, true, nil
+}
+
+// readSidecar 读取附属文件内容，超过 maxSidecarSize 的文件视为无效
+func readSidecar(r io.Reader, name string) ([]byte, error) {
+	content, err := io.ReadAll(io.LimitReader(r, maxSidecarSize+1))
+	if err != nil {
+		return nil, classifyError(models.FailureClassDownload, fmt.Errorf("failed to read %s: %v", name, err))
+	}
+	if len(content) > maxSidecarSize {
+		return nil, classifyError(models.FailureClassIntegrity, fmt.Errorf("%s exceeds %d bytes", name, maxSidecarSize))
+	}
+	return content, nil
+}
+
+// fileDigests 计算文件的 sha256 和 md5
+func fileDigests(path string) (string, string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", "", err
+	}
+	defer file.Close()
+
+	sha256Hash, md5Hash := sha256.New(), md5.New()
+	if _, err := io.Copy(io.MultiWriter(sha256Hash, md5Hash), file); err != nil {
+		return "", "", err
+	}
+	return hexDigest(sha256Hash), hexDigest(md5Hash), nil
+}
+
+func hexDigest(h hash.Hash) string {
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// parseSigningKeys 解析签名公钥，多个公钥以逗号或换行分隔，支持 base64 和十六进制格式
+func parseSigningKeys(raw string) ([]ed25519.PublicKey, error) {
+	var keys []ed25519.PublicKey
+	for _, field := range strings.FieldsFunc(raw, func(r rune) bool { return r == ',' || r == '\n' || r == ' ' }) {
+		key, err := decodeKeyMaterial(field, ed25519.PublicKeySize)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, ed25519.PublicKey(key))
+	}
+	return keys, nil
+}
+
+// decodeKeyMaterial 解码 base64 或十六进制编码的密钥/签名
+func decodeKeyMaterial(value string, size int) ([]byte, error) {
+	value = strings.TrimSpace(value)
+	if decoded, err := hex.DecodeString(value); err == nil && len(decoded) == size {
+		return decoded, nil
+	}
+	if decoded, err := base64.StdEncoding.DecodeString(value); err == nil && len(decoded) == size {
+		return decoded, nil
+	}
+	return nil, fmt.Errorf("expected %d bytes encoded as base64 or hex", size)
+}
+
+// verifySignature 使用任一公钥校验包文件的 ed25519 签名
+//
+// 签名对象为包文件 sha256 摘要的32字节原始值，摘要在计算校验和时已流式得出，无需把包读入内存。
+func verifySignature(sha256Sum string, signature []byte, publicKeys []ed25519.PublicKey) error {
+	// 签名文件可以是原始64字节，也可以是 base64 或十六进制文本
+	if len(signature) != ed25519.SignatureSize {
+		decoded, err := decodeKeyMaterial(string(signature), ed25519.SignatureSize)
+		if err != nil {
+			return fmt.Errorf("%w: invalid signature file: %v", ErrPackageSignatureInvalid, err)
+		}
+		signature = decoded
+	}
+
+	digest, err := hex.DecodeString(sha256Sum)
+	if err != nil || len(digest) != sha256.Size {
+		return fmt.Errorf("invalid package sha256 digest: %s", sha256Sum)
+	}
+
+	for _, key := range publicKeys {
+		if ed25519.Verify(key, digest, signature) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: signature does not match any configured public key", ErrPackageSignatureInvalid)
+}
@@ -0,0 +1,68 @@
+package services
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+
+	"crat/models"
+)
+
+func TestVerifySignature(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	otherKey, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+
+	digest := sha256.Sum256([]byte("package content"))
+	sha256Sum := hex.EncodeToString(digest[:])
+	signature := ed25519.Sign(privateKey, digest[:])
+	otherDigest := sha256.Sum256([]byte("tampered content"))
+
+	tests := []struct {
+		name      string
+		sha256Sum string
+		signature []byte
+		keys      []ed25519.PublicKey
+		wantErr   bool
+	}{
+		{"raw signature", sha256Sum, signature, []ed25519.PublicKey{publicKey}, false},
+		{"base64 signature", sha256Sum, []byte(base64.StdEncoding.EncodeToString(signature) + "\n"), []ed25519.PublicKey{publicKey}, false},
+		{"hex signature", sha256Sum, []byte(hex.EncodeToString(signature)), []ed25519.PublicKey{publicKey}, false},
+		{"any configured key", sha256Sum, signature, []ed25519.PublicKey{otherKey, publicKey}, false},
+		{"wrong key", sha256Sum, signature, []ed25519.PublicKey{otherKey}, true},
+		{"different content", hex.EncodeToString(otherDigest[:]), signature, []ed25519.PublicKey{publicKey}, true},
+		{"malformed signature", sha256Sum, []byte("not a signature"), []ed25519.PublicKey{publicKey}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifySignature(tt.sha256Sum, tt.signature, tt.keys)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("verifySignature() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrPackageSignatureInvalid) {
+				t.Errorf("verifySignature() error = %v, want ErrPackageSignatureInvalid", err)
+			}
+		})
+	}
+}
+
+func TestReadSidecar(t *testing.T) {
+	content, err := readSidecar(strings.NewReader(strings.Repeat("a", maxSidecarSize)), "app.apk.sig")
+	if err != nil || len(content) != maxSidecarSize {
+		t.Fatalf("readSidecar() = %d bytes, %v, want %d bytes", len(content), err, maxSidecarSize)
+	}
+
+	_, err = readSidecar(strings.NewReader(strings.Repeat("a", maxSidecarSize+1)), "app.apk.sig")
+	if failureClassOf(models.StepDownload, err) != models.FailureClassIntegrity {
+		t.Errorf("readSidecar() oversized error = %v, want integrity failure", err)
+	}
+}
@@ -0,0 +1,146 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+
+	"crat/models"
+)
+
+// Step 部署测试流水线中的一个步骤
+type Step interface {
+	// Name 返回步骤名称，与步骤记录中的名称一致
+	Name() string
+	// Run 执行步骤，返回错误时流水线终止
+	Run(sc *StepContext) error
+}
+
+// StepContext 步骤执行上下文
+type StepContext struct {
+	Ctx       context.Context // 运行被取消时会被关闭
+	Service   *DeployTestService
+	Run       *models.DeployTestRun
+	TestItem  *models.TestItem
+	BuildInfo *models.BuildInfo
+}
+
+// StepFactory 根据步骤选项创建步骤实例
+type StepFactory func(options json.RawMessage) (Step, error)
+
+var (
+	stepRegistry      = make(map[string]StepFactory)
+	stepRegistryMutex sync.RWMutex
+)
+
+// defaultPipeline 测试项未配置流水线时使用的默认步骤顺序
+var defaultPipeline = []models.PipelineStepConfig{
+	{Name: models.StepDownload},
+	{Name: models.StepInspect},
+	{Name: models.StepTest},
+	{Name: models.StepMonitor},
+	{Name: models.StepNotify},
+}
+
+// stepFailurePrefixes 内置步骤失败时写入 error_message 的前缀
+var stepFailurePrefixes = map[string]string{
+	models.StepDownload: "Download failed",
+	models.StepInspect:  "Package inspection failed",
+	models.StepTest:     "Trigger test failed",
+	models.StepMonitor:  "Monitor failed",
+}
+
+// RegisterStep 注册步骤，同名步骤会被覆盖
+func RegisterStep(name string, factory StepFactory) {
+	stepRegistryMutex.Lock()
+	defer stepRegistryMutex.Unlock()
+	stepRegistry[name] = factory
+}
+
+// AvailableSteps 获取所有已注册的步骤名称
+func AvailableSteps() []string {
+	stepRegistryMutex.RLock()
+	defer stepRegistryMutex.RUnlock()
+
+	names := make([]string, 0, len(stepRegistry))
+	for name := range stepRegistry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// pipelineEntry 已实例化的流水线步骤
+type pipelineEntry struct {
+	step      Step
+	alwaysRun bool
+}
+
+// buildPipeline 根据步骤配置实例化流水线
+func buildPipeline(configs []models.PipelineStepConfig) ([]pipelineEntry, error) {
+	stepRegistryMutex.RLock()
+	defer stepRegistryMutex.RUnlock()
+
+	seen := make(map[string]bool)
+	entries := make([]pipelineEntry, 0, len(configs))
+	for i, cfg := range configs {
+		if cfg.Name == "" {
+			return nil, fmt.Errorf("step #%d has no name", i+1)
+		}
+		if seen[cfg.Name] {
+			return nil, fmt.Errorf("step %s appears more than once", cfg.Name)
+		}
+		seen[cfg.Name] = true
+
+		factory, ok := stepRegistry[cfg.Name]
+		if !ok {
+			return nil, fmt.Errorf("unknown step: %s", cfg.Name)
+		}
+		step, err := factory(cfg.Options)
+		if err != nil {
+			return nil, fmt.Errorf("invalid options for step %s: %v", cfg.Name, err)
+		}
+		entries = append(entries, pipelineEntry{step: step, alwaysRun: cfg.AlwaysRun})
+	}
+
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("pipeline has no steps")
+	}
+	return entries, nil
+}
+
+// ValidatePipeline 校验流水线配置，供创建和更新测试项时使用
+func ValidatePipeline(raw json.RawMessage) error {
+	testItem := models.TestItem{Pipeline: raw}
+	configs, err := testItem.GetPipeline()
+	if err != nil {
+		return fmt.Errorf("invalid pipeline format: %v", err)
+	}
+	if configs == nil {
+		return nil
+	}
+	_, err = buildPipeline(configs)
+	return err
+}
+
+// resolvePipeline 获取测试项的流水线，未配置时使用默认流水线
+func resolvePipeline(testItem *models.TestItem) ([]pipelineEntry, error) {
+	configs, err := testItem.GetPipeline()
+	if err != nil {
+		return nil, fmt.Errorf("invalid pipeline format: %v", err)
+	}
+	if len(configs) == 0 {
+		configs = defaultPipeline
+	}
+	return buildPipeline(configs)
+}
+
+// stepFailureMessage 生成步骤失败时的错误信息
+func stepFailureMessage(stepName string, err error) string {
+	if prefix, ok := stepFailurePrefixes[stepName]; ok {
+		return fmt.Sprintf("%s: %v", prefix, err)
+	}
+	return fmt.Sprintf("Step %s failed: %v", stepName, err)
+}
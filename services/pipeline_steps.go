@@ -0,0 +1,191 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"crat/config"
+	"crat/models"
+)
+
+func init() {
+	RegisterStep(models.StepDownload, noOptionStep(func() Step { return downloadStep{} }))
+	RegisterStep(models.StepInspect, noOptionStep(func() Step { return inspectStep{} }))
+	RegisterStep(models.StepTest, noOptionStep(func() Step { return triggerTestStep{} }))
+	RegisterStep(models.StepMonitor, noOptionStep(func() Step { return monitorStep{} }))
+	RegisterStep(models.StepNotify, noOptionStep(func() Step { return notifyStep{} }))
+	RegisterStep(models.StepHealthCheck, newHealthCheckStep)
+	RegisterStep(models.StepCleanup, noOptionStep(func() Step { return cleanupStep{} }))
+}
+
+// noOptionStep 包装不需要选项的步骤
+func noOptionStep(create func() Step) StepFactory {
+	return func(options json.RawMessage) (Step, error) {
+		return create(), nil
+	}
+}
+
+// downloadStep 下载包文件
+type downloadStep struct{}
+
+func (downloadStep) Name() string { return models.StepDownload }
+
+func (downloadStep) Run(sc *StepContext) error {
+	return sc.Service.downloadPackage(sc.Ctx, sc.Run, sc.TestItem, sc.BuildInfo)
+}
+
+// inspectStep 部署前检查包内容
+type inspectStep struct{}
+
+func (inspectStep) Name() string { return models.StepInspect }
+
+func (inspectStep) Run(sc *StepContext) error {
+	return sc.Service.inspectRunPackage(sc.Ctx, sc.Run, sc.TestItem)
+}
+
+// triggerTestStep 触发外部测试
+type triggerTestStep struct{}
+
+func (triggerTestStep) Name() string { return models.StepTest }
+
+func (triggerTestStep) Run(sc *StepContext) error {
+	return sc.Service.triggerExternalTest(sc.Ctx, sc.Run, sc.TestItem, sc.BuildInfo)
+}
+
+// monitorStep 监控外部测试进度
+type monitorStep struct{}
+
+func (monitorStep) Name() string { return models.StepMonitor }
+
+func (monitorStep) Run(sc *StepContext) error {
+	return sc.Service.monitorTestProgress(sc.Ctx, sc.Run)
+}
+
+// notifyStep 发送结果通知
+type notifyStep struct{}
+
+func (notifyStep) Name() string { return models.StepNotify }
+
+func (notifyStep) Run(sc *StepContext) error {
+	sc.Service.sendNotification(sc.Run, sc.TestItem, sc.BuildInfo)
+	return nil
+}
+
+// healthCheckStep 部署后检查服务健康状态
+type healthCheckStep struct {
+	URL             string `json:"url"`              // 检查地址，为空时使用参数集中的 base_url
+	ExpectStatus    int    `json:"expect_status"`    // 期望的HTTP状态码，默认200
+	TimeoutSeconds  int    `json:"timeout_seconds"`  // 单次请求超时，默认10秒
+	Retries         int    `json:"retries"`          // 最大尝试次数，默认5次
+	IntervalSeconds int    `json:"interval_seconds"` // 重试间隔，默认10秒
+}
+
+func newHealthCheckStep(options json.RawMessage) (Step, error) {
+	step := &healthCheckStep{
+		ExpectStatus:    200,
+		TimeoutSeconds:  10,
+		Retries:         5,
+		IntervalSeconds: 10,
+	}
+	if len(options) > 0 && string(options) != "null" {
+		if err := json.Unmarshal(options, step); err != nil {
+			return nil, err
+		}
+	}
+	if step.ExpectStatus < 100 || step.ExpectStatus > 599 {
+		return nil, fmt.Errorf("expect_status must be a valid HTTP status code")
+	}
+	if step.TimeoutSeconds <= 0 || step.Retries <= 0 || step.IntervalSeconds < 0 {
+		return nil, fmt.Errorf("timeout_seconds and retries must be positive, interval_seconds must not be negative")
+	}
+	return step, nil
+}
+
+func (h *healthCheckStep) Name() string { return models.StepHealthCheck }
+
+func (h *healthCheckStep) Run(sc *StepContext) error {
+	s := sc.Service
+	runID := sc.Run.ID
+
+	checkURL := h.URL
+	if checkURL == "" {
+		params, err := s.getTestParameters(sc.Run.ParameterSetID, sc.TestItem)
+		if err != nil {
+			s.addStep(runID, models.StepHealthCheck, "FAILED", "", fmt.Sprintf("Failed to get test parameters: %v", err))
+			return err
+		}
+		checkURL = params.BaseURL
+	}
+	if checkURL == "" {
+		err := fmt.Errorf("no health check URL configured")
+		s.addStep(runID, models.StepHealthCheck, "FAILED", "", err.Error())
+		return err
+	}
+
+	s.addStep(runID, models.StepHealthCheck, "RUNNING", fmt.Sprintf("Checking health of %s", checkURL), "")
+
+	var lastErr error
+	for attempt := 1; attempt <= h.Retries; attempt++ {
+		response, err := s.httpClient.SendRequestWithContext(sc.Ctx, "GET", checkURL, nil, nil, h.TimeoutSeconds)
+		if err == nil && response.StatusCode == h.ExpectStatus {
+			s.addStep(runID, models.StepHealthCheck, "COMPLETED", fmt.Sprintf("Health check passed after %d attempt(s)", attempt), "")
+			return nil
+		}
+		if err != nil {
+			lastErr = err
+		} else {
+			lastErr = fmt.Errorf("unexpected status code: %d", response.StatusCode)
+		}
+		log.Printf("Health check attempt %d/%d for run ID %d failed: %v", attempt, h.Retries, runID, lastErr)
+
+		if attempt < h.Retries {
+			if err := sleepWithContext(sc.Ctx, time.Duration(h.IntervalSeconds)*time.Second); err != nil {
+				return err
+			}
+		}
+	}
+
+	err := fmt.Errorf("health check failed after %d attempts: %v", h.Retries, lastErr)
+	s.addStep(runID, models.StepHealthCheck, "FAILED", "", err.Error())
+	return err
+}
+
+// cleanupStep 释放本地下载的包文件，共享缓存中的文件只释放引用，由缓存淘汰删除
+type cleanupStep struct{}
+
+func (cleanupStep) Name() string { return models.StepCleanup }
+
+func (cleanupStep) Run(sc *StepContext) error {
+	s := sc.Service
+	s.addStep(sc.Run.ID, models.StepCleanup, "RUNNING", "Removing downloaded package", "")
+
+	// 从数据库重新加载以获取下载路径
+	var run models.DeployTestRun
+	if err := config.DB.First(&run, sc.Run.ID).Error; err != nil {
+		s.addStep(sc.Run.ID, models.StepCleanup, "FAILED", "", fmt.Sprintf("Failed to reload deploy test run: %v", err))
+		return err
+	}
+
+	if run.DownloadPath == "" {
+		s.addStep(sc.Run.ID, models.StepCleanup, "COMPLETED", "Nothing to clean up", "")
+		return nil
+	}
+
+	if run.CacheEntryID != nil {
+		s.packageCache.Release(run.ID)
+		go s.packageCache.Evict()
+		s.addStep(sc.Run.ID, models.StepCleanup, "COMPLETED", fmt.Sprintf("Released cached package %s", run.DownloadPath), "")
+		return nil
+	}
+
+	if err := os.Remove(run.DownloadPath); err != nil && !os.IsNotExist(err) {
+		s.addStep(sc.Run.ID, models.StepCleanup, "FAILED", "", fmt.Sprintf("Failed to remove %s: %v", run.DownloadPath, err))
+		return err
+	}
+
+	s.addStep(sc.Run.ID, models.StepCleanup, "COMPLETED", fmt.Sprintf("Removed %s", run.DownloadPath), "")
+	return nil
+}
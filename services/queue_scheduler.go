@@ -0,0 +1,199 @@
+package services
+
+import (
+	"encoding/json"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+
+	"crat/config"
+	"crat/models"
+)
+
+// MaxQueuePriority 触发时允许设置的最高优先级
+const MaxQueuePriority = 100
+
+// queueOrder 队列的调度顺序：优先级高的在前，同优先级按排序序号
+const queueOrder = "priority DESC, queue_seq ASC, id ASC"
+
+// dispatchMutex 串行化入队和调度，所有 DeployTestService 实例共享
+var dispatchMutex sync.Mutex
+
+// schedulerLimits 调度并发限制
+type schedulerLimits struct {
+	GlobalLimit       int            // 全局并发上限，0表示不限制
+	EnvironmentLimit  int            // 每个环境默认的并发上限，0表示不限制
+	EnvironmentLimits map[string]int // 指定环境的并发上限
+}
+
+// loadSchedulerLimits 从系统设置读取调度并发限制
+//
+// 阻塞模式只决定默认值：启用时与原有阻塞模式保持一致，默认同一时间只执行一个测试；未启用时默认不限制。
+// 显式配置的全局和环境并发上限在两种模式下都生效。
+func loadSchedulerLimits(settings map[string]string) *schedulerLimits {
+	limits := &schedulerLimits{EnvironmentLimits: make(map[string]int)}
+	if settings["test_blocking_enabled"] == "true" {
+		limits.GlobalLimit = 1
+		limits.EnvironmentLimit = 1
+	}
+
+	if value, err := strconv.Atoi(settings["queue_global_limit"]); err == nil && value >= 0 {
+		limits.GlobalLimit = value
+	}
+	if value, err := strconv.Atoi(settings["queue_environment_limit"]); err == nil && value >= 0 {
+		limits.EnvironmentLimit = value
+	}
+	if raw := settings["queue_environment_limits"]; raw != "" {
+		var overrides map[string]int
+		if err := json.Unmarshal([]byte(raw), &overrides); err != nil {
+			log.Printf("Invalid queue_environment_limits setting: %v", err)
+		} else {
+			for env, limit := range overrides {
+				limits.EnvironmentLimits[strings.TrimSuffix(env, "/")] = limit
+			}
+		}
+	}
+
+	return limits
+}
+
+// environmentLimit 获取指定环境的并发上限
+func (l *schedulerLimits) environmentLimit(environment string) int {
+	if limit, ok := l.EnvironmentLimits[environment]; ok {
+		return limit
+	}
+	return l.EnvironmentLimit
+}
+
+// resolveEnvironment 确定运行所属的环境，用于环境级并发限制
+//
+// 默认使用参数集中的 base_url，系统设置 queue_environment_key 为 test_server 时使用外部测试服务器地址。
+func (s *DeployTestService) resolveEnvironment(testItem *models.TestItem, parameterSetID *uint) string {
+	settings, err := s.systemUtils.GetSystemSettings()
+	if err != nil {
+		log.Printf("Failed to get system settings: %v", err)
+		settings = map[string]string{}
+	}
+
+	if settings["queue_environment_key"] != "test_server" {
+		if params, err := s.getTestParameters(parameterSetID, testItem); err == nil && params.BaseURL != "" {
+			return strings.TrimSuffix(params.BaseURL, "/")
+		}
+	}
+	return strings.TrimSuffix(settings["external_test_server_url"], "/")
+}
+
+// nextQueueSeq 计算新入队运行的排序序号
+//
+// 同一优先级内按用户轮流排列：用户的第N个排队项排在其他用户第N轮之后，
+// 避免单个用户一次触发大量测试时占满队列。
+func nextQueueSeq(priority int, triggeredBy string) float64 {
+	var band []models.DeployTestRun
+	if err := config.DB.Select("id", "triggered_by", "queue_seq").
+		Where("status = ? AND priority = ?", models.DeployTestStatusQueued, priority).
+		Order("queue_seq ASC, id ASC").
+		Find(&band).Error; err != nil {
+		log.Printf("Failed to load queue for fair ordering: %v", err)
+	}
+
+	if len(band) == 0 {
+		var maxSeq float64
+		config.DB.Model(&models.DeployTestRun{}).
+			Where("status = ?", models.DeployTestStatusQueued).
+			Select("COALESCE(MAX(queue_seq), 0)").
+			Scan(&maxSeq)
+		return maxSeq + 1
+	}
+	return fairQueueSeq(band, triggeredBy)
+}
+
+// fairQueueSeq 在同一优先级已排序的排队运行 band (不为空) 中为 triggeredBy 的新运行计算序号
+func fairQueueSeq(band []models.DeployTestRun, triggeredBy string) float64 {
+	// 新运行所在的轮次
+	newRound := 1
+	for _, run := range band {
+		if run.TriggeredBy == triggeredBy {
+			newRound++
+		}
+	}
+
+	// 插入到轮次不大于新运行轮次的最后一个运行之后
+	rounds := make(map[string]int)
+	insertAfter := 0
+	for i, run := range band {
+		rounds[run.TriggeredBy]++
+		if rounds[run.TriggeredBy] <= newRound {
+			insertAfter = i
+		}
+	}
+
+	if insertAfter == len(band)-1 {
+		return band[insertAfter].QueueSeq + 1
+	}
+	return (band[insertAfter].QueueSeq + band[insertAfter+1].QueueSeq) / 2
+}
+
+// queuePositionOf 计算排队运行在队列中的位置 (从1开始)
+func queuePositionOf(run *models.DeployTestRun) int {
+	var ahead int64
+	if err := config.DB.Model(&models.DeployTestRun{}).
+		Where("status = ?", models.DeployTestStatusQueued).
+		Where("priority > ? OR (priority = ? AND (queue_seq < ? OR (queue_seq = ? AND id < ?)))",
+			run.Priority, run.Priority, run.QueueSeq, run.QueueSeq, run.ID).
+		Count(&ahead).Error; err != nil {
+		ahead = 0
+	}
+	return int(ahead) + 1
+}
+
+// dispatchQueueLocked 在并发限制内启动排队中的测试，调用方需持有 dispatchMutex
+func (s *DeployTestService) dispatchQueueLocked() {
+	settings, err := s.systemUtils.GetSystemSettings()
+	if err != nil {
+		log.Printf("Failed to get system settings: %v", err)
+		return
+	}
+	if isQueuePaused(settings) {
+		return
+	}
+	limits := loadSchedulerLimits(settings)
+
+	// 统计执行中的测试
+	var activeRuns []models.DeployTestRun
+	if err := config.DB.Select("id", "environment").
+		Where("status IN ?", models.ActiveDeployTestStatuses).
+		Find(&activeRuns).Error; err != nil {
+		log.Printf("Failed to count running tests: %v", err)
+		return
+	}
+	running := len(activeRuns)
+	environmentCounts := make(map[string]int)
+	for _, run := range activeRuns {
+		environmentCounts[run.Environment]++
+	}
+
+	var queuedRuns []models.DeployTestRun
+	if err := config.DB.Where("status = ?", models.DeployTestStatusQueued).
+		Order(queueOrder).
+		Find(&queuedRuns).Error; err != nil {
+		log.Printf("Failed to load queued tests: %v", err)
+		return
+	}
+
+	for i := range queuedRuns {
+		run := &queuedRuns[i]
+		if limits.GlobalLimit > 0 && running >= limits.GlobalLimit {
+			break
+		}
+		// 所在环境已满时跳过，让其他环境的测试并行执行
+		if limit := limits.environmentLimit(run.Environment); limit > 0 && environmentCounts[run.Environment] >= limit {
+			continue
+		}
+
+		if s.dispatchRun(run) {
+			running++
+			environmentCounts[run.Environment]++
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package services
+
+import (
+	"testing"
+
+	"crat/models"
+)
+
+func TestFairQueueSeq(t *testing.T) {
+	// queue 为同一优先级内按序号排列的排队运行
+	type queued struct {
+		user string
+		seq  float64
+	}
+	tests := []struct {
+		name        string
+		queue       []queued
+		triggeredBy string
+		want        float64
+	}{
+		{"same user appends", []queued{{"alice", 1}, {"alice", 2}}, "alice", 3},
+		{"other user goes after first round", []queued{{"alice", 1}, {"alice", 2}, {"alice", 3}}, "bob", 1.5},
+		{"third user joins first round", []queued{{"alice", 1}, {"bob", 1.5}, {"alice", 2}, {"alice", 3}}, "carol", 1.75},
+		{"second item goes after second round", []queued{{"alice", 1}, {"bob", 1.5}, {"alice", 2}, {"alice", 3}}, "bob", 2.5},
+		{"all rounds filled appends", []queued{{"alice", 1}, {"bob", 2}}, "carol", 3},
+		{"non integer tail appends", []queued{{"alice", 4.5}}, "bob", 5.5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			band := make([]models.DeployTestRun, len(tt.queue))
+			for i, item := range tt.queue {
+				band[i] = models.DeployTestRun{TriggeredBy: item.user, QueueSeq: item.seq}
+			}
+			if got := fairQueueSeq(band, tt.triggeredBy); got != tt.want {
+				t.Errorf("fairQueueSeq(%s) = %v, want %v", tt.triggeredBy, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadSchedulerLimits(t *testing.T) {
+	tests := []struct {
+		name         string
+		settings     map[string]string
+		wantGlobal   int
+		wantDefault  int
+		wantOverride int // 环境 http://env-a 的并发上限
+	}{
+		{"blocking defaults", map[string]string{"test_blocking_enabled": "true"}, 1, 1, 1},
+		{"non-blocking defaults", map[string]string{}, 0, 0, 0},
+		{"blocking with limits", map[string]string{
+			"test_blocking_enabled":    "true",
+			"queue_global_limit":       "4",
+			"queue_environment_limit":  "2",
+			"queue_environment_limits": `{"http://env-a/": 3}`,
+		}, 4, 2, 3},
+		{"non-blocking with limits", map[string]string{
+			"test_blocking_enabled":    "false",
+			"queue_global_limit":       "4",
+			"queue_environment_limit":  "2",
+			"queue_environment_limits": `{"http://env-a": 3}`,
+		}, 4, 2, 3},
+		{"invalid values keep defaults", map[string]string{
+			"test_blocking_enabled":    "true",
+			"queue_global_limit":       "-1",
+			"queue_environment_limit":  "many",
+			"queue_environment_limits": `{"http://env-a":`,
+		}, 1, 1, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limits := loadSchedulerLimits(tt.settings)
+			if limits.GlobalLimit != tt.wantGlobal {
+				t.Errorf("GlobalLimit = %d, want %d", limits.GlobalLimit, tt.wantGlobal)
+			}
+			if got := limits.environmentLimit("http://env-b"); got != tt.wantDefault {
+				t.Errorf("environmentLimit(env-b) = %d, want %d", got, tt.wantDefault)
+			}
+			if got := limits.environmentLimit("http://env-a"); got != tt.wantOverride {
+				t.Errorf("environmentLimit(env-a) = %d, want %d", got, tt.wantOverride)
+			}
+		})
+	}
+}
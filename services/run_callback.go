@@ -0,0 +1,213 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"crat/config"
+	"crat/models"
+)
+
+var (
+	// ErrCallbackSignatureInvalid 回调签名错误
+	ErrCallbackSignatureInvalid = errors.New("invalid callback signature")
+	// ErrCallbackInvalid 回调内容无法解析
+	ErrCallbackInvalid = errors.New("invalid callback payload")
+	// ErrCallbackTaskMismatch 回调的任务与运行当前的任务不一致
+	ErrCallbackTaskMismatch = errors.New("callback task_id does not match the run")
+)
+
+// defaultCallbackPollInterval 启用回调后轮询的默认间隔，可通过系统设置 callback_poll_interval_seconds 调整
+const defaultCallbackPollInterval = 10 * time.Minute
+
+// callbackKey 等待回调的外部任务，运行有测试阶段时同时等待多个任务
+type callbackKey struct {
+	runID  uint
+	taskID string
+}
+
+// callbackWaiters 等待回调的 monitor 步骤，按运行ID和任务ID索引，所有 DeployTestService 实例共享
+var (
+	callbackWaiters      = make(map[callbackKey]chan *TaskStatus)
+	callbackWaitersMutex sync.Mutex
+)
+
+// TestCallback 测试服务器推送的任务状态
+type TestCallback struct {
+	TaskID    string          `json:"task_id"`
+	Status    string          `json:"status"` // pending、running、completed、failed
+	ReportURL string          `json:"report_url"`
+	Error     string          `json:"error"`
+	Summary   json.RawMessage `json:"summary,omitempty"`
+}
+
+// callbackToken 运行的回调令牌，测试服务器用它对回调请求体签名
+func callbackToken(runID uint) string {
+	mac := hmac.New(sha256.New, artifactSigningKey())
+	fmt.Fprintf(mac, "callback:run:%d", runID)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// callbackURL 运行的回调地址，未配置 crat_public_base_url 时返回空字符串
+func callbackURL(runID uint, settings map[string]string) string {
+	baseURL := strings.TrimSuffix(settings["crat_public_base_url"], "/")
+	if baseURL == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s/api/v1/deploy-test-runs/%d/callback", baseURL, runID)
+}
+
+// callbackPollInterval 启用回调后的轮询间隔，不小于运行的 query_interval
+func callbackPollInterval(settings map[string]string, queryInterval time.Duration) time.Duration {
+	interval := defaultCallbackPollInterval
+	if seconds, err := strconv.Atoi(settings["callback_poll_interval_seconds"]); err == nil && seconds > 0 {
+		interval = time.Duration(seconds) * time.Second
+	}
+	if interval < queryInterval {
+		return queryInterval
+	}
+	return interval
+}
+
+// callbackMaxSkew 回调时间戳与服务器时间允许的最大偏差，超出时视为重放拒绝
+const callbackMaxSkew = 5 * time.Minute
+
+// SignCallback 计算回调的签名，即以回调令牌为密钥对 "<时间戳>.<请求体>" 计算的 HMAC-SHA256 (十六进制)
+func SignCallback(token, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(token))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifyCallbackSignature 校验回调签名和时间戳，签名可带 sha256= 前缀，时间戳为 Unix 秒
+func verifyCallbackSignature(runID uint, body []byte, timestamp, signature string, now time.Time) error {
+	signature = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(signature), "sha256="))
+	if signature == "" {
+		return ErrCallbackSignatureInvalid
+	}
+	timestamp = strings.TrimSpace(timestamp)
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: missing or invalid timestamp", ErrCallbackSignatureInvalid)
+	}
+	if skew := now.Sub(time.Unix(seconds, 0)); skew > callbackMaxSkew || skew < -callbackMaxSkew {
+		return fmt.Errorf("%w: timestamp outside the allowed window", ErrCallbackSignatureInvalid)
+	}
+	if !hmac.Equal([]byte(SignCallback(callbackToken(runID), timestamp, body)), []byte(signature)) {
+		return ErrCallbackSignatureInvalid
+	}
+	return nil
+}
+
+// registerCallbackWaiter 登记等待回调的任务，返回接收状态的通道和注销函数
+func registerCallbackWaiter(runID uint, taskID string) (<-chan *TaskStatus, func()) {
+	key := callbackKey{runID: runID, taskID: taskID}
+	ch := make(chan *TaskStatus, 1)
+	callbackWaitersMutex.Lock()
+	callbackWaiters[key] = ch
+	callbackWaitersMutex.Unlock()
+
+	return ch, func() {
+		callbackWaitersMutex.Lock()
+		if callbackWaiters[key] == ch {
+			delete(callbackWaiters, key)
+		}
+		callbackWaitersMutex.Unlock()
+	}
+}
+
+// deliverCallback 把状态交给等待中的 monitor 步骤，未处理的旧状态被新状态替换
+func deliverCallback(runID uint, taskID string, status *TaskStatus) bool {
+	callbackWaitersMutex.Lock()
+	defer callbackWaitersMutex.Unlock()
+
+	ch, ok := callbackWaiters[callbackKey{runID: runID, taskID: taskID}]
+	if !ok {
+		return false
+	}
+	select {
+	case <-ch:
+	default:
+	}
+	ch <- status
+	return true
+}
+
+// waitForTaskUpdate 等待回调或下一次轮询，返回 nil 表示需要轮询
+func waitForTaskUpdate(ctx context.Context, interval time.Duration, callbacks <-chan *TaskStatus) (*TaskStatus, error) {
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-timer.C:
+		return nil, nil
+	case status := <-callbacks:
+		return status, nil
+	}
+}
+
+// HandleTestCallback 处理测试服务器推送的任务状态
+//
+// 返回的 delivered 表示状态已交给 monitor 步骤处理；运行已结束或 monitor 步骤未在等待时
+// 回调被忽略，由轮询兜底。
+func (s *DeployTestService) HandleTestCallback(runID uint, body []byte, timestamp, signature string) (delivered bool, err error) {
+	if err := verifyCallbackSignature(runID, body, timestamp, signature, time.Now()); err != nil {
+		return false, err
+	}
+
+	var run models.DeployTestRun
+	if err := config.DB.First(&run, runID).Error; err != nil {
+		return false, ErrDeployTestRunNotFound
+	}
+
+	var callback TestCallback
+	if err := json.Unmarshal(body, &callback); err != nil {
+		return false, fmt.Errorf("%w: %v", ErrCallbackInvalid, err)
+	}
+	switch callback.Status {
+	case TaskStatePending, TaskStateRunning, TaskStateCompleted, TaskStateFailed:
+	default:
+		return false, fmt.Errorf("%w: unknown status %q", ErrCallbackInvalid, callback.Status)
+	}
+	if run.TaskID == "" {
+		return false, ErrCallbackTaskMismatch
+	}
+	// 未带 task_id 的回调属于部署任务，测试阶段的回调必须带 task_id
+	taskID := run.TaskID
+	if callback.TaskID != "" && callback.TaskID != run.TaskID {
+		var stages int64
+		config.DB.Model(&models.DeployTestStage{}).Where("deploy_test_run_id = ? AND task_id = ?", runID, callback.TaskID).Count(&stages)
+		if stages == 0 {
+			return false, ErrCallbackTaskMismatch
+		}
+		taskID = callback.TaskID
+	}
+
+	if !isCancellableStatus(run.Status) {
+		log.Printf("Ignoring callback for finished run ID %d (status %s)", runID, run.Status)
+		return false, nil
+	}
+
+	log.Printf("Callback received for run ID %d: task %s %s", runID, taskID, callback.Status)
+	return deliverCallback(runID, taskID, &TaskStatus{
+		State:     callback.Status,
+		ReportURL: callback.ReportURL,
+		Error:     callback.Error,
+		Summary:   callback.Summary,
+		Raw:       json.RawMessage(body),
+	}), nil
+}
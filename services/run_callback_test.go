@@ -0,0 +1,62 @@
+package services
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"crat/config"
+)
+
+func TestVerifyCallbackSignature(t *testing.T) {
+	previous := config.AppConfig
+	config.AppConfig = &config.Config{}
+	config.AppConfig.Auth.ArtifactSigningSecret = "test-secret"
+	defer func() { config.AppConfig = previous }()
+
+	const runID = 42
+	now := time.Unix(1760000000, 0)
+	body := []byte(`{"task_id":"a1b2c3","status":"completed"}`)
+	// at 返回相对 now 偏移后的时间戳
+	at := func(offset time.Duration) string {
+		return strconv.FormatInt(now.Add(offset).Unix(), 10)
+	}
+	sign := func(timestamp string) string {
+		return SignCallback(callbackToken(runID), timestamp, body)
+	}
+	timestamp := at(0)
+	signature := sign(timestamp)
+
+	tests := []struct {
+		name      string
+		runID     uint
+		body      []byte
+		timestamp string
+		signature string
+		wantErr   bool
+	}{
+		{"valid", runID, body, timestamp, signature, false},
+		{"valid with prefix", runID, body, timestamp, "sha256=" + signature, false},
+		{"valid within skew", runID, body, at(-4 * time.Minute), sign(at(-4 * time.Minute)), false},
+		{"missing signature", runID, body, timestamp, "", true},
+		{"missing timestamp", runID, body, "", signature, true},
+		{"invalid timestamp", runID, body, "yesterday", signature, true},
+		{"stale timestamp", runID, body, at(-6 * time.Minute), sign(at(-6 * time.Minute)), true},
+		{"future timestamp", runID, body, at(6 * time.Minute), sign(at(6 * time.Minute)), true},
+		{"timestamp not signed", runID, body, at(-time.Minute), signature, true},
+		{"body changed", runID, []byte(`{"task_id":"a1b2c3","status":"failed"}`), timestamp, signature, true},
+		{"other run", runID + 1, body, timestamp, signature, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifyCallbackSignature(tt.runID, tt.body, tt.timestamp, tt.signature, now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("verifyCallbackSignature error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrCallbackSignatureInvalid) {
+				t.Errorf("error %v is not ErrCallbackSignatureInvalid", err)
+			}
+		})
+	}
+}
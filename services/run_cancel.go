@@ -0,0 +1,223 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"crat/config"
+	"crat/models"
+
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrDeployTestRunNotFound 部署测试运行不存在
+	ErrDeployTestRunNotFound = errors.New("deploy test run not found")
+	// ErrDeployTestRunNotCancellable 部署测试运行已结束，无法取消
+	ErrDeployTestRunNotCancellable = errors.New("deploy test run is already finished")
+	// errRunCancelled 步骤执行期间运行已被取消，取消可能来自其他实例，此时本进程的 context 未被取消
+	errRunCancelled = errors.New("deploy test run was cancelled")
+)
+
+// runCancels 运行中流程的取消函数，所有 DeployTestService 实例共享
+var (
+	runCancels      = make(map[uint]context.CancelFunc)
+	runCancelsMutex sync.Mutex
+)
+
+// registerRunCancel 登记运行的取消函数
+func registerRunCancel(runID uint, cancel context.CancelFunc) {
+	runCancelsMutex.Lock()
+	defer runCancelsMutex.Unlock()
+	runCancels[runID] = cancel
+}
+
+// unregisterRunCancel 移除运行的取消函数
+func unregisterRunCancel(runID uint) {
+	runCancelsMutex.Lock()
+	defer runCancelsMutex.Unlock()
+	delete(runCancels, runID)
+}
+
+// cancelRunContext 取消运行的context，返回该运行是否在本进程中执行
+func cancelRunContext(runID uint) bool {
+	runCancelsMutex.Lock()
+	cancel, ok := runCancels[runID]
+	runCancelsMutex.Unlock()
+
+	if ok {
+		cancel()
+	}
+	return ok
+}
+
+// sleepWithContext 等待指定时间，context被取消时提前返回错误
+func sleepWithContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// submitTestTask 提交外部任务并通过 record 写入任务ID
+//
+// record 返回 false 表示提交期间运行已被取消，任务ID未被记录，此时立即终止刚提交的任务并返回
+// errRunCancelled，避免外部任务在无人监控的情况下继续执行；写入失败时同样终止任务。
+func submitTestTask(ctx context.Context, runner TestRunner, sub *TestSubmission, record func(taskID string) (bool, error)) (*SubmitResult, error) {
+	result, err := runner.Submit(ctx, sub)
+	if err != nil {
+		return nil, err
+	}
+
+	recorded, err := record(result.TaskID)
+	if err == nil && recorded {
+		return result, nil
+	}
+	if cancelErr := runner.Cancel(context.Background(), TestTask{
+		ServerURL:      sub.ServerURL,
+		TaskID:         result.TaskID,
+		TimeoutSeconds: 30,
+	}); cancelErr != nil {
+		log.Printf("Failed to abort unrecorded task %s for run ID %d: %v", result.TaskID, sub.Run.ID, cancelErr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to record task_id %s: %v", result.TaskID, err)
+	}
+	log.Printf("Run ID %d was cancelled while submitting, task %s aborted", sub.Run.ID, result.TaskID)
+	return result, errRunCancelled
+}
+
+// isCancellableStatus 判断运行状态是否允许取消
+func isCancellableStatus(status string) bool {
+	switch status {
+	case models.DeployTestStatusCompleted, models.DeployTestStatusDeployComplete,
+		models.DeployTestStatusFailed, models.DeployTestStatusCancelled:
+		return false
+	}
+	return true
+}
+
+// cancelRetryAttempts 取消时运行状态被并发修改后重新读取的最大次数
+const cancelRetryAttempts = 5
+
+// CancelDeployTestRun 取消运行中或排队中的部署测试
+func (s *DeployTestService) CancelDeployTestRun(runID uint, cancelledBy, reason string) (*models.DeployTestRun, error) {
+	now := time.Now()
+	errorMsg := fmt.Sprintf("Cancelled by %s", cancelledBy)
+	if reason != "" {
+		errorMsg += ": " + reason
+	}
+
+	// 仅当状态未被并发修改时才写入取消状态，状态在读取后发生正常流转 (例如 QUEUED → PENDING) 时重新读取，
+	// 只要仍可取消就继续尝试
+	var run models.DeployTestRun
+	var previousStatus string
+	cancelled := false
+	for attempt := 0; attempt < cancelRetryAttempts && !cancelled; attempt++ {
+		run = models.DeployTestRun{}
+		if err := config.DB.First(&run, runID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, ErrDeployTestRunNotFound
+			}
+			return nil, fmt.Errorf("failed to get deploy test run: %v", err)
+		}
+		if !isCancellableStatus(run.Status) {
+			return nil, ErrDeployTestRunNotCancellable
+		}
+
+		previousStatus = run.Status
+		result := config.DB.Model(&models.DeployTestRun{}).
+			Where("id = ? AND status = ?", runID, previousStatus).
+			Updates(map[string]interface{}{
+				"status":        models.DeployTestStatusCancelled,
+				"cancelled_by":  cancelledBy,
+				"cancel_reason": reason,
+				"cancelled_at":  &now,
+				"finished_at":   &now,
+				"error_message": errorMsg,
+			})
+		if result.Error != nil {
+			return nil, fmt.Errorf("failed to cancel deploy test run: %v", result.Error)
+		}
+		cancelled = result.RowsAffected > 0
+	}
+	if !cancelled {
+		return nil, fmt.Errorf("failed to cancel deploy test run: status kept changing")
+	}
+
+	log.Printf("Deploy test run %d cancelled by %s (previous status: %s, reason: %s)", runID, cancelledBy, previousStatus, reason)
+	publishRunStatus(runID)
+	if previousStatus == models.DeployTestStatusQueued {
+		publishQueueEvent("removed", runID)
+	}
+
+	// 终止本进程中的执行流程
+	stopped := cancelRunContext(runID)
+
+	// 通知外部测试服务器终止任务
+	details := fmt.Sprintf("Cancelled by %s while %s", cancelledBy, previousStatus)
+	if run.TaskID != "" {
+		if err := s.abortExternalTask(&run); err != nil {
+			log.Printf("Failed to abort external task %s for run ID %d: %v", run.TaskID, runID, err)
+			details += fmt.Sprintf("; failed to abort external task %s: %v", run.TaskID, err)
+		} else {
+			details += fmt.Sprintf("; external task %s aborted", run.TaskID)
+		}
+	}
+	if !stopped && previousStatus != models.DeployTestStatusQueued {
+		details += "; no active execution found in this process"
+	}
+	s.addStep(runID, models.StepCancel, "COMPLETED", details, "")
+
+	// 释放队列位置
+	go s.processNextInQueue()
+	if run.RunGroupID != nil {
+		go s.refreshRunGroup(*run.RunGroupID)
+	}
+
+	run.Status = models.DeployTestStatusCancelled
+	run.CancelledBy = cancelledBy
+	run.CancelReason = reason
+	run.CancelledAt = &now
+	run.FinishedAt = &now
+	run.ErrorMessage = errorMsg
+	return &run, nil
+}
+
+// abortExternalTask 通过测试项的执行后端终止外部任务
+func (s *DeployTestService) abortExternalTask(run *models.DeployTestRun) error {
+	settings, err := s.systemUtils.GetSystemSettings()
+	if err != nil {
+		return fmt.Errorf("failed to get system settings: %v", err)
+	}
+
+	var testItem models.TestItem
+	if err := config.DB.First(&testItem, run.TestItemID).Error; err != nil {
+		return fmt.Errorf("failed to get test item: %v", err)
+	}
+	runner, err := s.testRunnerFor(&testItem)
+	if err != nil {
+		return err
+	}
+
+	// 部署已完成并开始执行测试阶段时终止阶段任务
+	serverURL := runTestServerURL(run, settings)
+	if hasStages, err := s.cancelTestStages(run, runner, serverURL); hasStages {
+		return err
+	}
+
+	return runner.Cancel(context.Background(), TestTask{
+		ServerURL:      serverURL,
+		TaskID:         run.TaskID,
+		TimeoutSeconds: 30,
+	})
+}
@@ -0,0 +1,99 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"crat/models"
+)
+
+// cancelDuringSubmitRunner 在任务提交后、返回前调用 cancel，模拟提交期间收到取消请求
+type cancelDuringSubmitRunner struct {
+	TestRunner
+	cancel func()
+}
+
+func (r *cancelDuringSubmitRunner) Submit(ctx context.Context, sub *TestSubmission) (*SubmitResult, error) {
+	result, err := r.TestRunner.Submit(ctx, sub)
+	r.cancel()
+	return result, err
+}
+
+func TestSubmitTestTask(t *testing.T) {
+	fake, err := newFakeTestRunner(nil)
+	if err != nil {
+		t.Fatalf("newFakeTestRunner: %v", err)
+	}
+	sub := &TestSubmission{
+		Run:       &models.DeployTestRun{ID: 7},
+		Params:    &models.TestParameters{ServiceName: "app"},
+		ServerURL: "http://test-server",
+	}
+	taskStatus := func(taskID string) *TaskStatus {
+		t.Helper()
+		status, err := fake.Status(context.Background(), TestTask{TaskID: taskID})
+		if err != nil {
+			t.Fatalf("Status(%s): %v", taskID, err)
+		}
+		return status
+	}
+
+	t.Run("recorded", func(t *testing.T) {
+		var recordedID string
+		result, err := submitTestTask(context.Background(), fake, sub, func(taskID string) (bool, error) {
+			recordedID = taskID
+			return true, nil
+		})
+		if err != nil {
+			t.Fatalf("submitTestTask: %v", err)
+		}
+		if recordedID != result.TaskID {
+			t.Errorf("recorded task %q, submitted %q", recordedID, result.TaskID)
+		}
+		if status := taskStatus(result.TaskID); status.State != TaskStateCompleted {
+			t.Errorf("task state = %s, want %s", status.State, TaskStateCompleted)
+		}
+	})
+
+	t.Run("cancelled during submit", func(t *testing.T) {
+		// 取消接口把运行标记为 CANCELLED 后，守护更新不再写入任务ID
+		var mu sync.Mutex
+		cancelled := false
+		runner := &cancelDuringSubmitRunner{TestRunner: fake, cancel: func() {
+			mu.Lock()
+			cancelled = true
+			mu.Unlock()
+		}}
+		result, err := submitTestTask(context.Background(), runner, sub, func(taskID string) (bool, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			return !cancelled, nil
+		})
+		if !errors.Is(err, errRunCancelled) {
+			t.Fatalf("submitTestTask error = %v, want errRunCancelled", err)
+		}
+		status := taskStatus(result.TaskID)
+		if status.State != TaskStateFailed || status.Error != "task cancelled" {
+			t.Errorf("task status = %s (%q), want the submitted task to be cancelled", status.State, status.Error)
+		}
+	})
+
+	t.Run("record error", func(t *testing.T) {
+		var submittedID string
+		result, err := submitTestTask(context.Background(), fake, sub, func(taskID string) (bool, error) {
+			submittedID = taskID
+			return false, errors.New("connection reset")
+		})
+		if err == nil || errors.Is(err, errRunCancelled) {
+			t.Fatalf("submitTestTask error = %v, want record error", err)
+		}
+		if result != nil {
+			t.Errorf("result = %+v, want nil", result)
+		}
+		if status := taskStatus(submittedID); status.Error != "task cancelled" {
+			t.Errorf("task status = %s (%q), want the unrecorded task to be cancelled", status.State, status.Error)
+		}
+	})
+}
@@ -0,0 +1,393 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"crat/config"
+	"crat/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// MaxRunGroupSize 一次批量触发最多创建的运行数
+const MaxRunGroupSize = 50
+
+var (
+	// ErrRunGroupNotFound 运行组不存在
+	ErrRunGroupNotFound = errors.New("run group not found")
+	// ErrRunGroupInvalid 批量触发的请求无效
+	ErrRunGroupInvalid = errors.New("invalid run group request")
+)
+
+// RunGroupRequest 批量触发参数: 构建 × 测试项 × 参数集
+type RunGroupRequest struct {
+	BuildInfoID         uint
+	TestItemIDs         []uint
+	ParameterSetIDs     []uint // 为空时每个测试项使用关联的参数集或默认参数集
+	TriggeredBy         string
+	Priority            int
+	Monitor             *models.MonitorSettings
+	NotificationEnabled bool
+	TriggerRuleID       *uint // 自动触发规则
+	ScheduleID          *uint // 定时运行
+}
+
+// RunGroupResult 批量触发结果，Runs 按测试项、参数集的顺序排列
+type RunGroupResult struct {
+	Group *models.RunGroup
+	Runs  []RunGroupRunResult
+}
+
+// RunGroupRunResult 运行组中一个组合的触发结果
+type RunGroupRunResult struct {
+	TestItemID     uint   `json:"test_item_id"`
+	ParameterSetID *uint  `json:"parameter_set_id"`
+	RunID          uint   `json:"run_id,omitempty"`
+	Queued         bool   `json:"queued"`
+	QueuePosition  int    `json:"queue_position,omitempty"`
+	Error          string `json:"error,omitempty"`
+}
+
+// uniqueIDs 去掉重复的ID，保留原有顺序
+func uniqueIDs(ids []uint) []uint {
+	seen := make(map[uint]bool)
+	var result []uint
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			result = append(result, id)
+		}
+	}
+	return result
+}
+
+// TriggerRunGroup 为构建在每个测试项 × 参数集的组合上触发一次运行，运行按阻塞模式设置排队
+func (s *DeployTestService) TriggerRunGroup(req *RunGroupRequest) (*RunGroupResult, error) {
+	testItemIDs := uniqueIDs(req.TestItemIDs)
+	parameterSetIDs := uniqueIDs(req.ParameterSetIDs)
+	if len(testItemIDs) == 0 {
+		return nil, fmt.Errorf("%w: test_item_ids is required", ErrRunGroupInvalid)
+	}
+	size := len(testItemIDs) * max(len(parameterSetIDs), 1)
+	if size > MaxRunGroupSize {
+		return nil, fmt.Errorf("%w: %d runs requested, at most %d allowed", ErrRunGroupInvalid, size, MaxRunGroupSize)
+	}
+
+	// 创建运行组前校验所有引用，避免只触发部分组合
+	if _, err := s.buildService.GetBuildInfoByID(req.BuildInfoID); err != nil {
+		return nil, fmt.Errorf("%w: build info %d not found", ErrRunGroupInvalid, req.BuildInfoID)
+	}
+	var itemCount, setCount int64
+	config.DB.Model(&models.TestItem{}).Where("id IN ?", testItemIDs).Count(&itemCount)
+	if int(itemCount) != len(testItemIDs) {
+		return nil, fmt.Errorf("%w: some test items do not exist", ErrRunGroupInvalid)
+	}
+	if len(parameterSetIDs) > 0 {
+		config.DB.Model(&models.ParameterSet{}).Where("id IN ?", parameterSetIDs).Count(&setCount)
+		if int(setCount) != len(parameterSetIDs) {
+			return nil, fmt.Errorf("%w: some parameter sets do not exist", ErrRunGroupInvalid)
+		}
+	}
+
+	group := &models.RunGroup{
+		BuildInfoID:         req.BuildInfoID,
+		TriggeredBy:         req.TriggeredBy,
+		Status:              models.RunGroupStatusRunning,
+		NotificationEnabled: req.NotificationEnabled,
+		TriggerRuleID:       req.TriggerRuleID,
+		ScheduleID:          req.ScheduleID,
+		Total:               size,
+		Active:              size,
+	}
+	if err := config.DB.Create(group).Error; err != nil {
+		return nil, fmt.Errorf("failed to create run group: %v", err)
+	}
+
+	result := &RunGroupResult{Group: group}
+	for _, testItemID := range testItemIDs {
+		var sets []*uint
+		if len(parameterSetIDs) == 0 {
+			sets = []*uint{nil}
+		}
+		for i := range parameterSetIDs {
+			sets = append(sets, &parameterSetIDs[i])
+		}
+
+		for _, parameterSetID := range sets {
+			entry := RunGroupRunResult{TestItemID: testItemID, ParameterSetID: parameterSetID}
+			trigger, err := s.TriggerRun(&TriggerRequest{
+				TestItemID:     testItemID,
+				BuildInfoID:    req.BuildInfoID,
+				TriggeredBy:    req.TriggeredBy,
+				ParameterSetID: parameterSetID,
+				Priority:       req.Priority,
+				RunGroupID:     &group.ID,
+				Monitor:        req.Monitor,
+			})
+			if err != nil {
+				log.Printf("Failed to trigger test item %d in run group %d: %v", testItemID, group.ID, err)
+				entry.Error = err.Error()
+			} else {
+				entry.RunID = trigger.RunID
+				entry.Queued = trigger.Queued
+				entry.QueuePosition = trigger.QueuePosition
+			}
+			result.Runs = append(result.Runs, entry)
+		}
+	}
+
+	// 未能创建的组合不计入运行组
+	created := 0
+	var triggerErr string
+	for _, entry := range result.Runs {
+		if entry.RunID != 0 {
+			created++
+		} else if triggerErr == "" {
+			triggerErr = entry.Error
+		}
+	}
+	if created < size {
+		config.DB.Model(&models.RunGroup{}).Where("id = ?", group.ID).Update("total", created)
+	}
+
+	log.Printf("Run group %d created by %s: build %d, %d of %d run(s) triggered", group.ID, req.TriggeredBy, req.BuildInfoID, created, size)
+	s.refreshRunGroup(group.ID)
+	if created == 0 {
+		return nil, fmt.Errorf("failed to trigger any run: %s", triggerErr)
+	}
+	if err := config.DB.First(group, group.ID).Error; err != nil {
+		return nil, fmt.Errorf("failed to reload run group: %v", err)
+	}
+	return result, nil
+}
+
+// refreshRunGroupOf 运行结束后更新其所属运行组的汇总状态
+func (s *DeployTestService) refreshRunGroupOf(runID uint) {
+	var run models.DeployTestRun
+	if err := config.DB.Select("id", "run_group_id").First(&run, runID).Error; err != nil || run.RunGroupID == nil {
+		return
+	}
+	s.refreshRunGroup(*run.RunGroupID)
+}
+
+// refreshRunGroup 根据各组合的最新运行更新运行组的统计和状态，全部结束时发送汇总通知
+//
+// 被自动重试替换的运行不计入统计，等待自动重试的失败运行视为未结束。
+// 统计在锁定运行组行的事务中完成，并发的刷新按顺序写入，只有一次能把运行组标记为结束。
+func (s *DeployTestService) refreshRunGroup(groupID uint) {
+	var runs []models.DeployTestRun
+	var completed, failed, cancelled int
+	finished := false
+
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		var group models.RunGroup
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&group, groupID).Error; err != nil {
+			return fmt.Errorf("failed to load run group: %v", err)
+		}
+
+		var err error
+		runs, err = latestGroupRuns(tx, groupID)
+		if err != nil {
+			return fmt.Errorf("failed to load runs: %v", err)
+		}
+
+		// 批量触发过程中尚未创建的运行视为未结束
+		active := max(group.Total-len(runs), 0)
+		for _, run := range runs {
+			switch {
+			case isCancellableStatus(run.Status):
+				active++
+			case run.Status == models.DeployTestStatusFailed && run.NextRetryAt != nil:
+				active++
+			case run.Status == models.DeployTestStatusCompleted || run.Status == models.DeployTestStatusDeployComplete:
+				completed++
+			case run.Status == models.DeployTestStatusCancelled:
+				cancelled++
+			default:
+				failed++
+			}
+		}
+
+		updates := map[string]interface{}{
+			"active":    active,
+			"completed": completed,
+			"failed":    failed,
+			"cancelled": cancelled,
+		}
+		if active > 0 || group.Status != models.RunGroupStatusRunning {
+			return tx.Model(&models.RunGroup{}).Where("id = ?", groupID).Updates(updates).Error
+		}
+
+		status := models.RunGroupStatusCompleted
+		if failed > 0 || len(runs) == 0 {
+			status = models.RunGroupStatusFailed
+		} else if cancelled > 0 {
+			status = models.RunGroupStatusCancelled
+		}
+		now := time.Now()
+		updates["status"] = status
+		updates["finished_at"] = &now
+		result := tx.Model(&models.RunGroup{}).
+			Where("id = ? AND status = ?", groupID, models.RunGroupStatusRunning).
+			Updates(updates)
+		finished = result.RowsAffected > 0
+		return result.Error
+	})
+	if err != nil {
+		log.Printf("Failed to refresh run group %d: %v", groupID, err)
+		return
+	}
+	publishRunGroupEvent(groupID)
+
+	if finished {
+		log.Printf("Run group %d finished: %d completed, %d failed, %d cancelled", groupID, completed, failed, cancelled)
+		s.sendRunGroupNotification(groupID, runs)
+	}
+}
+
+// latestGroupRuns 获取运行组中每个组合的最新运行，按运行ID排序
+func latestGroupRuns(db *gorm.DB, groupID uint) ([]models.DeployTestRun, error) {
+	var runs []models.DeployTestRun
+	if err := db.Omit("package_files", "steps", "response_raw_data").
+		Preload("TestItem").Preload("ParameterSet").
+		Where("run_group_id = ?", groupID).Order("id ASC").Find(&runs).Error; err != nil {
+		return nil, err
+	}
+
+	superseded := make(map[uint]bool)
+	for _, run := range runs {
+		if run.RerunOfID != nil {
+			superseded[*run.RerunOfID] = true
+		}
+	}
+	latest := runs[:0]
+	for _, run := range runs {
+		if !superseded[run.ID] {
+			latest = append(latest, run)
+		}
+	}
+	return latest, nil
+}
+
+// sendRunGroupNotification 运行组结束后向触发人发送一封汇总通知，只发送一次
+func (s *DeployTestService) sendRunGroupNotification(groupID uint, runs []models.DeployTestRun) {
+	now := time.Now()
+	result := config.DB.Model(&models.RunGroup{}).
+		Where("id = ? AND notification_enabled = ? AND notified_at IS NULL", groupID, true).
+		Update("notified_at", &now)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return
+	}
+
+	var group models.RunGroup
+	if err := config.DB.Preload("BuildInfo").First(&group, groupID).Error; err != nil || group.BuildInfo == nil {
+		log.Printf("Failed to load run group %d for notification: %v", groupID, err)
+		return
+	}
+
+	rows := make([]RunGroupNotificationRow, 0, len(runs))
+	for _, run := range runs {
+		row := RunGroupNotificationRow{
+			RunID:        run.ID,
+			Status:       run.Status,
+			ReportURL:    run.ReportURL,
+			ErrorMessage: run.ErrorMessage,
+		}
+		if run.TestItem != nil {
+			row.TestName = run.TestItem.Name
+		}
+		if run.ParameterSet != nil {
+			row.ParameterSet = run.ParameterSet.Name
+		}
+		rows = append(rows, row)
+	}
+
+	if err := s.notificationService.SendRunGroupNotification(group.TriggeredBy, &group, group.BuildInfo, rows); err != nil {
+		log.Printf("Failed to send notification for run group %d: %v", groupID, err)
+	}
+}
+
+// GetRunGroups 获取运行组列表，按创建时间倒序
+func (s *DeployTestService) GetRunGroups(limit, offset int) ([]models.RunGroup, int64, error) {
+	var groups []models.RunGroup
+	var total int64
+
+	query := config.DB.Model(&models.RunGroup{})
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err := query.Preload("BuildInfo").Order("created_at DESC").Limit(limit).Offset(offset).Find(&groups).Error
+	return groups, total, err
+}
+
+// GetRunGroupByID 获取运行组及其所有运行 (包括被自动重试替换的运行)
+func (s *DeployTestService) GetRunGroupByID(id uint) (*models.RunGroup, error) {
+	var group models.RunGroup
+	err := config.DB.Preload("BuildInfo").Preload("Runs", func(db *gorm.DB) *gorm.DB {
+		return db.Omit("package_files").Order("id ASC")
+	}).Preload("Runs.TestItem").Preload("Runs.ParameterSet").First(&group, id).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrRunGroupNotFound
+		}
+		return nil, err
+	}
+	return &group, nil
+}
+
+// CancelRunGroup 取消运行组中所有未结束的运行，并取消计划中的自动重试
+func (s *DeployTestService) CancelRunGroup(groupID uint, cancelledBy, reason string) (*models.RunGroup, error) {
+	var group models.RunGroup
+	if err := config.DB.First(&group, groupID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrRunGroupNotFound
+		}
+		return nil, fmt.Errorf("failed to get run group: %v", err)
+	}
+	if group.Status != models.RunGroupStatusRunning {
+		return nil, ErrDeployTestRunNotCancellable
+	}
+
+	config.DB.Model(&models.DeployTestRun{}).
+		Where("run_group_id = ? AND next_retry_at IS NOT NULL", groupID).
+		Update("next_retry_at", nil)
+
+	var runs []models.DeployTestRun
+	if err := config.DB.Select("id", "status").Where("run_group_id = ?", groupID).Find(&runs).Error; err != nil {
+		return nil, fmt.Errorf("failed to load runs of run group: %v", err)
+	}
+	var errs []string
+	for _, run := range runs {
+		if !isCancellableStatus(run.Status) {
+			continue
+		}
+		if _, err := s.CancelDeployTestRun(run.ID, cancelledBy, reason); err != nil && !errors.Is(err, ErrDeployTestRunNotCancellable) {
+			errs = append(errs, fmt.Sprintf("run %d: %v", run.ID, err))
+		}
+	}
+	log.Printf("Run group %d cancelled by %s (reason: %s)", groupID, cancelledBy, reason)
+
+	s.refreshRunGroup(groupID)
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("failed to cancel some runs: %s", strings.Join(errs, "; "))
+	}
+	return s.GetRunGroupByID(groupID)
+}
+
+// recoverRunGroups 服务启动时更新仍在执行中的运行组，补充重启前遗漏的结束状态和通知
+func (s *DeployTestService) recoverRunGroups() {
+	var groupIDs []uint
+	if err := config.DB.Model(&models.RunGroup{}).Where("status = ?", models.RunGroupStatusRunning).
+		Pluck("id", &groupIDs).Error; err != nil {
+		log.Printf("Failed to load running run groups: %v", err)
+		return
+	}
+	for _, id := range groupIDs {
+		s.refreshRunGroup(id)
+	}
+}
@@ -0,0 +1,331 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"crat/config"
+	"crat/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// defaultLogPollInterval 拉取日志的默认间隔，可通过系统设置 log_poll_interval_seconds 调整
+	defaultLogPollInterval = 5 * time.Second
+	// defaultRunLogMaxBytes 每个运行保存的日志上限，可通过系统设置 run_log_max_bytes 调整
+	defaultRunLogMaxBytes = 50 << 20
+	// runLogTruncatedMarker 日志超过上限后追加的提示
+	runLogTruncatedMarker = "\n[crat] log truncated: run_log_max_bytes exceeded\n"
+)
+
+// logCollectors 每个运行正在拉取日志的任务数，所有 DeployTestService 实例共享
+var (
+	logCollectors      = make(map[uint]int)
+	logCollectorsMutex sync.Mutex
+)
+
+// RunLogs 运行日志片段
+type RunLogs struct {
+	Content    string `json:"content"`
+	Offset     int64  `json:"offset"`      // 本段的起始字节
+	NextOffset int64  `json:"next_offset"` // 继续读取时使用的 offset
+	Complete   bool   `json:"complete"`    // 日志不会再增加
+}
+
+// logCollectorRunning 判断是否正在拉取运行的日志
+func logCollectorRunning(runID uint) bool {
+	logCollectorsMutex.Lock()
+	defer logCollectorsMutex.Unlock()
+	return logCollectors[runID] > 0
+}
+
+// startLogCollector 在 monitor 步骤期间定期拉取外部任务的日志，返回的通道在拉取结束后关闭
+//
+// ctx 结束后再拉取一次，保存任务结束前输出的剩余日志。stage 不为 nil 时拉取测试阶段任务的日志，
+// 每行带阶段名称前缀；final 表示该任务的日志结束即运行的日志结束。
+func (s *DeployTestService) startLogCollector(ctx context.Context, run *models.DeployTestRun, runner TestRunner, task TestTask, settings map[string]string, stage *models.DeployTestStage, final bool) <-chan struct{} {
+	done := make(chan struct{})
+	if run.LogComplete {
+		close(done)
+		return done
+	}
+
+	interval := defaultLogPollInterval
+	if seconds, err := strconv.Atoi(settings["log_poll_interval_seconds"]); err == nil && seconds > 0 {
+		interval = time.Duration(seconds) * time.Second
+	}
+	maxBytes := int64(defaultRunLogMaxBytes)
+	if limit, err := strconv.ParseInt(settings["run_log_max_bytes"], 10, 64); err == nil && limit > 0 {
+		maxBytes = limit
+	}
+
+	logCollectorsMutex.Lock()
+	logCollectors[run.ID]++
+	logCollectorsMutex.Unlock()
+
+	go func() {
+		defer close(done)
+		defer func() {
+			logCollectorsMutex.Lock()
+			if logCollectors[run.ID]--; logCollectors[run.ID] <= 0 {
+				delete(logCollectors, run.ID)
+			}
+			logCollectorsMutex.Unlock()
+		}()
+
+		collector := &runLogCollector{
+			runID:        run.ID,
+			runner:       runner,
+			task:         task,
+			remoteOffset: run.LogRemoteOffset,
+			maxBytes:     maxBytes,
+			final:        final,
+			atLineStart:  true,
+		}
+		if stage != nil {
+			collector.stageID = stage.ID
+			collector.prefix = fmt.Sprintf("[%s] ", stage.Name)
+			collector.remoteOffset = stage.LogRemoteOffset
+		}
+		for {
+			finished, err := collector.pull(ctx)
+			if err != nil {
+				if errors.Is(err, ErrRunnerOperationUnsupported) {
+					log.Printf("Test runner %s does not provide logs for run ID %d", runner.Type(), run.ID)
+					return
+				}
+				if ctx.Err() == nil {
+					log.Printf("Failed to fetch logs for run ID %d: %v", run.ID, err)
+				}
+			}
+			if finished {
+				return
+			}
+			if sleepWithContext(ctx, interval) != nil {
+				break
+			}
+		}
+
+		// 任务结束后拉取剩余日志
+		finalCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		for i := 0; i < 10; i++ {
+			finished, err := collector.pull(finalCtx)
+			if err != nil {
+				if !errors.Is(err, ErrRunnerOperationUnsupported) {
+					log.Printf("Failed to fetch final logs for run ID %d: %v", run.ID, err)
+				}
+				return
+			}
+			if finished {
+				return
+			}
+		}
+	}()
+	return done
+}
+
+// runLogCollector 拉取运行中一个外部任务的日志
+//
+// 测试阶段并行执行时多个任务的日志追加到同一个运行日志，写入位置在事务中锁定运行后确定。
+type runLogCollector struct {
+	runID        uint
+	stageID      uint // 测试阶段任务的阶段ID，部署任务为0
+	prefix       string
+	runner       TestRunner
+	task         TestTask
+	remoteOffset int64 // 下次请求的 offset
+	maxBytes     int64
+	final        bool // 任务日志结束时标记运行日志结束
+	atLineStart  bool // 下一段日志从新行开始，用于添加前缀
+}
+
+// pull 拉取一次日志并保存，日志已全部拉取或超过上限时返回 finished 为 true
+func (c *runLogCollector) pull(ctx context.Context) (finished bool, err error) {
+	logs, err := c.runner.Logs(ctx, c.task, c.remoteOffset)
+	if err != nil {
+		return false, err
+	}
+
+	// 数据库只接受有效的 UTF-8，无效字节替换为 U+FFFD
+	content := strings.ToValidUTF8(logs.Content, "\uFFFD")
+	atLineStart := c.atLineStart
+	if c.prefix != "" {
+		content, atLineStart = prefixLogLines(content, c.prefix, c.atLineStart)
+	}
+	if content == "" && logs.NextOffset == c.remoteOffset && !logs.Complete {
+		return false, nil
+	}
+
+	truncated := false
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		var run models.DeployTestRun
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id", "log_size").First(&run, c.runID).Error; err != nil {
+			return err
+		}
+		size := run.LogSize
+		if size > c.maxBytes {
+			// 其他任务的日志已超过上限
+			content = ""
+			truncated = true
+		} else if size+int64(len(content)) > c.maxBytes {
+			cut := int(c.maxBytes - size)
+			for cut > 0 && !utf8.RuneStart(content[cut]) {
+				cut--
+			}
+			content = content[:cut] + runLogTruncatedMarker
+			truncated = true
+		}
+
+		if content != "" {
+			if err := tx.Create(&models.DeployTestRunLog{
+				DeployTestRunID: c.runID,
+				StartOffset:     size,
+				Content:         content,
+			}).Error; err != nil {
+				return err
+			}
+		}
+		updates := map[string]interface{}{"log_size": size + int64(len(content))}
+		if c.final || truncated {
+			updates["log_complete"] = logs.Complete || truncated
+		}
+		if c.stageID == 0 {
+			updates["log_remote_offset"] = logs.NextOffset
+		} else if err := tx.Model(&models.DeployTestStage{}).Where("id = ?", c.stageID).
+			Update("log_remote_offset", logs.NextOffset).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.DeployTestRun{}).Where("id = ?", c.runID).Updates(updates).Error
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to save logs: %v", err)
+	}
+	c.remoteOffset = logs.NextOffset
+	c.atLineStart = atLineStart
+	return logs.Complete || truncated, nil
+}
+
+// prefixLogLines 在每行开头添加前缀，返回处理后的内容和下一段日志是否从新行开始
+func prefixLogLines(content, prefix string, atLineStart bool) (string, bool) {
+	if content == "" {
+		return content, atLineStart
+	}
+	var b strings.Builder
+	for _, line := range strings.SplitAfter(content, "\n") {
+		if line == "" {
+			continue
+		}
+		if atLineStart {
+			b.WriteString(prefix)
+		}
+		b.WriteString(line)
+		atLineStart = strings.HasSuffix(line, "\n")
+	}
+	return b.String(), atLineStart
+}
+
+// GetRunLogs 读取运行日志
+//
+// tail 大于 0 时返回最后 tail 行，否则返回从 offset 开始的内容，单次最多返回 limit 字节 (按日志段截断)。
+func (s *DeployTestService) GetRunLogs(runID uint, offset int64, tail int, limit int64) (*RunLogs, error) {
+	var run models.DeployTestRun
+	if err := config.DB.Select("id", "status", "log_size", "log_complete").First(&run, runID).Error; err != nil {
+		return nil, ErrDeployTestRunNotFound
+	}
+
+	result := &RunLogs{
+		Offset:     offset,
+		NextOffset: offset,
+		Complete:   run.LogComplete || (!isCancellableStatus(run.Status) && !logCollectorRunning(runID)),
+	}
+	if tail > 0 {
+		content, start, err := tailRunLog(runID, run.LogSize, tail)
+		if err != nil {
+			return nil, err
+		}
+		result.Content = content
+		result.Offset = start
+		result.NextOffset = run.LogSize
+		return result, nil
+	}
+	if offset >= run.LogSize {
+		return result, nil
+	}
+
+	// 包含 offset 的日志段及其后的日志段
+	var chunks []models.DeployTestRunLog
+	if err := config.DB.Where("deploy_test_run_id = ? AND start_offset + OCTET_LENGTH(content) > ?", runID, offset).
+		Order("start_offset ASC").Find(&chunks).Error; err != nil {
+		return nil, err
+	}
+	var b strings.Builder
+	for _, chunk := range chunks {
+		if b.Len() > 0 && int64(b.Len()+len(chunk.Content)) > limit {
+			break
+		}
+		if chunk.StartOffset < offset {
+			b.WriteString(chunk.Content[offset-chunk.StartOffset:])
+		} else {
+			b.WriteString(chunk.Content)
+		}
+	}
+	result.Content = b.String()
+	result.NextOffset = offset + int64(b.Len())
+	return result, nil
+}
+
+// tailRunLog 读取日志的最后 lines 行，返回内容及其起始字节
+func tailRunLog(runID uint, size int64, lines int) (string, int64, error) {
+	var parts []string
+	newlines := 0
+	start := size
+	beforeOffset := size + 1
+	for newlines <= lines && start > 0 {
+		var chunks []models.DeployTestRunLog
+		if err := config.DB.Where("deploy_test_run_id = ? AND start_offset < ?", runID, beforeOffset).
+			Order("start_offset DESC").Limit(20).Find(&chunks).Error; err != nil {
+			return "", 0, err
+		}
+		if len(chunks) == 0 {
+			break
+		}
+		for _, chunk := range chunks {
+			parts = append(parts, chunk.Content)
+			newlines += strings.Count(chunk.Content, "\n")
+			start = chunk.StartOffset
+			beforeOffset = chunk.StartOffset
+			if newlines > lines {
+				break
+			}
+		}
+	}
+
+	// 日志段按倒序收集
+	var b strings.Builder
+	for i := len(parts) - 1; i >= 0; i-- {
+		b.WriteString(parts[i])
+	}
+	content := b.String()
+
+	// 去掉末尾换行后从后往前数 lines 行
+	cut := len(content)
+	trimmed := strings.TrimSuffix(content, "\n")
+	for i := 0; i < lines; i++ {
+		idx := strings.LastIndexByte(trimmed[:min(cut, len(trimmed))], '\n')
+		if idx < 0 {
+			return content, start, nil
+		}
+		cut = idx
+	}
+	return content[cut+1:], start + int64(cut+1), nil
+}
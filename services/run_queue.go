@@ -0,0 +1,300 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"crat/config"
+	"crat/models"
+
+	"gorm.io/gorm"
+)
+
+// ErrDeployTestRunNotQueued 部署测试运行不在队列中
+var ErrDeployTestRunNotQueued = errors.New("deploy test run is not queued")
+
+// defaultRunDuration 没有历史数据时估算使用的单次运行时长
+const defaultRunDuration = 30 * time.Minute
+
+// durationHistoryWindow 估算运行时长时参考的历史范围
+const durationHistoryWindow = 30 * 24 * time.Hour
+
+// QueueEntry 队列中的运行及其实时位置和预计开始时间
+type QueueEntry struct {
+	*models.DeployTestRun
+	Position             int       `json:"position"`
+	EstimatedStartAt     time.Time `json:"estimated_start_at"`
+	EstimatedWaitSeconds int64     `json:"estimated_wait_seconds"`
+}
+
+// QueueStatus 队列概况
+type QueueStatus struct {
+	Paused       bool         `json:"paused"`
+	RunningCount int          `json:"running_count"`
+	Entries      []QueueEntry `json:"entries"`
+}
+
+// isQueuePaused 判断队列调度是否已暂停
+func isQueuePaused(settings map[string]string) bool {
+	return settings["queue_paused"] == "true"
+}
+
+// GetQueue 获取队列中的运行，按调度顺序返回实时位置和预计开始时间
+//
+// 预计开始时间按各测试项近期运行的平均时长和当前并发限制模拟得出，队列暂停时按立即恢复估算。
+func (s *DeployTestService) GetQueue() (*QueueStatus, error) {
+	settings, err := s.systemUtils.GetSystemSettings()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get system settings: %v", err)
+	}
+	limits := loadSchedulerLimits(settings)
+
+	var queuedRuns []models.DeployTestRun
+	if err := config.DB.Preload("TestItem").Preload("BuildInfo").Preload("ParameterSet").
+		Where("status = ?", models.DeployTestStatusQueued).
+		Order(queueOrder).
+		Find(&queuedRuns).Error; err != nil {
+		return nil, fmt.Errorf("failed to get queued runs: %v", err)
+	}
+
+	var activeRuns []models.DeployTestRun
+	if err := config.DB.Select("id", "test_item_id", "environment", "started_at", "dispatched_at").
+		Where("status IN ?", models.ActiveDeployTestStatuses).
+		Find(&activeRuns).Error; err != nil {
+		return nil, fmt.Errorf("failed to get running tests: %v", err)
+	}
+
+	estimator := newDurationEstimator()
+	now := time.Now()
+
+	// 执行中运行的预计结束时间
+	var slots []scheduledSlot
+	for _, run := range activeRuns {
+		startedAt := run.StartedAt
+		if run.DispatchedAt != nil {
+			startedAt = *run.DispatchedAt
+		}
+		endAt := startedAt.Add(estimator.estimate(run.TestItemID))
+		if endAt.Before(now) {
+			endAt = now
+		}
+		slots = append(slots, scheduledSlot{environment: run.Environment, endAt: endAt})
+	}
+
+	entries := make([]QueueEntry, 0, len(queuedRuns))
+	for i := range queuedRuns {
+		run := &queuedRuns[i]
+		startAt := earliestStart(slots, run.Environment, limits, now)
+		slots = append(slots, scheduledSlot{
+			environment: run.Environment,
+			endAt:       startAt.Add(estimator.estimate(run.TestItemID)),
+		})
+
+		entries = append(entries, QueueEntry{
+			DeployTestRun:        run,
+			Position:             i + 1,
+			EstimatedStartAt:     startAt,
+			EstimatedWaitSeconds: int64(startAt.Sub(now).Seconds()),
+		})
+	}
+
+	return &QueueStatus{
+		Paused:       isQueuePaused(settings),
+		RunningCount: len(activeRuns),
+		Entries:      entries,
+	}, nil
+}
+
+// scheduledSlot 估算中占用的执行位置
+type scheduledSlot struct {
+	environment string
+	endAt       time.Time
+}
+
+// earliestStart 估算在并发限制下最早可以开始执行的时间
+func earliestStart(slots []scheduledSlot, environment string, limits *schedulerLimits, now time.Time) time.Time {
+	// 候选时间为当前时间和各执行位置的结束时间
+	candidates := []time.Time{now}
+	for _, slot := range slots {
+		if slot.endAt.After(now) {
+			candidates = append(candidates, slot.endAt)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].Before(candidates[j]) })
+
+	environmentLimit := limits.environmentLimit(environment)
+	for _, candidate := range candidates {
+		running, environmentRunning := 0, 0
+		for _, slot := range slots {
+			if slot.endAt.After(candidate) {
+				running++
+				if slot.environment == environment {
+					environmentRunning++
+				}
+			}
+		}
+		if (limits.GlobalLimit == 0 || running < limits.GlobalLimit) &&
+			(environmentLimit == 0 || environmentRunning < environmentLimit) {
+			return candidate
+		}
+	}
+	return candidates[len(candidates)-1]
+}
+
+// durationEstimator 根据历史运行估算运行时长
+type durationEstimator struct {
+	byTestItem map[uint]time.Duration
+	overall    time.Duration
+}
+
+// newDurationEstimator 加载近期已结束运行的平均时长
+func newDurationEstimator() *durationEstimator {
+	estimator := &durationEstimator{
+		byTestItem: make(map[uint]time.Duration),
+		overall:    defaultRunDuration,
+	}
+
+	var rows []struct {
+		TestItemID uint
+		AvgSeconds float64
+		Runs       int64
+	}
+	if err := config.DB.Model(&models.DeployTestRun{}).
+		Select("test_item_id, AVG(EXTRACT(EPOCH FROM (finished_at - COALESCE(dispatched_at, started_at)))) AS avg_seconds, COUNT(*) AS runs").
+		Where("status IN ? AND finished_at IS NOT NULL AND finished_at > ?",
+			[]string{models.DeployTestStatusCompleted, models.DeployTestStatusFailed}, time.Now().Add(-durationHistoryWindow)).
+		Group("test_item_id").
+		Scan(&rows).Error; err != nil {
+		log.Printf("Failed to load run duration history: %v", err)
+		return estimator
+	}
+
+	var totalSeconds float64
+	var totalRuns int64
+	for _, row := range rows {
+		if row.AvgSeconds <= 0 {
+			continue
+		}
+		estimator.byTestItem[row.TestItemID] = time.Duration(row.AvgSeconds * float64(time.Second))
+		totalSeconds += row.AvgSeconds * float64(row.Runs)
+		totalRuns += row.Runs
+	}
+	if totalRuns > 0 {
+		estimator.overall = time.Duration(totalSeconds / float64(totalRuns) * float64(time.Second))
+	}
+	return estimator
+}
+
+// estimate 估算测试项单次运行的时长
+func (e *durationEstimator) estimate(testItemID uint) time.Duration {
+	if duration, ok := e.byTestItem[testItemID]; ok {
+		return duration
+	}
+	return e.overall
+}
+
+// MoveQueuedRun 调整排队运行在队列中的位置 (从1开始)，位置1即移到队首
+func (s *DeployTestService) MoveQueuedRun(runID uint, position int) error {
+	if position < 1 {
+		return fmt.Errorf("position must be at least 1")
+	}
+
+	dispatchMutex.Lock()
+	defer dispatchMutex.Unlock()
+
+	run, err := getQueuedRun(runID)
+	if err != nil {
+		return err
+	}
+
+	var others []models.DeployTestRun
+	if err := config.DB.Select("id", "priority", "queue_seq").
+		Where("status = ? AND id <> ?", models.DeployTestStatusQueued, runID).
+		Order(queueOrder).
+		Find(&others).Error; err != nil {
+		return fmt.Errorf("failed to get queued runs: %v", err)
+	}
+
+	priority, queueSeq := run.Priority, run.QueueSeq
+	switch {
+	case len(others) == 0:
+		// 队列中只有该运行，无需调整
+	case position == 1:
+		priority, queueSeq = others[0].Priority, others[0].QueueSeq-1
+	case position > len(others):
+		last := others[len(others)-1]
+		priority, queueSeq = last.Priority, last.QueueSeq+1
+	default:
+		// 放在 before 与 after 之间，优先级不同时放到 after 所在优先级的最前面
+		before, after := others[position-2], others[position-1]
+		priority = after.Priority
+		if before.Priority == after.Priority {
+			queueSeq = (before.QueueSeq + after.QueueSeq) / 2
+		} else {
+			queueSeq = after.QueueSeq - 1
+		}
+	}
+
+	if err := config.DB.Model(&models.DeployTestRun{}).
+		Where("id = ? AND status = ?", runID, models.DeployTestStatusQueued).
+		Updates(map[string]interface{}{
+			"priority":  priority,
+			"queue_seq": queueSeq,
+		}).Error; err != nil {
+		return fmt.Errorf("failed to reorder queued run: %v", err)
+	}
+
+	log.Printf("Queued run %d moved to position %d (priority: %d)", runID, position, priority)
+	publishQueueEvent("moved", runID)
+
+	s.dispatchQueueLocked()
+	return nil
+}
+
+// RemoveQueuedRun 将运行移出队列，记录为已取消
+func (s *DeployTestService) RemoveQueuedRun(runID uint, removedBy string) (*models.DeployTestRun, error) {
+	dispatchMutex.Lock()
+	defer dispatchMutex.Unlock()
+
+	if _, err := getQueuedRun(runID); err != nil {
+		return nil, err
+	}
+	return s.CancelDeployTestRun(runID, removedBy, "removed from queue")
+}
+
+// SetQueuePaused 暂停或恢复队列调度，暂停期间新触发的测试仍会进入队列
+func (s *DeployTestService) SetQueuePaused(paused bool, changedBy string) error {
+	if err := s.systemUtils.SetSystemSetting("queue_paused", fmt.Sprintf("%t", paused), "是否暂停队列调度"); err != nil {
+		return fmt.Errorf("failed to update queue_paused setting: %v", err)
+	}
+
+	log.Printf("Queue dispatch paused=%v by %s", paused, changedBy)
+	if paused {
+		publishQueueEvent("paused", 0)
+	} else {
+		publishQueueEvent("resumed", 0)
+	}
+
+	if !paused {
+		go s.processNextInQueue()
+	}
+	return nil
+}
+
+// getQueuedRun 获取排队中的运行
+func getQueuedRun(runID uint) (*models.DeployTestRun, error) {
+	var run models.DeployTestRun
+	if err := config.DB.First(&run, runID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrDeployTestRunNotFound
+		}
+		return nil, fmt.Errorf("failed to get deploy test run: %v", err)
+	}
+	if run.Status != models.DeployTestStatusQueued {
+		return nil, ErrDeployTestRunNotQueued
+	}
+	return &run, nil
+}
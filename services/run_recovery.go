@@ -0,0 +1,168 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"crat/config"
+	"crat/models"
+)
+
+// RecoverInFlightRuns 服务启动时恢复重启前未完成的部署测试
+//
+// 已提交到外部测试服务器的运行继续监控，下载阶段中断的运行重新执行或标记失败，
+// 提交阶段中断且没有 task_id 的运行无法确认外部状态，直接标记失败。
+func (s *DeployTestService) RecoverInFlightRuns() {
+	var runs []models.DeployTestRun
+	if err := config.DB.Where("status IN ?", models.ActiveDeployTestStatuses).
+		Order("id ASC").
+		Find(&runs).Error; err != nil {
+		log.Printf("Failed to load in-flight deploy test runs: %v", err)
+		return
+	}
+
+	if len(runs) > 0 {
+		log.Printf("Recovering %d in-flight deploy test run(s)", len(runs))
+	}
+
+	// 修正包缓存引用计数，重启前已结束的运行不再持有引用
+	s.packageCache.Reconcile()
+
+	settings, err := s.systemUtils.GetSystemSettings()
+	if err != nil {
+		log.Printf("Failed to get system settings: %v", err)
+		settings = map[string]string{}
+	}
+	redriveEnabled := settings["recovery_redrive_enabled"] != "false"
+
+	for i := range runs {
+		s.recoverRun(&runs[i], redriveEnabled)
+	}
+
+	s.recoverPendingRetries()
+	s.recoverRunGroups()
+
+	// 队列中仍有等待的测试时重新启动队列监控
+	var queuedCount int64
+	if err := config.DB.Model(&models.DeployTestRun{}).
+		Where("status = ?", models.DeployTestStatusQueued).
+		Count(&queuedCount).Error; err != nil {
+		log.Printf("Failed to count queued tests: %v", err)
+		return
+	}
+	if queuedCount > 0 {
+		log.Printf("Restarting queue monitor for %d queued run(s)", queuedCount)
+		s.monitorQueue()
+		go s.processNextInQueue()
+	}
+}
+
+// recoverRun 恢复单个中断的运行
+func (s *DeployTestService) recoverRun(run *models.DeployTestRun, redriveEnabled bool) {
+	var testItem models.TestItem
+	if err := config.DB.First(&testItem, run.TestItemID).Error; err != nil {
+		s.failRecoveredRun(run.ID, fmt.Sprintf("Server restarted and test item could not be loaded: %v", err))
+		return
+	}
+
+	buildInfo, err := s.buildService.GetBuildInfoByID(run.BuildInfoID)
+	if err != nil {
+		s.failRecoveredRun(run.ID, fmt.Sprintf("Server restarted and build info could not be loaded: %v", err))
+		return
+	}
+
+	pipeline, err := resolvePipeline(&testItem)
+	if err != nil {
+		s.failRecoveredRun(run.ID, fmt.Sprintf("Server restarted and pipeline is invalid: %v", err))
+		return
+	}
+
+	// 找到第一个未完成的步骤作为恢复点
+	stepStatuses := recordedStepStatuses(run)
+	resumeStep := ""
+	for _, entry := range pipeline {
+		if stepStatuses[entry.step.Name()] != "COMPLETED" {
+			resumeStep = entry.step.Name()
+			break
+		}
+	}
+	if resumeStep == "" {
+		// 所有步骤均已完成但状态未更新，仅补充结束状态
+		log.Printf("All steps of run ID %d finished before restart, finalizing", run.ID)
+		s.finalizeRun(run.ID)
+		return
+	}
+
+	switch resumeStep {
+	case models.StepDownload:
+		if !redriveEnabled {
+			s.failRecoveredRun(run.ID, "Server restarted during package download")
+			return
+		}
+	case models.StepTest:
+		if run.TaskID != "" {
+			// 测试已提交成功，继续监控
+			resumeStep = models.StepMonitor
+		} else if stepStatuses[models.StepTest] != "" {
+			s.failRecoveredRun(run.ID, "Server restarted while submitting the test; external task state is unknown")
+			return
+		}
+	}
+
+	log.Printf("Resuming run ID %d from step %s (status: %s)", run.ID, resumeStep, run.Status)
+	s.addStep(run.ID, models.StepRecovery, "COMPLETED", fmt.Sprintf("Resumed from step %s after server restart", resumeStep), "")
+
+	go s.executePipeline(run, &testItem, buildInfo, resumeStep)
+}
+
+// failRecoveredRun 将无法恢复的运行标记为失败
+func (s *DeployTestService) failRecoveredRun(runID uint, reason string) {
+	log.Printf("Marking run ID %d as failed during recovery: %s", runID, reason)
+	s.addStep(runID, models.StepRecovery, "FAILED", "", reason)
+	s.updateDeployTestStatus(runID, models.DeployTestStatusFailed, reason)
+	config.DB.Model(&models.DeployTestRun{}).Where("id = ?", runID).Update("failure_class", models.FailureClassInternal)
+	s.packageCache.Release(runID)
+	s.refreshRunGroupOf(runID)
+}
+
+// recoverPendingRetries 重新安排重启前已计划但未执行的自动重试
+func (s *DeployTestService) recoverPendingRetries() {
+	var runs []models.DeployTestRun
+	if err := config.DB.Where("status = ? AND next_retry_at IS NOT NULL", models.DeployTestStatusFailed).
+		Find(&runs).Error; err != nil {
+		log.Printf("Failed to load pending retries: %v", err)
+		return
+	}
+
+	for _, run := range runs {
+		delay := time.Until(*run.NextRetryAt)
+		if delay < 0 {
+			delay = 0
+		}
+		runID := run.ID
+		log.Printf("Rescheduling retry for run ID %d in %v", runID, delay)
+		time.AfterFunc(delay, func() {
+			s.retryRun(runID)
+		})
+	}
+}
+
+// recordedStepStatuses 获取运行中已记录的各步骤状态
+func recordedStepStatuses(run *models.DeployTestRun) map[string]string {
+	statuses := make(map[string]string)
+	if len(run.Steps) == 0 {
+		return statuses
+	}
+
+	var steps []models.DeployTestStep
+	if err := json.Unmarshal(run.Steps, &steps); err != nil {
+		log.Printf("Failed to unmarshal steps for run ID %d: %v", run.ID, err)
+		return statuses
+	}
+	for _, step := range steps {
+		statuses[step.Name] = step.Status
+	}
+	return statuses
+}
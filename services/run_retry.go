@@ -0,0 +1,174 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"crat/config"
+	"crat/models"
+
+	"gorm.io/gorm"
+)
+
+// ErrDeployTestRunInProgress 部署测试运行尚未结束
+var ErrDeployTestRunInProgress = errors.New("deploy test run is still in progress")
+
+// classifiedError 带失败分类的错误
+type classifiedError struct {
+	class string
+	err   error
+}
+
+func (e *classifiedError) Error() string { return e.err.Error() }
+
+func (e *classifiedError) Unwrap() error { return e.err }
+
+// classifyError 为错误附加失败分类
+func classifyError(class string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &classifiedError{class: class, err: err}
+}
+
+// failureClassOf 获取步骤错误的失败分类，未显式分类时按步骤推断
+func failureClassOf(stepName string, err error) string {
+	var classified *classifiedError
+	if errors.As(err, &classified) {
+		return classified.class
+	}
+
+	switch stepName {
+	case models.StepDownload:
+		return models.FailureClassDownload
+	case models.StepInspect:
+		return models.FailureClassPackageContent
+	case models.StepTest:
+		return models.FailureClassTrigger
+	case models.StepMonitor:
+		return models.FailureClassMonitor
+	default:
+		return models.FailureClassStep
+	}
+}
+
+// ValidateRetryPolicy 校验重试策略配置，供创建和更新测试项时使用
+func ValidateRetryPolicy(raw json.RawMessage) error {
+	testItem := models.TestItem{RetryPolicy: raw}
+	policy, err := testItem.GetRetryPolicy()
+	if err != nil {
+		return fmt.Errorf("invalid retry policy format: %v", err)
+	}
+	if policy == nil {
+		return nil
+	}
+	if policy.MaxAttempts < 1 || policy.MaxAttempts > 10 {
+		return fmt.Errorf("retry policy max_attempts must be between 1 and 10")
+	}
+	if policy.BackoffSeconds < 0 || policy.MaxBackoffSeconds < 0 {
+		return fmt.Errorf("retry policy backoff must not be negative")
+	}
+	return nil
+}
+
+// scheduleRetry 根据测试项的重试策略安排自动重试
+func (s *DeployTestService) scheduleRetry(deployTestRun *models.DeployTestRun, testItem *models.TestItem, failureClass string) {
+	policy, err := testItem.GetRetryPolicy()
+	if err != nil {
+		log.Printf("Invalid retry policy for test item %d: %v", testItem.ID, err)
+		return
+	}
+	if policy == nil {
+		return
+	}
+
+	attempt := deployTestRun.Attempt
+	if attempt <= 0 {
+		attempt = 1
+	}
+	if !policy.ShouldRetry(failureClass, attempt) {
+		return
+	}
+
+	backoff := policy.Backoff(attempt)
+	nextRetryAt := time.Now().Add(backoff)
+	config.DB.Model(&models.DeployTestRun{}).Where("id = ?", deployTestRun.ID).Update("next_retry_at", &nextRetryAt)
+
+	log.Printf("Scheduling retry for run ID %d (attempt %d/%d, class: %s) in %v", deployTestRun.ID, attempt+1, policy.MaxAttempts, failureClass, backoff)
+
+	time.AfterFunc(backoff, func() {
+		s.retryRun(deployTestRun.ID)
+	})
+}
+
+// retryRun 为失败的运行创建下一次重试
+func (s *DeployTestService) retryRun(runID uint) {
+	var run models.DeployTestRun
+	if err := config.DB.First(&run, runID).Error; err != nil {
+		log.Printf("Failed to load run ID %d for retry: %v", runID, err)
+		return
+	}
+	// 运行组取消时清除了计划的重试
+	if run.Status != models.DeployTestStatusFailed || run.NextRetryAt == nil {
+		return
+	}
+
+	// 避免重复创建重试
+	var existing int64
+	config.DB.Model(&models.DeployTestRun{}).
+		Where("rerun_of_id = ? AND attempt = ?", run.ID, run.Attempt+1).
+		Count(&existing)
+	if existing > 0 {
+		return
+	}
+
+	result, err := s.TriggerRun(&TriggerRequest{
+		TestItemID:     run.TestItemID,
+		BuildInfoID:    run.BuildInfoID,
+		TriggeredBy:    run.TriggeredBy,
+		ParameterSetID: run.ParameterSetID,
+		RerunOfID:      &run.ID,
+		Attempt:        run.Attempt + 1,
+		Priority:       run.Priority,
+		RunGroupID:     run.RunGroupID,
+		Monitor:        monitorSettingsOf(&run),
+	})
+	if err != nil {
+		log.Printf("Failed to retry run ID %d: %v", run.ID, err)
+		config.DB.Model(&models.DeployTestRun{}).Where("id = ?", run.ID).Update("next_retry_at", nil)
+		s.refreshRunGroupOf(run.ID)
+		return
+	}
+
+	config.DB.Model(&models.DeployTestRun{}).Where("id = ?", run.ID).Update("next_retry_at", nil)
+	log.Printf("Retry of run ID %d created: run_id=%d, attempt=%d, queued=%v", run.ID, result.RunID, run.Attempt+1, result.Queued)
+}
+
+// RerunDeployTestRun 使用相同的测试项、构建和参数集重新执行已结束的运行
+func (s *DeployTestService) RerunDeployTestRun(runID uint, triggeredBy string) (*TriggerResult, error) {
+	var run models.DeployTestRun
+	if err := config.DB.First(&run, runID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrDeployTestRunNotFound
+		}
+		return nil, fmt.Errorf("failed to get deploy test run: %v", err)
+	}
+
+	if isCancellableStatus(run.Status) {
+		return nil, ErrDeployTestRunInProgress
+	}
+
+	log.Printf("Rerunning deploy test run %d, requested by %s", run.ID, triggeredBy)
+
+	return s.TriggerRun(&TriggerRequest{
+		TestItemID:     run.TestItemID,
+		BuildInfoID:    run.BuildInfoID,
+		TriggeredBy:    triggeredBy,
+		ParameterSetID: run.ParameterSetID,
+		RerunOfID:      &run.ID,
+		Priority:       run.Priority,
+	})
+}
@@ -0,0 +1,425 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"crat/config"
+	"crat/models"
+)
+
+// monitorContext monitor 步骤中部署任务和各测试阶段任务共享的参数
+type monitorContext struct {
+	ctx       context.Context
+	run       *models.DeployTestRun
+	params    *models.TestParameters
+	runner    TestRunner
+	settings  map[string]string
+	serverURL string
+	deadline  time.Time
+	stages    *models.TestStagesConfig // 为 nil 时部署和测试在一个任务中完成
+}
+
+// ValidateTestStages 校验测试项的 test_stages 配置
+func ValidateTestStages(raw json.RawMessage) error {
+	item := &models.TestItem{TestStages: raw}
+	stages, err := item.GetTestStages()
+	if err != nil {
+		return fmt.Errorf("invalid test_stages: %v", err)
+	}
+	if stages == nil {
+		return nil
+	}
+	if stages.Mode != models.TestStageModeSequential && stages.Mode != models.TestStageModeParallel {
+		return fmt.Errorf("invalid test_stages mode %q, must be sequential or parallel", stages.Mode)
+	}
+	names := make(map[string]bool)
+	for i, stage := range stages.Stages {
+		if stage.Name == "" {
+			return fmt.Errorf("test stage %d requires a name", i+1)
+		}
+		if names[stage.Name] {
+			return fmt.Errorf("duplicate test stage name %q", stage.Name)
+		}
+		names[stage.Name] = true
+		if stage.TestPath == "" {
+			return fmt.Errorf("test stage %q requires a test_path", stage.Name)
+		}
+		if stage.TestPath == models.DeployOnlyTestPath {
+			return fmt.Errorf("test stage %q must not use test_path %q", stage.Name, models.DeployOnlyTestPath)
+		}
+	}
+	return nil
+}
+
+// resolveTestStages 获取运行的测试阶段配置
+//
+// 未配置 test_stages 时返回 nil，部署和测试在一个任务中完成；参数集的 test_path 为 deploy 时
+// 视为没有测试阶段，即仅部署 (兼容旧配置)。
+func resolveTestStages(testItem *models.TestItem, params *models.TestParameters) (*models.TestStagesConfig, error) {
+	stages, err := testItem.GetTestStages()
+	if err != nil {
+		return nil, fmt.Errorf("invalid test_stages: %v", err)
+	}
+	if stages == nil && params.TestPath == models.DeployOnlyTestPath {
+		return &models.TestStagesConfig{Mode: models.TestStageModeSequential}, nil
+	}
+	return stages, nil
+}
+
+// waitForTask 等待外部任务结束，返回 completed 或 failed 状态
+//
+// 等待期间拉取任务日志并接收回调，超过 deadline 时返回 monitor_timeout 错误。
+func (s *DeployTestService) waitForTask(mc *monitorContext, task TestTask, stage *models.DeployTestStage) (*TaskStatus, error) {
+	logCtx, stopLogs := context.WithCancel(mc.ctx)
+	logsDone := s.startLogCollector(logCtx, mc.run, mc.runner, task, mc.settings, stage, stage == nil && mc.stages == nil)
+	defer func() {
+		stopLogs()
+		<-logsDone
+	}()
+
+	// 测试服务器通过回调推送状态时，轮询只作为兜底
+	queryInterval := time.Duration(mc.run.QueryInterval) * time.Second
+	pollInterval := queryInterval
+	var callbacks <-chan *TaskStatus
+	if callbackURL(mc.run.ID, mc.settings) != "" {
+		ch, unregister := registerCallbackWaiter(mc.run.ID, task.TaskID)
+		defer unregister()
+		callbacks = ch
+		pollInterval = callbackPollInterval(mc.settings, queryInterval)
+	}
+
+	var taskStatus *TaskStatus
+	var err error
+	for {
+		// 检查是否超时
+		if time.Now().After(mc.deadline) {
+			return nil, classifyError(models.FailureClassMonitorTimeout, fmt.Errorf("monitoring timeout after %d hours", mc.run.MaxQueryHours))
+		}
+
+		// 没有收到回调时查询任务状态
+		if taskStatus == nil {
+			taskStatus, err = mc.runner.Status(mc.ctx, task)
+			if err != nil {
+				log.Printf("Failed to query task status: %v", err)
+			}
+		}
+
+		if taskStatus != nil {
+			log.Printf("Task %s status: %s", task.TaskID, taskStatus.State)
+			switch taskStatus.State {
+			case TaskStateCompleted, TaskStateFailed:
+				return taskStatus, nil
+			case TaskStatePending, TaskStateRunning:
+				// 继续等待
+			default:
+				log.Printf("Unknown task status: %s", taskStatus.State)
+			}
+		}
+
+		taskStatus, err = waitForTaskUpdate(mc.ctx, pollInterval, callbacks)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// runTestStages 部署完成后执行测试阶段，并根据各阶段结果写入运行的最终状态
+//
+// 服务重启后恢复 monitor 步骤时，已结束的阶段不再执行，执行中的阶段继续等待原任务。
+func (s *DeployTestService) runTestStages(mc *monitorContext) error {
+	stages, err := s.ensureTestStages(mc.run.ID, mc.stages)
+	if err != nil {
+		s.addStep(mc.run.ID, models.StepMonitor, "FAILED", "", err.Error())
+		return err
+	}
+	s.addStep(mc.run.ID, models.StepMonitor, "RUNNING", fmt.Sprintf("Deploy completed, running %d test stages (%s)", len(stages), mc.stages.Mode), "")
+
+	scheduleTestStages(mc.ctx, mc.stages, stages, func(stage *models.DeployTestStage) {
+		s.runTestStage(mc, stage)
+	}, func(stage *models.DeployTestStage) {
+		s.finishStage(stage, models.StageStatusSkipped, nil, "Skipped after a previous stage failed")
+	})
+
+	if err := mc.ctx.Err(); err != nil {
+		// 运行已被取消，阶段状态由取消接口写入
+		return err
+	}
+	return s.completeTestStages(mc, stages)
+}
+
+// scheduleTestStages 按配置的模式执行尚未结束的测试阶段
+//
+// 并行模式同时执行所有阶段；顺序模式逐个执行，未开启 continue_on_failure 时阶段失败后其余阶段交给 skip，
+// 运行被取消后不再执行新的阶段。run 和 skip 负责更新阶段状态。
+func scheduleTestStages(ctx context.Context, cfg *models.TestStagesConfig, stages []models.DeployTestStage, run, skip func(stage *models.DeployTestStage)) {
+	if cfg.Mode == models.TestStageModeParallel {
+		var wg sync.WaitGroup
+		for i := range stages {
+			if !isActiveStageStatus(stages[i].Status) {
+				continue
+			}
+			wg.Add(1)
+			go func(stage *models.DeployTestStage) {
+				defer wg.Done()
+				run(stage)
+			}(&stages[i])
+		}
+		wg.Wait()
+		return
+	}
+
+	failed := false
+	for i := range stages {
+		stage := &stages[i]
+		if ctx.Err() != nil {
+			return
+		}
+		if isActiveStageStatus(stage.Status) {
+			if failed && !cfg.ContinueOnFailure {
+				skip(stage)
+				continue
+			}
+			run(stage)
+		}
+		if stage.Status == models.StageStatusFailed {
+			failed = true
+		}
+	}
+}
+
+// ensureTestStages 获取运行的测试阶段记录，首次执行时按配置创建
+func (s *DeployTestService) ensureTestStages(runID uint, cfg *models.TestStagesConfig) ([]models.DeployTestStage, error) {
+	var stages []models.DeployTestStage
+	if err := config.DB.Where("deploy_test_run_id = ?", runID).Order("seq ASC").Find(&stages).Error; err != nil {
+		return nil, fmt.Errorf("failed to load test stages: %v", err)
+	}
+	if len(stages) > 0 {
+		return stages, nil
+	}
+
+	for i, stage := range cfg.Stages {
+		stages = append(stages, models.DeployTestStage{
+			DeployTestRunID: runID,
+			Seq:             i,
+			Name:            stage.Name,
+			TestPath:        stage.TestPath,
+			Status:          models.StageStatusPending,
+		})
+	}
+	if err := config.DB.Create(&stages).Error; err != nil {
+		return nil, fmt.Errorf("failed to create test stages: %v", err)
+	}
+	return stages, nil
+}
+
+// runTestStage 在部署所用的测试服务器上执行一个测试阶段，结果写入阶段记录
+func (s *DeployTestService) runTestStage(mc *monitorContext, stage *models.DeployTestStage) {
+	stepName := models.StepStagePrefix + stage.Name
+
+	if stage.TaskID == "" {
+		params := *mc.params
+		params.TestPath = stage.TestPath
+		for _, cfg := range mc.stages.Stages {
+			if cfg.Name == stage.Name && cfg.ReportKeyword != "" {
+				params.ReportKeyword = cfg.ReportKeyword
+			}
+		}
+
+		s.addStep(mc.run.ID, stepName, "RUNNING", fmt.Sprintf("Submitting test stage, test_path: %s", stage.TestPath), "")
+		// 包已部署，测试阶段只传递本地路径用于标识；提交期间运行被取消时终止刚提交的任务
+		now := time.Now()
+		result, err := submitTestTask(mc.ctx, mc.runner, &TestSubmission{
+			Run:          mc.run,
+			Params:       &params,
+			ServerURL:    mc.serverURL,
+			TransferMode: TransferModePath,
+			Settings:     mc.settings,
+			Stage:        stage.Name,
+		}, func(taskID string) (bool, error) {
+			updated := config.DB.Model(&models.DeployTestStage{}).
+				Where("id = ? AND status = ?", stage.ID, models.StageStatusPending).
+				Updates(map[string]interface{}{
+					"status":     models.StageStatusRunning,
+					"task_id":    taskID,
+					"started_at": &now,
+				})
+			return updated.RowsAffected > 0, updated.Error
+		})
+		if errors.Is(err, errRunCancelled) {
+			return
+		}
+		if err != nil {
+			if mc.ctx.Err() != nil {
+				return
+			}
+			s.finishStage(stage, models.StageStatusFailed, nil, fmt.Sprintf("Failed to submit test stage: %v", err))
+			s.addStep(mc.run.ID, stepName, "FAILED", "", stage.ErrorMessage)
+			return
+		}
+		stage.Status = models.StageStatusRunning
+		stage.TaskID = result.TaskID
+		stage.StartedAt = &now
+		s.addStep(mc.run.ID, stepName, "RUNNING", fmt.Sprintf("Test stage running, task_id: %s", result.TaskID), "")
+	}
+
+	taskStatus, err := s.waitForTask(mc, TestTask{
+		ServerURL:      mc.serverURL,
+		TaskID:         stage.TaskID,
+		TimeoutSeconds: mc.run.QueryTimeout,
+	}, stage)
+	if err != nil {
+		if mc.ctx.Err() != nil {
+			return
+		}
+		s.finishStage(stage, models.StageStatusFailed, nil, err.Error())
+		s.addStep(mc.run.ID, stepName, "FAILED", "", err.Error())
+		return
+	}
+
+	if taskStatus.State == TaskStateCompleted {
+		s.finishStage(stage, models.StageStatusCompleted, taskStatus, "")
+		s.addStep(mc.run.ID, stepName, "COMPLETED", fmt.Sprintf("Test stage completed, report URL: %s", taskStatus.ReportURL), "")
+		return
+	}
+	s.finishStage(stage, models.StageStatusFailed, taskStatus, taskStatus.Error)
+	s.addStep(mc.run.ID, stepName, "FAILED", "", fmt.Sprintf("Test stage failed: %s", taskStatus.Error))
+}
+
+// finishStage 写入测试阶段的结束状态，已被取消的阶段不再更新
+func (s *DeployTestService) finishStage(stage *models.DeployTestStage, status string, taskStatus *TaskStatus, errorMsg string) {
+	now := time.Now()
+	updates := map[string]interface{}{
+		"status":        status,
+		"error_message": errorMsg,
+		"finished_at":   &now,
+	}
+	if taskStatus != nil {
+		updates["report_url"] = taskStatus.ReportURL
+		if len(taskStatus.Summary) > 0 {
+			updates["summary"] = taskStatus.Summary
+		}
+		stage.ReportURL = taskStatus.ReportURL
+		stage.Summary = taskStatus.Summary
+	}
+	result := config.DB.Model(&models.DeployTestStage{}).
+		Where("id = ? AND status IN ?", stage.ID, []string{models.StageStatusPending, models.StageStatusRunning}).
+		Updates(updates)
+	if result.Error != nil {
+		log.Printf("Failed to update test stage %s for run ID %d: %v", stage.Name, stage.DeployTestRunID, result.Error)
+	}
+	if result.RowsAffected > 0 {
+		stage.Status = status
+		stage.ErrorMessage = errorMsg
+		stage.FinishedAt = &now
+	}
+}
+
+// completeTestStages 汇总测试阶段结果：全部完成时运行完成，否则运行失败
+//
+// 运行的报告地址为第一个有报告的阶段的报告地址。
+func (s *DeployTestService) completeTestStages(mc *monitorContext, stages []models.DeployTestStage) error {
+	reportURL, failed, skipped := summarizeTestStages(stages)
+
+	now := time.Now()
+	updates := map[string]interface{}{
+		"report_url":  reportURL,
+		"finished_at": &now,
+	}
+	if len(failed) == 0 && len(skipped) == 0 {
+		updates["status"] = models.DeployTestStatusCompleted
+		if err := config.DB.Model(&models.DeployTestRun{}).Where("id = ? AND status <> ?", mc.run.ID, models.DeployTestStatusCancelled).Updates(updates).Error; err != nil {
+			return fmt.Errorf("failed to update deploy test run status: %v", err)
+		}
+		publishRunStatus(mc.run.ID)
+		s.addStep(mc.run.ID, models.StepMonitor, "COMPLETED", fmt.Sprintf("All %d test stages completed, report URL: %s", len(stages), reportURL), "")
+		return nil
+	}
+
+	errorMsg := fmt.Sprintf("%d of %d test stages failed: %s", len(failed), len(stages), strings.Join(failed, ", "))
+	if len(skipped) > 0 {
+		errorMsg += fmt.Sprintf("; skipped: %s", strings.Join(skipped, ", "))
+	}
+	updates["status"] = models.DeployTestStatusFailed
+	updates["error_message"] = errorMsg
+	if err := config.DB.Model(&models.DeployTestRun{}).Where("id = ? AND status <> ?", mc.run.ID, models.DeployTestStatusCancelled).Updates(updates).Error; err != nil {
+		return fmt.Errorf("failed to update deploy test run status: %v", err)
+	}
+	publishRunStatus(mc.run.ID)
+	s.addStep(mc.run.ID, models.StepMonitor, "FAILED", "", errorMsg)
+
+	failureClass := models.FailureClassTestFailed
+	if time.Now().After(mc.deadline) {
+		failureClass = models.FailureClassMonitorTimeout
+	}
+	return classifyError(failureClass, errors.New(errorMsg))
+}
+
+// summarizeTestStages 汇总测试阶段结果，返回第一个有报告的阶段的报告地址、失败和跳过的阶段名
+func summarizeTestStages(stages []models.DeployTestStage) (string, []string, []string) {
+	var reportURL string
+	var failed, skipped []string
+	for _, stage := range stages {
+		if reportURL == "" {
+			reportURL = stage.ReportURL
+		}
+		switch stage.Status {
+		case models.StageStatusCompleted:
+		case models.StageStatusSkipped:
+			skipped = append(skipped, stage.Name)
+		default:
+			failed = append(failed, stage.Name)
+		}
+	}
+	return reportURL, failed, skipped
+}
+
+// isActiveStageStatus 判断测试阶段是否尚未结束
+func isActiveStageStatus(status string) bool {
+	return status == models.StageStatusPending || status == models.StageStatusRunning
+}
+
+// cancelTestStages 终止执行中的测试阶段任务，并将未结束的阶段标记为已取消
+//
+// 运行没有测试阶段记录时返回 false，由调用方终止部署任务。
+func (s *DeployTestService) cancelTestStages(run *models.DeployTestRun, runner TestRunner, serverURL string) (bool, error) {
+	var stages []models.DeployTestStage
+	if err := config.DB.Where("deploy_test_run_id = ?", run.ID).Find(&stages).Error; err != nil {
+		return false, fmt.Errorf("failed to load test stages: %v", err)
+	}
+	if len(stages) == 0 {
+		return false, nil
+	}
+
+	now := time.Now()
+	config.DB.Model(&models.DeployTestStage{}).
+		Where("deploy_test_run_id = ? AND status IN ?", run.ID, []string{models.StageStatusPending, models.StageStatusRunning}).
+		Updates(map[string]interface{}{
+			"status":      models.StageStatusCancelled,
+			"finished_at": &now,
+		})
+
+	var errs []string
+	for _, stage := range stages {
+		if stage.Status != models.StageStatusRunning || stage.TaskID == "" {
+			continue
+		}
+		if err := runner.Cancel(context.Background(), TestTask{
+			ServerURL:      serverURL,
+			TaskID:         stage.TaskID,
+			TimeoutSeconds: 30,
+		}); err != nil {
+			errs = append(errs, fmt.Sprintf("stage %s: %v", stage.Name, err))
+		}
+	}
+	if len(errs) > 0 {
+		return true, errors.New(strings.Join(errs, "; "))
+	}
+	return true, nil
+}
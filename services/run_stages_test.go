@@ -0,0 +1,214 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"crat/models"
+)
+
+// stageRunner 通过模拟后端执行测试阶段，记录阶段的执行顺序
+type stageRunner struct {
+	t      *testing.T
+	runner TestRunner
+	run    *models.DeployTestRun
+
+	mu  sync.Mutex
+	ran []string
+}
+
+func newStageRunner(t *testing.T, failStages ...string) *stageRunner {
+	t.Helper()
+	raw, _ := json.Marshal(fakeRunnerConfig{ReportURL: "http://reports/1", FailStages: failStages})
+	runner, err := newFakeTestRunner(raw)
+	if err != nil {
+		t.Fatalf("newFakeTestRunner: %v", err)
+	}
+	return &stageRunner{t: t, runner: runner, run: &models.DeployTestRun{ID: 11}}
+}
+
+func (r *stageRunner) runStage(stage *models.DeployTestStage) {
+	r.mu.Lock()
+	r.ran = append(r.ran, stage.Name)
+	r.mu.Unlock()
+
+	result, err := r.runner.Submit(context.Background(), &TestSubmission{
+		Run:    r.run,
+		Params: &models.TestParameters{ServiceName: "app"},
+		Stage:  stage.Name,
+	})
+	if err != nil {
+		r.t.Errorf("Submit(%s): %v", stage.Name, err)
+		return
+	}
+	status, err := r.runner.Status(context.Background(), TestTask{TaskID: result.TaskID})
+	if err != nil {
+		r.t.Errorf("Status(%s): %v", stage.Name, err)
+		return
+	}
+	stage.TaskID = result.TaskID
+	stage.ReportURL = status.ReportURL
+	if status.State == TaskStateCompleted {
+		stage.Status = models.StageStatusCompleted
+	} else {
+		stage.Status = models.StageStatusFailed
+	}
+}
+
+func skipStage(stage *models.DeployTestStage) {
+	stage.Status = models.StageStatusSkipped
+}
+
+func newTestStages(names ...string) []models.DeployTestStage {
+	stages := make([]models.DeployTestStage, len(names))
+	for i, name := range names {
+		stages[i] = models.DeployTestStage{Seq: i, Name: name, Status: models.StageStatusPending}
+	}
+	return stages
+}
+
+func stageStatuses(stages []models.DeployTestStage) []string {
+	statuses := make([]string, len(stages))
+	for i, stage := range stages {
+		statuses[i] = stage.Name + "=" + stage.Status
+	}
+	return statuses
+}
+
+func TestScheduleTestStagesSequential(t *testing.T) {
+	tests := []struct {
+		name              string
+		continueOnFailure bool
+		failStages        []string
+		wantRan           []string
+		wantStatuses      []string
+	}{
+		{
+			name:         "all completed",
+			wantRan:      []string{"smoke", "api", "ui"},
+			wantStatuses: []string{"smoke=COMPLETED", "api=COMPLETED", "ui=COMPLETED"},
+		},
+		{
+			name:         "stop after failure",
+			failStages:   []string{"smoke"},
+			wantRan:      []string{"smoke"},
+			wantStatuses: []string{"smoke=FAILED", "api=SKIPPED", "ui=SKIPPED"},
+		},
+		{
+			name:              "continue on failure",
+			continueOnFailure: true,
+			failStages:        []string{"smoke"},
+			wantRan:           []string{"smoke", "api", "ui"},
+			wantStatuses:      []string{"smoke=FAILED", "api=COMPLETED", "ui=COMPLETED"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner := newStageRunner(t, tt.failStages...)
+			stages := newTestStages("smoke", "api", "ui")
+			cfg := &models.TestStagesConfig{Mode: models.TestStageModeSequential, ContinueOnFailure: tt.continueOnFailure}
+
+			scheduleTestStages(context.Background(), cfg, stages, runner.runStage, skipStage)
+
+			if !reflect.DeepEqual(runner.ran, tt.wantRan) {
+				t.Errorf("ran %v, want %v", runner.ran, tt.wantRan)
+			}
+			if got := stageStatuses(stages); !reflect.DeepEqual(got, tt.wantStatuses) {
+				t.Errorf("statuses %v, want %v", got, tt.wantStatuses)
+			}
+		})
+	}
+}
+
+func TestScheduleTestStagesSequentialResume(t *testing.T) {
+	// 恢复执行时已结束的阶段不再执行，但之前的失败仍会跳过后续阶段
+	runner := newStageRunner(t)
+	stages := newTestStages("smoke", "api", "ui")
+	stages[0].Status = models.StageStatusCompleted
+	stages[1].Status = models.StageStatusFailed
+	cfg := &models.TestStagesConfig{Mode: models.TestStageModeSequential}
+
+	scheduleTestStages(context.Background(), cfg, stages, runner.runStage, skipStage)
+
+	if len(runner.ran) != 0 {
+		t.Errorf("ran %v, want none", runner.ran)
+	}
+	want := []string{"smoke=COMPLETED", "api=FAILED", "ui=SKIPPED"}
+	if got := stageStatuses(stages); !reflect.DeepEqual(got, want) {
+		t.Errorf("statuses %v, want %v", got, want)
+	}
+}
+
+func TestScheduleTestStagesSequentialCancelled(t *testing.T) {
+	runner := newStageRunner(t)
+	stages := newTestStages("smoke", "api")
+	ctx, cancel := context.WithCancel(context.Background())
+	cfg := &models.TestStagesConfig{Mode: models.TestStageModeSequential}
+
+	scheduleTestStages(ctx, cfg, stages, func(stage *models.DeployTestStage) {
+		runner.runStage(stage)
+		cancel()
+	}, skipStage)
+
+	want := []string{"smoke=COMPLETED", "api=PENDING"}
+	if got := stageStatuses(stages); !reflect.DeepEqual(got, want) {
+		t.Errorf("statuses %v, want %v", got, want)
+	}
+}
+
+func TestScheduleTestStagesParallel(t *testing.T) {
+	for _, continueOnFailure := range []bool{false, true} {
+		runner := newStageRunner(t, "smoke")
+		stages := newTestStages("smoke", "api", "ui")
+		stages[2].Status = models.StageStatusCompleted
+		cfg := &models.TestStagesConfig{Mode: models.TestStageModeParallel, ContinueOnFailure: continueOnFailure}
+
+		// 所有未结束的阶段都开始执行后才放行，阶段之间互相等待说明它们是并发执行的
+		var started sync.WaitGroup
+		started.Add(2)
+		allStarted := make(chan struct{})
+		go func() {
+			started.Wait()
+			close(allStarted)
+		}()
+
+		scheduleTestStages(context.Background(), cfg, stages, func(stage *models.DeployTestStage) {
+			started.Done()
+			select {
+			case <-allStarted:
+			case <-time.After(5 * time.Second):
+				t.Errorf("stage %s did not run concurrently with the other stages", stage.Name)
+			}
+			runner.runStage(stage)
+		}, func(stage *models.DeployTestStage) {
+			t.Errorf("stage %s skipped in parallel mode", stage.Name)
+		})
+
+		want := []string{"smoke=FAILED", "api=COMPLETED", "ui=COMPLETED"}
+		if got := stageStatuses(stages); !reflect.DeepEqual(got, want) {
+			t.Errorf("continue_on_failure=%v: statuses %v, want %v", continueOnFailure, got, want)
+		}
+	}
+}
+
+func TestSummarizeTestStages(t *testing.T) {
+	stages := newTestStages("smoke", "api", "ui", "perf")
+	stages[0].Status = models.StageStatusCompleted
+	stages[1].Status = models.StageStatusFailed
+	stages[1].ReportURL = "http://reports/api"
+	stages[2].Status = models.StageStatusSkipped
+	stages[3].Status = models.StageStatusCompleted
+	stages[3].ReportURL = "http://reports/perf"
+
+	reportURL, failed, skipped := summarizeTestStages(stages)
+	if reportURL != "http://reports/api" {
+		t.Errorf("reportURL = %q, want the first stage report", reportURL)
+	}
+	if !reflect.DeepEqual(failed, []string{"api"}) || !reflect.DeepEqual(skipped, []string{"ui"}) {
+		t.Errorf("failed %v skipped %v, want [api] and [ui]", failed, skipped)
+	}
+}
@@ -0,0 +1,443 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"crat/config"
+	"crat/models"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// scheduleTickInterval 调度器检查到期定时运行的间隔
+	scheduleTickInterval = 30 * time.Second
+	// defaultScheduleMisfireGrace 超过计划时间多久仍然触发，可通过系统设置 schedule_misfire_grace_seconds 调整
+	defaultScheduleMisfireGrace = 5 * time.Minute
+	// maxCountedMissedFires 统计错过的触发次数的上限
+	maxCountedMissedFires = 10000
+)
+
+// ErrScheduleNotFound 定时运行不存在
+var ErrScheduleNotFound = errors.New("schedule not found")
+
+// schedulerOnce 保证调度器只启动一次
+var schedulerOnce sync.Once
+
+// ScheduleService 定时运行调度
+type ScheduleService struct {
+	deployTestService *DeployTestService
+	systemUtils       *SystemUtils
+}
+
+func NewScheduleService() *ScheduleService {
+	return &ScheduleService{
+		deployTestService: NewDeployTestService(),
+		systemUtils:       NewSystemUtils(),
+	}
+}
+
+// scheduleLocation 获取定时运行的时区，未配置时使用服务器时区
+func scheduleLocation(timezone string) (*time.Location, error) {
+	if timezone == "" {
+		return time.Local, nil
+	}
+	return time.LoadLocation(timezone)
+}
+
+// nextScheduleFire 计算 after 之后的下一次触发时间
+func nextScheduleFire(schedule *models.Schedule, after time.Time) (time.Time, error) {
+	cron, err := parseCron(schedule.CronExpression)
+	if err != nil {
+		return time.Time{}, err
+	}
+	loc, err := scheduleLocation(schedule.Timezone)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid timezone: %v", err)
+	}
+	next := cron.next(after, loc)
+	if next.IsZero() {
+		return time.Time{}, fmt.Errorf("cron expression %q never fires", schedule.CronExpression)
+	}
+	return next, nil
+}
+
+// ValidateSchedule 校验定时运行配置，引用的测试项、参数集和构建必须存在
+func ValidateSchedule(schedule *models.Schedule) error {
+	if strings.TrimSpace(schedule.Name) == "" {
+		return fmt.Errorf("name is required")
+	}
+	if _, err := nextScheduleFire(schedule, time.Now()); err != nil {
+		return err
+	}
+
+	testItemIDs, err := schedule.GetTestItemIDs()
+	if err != nil {
+		return fmt.Errorf("test_item_ids must be a list of IDs: %v", err)
+	}
+	testItemIDs = uniqueIDs(testItemIDs)
+	if len(testItemIDs) == 0 {
+		return fmt.Errorf("test_item_ids is required")
+	}
+	if len(testItemIDs) > MaxRunGroupSize {
+		return fmt.Errorf("schedule triggers %d runs, at most %d allowed", len(testItemIDs), MaxRunGroupSize)
+	}
+	var count int64
+	config.DB.Model(&models.TestItem{}).Where("id IN ?", testItemIDs).Count(&count)
+	if int(count) != len(testItemIDs) {
+		return fmt.Errorf("some test items do not exist")
+	}
+	if schedule.ParameterSetID != nil {
+		config.DB.Model(&models.ParameterSet{}).Where("id = ?", *schedule.ParameterSetID).Count(&count)
+		if count == 0 {
+			return fmt.Errorf("parameter set %d does not exist", *schedule.ParameterSetID)
+		}
+	}
+
+	switch schedule.BuildSelector {
+	case models.ScheduleBuildSelected, models.ScheduleBuildLatest:
+		if schedule.JobName == "" {
+			return fmt.Errorf("job_name is required for build_selector %s", schedule.BuildSelector)
+		}
+	case models.ScheduleBuildFixed:
+		if schedule.BuildInfoID == nil {
+			return fmt.Errorf("build_info_id is required for build_selector fixed")
+		}
+		config.DB.Model(&models.BuildInfo{}).Where("id = ?", *schedule.BuildInfoID).Count(&count)
+		if count == 0 {
+			return fmt.Errorf("build info %d does not exist", *schedule.BuildInfoID)
+		}
+	default:
+		return fmt.Errorf("build_selector must be one of selected, latest, fixed")
+	}
+
+	if schedule.Priority < 0 || schedule.Priority > MaxQueuePriority {
+		return fmt.Errorf("priority must be between 0 and %d", MaxQueuePriority)
+	}
+	return nil
+}
+
+// resolveScheduleBuild 按构建选择方式确定本次触发使用的构建
+func (s *ScheduleService) resolveScheduleBuild(schedule *models.Schedule) (*models.BuildInfo, error) {
+	buildService := s.deployTestService.buildService
+	switch schedule.BuildSelector {
+	case models.ScheduleBuildSelected:
+		var selection models.JobVersionSelection
+		if err := config.DB.Where("job_name = ?", schedule.JobName).First(&selection).Error; err != nil || selection.SelectedBuildID == nil {
+			return nil, fmt.Errorf("no build is selected for job %s", schedule.JobName)
+		}
+		build, err := buildService.GetBuildInfoByID(*selection.SelectedBuildID)
+		if err != nil {
+			return nil, fmt.Errorf("selected build %d of job %s not found", *selection.SelectedBuildID, schedule.JobName)
+		}
+		return build, nil
+	case models.ScheduleBuildLatest:
+		build, err := buildService.GetLatestBuildByJobName(schedule.JobName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get latest build of job %s: %v", schedule.JobName, err)
+		}
+		if build == nil {
+			return nil, fmt.Errorf("job %s has no builds", schedule.JobName)
+		}
+		return build, nil
+	case models.ScheduleBuildFixed:
+		if schedule.BuildInfoID == nil {
+			return nil, fmt.Errorf("build_info_id is not set")
+		}
+		build, err := buildService.GetBuildInfoByID(*schedule.BuildInfoID)
+		if err != nil {
+			return nil, fmt.Errorf("build info %d not found", *schedule.BuildInfoID)
+		}
+		return build, nil
+	default:
+		return nil, fmt.Errorf("unknown build selector: %s", schedule.BuildSelector)
+	}
+}
+
+// StartScheduler 启动定时运行调度器，每 30 秒触发到期的定时运行
+func (s *ScheduleService) StartScheduler() {
+	schedulerOnce.Do(func() {
+		go func() {
+			for {
+				s.tick(time.Now())
+				time.Sleep(scheduleTickInterval)
+			}
+		}()
+	})
+}
+
+// misfireGrace 读取超过计划时间后仍然触发的时长
+func (s *ScheduleService) misfireGrace() time.Duration {
+	if settings, err := s.systemUtils.GetSystemSettings(); err == nil {
+		if seconds, err := strconv.Atoi(settings["schedule_misfire_grace_seconds"]); err == nil && seconds >= 0 {
+			return time.Duration(seconds) * time.Second
+		}
+	}
+	return defaultScheduleMisfireGrace
+}
+
+// tick 触发所有到期的定时运行
+//
+// 超过计划时间 misfire grace 仍未触发的 (通常是服务停机期间) 不再补触发，记录为一次错过的触发。
+func (s *ScheduleService) tick(now time.Time) {
+	var schedules []models.Schedule
+	if err := config.DB.Where("paused = ? AND next_fire_at IS NOT NULL AND next_fire_at <= ?", false, now).
+		Order("next_fire_at ASC").Find(&schedules).Error; err != nil {
+		log.Printf("Failed to load due schedules: %v", err)
+		return
+	}
+	grace := s.misfireGrace()
+
+	for i := range schedules {
+		schedule := &schedules[i]
+		scheduledAt := *schedule.NextFireAt
+
+		next, err := nextScheduleFire(schedule, now)
+		var nextFireAt *time.Time
+		if err != nil {
+			log.Printf("Schedule %s has no next fire time: %v", schedule.Name, err)
+		} else {
+			nextFireAt = &next
+		}
+		// 先推进下一次触发时间，避免重复触发
+		result := config.DB.Model(&models.Schedule{}).
+			Where("id = ? AND next_fire_at = ?", schedule.ID, scheduledAt).
+			Update("next_fire_at", nextFireAt)
+		if result.Error != nil || result.RowsAffected == 0 {
+			continue
+		}
+
+		if now.Sub(scheduledAt) > grace {
+			s.recordMissedFires(schedule, scheduledAt, now)
+			continue
+		}
+		s.fire(schedule, scheduledAt, false, scheduleOwner(schedule))
+	}
+}
+
+// recordMissedFires 记录 scheduledAt 到 now 之间错过的触发
+func (s *ScheduleService) recordMissedFires(schedule *models.Schedule, scheduledAt, now time.Time) {
+	missed := 1
+	for t := scheduledAt; missed < maxCountedMissedFires; missed++ {
+		next, err := nextScheduleFire(schedule, t)
+		if err != nil || next.After(now) {
+			break
+		}
+		t = next
+	}
+
+	message := fmt.Sprintf("missed %d fire(s) since %s", missed, scheduledAt.Format(time.RFC3339))
+	fire := &models.ScheduleFire{
+		ScheduleID:  schedule.ID,
+		ScheduledAt: scheduledAt,
+		FiredAt:     now,
+		Status:      models.ScheduleFireMissed,
+		MissedCount: missed,
+		Message:     message,
+	}
+	if err := config.DB.Create(fire).Error; err != nil {
+		log.Printf("Failed to record missed fire of schedule %s: %v", schedule.Name, err)
+	}
+	config.DB.Model(&models.Schedule{}).Where("id = ?", schedule.ID).Update("last_error", message)
+	log.Printf("Schedule %s %s", schedule.Name, message)
+}
+
+// scheduleOwner 定时触发的运行归属: 配置的负责人，未配置时为创建人
+func scheduleOwner(schedule *models.Schedule) string {
+	if schedule.Owner != "" {
+		return schedule.Owner
+	}
+	return schedule.CreatedBy
+}
+
+// fire 按定时运行配置创建运行组，运行按阻塞模式设置排队，返回触发记录
+func (s *ScheduleService) fire(schedule *models.Schedule, scheduledAt time.Time, manual bool, triggeredBy string) *models.ScheduleFire {
+	now := time.Now()
+	fire := &models.ScheduleFire{
+		ScheduleID:  schedule.ID,
+		ScheduledAt: scheduledAt,
+		FiredAt:     now,
+		Manual:      manual,
+		TriggeredBy: triggeredBy,
+	}
+	updates := map[string]interface{}{"last_fire_at": &now}
+
+	if err := s.triggerFire(schedule, fire); err != nil {
+		fire.Status = models.ScheduleFireFailed
+		fire.Message = err.Error()
+		updates["last_error"] = err.Error()
+		log.Printf("Schedule %s failed to fire: %v", schedule.Name, err)
+	} else {
+		fire.Status = models.ScheduleFireTriggered
+		updates["last_run_group_id"] = *fire.RunGroupID
+		updates["last_error"] = ""
+		log.Printf("Schedule %s triggered run group %d for build %d on behalf of %s",
+			schedule.Name, *fire.RunGroupID, *fire.BuildInfoID, triggeredBy)
+	}
+
+	if err := config.DB.Create(fire).Error; err != nil {
+		log.Printf("Failed to record fire of schedule %s: %v", schedule.Name, err)
+	}
+	config.DB.Model(&models.Schedule{}).Where("id = ?", schedule.ID).Updates(updates)
+	return fire
+}
+
+// triggerFire 选择构建并触发运行组，结果写入触发记录
+func (s *ScheduleService) triggerFire(schedule *models.Schedule, fire *models.ScheduleFire) error {
+	build, err := s.resolveScheduleBuild(schedule)
+	if err != nil {
+		return err
+	}
+	fire.BuildInfoID = &build.ID
+
+	testItemIDs, err := schedule.GetTestItemIDs()
+	if err != nil {
+		return fmt.Errorf("invalid test_item_ids: %v", err)
+	}
+	var parameterSetIDs []uint
+	if schedule.ParameterSetID != nil {
+		parameterSetIDs = []uint{*schedule.ParameterSetID}
+	}
+
+	result, err := s.deployTestService.TriggerRunGroup(&RunGroupRequest{
+		BuildInfoID:         build.ID,
+		TestItemIDs:         testItemIDs,
+		ParameterSetIDs:     parameterSetIDs,
+		TriggeredBy:         fire.TriggeredBy,
+		Priority:            schedule.Priority,
+		NotificationEnabled: schedule.NotificationEnabled,
+		ScheduleID:          &schedule.ID,
+	})
+	if err != nil {
+		return err
+	}
+	fire.RunGroupID = &result.Group.ID
+	return nil
+}
+
+// RunNow 立即触发一次定时运行，不影响下一次计划触发，暂停的定时运行也可立即触发
+func (s *ScheduleService) RunNow(id uint, triggeredBy string) (*models.ScheduleFire, error) {
+	schedule, err := s.GetScheduleByID(id)
+	if err != nil {
+		return nil, err
+	}
+	return s.fire(schedule, time.Now(), true, triggeredBy), nil
+}
+
+// SetPaused 暂停或恢复定时运行，恢复时从当前时间计算下一次触发，暂停期间的触发不记为错过
+func (s *ScheduleService) SetPaused(id uint, paused bool) (*models.Schedule, error) {
+	schedule, err := s.GetScheduleByID(id)
+	if err != nil {
+		return nil, err
+	}
+	schedule.Paused = paused
+	nextFireAt, err := scheduleNextFireAt(schedule)
+	if err != nil {
+		return nil, err
+	}
+	// 只更新暂停状态和下一次触发时间，不覆盖调度器同时写入的触发结果
+	if err := config.DB.Model(&models.Schedule{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"paused":       paused,
+		"next_fire_at": nextFireAt,
+	}).Error; err != nil {
+		return nil, err
+	}
+	log.Printf("Schedule %s paused: %v", schedule.Name, paused)
+	return s.GetScheduleByID(id)
+}
+
+// GetSchedules 获取所有定时运行
+func (s *ScheduleService) GetSchedules() ([]models.Schedule, error) {
+	var schedules []models.Schedule
+	err := config.DB.Order("name ASC").Find(&schedules).Error
+	return schedules, err
+}
+
+// GetScheduleByID 获取定时运行
+func (s *ScheduleService) GetScheduleByID(id uint) (*models.Schedule, error) {
+	var schedule models.Schedule
+	if err := config.DB.First(&schedule, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrScheduleNotFound
+		}
+		return nil, err
+	}
+	return &schedule, nil
+}
+
+// scheduleNextFireAt 从当前时间计算下一次触发，暂停的定时运行为空
+func scheduleNextFireAt(schedule *models.Schedule) (*time.Time, error) {
+	if schedule.Paused {
+		return nil, nil
+	}
+	next, err := nextScheduleFire(schedule, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return &next, nil
+}
+
+// scheduleConfigColumns 管理员可修改的定时运行字段，最近一次触发结果由调度器维护
+var scheduleConfigColumns = []string{
+	"name", "cron_expression", "timezone", "test_item_ids", "parameter_set_id", "priority",
+	"notification_enabled", "build_selector", "job_name", "build_info_id", "owner", "paused",
+	"next_fire_at", "updated_at",
+}
+
+// SaveSchedule 保存定时运行的配置，并从当前时间重新计算下一次触发
+func (s *ScheduleService) SaveSchedule(schedule *models.Schedule) error {
+	nextFireAt, err := scheduleNextFireAt(schedule)
+	if err != nil {
+		return err
+	}
+	schedule.NextFireAt = nextFireAt
+
+	if schedule.ID == 0 {
+		return config.DB.Create(schedule).Error
+	}
+	result := config.DB.Model(&models.Schedule{}).Where("id = ?", schedule.ID).
+		Select(scheduleConfigColumns).Updates(schedule)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrScheduleNotFound
+	}
+	return config.DB.First(schedule, schedule.ID).Error
+}
+
+// DeleteSchedule 删除定时运行及其触发记录，已触发的运行组不受影响
+func (s *ScheduleService) DeleteSchedule(id uint) error {
+	return config.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Delete(&models.Schedule{}, id)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrScheduleNotFound
+		}
+		return tx.Where("schedule_id = ?", id).Delete(&models.ScheduleFire{}).Error
+	})
+}
+
+// GetScheduleFires 获取定时运行的触发记录，按触发时间倒序
+func (s *ScheduleService) GetScheduleFires(id uint, limit, offset int) ([]models.ScheduleFire, int64, error) {
+	if _, err := s.GetScheduleByID(id); err != nil {
+		return nil, 0, err
+	}
+
+	var fires []models.ScheduleFire
+	var total int64
+	query := config.DB.Model(&models.ScheduleFire{}).Where("schedule_id = ?", id)
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err := query.Order("fired_at DESC, id DESC").Limit(limit).Offset(offset).Find(&fires).Error
+	return fires, total, err
+}
@@ -1,8 +1,12 @@
 package services
 
 import (
+	"errors"
+
 	"crat/config"
 	"crat/models"
+
+	"gorm.io/gorm"
 )
 
 // SystemUtils 提供系统相关的工具方法
@@ -28,3 +32,23 @@ func (s *SystemUtils) GetSystemSettings() (map[string]string, error) {
 
 	return result, nil
 }
+
+// SetSystemSetting 写入系统设置，不存在时创建
+func (s *SystemUtils) SetSystemSetting(key, value, description string) error {
+	var setting models.SystemSetting
+	err := config.DB.Where("key = ?", key).First(&setting).Error
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		setting = models.SystemSetting{
+			Key:         key,
+			Value:       value,
+			Description: description,
+		}
+		return config.DB.Create(&setting).Error
+	}
+
+	setting.Value = value
+	return config.DB.Save(&setting).Error
+}
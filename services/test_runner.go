@@ -0,0 +1,149 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"crat/models"
+)
+
+// ErrRunnerOperationUnsupported 执行后端不支持该操作
+var ErrRunnerOperationUnsupported = errors.New("operation not supported by test runner")
+
+// 外部测试任务的状态
+const (
+	TaskStatePending   = "pending"
+	TaskStateRunning   = "running"
+	TaskStateCompleted = "completed"
+	TaskStateFailed    = "failed"
+)
+
+// TestRunner 外部测试执行后端，负责提交测试任务并查询其状态
+type TestRunner interface {
+	// Type 返回后端类型
+	Type() string
+	// Submit 把包和测试参数提交给测试服务器，返回任务ID
+	Submit(ctx context.Context, sub *TestSubmission) (*SubmitResult, error)
+	// Status 查询任务状态
+	Status(ctx context.Context, task TestTask) (*TaskStatus, error)
+	// Cancel 终止任务
+	Cancel(ctx context.Context, task TestTask) error
+	// Logs 获取任务从 offset 开始的日志
+	Logs(ctx context.Context, task TestTask, offset int64) (*TaskLogs, error)
+}
+
+// TestSubmission 提交测试任务所需的信息
+type TestSubmission struct {
+	Run          *models.DeployTestRun
+	Params       *models.TestParameters
+	ServerURL    string // 测试服务器地址，以 / 结尾
+	TransferMode string // 包传递方式
+	Settings     map[string]string
+	Stage        string // 测试阶段名称，为空时提交部署任务
+}
+
+// requestFields 提交请求中的测试参数，包相关的字段按传递方式另行添加
+//
+// 配置了 crat_public_base_url 时附带回调地址和回调令牌，提交测试阶段时附带阶段名称和 skip_deploy。
+func (sub *TestSubmission) requestFields() map[string]interface{} {
+	fields := map[string]interface{}{
+		"service_name":   sub.Params.ServiceName,
+		"install_dir":    sub.Params.InstallDir,
+		"upgrade_type":   sub.Params.UpgradeType,
+		"test_path":      sub.Params.TestPath,
+		"base_url":       sub.Params.BaseURL,
+		"report_keyword": sub.Params.ReportKeyword,
+	}
+	if url := callbackURL(sub.Run.ID, sub.Settings); url != "" {
+		fields["callback_url"] = url
+		fields["callback_token"] = callbackToken(sub.Run.ID)
+	}
+	// 测试阶段在已部署的环境上执行
+	if sub.Stage != "" {
+		fields["stage"] = sub.Stage
+		fields["skip_deploy"] = true
+	}
+	return fields
+}
+
+// SubmitResult 提交结果
+type SubmitResult struct {
+	TaskID  string
+	Details string // 写入步骤详情的补充说明，例如包的传递方式
+}
+
+// TestTask 测试服务器上的任务
+type TestTask struct {
+	ServerURL      string // 测试服务器地址，以 / 结尾
+	TaskID         string
+	TimeoutSeconds int // 单次请求超时，0 时使用默认值
+}
+
+// TaskStatus 任务状态
+type TaskStatus struct {
+	State     string          // pending、running、completed、failed，其他值视为未知状态继续等待
+	ReportURL string          // 完成时的测试报告地址
+	Error     string          // 失败原因
+	Summary   json.RawMessage // 测试结果摘要，例如通过和失败的用例数
+	Raw       json.RawMessage // 测试服务器返回的原始数据
+}
+
+// TaskLogs 任务日志片段
+type TaskLogs struct {
+	Content    string
+	NextOffset int64 // 下次请求使用的 offset
+	Complete   bool  // 任务已结束且日志已全部返回
+}
+
+// newTestRunner 根据执行后端配置创建后端实例，client 为 nil 时只校验配置
+func newTestRunner(runnerConfig *models.TestRunnerConfig, client *HTTPClient) (TestRunner, error) {
+	switch runnerConfig.Type {
+	case models.TestRunnerHTTP:
+		return &httpTestRunner{client: client}, nil
+	case models.TestRunnerREST:
+		return newRESTTestRunner(runnerConfig.Config, client)
+	case models.TestRunnerFake:
+		return newFakeTestRunner(runnerConfig.Config)
+	}
+	return nil, fmt.Errorf("unknown test runner type: %s", runnerConfig.Type)
+}
+
+// ValidateTestRunner 校验执行后端配置，供创建和更新测试项时使用
+func ValidateTestRunner(raw json.RawMessage) error {
+	testItem := models.TestItem{TestRunner: raw}
+	runnerConfig, err := testItem.GetTestRunner()
+	if err != nil {
+		return fmt.Errorf("invalid test runner format: %v", err)
+	}
+	_, err = newTestRunner(runnerConfig, nil)
+	return err
+}
+
+// testRunnerFor 获取测试项使用的执行后端
+func (s *DeployTestService) testRunnerFor(testItem *models.TestItem) (TestRunner, error) {
+	runnerConfig, err := testItem.GetTestRunner()
+	if err != nil {
+		return nil, fmt.Errorf("invalid test runner format: %v", err)
+	}
+	return newTestRunner(runnerConfig, s.httpClient)
+}
+
+// testServerURL 获取外部测试服务器地址，以 / 结尾
+func testServerURL(settings map[string]string) string {
+	serverURL := settings["external_test_server_url"]
+	if !strings.HasSuffix(serverURL, "/") {
+		serverURL += "/"
+	}
+	return serverURL
+}
+
+// runnerURL 拼接测试服务器地址和接口路径，路径为完整URL时直接使用
+func runnerURL(serverURL, path string) string {
+	if strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://") {
+		return path
+	}
+	return serverURL + strings.TrimPrefix(path, "/")
+}
@@ -0,0 +1,178 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"crat/models"
+)
+
+// fakeRunnerConfig 模拟后端配置
+type fakeRunnerConfig struct {
+	Outcome         string `json:"outcome,omitempty"`          // completed (默认) 或 failed
+	DurationSeconds int    `json:"duration_seconds,omitempty"` // 任务从提交到结束的时长
+	ReportURL       string `json:"report_url,omitempty"`       // 完成时返回的报告地址
+	Error           string `json:"error,omitempty"`            // 失败时返回的错误信息
+
+	Summary    json.RawMessage `json:"summary,omitempty"`     // 完成时返回的结果摘要
+	FailStages []string        `json:"fail_stages,omitempty"` // 这些测试阶段的结果为 failed，用于调试阶段失败处理
+}
+
+// fakeTask 模拟的测试任务
+type fakeTask struct {
+	config      fakeRunnerConfig
+	submittedAt time.Time
+	serviceName string
+	stage       string
+	cancelled   bool
+}
+
+// fakeTasks 模拟任务保存在内存中，所有 DeployTestService 实例共享，服务重启后丢失
+var (
+	fakeTasks      = make(map[string]*fakeTask)
+	fakeTaskSeq    int
+	fakeTasksMutex sync.Mutex
+)
+
+// fakeTestRunner 不连接测试服务器，在内存中模拟任务执行，用于调试流水线和通知
+type fakeTestRunner struct {
+	config fakeRunnerConfig
+}
+
+func newFakeTestRunner(raw json.RawMessage) (TestRunner, error) {
+	var cfg fakeRunnerConfig
+	if len(raw) > 0 && string(raw) != "null" {
+		if err := json.Unmarshal(raw, &cfg); err != nil {
+			return nil, fmt.Errorf("invalid fake test runner config: %v", err)
+		}
+	}
+	if cfg.Outcome == "" {
+		cfg.Outcome = TaskStateCompleted
+	}
+	if cfg.Outcome != TaskStateCompleted && cfg.Outcome != TaskStateFailed {
+		return nil, fmt.Errorf("fake test runner outcome must be completed or failed")
+	}
+	if cfg.DurationSeconds < 0 {
+		return nil, fmt.Errorf("fake test runner duration_seconds must not be negative")
+	}
+	return &fakeTestRunner{config: cfg}, nil
+}
+
+func (r *fakeTestRunner) Type() string { return models.TestRunnerFake }
+
+func (r *fakeTestRunner) Submit(ctx context.Context, sub *TestSubmission) (*SubmitResult, error) {
+	fakeTasksMutex.Lock()
+	defer fakeTasksMutex.Unlock()
+
+	// 清理一天前提交的任务
+	for id, t := range fakeTasks {
+		if time.Since(t.submittedAt) > 24*time.Hour {
+			delete(fakeTasks, id)
+		}
+	}
+
+	fakeTaskSeq++
+	taskID := fmt.Sprintf("fake-%d-%d", sub.Run.ID, fakeTaskSeq)
+	cfg := r.config
+	for _, stage := range cfg.FailStages {
+		if sub.Stage != "" && stage == sub.Stage {
+			cfg.Outcome = TaskStateFailed
+		}
+	}
+	fakeTasks[taskID] = &fakeTask{
+		config:      cfg,
+		submittedAt: time.Now(),
+		serviceName: sub.Params.ServiceName,
+		stage:       sub.Stage,
+	}
+	return &SubmitResult{TaskID: taskID, Details: "simulated by fake test runner"}, nil
+}
+
+// state 获取任务当前状态，调用方需持有 fakeTasksMutex
+func (t *fakeTask) state() string {
+	switch {
+	case t.cancelled:
+		return TaskStateFailed
+	case time.Since(t.submittedAt) < time.Duration(t.config.DurationSeconds)*time.Second:
+		return TaskStateRunning
+	}
+	return t.config.Outcome
+}
+
+// logs 根据任务状态生成的日志，只会在末尾追加内容
+func (t *fakeTask) logs(taskID string) string {
+	var b strings.Builder
+	if t.stage != "" {
+		fmt.Fprintf(&b, "[fake] task %s submitted for %s, stage %s\n", taskID, t.serviceName, t.stage)
+	} else {
+		fmt.Fprintf(&b, "[fake] task %s submitted for %s\n", taskID, t.serviceName)
+	}
+	fmt.Fprintf(&b, "[fake] task %s running\n", taskID)
+	switch state := t.state(); {
+	case t.cancelled:
+		fmt.Fprintf(&b, "[fake] task %s cancelled\n", taskID)
+	case state == TaskStateFailed:
+		fmt.Fprintf(&b, "[fake] task %s failed: %s\n", taskID, t.config.Error)
+	case state == TaskStateCompleted:
+		fmt.Fprintf(&b, "[fake] task %s completed\n", taskID)
+	}
+	return b.String()
+}
+
+func (r *fakeTestRunner) Status(ctx context.Context, task TestTask) (*TaskStatus, error) {
+	fakeTasksMutex.Lock()
+	defer fakeTasksMutex.Unlock()
+
+	t, ok := fakeTasks[task.TaskID]
+	if !ok {
+		return nil, fmt.Errorf("unknown fake task: %s", task.TaskID)
+	}
+	status := &TaskStatus{State: t.state()}
+	switch {
+	case t.cancelled:
+		status.Error = "task cancelled"
+	case status.State == TaskStateCompleted:
+		status.ReportURL = t.config.ReportURL
+		status.Summary = t.config.Summary
+	case status.State == TaskStateFailed:
+		status.Error = t.config.Error
+	}
+	status.Raw, _ = json.Marshal(map[string]interface{}{
+		"status": status.State,
+		"error":  status.Error,
+		"result": map[string]interface{}{"test": map[string]interface{}{"report_url": status.ReportURL, "summary": status.Summary}},
+	})
+	return status, nil
+}
+
+func (r *fakeTestRunner) Cancel(ctx context.Context, task TestTask) error {
+	fakeTasksMutex.Lock()
+	defer fakeTasksMutex.Unlock()
+
+	t, ok := fakeTasks[task.TaskID]
+	if !ok {
+		return fmt.Errorf("unknown fake task: %s", task.TaskID)
+	}
+	t.cancelled = true
+	return nil
+}
+
+func (r *fakeTestRunner) Logs(ctx context.Context, task TestTask, offset int64) (*TaskLogs, error) {
+	fakeTasksMutex.Lock()
+	defer fakeTasksMutex.Unlock()
+
+	t, ok := fakeTasks[task.TaskID]
+	if !ok {
+		return nil, fmt.Errorf("unknown fake task: %s", task.TaskID)
+	}
+	content := t.logs(task.TaskID)
+	logs := &TaskLogs{NextOffset: int64(len(content)), Complete: t.state() != TaskStateRunning}
+	if offset < int64(len(content)) {
+		logs.Content = content[offset:]
+	}
+	return logs, nil
+}
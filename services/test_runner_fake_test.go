@@ -0,0 +1,144 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"crat/models"
+)
+
+func TestNewFakeTestRunner(t *testing.T) {
+	tests := []struct {
+		name        string
+		raw         string
+		wantOutcome string
+		wantErr     bool
+	}{
+		{"empty", "", TaskStateCompleted, false},
+		{"null", "null", TaskStateCompleted, false},
+		{"failed outcome", `{"outcome":"failed","error":"boom"}`, TaskStateFailed, false},
+		{"unknown outcome", `{"outcome":"running"}`, "", true},
+		{"negative duration", `{"duration_seconds":-1}`, "", true},
+		{"invalid json", `{"outcome":`, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner, err := newFakeTestRunner(json.RawMessage(tt.raw))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newFakeTestRunner() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && runner.(*fakeTestRunner).config.Outcome != tt.wantOutcome {
+				t.Errorf("outcome = %s, want %s", runner.(*fakeTestRunner).config.Outcome, tt.wantOutcome)
+			}
+		})
+	}
+}
+
+func TestFakeTestRunner(t *testing.T) {
+	ctx := context.Background()
+	submit := func(t *testing.T, raw, stage string) (TestRunner, string) {
+		t.Helper()
+		runner, err := newFakeTestRunner(json.RawMessage(raw))
+		if err != nil {
+			t.Fatalf("newFakeTestRunner: %v", err)
+		}
+		result, err := runner.Submit(ctx, &TestSubmission{
+			Run:    &models.DeployTestRun{ID: 3},
+			Params: &models.TestParameters{ServiceName: "app"},
+			Stage:  stage,
+		})
+		if err != nil {
+			t.Fatalf("Submit: %v", err)
+		}
+		return runner, result.TaskID
+	}
+	status := func(t *testing.T, runner TestRunner, taskID string) *TaskStatus {
+		t.Helper()
+		status, err := runner.Status(ctx, TestTask{TaskID: taskID})
+		if err != nil {
+			t.Fatalf("Status: %v", err)
+		}
+		return status
+	}
+
+	t.Run("completed", func(t *testing.T) {
+		runner, taskID := submit(t, `{"report_url":"http://reports/1","summary":{"passed":3}}`, "")
+		got := status(t, runner, taskID)
+		if got.State != TaskStateCompleted || got.ReportURL != "http://reports/1" || string(got.Summary) != `{"passed":3}` {
+			t.Errorf("status = %+v, want completed with report and summary", got)
+		}
+	})
+
+	t.Run("failed", func(t *testing.T) {
+		runner, taskID := submit(t, `{"outcome":"failed","error":"assertion failed"}`, "")
+		if got := status(t, runner, taskID); got.State != TaskStateFailed || got.Error != "assertion failed" {
+			t.Errorf("status = %+v, want failed with error", got)
+		}
+	})
+
+	t.Run("fail stages", func(t *testing.T) {
+		runner, smokeID := submit(t, `{"fail_stages":["smoke"]}`, "smoke")
+		if got := status(t, runner, smokeID); got.State != TaskStateFailed {
+			t.Errorf("stage smoke state = %s, want failed", got.State)
+		}
+		_, apiID := submit(t, `{"fail_stages":["smoke"]}`, "api")
+		if got := status(t, runner, apiID); got.State != TaskStateCompleted {
+			t.Errorf("stage api state = %s, want completed", got.State)
+		}
+	})
+
+	t.Run("running until cancelled", func(t *testing.T) {
+		runner, taskID := submit(t, `{"duration_seconds":3600}`, "")
+		if got := status(t, runner, taskID); got.State != TaskStateRunning {
+			t.Fatalf("state = %s, want running", got.State)
+		}
+		logs, err := runner.Logs(ctx, TestTask{TaskID: taskID}, 0)
+		if err != nil {
+			t.Fatalf("Logs: %v", err)
+		}
+		if logs.Complete {
+			t.Error("logs complete while task is running")
+		}
+
+		if err := runner.Cancel(ctx, TestTask{TaskID: taskID}); err != nil {
+			t.Fatalf("Cancel: %v", err)
+		}
+		if got := status(t, runner, taskID); got.State != TaskStateFailed || got.Error != "task cancelled" {
+			t.Errorf("status = %+v, want failed with task cancelled", got)
+		}
+
+		// 日志只在末尾追加，从上次的偏移继续读取
+		more, err := runner.Logs(ctx, TestTask{TaskID: taskID}, logs.NextOffset)
+		if err != nil {
+			t.Fatalf("Logs: %v", err)
+		}
+		if !more.Complete || !strings.Contains(more.Content, "cancelled") || strings.Contains(more.Content, "running") {
+			t.Errorf("logs after offset = %+v, want only the cancellation line", more)
+		}
+	})
+
+	t.Run("unknown task", func(t *testing.T) {
+		runner, _ := submit(t, "", "")
+		if _, err := runner.Status(ctx, TestTask{TaskID: "fake-0-0"}); err == nil {
+			t.Error("Status of unknown task succeeded")
+		}
+		if err := runner.Cancel(ctx, TestTask{TaskID: "fake-0-0"}); err == nil {
+			t.Error("Cancel of unknown task succeeded")
+		}
+	})
+
+	t.Run("expired tasks are removed", func(t *testing.T) {
+		runner, oldID := submit(t, "", "")
+		fakeTasksMutex.Lock()
+		fakeTasks[oldID].submittedAt = time.Now().Add(-25 * time.Hour)
+		fakeTasksMutex.Unlock()
+
+		submit(t, "", "")
+		if _, err := runner.Status(ctx, TestTask{TaskID: oldID}); err == nil {
+			t.Error("task submitted a day ago is still known")
+		}
+	})
+}
@@ -0,0 +1,138 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+
+	"crat/models"
+)
+
+// httpTestRunner 测试服务器的默认协议
+//
+// 提交: POST api/deploy_and_test，响应中的 task_id 为任务ID
+// 状态: GET api/tasks/{task_id}，读取 status、result.test.report_url、result.test.summary 和 error
+// 终止: POST api/tasks/{task_id}/cancel
+// 日志: GET api/tasks/{task_id}/logs?offset=N，响应为 {"content": "...", "next_offset": N, "complete": false}
+type httpTestRunner struct {
+	client *HTTPClient
+}
+
+func (r *httpTestRunner) Type() string { return models.TestRunnerHTTP }
+
+func (r *httpTestRunner) Submit(ctx context.Context, sub *TestSubmission) (*SubmitResult, error) {
+	requestURL := sub.ServerURL + "api/deploy_and_test"
+	transferMode := sub.TransferMode
+
+	log.Printf("Sending test request for run ID %d:", sub.Run.ID)
+	log.Printf("  service_name: %s", sub.Params.ServiceName)
+	log.Printf("  package_path: %s", sub.Run.DownloadPath)
+	log.Printf("  transfer_mode: %s", transferMode)
+	log.Printf("  install_dir: %s", sub.Params.InstallDir)
+
+	response, transferDetails, err := sendTestRequest(ctx, r.client, sub.Run, requestURL, transferMode, sub.requestFields(), sub.Settings)
+	if err != nil && response == nil {
+		return nil, fmt.Errorf("failed to send package (%s): %v", transferMode, err)
+	}
+	if err != nil {
+		return nil, classifyError(models.FailureClassTestServerUnavailable, fmt.Errorf("HTTP request failed: %v", err))
+	}
+	if err := checkRunnerResponse(response); err != nil {
+		return nil, err
+	}
+
+	var respData map[string]interface{}
+	if err := json.Unmarshal([]byte(response.Body), &respData); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %v", err)
+	}
+	taskID, ok := respData["task_id"].(string)
+	if !ok {
+		return nil, fmt.Errorf("no task_id in response")
+	}
+	return &SubmitResult{TaskID: taskID, Details: "package sent via " + transferDetails}, nil
+}
+
+func (r *httpTestRunner) Status(ctx context.Context, task TestTask) (*TaskStatus, error) {
+	statusURL := fmt.Sprintf("%sapi/tasks/%s", task.ServerURL, url.PathEscape(task.TaskID))
+	response, err := r.client.SendRequestWithContext(ctx, "GET", statusURL, nil, nil, task.TimeoutSeconds)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != 200 {
+		return nil, fmt.Errorf("unexpected status code when querying task: %d", response.StatusCode)
+	}
+
+	var taskStatus map[string]interface{}
+	if err := json.Unmarshal([]byte(response.Body), &taskStatus); err != nil {
+		return nil, fmt.Errorf("failed to parse task status response: %v", err)
+	}
+
+	status := &TaskStatus{Raw: json.RawMessage(response.Body)}
+	status.State, _ = taskStatus["status"].(string)
+	if result, ok := taskStatus["result"].(map[string]interface{}); ok {
+		if testResult, ok := result["test"].(map[string]interface{}); ok {
+			if reportURL, ok := testResult["report_url"].(string); ok && reportURL != "None" {
+				status.ReportURL = reportURL
+			}
+			if summary, ok := testResult["summary"]; ok && summary != nil {
+				status.Summary, _ = json.Marshal(summary)
+			}
+		}
+	}
+	status.Error, _ = taskStatus["error"].(string)
+	return status, nil
+}
+
+func (r *httpTestRunner) Cancel(ctx context.Context, task TestTask) error {
+	cancelURL := fmt.Sprintf("%sapi/tasks/%s/cancel", task.ServerURL, url.PathEscape(task.TaskID))
+	response, err := r.client.SendRequestWithContext(ctx, "POST", cancelURL, nil, nil, task.TimeoutSeconds)
+	if err != nil {
+		return err
+	}
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code: %d, body: %s", response.StatusCode, response.Body)
+	}
+	return nil
+}
+
+func (r *httpTestRunner) Logs(ctx context.Context, task TestTask, offset int64) (*TaskLogs, error) {
+	logsURL := fmt.Sprintf("%sapi/tasks/%s/logs?offset=%d", task.ServerURL, url.PathEscape(task.TaskID), offset)
+	response, err := r.client.SendRequestWithContext(ctx, "GET", logsURL, nil, nil, task.TimeoutSeconds)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode == 404 || response.StatusCode == 501 {
+		return nil, ErrRunnerOperationUnsupported
+	}
+	if response.StatusCode != 200 {
+		return nil, fmt.Errorf("unexpected status code: %d, body: %s", response.StatusCode, response.Body)
+	}
+
+	var logs struct {
+		Content    string `json:"content"`
+		NextOffset *int64 `json:"next_offset"`
+		Complete   bool   `json:"complete"`
+	}
+	if err := json.Unmarshal([]byte(response.Body), &logs); err != nil {
+		return nil, fmt.Errorf("failed to parse logs response: %v", err)
+	}
+	result := &TaskLogs{Content: logs.Content, NextOffset: offset + int64(len(logs.Content)), Complete: logs.Complete}
+	if logs.NextOffset != nil {
+		result.NextOffset = *logs.NextOffset
+	}
+	return result, nil
+}
+
+// checkRunnerResponse 检查提交请求的响应状态码，5xx 按测试服务器错误分类
+func checkRunnerResponse(response *HTTPResponse) error {
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		return nil
+	}
+	err := fmt.Errorf("HTTP status code: %d, body: %s", response.StatusCode, response.Body)
+	if response.StatusCode >= 500 {
+		return classifyError(models.FailureClassTestServer5xx, err)
+	}
+	return err
+}
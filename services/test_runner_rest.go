@@ -0,0 +1,305 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"crat/models"
+)
+
+// restEndpoint REST 后端的一个接口，path 可以是相对测试服务器地址的路径或完整URL
+//
+// path 中的 {task_id} 和 {offset} 会被替换。
+type restEndpoint struct {
+	Method string `json:"method,omitempty"`
+	Path   string `json:"path"`
+}
+
+// restRunnerConfig REST 后端配置，字段路径以 . 分隔，数组元素使用下标，例如 data.items.0.id
+type restRunnerConfig struct {
+	Headers map[string]string `json:"headers,omitempty"` // 每个请求附加的请求头，例如 Authorization
+
+	Submit restEndpoint  `json:"submit"`           // 默认 POST
+	Status restEndpoint  `json:"status"`           // 默认 GET
+	Cancel *restEndpoint `json:"cancel,omitempty"` // 为空时不支持终止，默认 POST
+	Logs   *restEndpoint `json:"logs,omitempty"`   // 为空时不支持日志，默认 GET
+
+	// BodyFields 提交请求体的字段路径 → 参数名，参数名为 service_name、install_dir、upgrade_type、test_path、
+	// base_url、report_keyword、package_path、package_url、package_name、package_sha256、run_id、
+	// callback_url、callback_token、stage、skip_deploy 之一。
+	// 为空时按默认协议发送各参数。
+	BodyFields map[string]string `json:"body_fields,omitempty"`
+
+	TaskIDPath        string            `json:"task_id_path,omitempty"`         // 默认 task_id
+	StatePath         string            `json:"state_path,omitempty"`           // 默认 status
+	StateMap          map[string]string `json:"state_map,omitempty"`            // 外部状态 → pending/running/completed/failed，未列出的状态原样使用
+	ReportURLPath     string            `json:"report_url_path,omitempty"`      // 默认 report_url
+	ErrorPath         string            `json:"error_path,omitempty"`           // 默认 error
+	SummaryPath       string            `json:"summary_path,omitempty"`         // 默认 summary
+	LogContentPath    string            `json:"log_content_path,omitempty"`     // 默认 content，为 . 时整个响应体即为日志
+	LogNextOffsetPath string            `json:"log_next_offset_path,omitempty"` // 默认 next_offset，缺失时按内容长度推算
+	LogCompletePath   string            `json:"log_complete_path,omitempty"`    // 默认 complete
+}
+
+// optionalBodyFields 只在部分提交请求中出现的参数
+var optionalBodyFields = map[string]bool{
+	"callback_url":   true,
+	"callback_token": true,
+	"stage":          true,
+	"skip_deploy":    true,
+}
+
+// restTestRunner 通过字段路径映射对接任意 REST 测试服务
+type restTestRunner struct {
+	client *HTTPClient
+	config restRunnerConfig
+}
+
+func newRESTTestRunner(raw json.RawMessage, client *HTTPClient) (TestRunner, error) {
+	var cfg restRunnerConfig
+	if len(raw) > 0 && string(raw) != "null" {
+		if err := json.Unmarshal(raw, &cfg); err != nil {
+			return nil, fmt.Errorf("invalid rest test runner config: %v", err)
+		}
+	}
+	if cfg.Submit.Path == "" || cfg.Status.Path == "" {
+		return nil, fmt.Errorf("rest test runner requires submit.path and status.path")
+	}
+
+	setDefault := func(value *string, fallback string) {
+		if *value == "" {
+			*value = fallback
+		}
+	}
+	setDefault(&cfg.Submit.Method, "POST")
+	setDefault(&cfg.Status.Method, "GET")
+	if cfg.Cancel != nil {
+		setDefault(&cfg.Cancel.Method, "POST")
+	}
+	if cfg.Logs != nil {
+		setDefault(&cfg.Logs.Method, "GET")
+	}
+	setDefault(&cfg.TaskIDPath, "task_id")
+	setDefault(&cfg.StatePath, "status")
+	setDefault(&cfg.ReportURLPath, "report_url")
+	setDefault(&cfg.ErrorPath, "error")
+	setDefault(&cfg.SummaryPath, "summary")
+	setDefault(&cfg.LogContentPath, "content")
+	setDefault(&cfg.LogNextOffsetPath, "next_offset")
+	setDefault(&cfg.LogCompletePath, "complete")
+
+	for _, state := range cfg.StateMap {
+		switch state {
+		case TaskStatePending, TaskStateRunning, TaskStateCompleted, TaskStateFailed:
+		default:
+			return nil, fmt.Errorf("invalid state_map value %q, must be pending, running, completed or failed", state)
+		}
+	}
+	return &restTestRunner{client: client, config: cfg}, nil
+}
+
+func (r *restTestRunner) Type() string { return models.TestRunnerREST }
+
+// endpointURL 获取接口地址，替换路径中的占位符
+func (r *restTestRunner) endpointURL(serverURL string, endpoint restEndpoint, taskID string, offset int64) string {
+	path := strings.ReplaceAll(endpoint.Path, "{task_id}", url.PathEscape(taskID))
+	path = strings.ReplaceAll(path, "{offset}", strconv.FormatInt(offset, 10))
+	return runnerURL(serverURL, path)
+}
+
+// request 发送请求并解析 JSON 响应，响应不是 JSON 时 data 为 nil
+func (r *restTestRunner) request(ctx context.Context, endpoint restEndpoint, requestURL string, body interface{}, timeoutSeconds int) (*HTTPResponse, interface{}, error) {
+	response, err := r.client.SendRequestWithContext(ctx, endpoint.Method, requestURL, r.config.Headers, body, timeoutSeconds)
+	if err != nil {
+		return response, nil, err
+	}
+	var data interface{}
+	if json.Unmarshal([]byte(response.Body), &data) != nil {
+		data = nil
+	}
+	return response, data, nil
+}
+
+func (r *restTestRunner) Submit(ctx context.Context, sub *TestSubmission) (*SubmitResult, error) {
+	transferMode := sub.TransferMode
+	if transferMode == TransferModeUpload {
+		return nil, fmt.Errorf("rest test runner does not support the upload transfer mode")
+	}
+	packageFields, transferDetails, err := packageRequestFields(sub.Run, transferMode, sub.Settings)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send package (%s): %v", transferMode, err)
+	}
+
+	values := sub.requestFields()
+	for key, value := range packageFields {
+		values[key] = value
+	}
+	values["run_id"] = sub.Run.ID
+
+	var body interface{} = values
+	if len(r.config.BodyFields) > 0 {
+		mapped := make(map[string]interface{})
+		for fieldPath, name := range r.config.BodyFields {
+			value, ok := values[name]
+			if !ok && optionalBodyFields[name] {
+				// 未配置 crat_public_base_url 时不发送回调字段，提交部署任务时不发送阶段字段
+				continue
+			}
+			if !ok {
+				return nil, fmt.Errorf("unknown body field source %q for %s", name, fieldPath)
+			}
+			setFieldPath(mapped, fieldPath, value)
+		}
+		body = mapped
+	}
+
+	response, data, err := r.request(ctx, r.config.Submit, r.endpointURL(sub.ServerURL, r.config.Submit, "", 0), body, 300)
+	if err != nil {
+		return nil, classifyError(models.FailureClassTestServerUnavailable, fmt.Errorf("HTTP request failed: %v", err))
+	}
+	if err := checkRunnerResponse(response); err != nil {
+		return nil, err
+	}
+
+	taskID := fieldPathString(data, r.config.TaskIDPath)
+	if taskID == "" {
+		return nil, fmt.Errorf("no %s in response", r.config.TaskIDPath)
+	}
+	return &SubmitResult{TaskID: taskID, Details: "package sent via " + transferDetails}, nil
+}
+
+func (r *restTestRunner) Status(ctx context.Context, task TestTask) (*TaskStatus, error) {
+	response, data, err := r.request(ctx, r.config.Status, r.endpointURL(task.ServerURL, r.config.Status, task.TaskID, 0), nil, task.TimeoutSeconds)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != 200 {
+		return nil, fmt.Errorf("unexpected status code when querying task: %d", response.StatusCode)
+	}
+	if data == nil {
+		return nil, fmt.Errorf("failed to parse task status response")
+	}
+
+	state := fieldPathString(data, r.config.StatePath)
+	if mapped, ok := r.config.StateMap[state]; ok {
+		state = mapped
+	}
+	reportURL := fieldPathString(data, r.config.ReportURLPath)
+	if reportURL == "None" {
+		reportURL = ""
+	}
+	status := &TaskStatus{
+		State:     state,
+		ReportURL: reportURL,
+		Error:     fieldPathString(data, r.config.ErrorPath),
+		Raw:       json.RawMessage(response.Body),
+	}
+	if summary, ok := lookupFieldPath(data, r.config.SummaryPath); ok && summary != nil {
+		status.Summary, _ = json.Marshal(summary)
+	}
+	return status, nil
+}
+
+func (r *restTestRunner) Cancel(ctx context.Context, task TestTask) error {
+	if r.config.Cancel == nil {
+		return ErrRunnerOperationUnsupported
+	}
+	response, _, err := r.request(ctx, *r.config.Cancel, r.endpointURL(task.ServerURL, *r.config.Cancel, task.TaskID, 0), nil, task.TimeoutSeconds)
+	if err != nil {
+		return err
+	}
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code: %d, body: %s", response.StatusCode, response.Body)
+	}
+	return nil
+}
+
+func (r *restTestRunner) Logs(ctx context.Context, task TestTask, offset int64) (*TaskLogs, error) {
+	if r.config.Logs == nil {
+		return nil, ErrRunnerOperationUnsupported
+	}
+	response, data, err := r.request(ctx, *r.config.Logs, r.endpointURL(task.ServerURL, *r.config.Logs, task.TaskID, offset), nil, task.TimeoutSeconds)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != 200 {
+		return nil, fmt.Errorf("unexpected status code: %d, body: %s", response.StatusCode, response.Body)
+	}
+
+	if r.config.LogContentPath == "." {
+		return &TaskLogs{Content: response.Body, NextOffset: offset + int64(len(response.Body))}, nil
+	}
+	if data == nil {
+		return nil, fmt.Errorf("failed to parse logs response")
+	}
+	logs := &TaskLogs{Content: fieldPathString(data, r.config.LogContentPath)}
+	logs.NextOffset = offset + int64(len(logs.Content))
+	if value, ok := lookupFieldPath(data, r.config.LogNextOffsetPath); ok {
+		if next, ok := value.(float64); ok {
+			logs.NextOffset = int64(next)
+		}
+	}
+	if value, ok := lookupFieldPath(data, r.config.LogCompletePath); ok {
+		logs.Complete, _ = value.(bool)
+	}
+	return logs, nil
+}
+
+// lookupFieldPath 按字段路径读取 JSON 值
+func lookupFieldPath(data interface{}, fieldPath string) (interface{}, bool) {
+	current := data
+	for _, key := range strings.Split(fieldPath, ".") {
+		switch node := current.(type) {
+		case map[string]interface{}:
+			value, ok := node[key]
+			if !ok {
+				return nil, false
+			}
+			current = value
+		case []interface{}:
+			index, err := strconv.Atoi(key)
+			if err != nil || index < 0 || index >= len(node) {
+				return nil, false
+			}
+			current = node[index]
+		default:
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// fieldPathString 按字段路径读取值并转换为字符串，不存在或为 null 时返回空字符串
+func fieldPathString(data interface{}, fieldPath string) string {
+	value, ok := lookupFieldPath(data, fieldPath)
+	if !ok || value == nil {
+		return ""
+	}
+	switch v := value.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// setFieldPath 按字段路径写入值，中间的对象按需创建
+func setFieldPath(data map[string]interface{}, fieldPath string, value interface{}) {
+	keys := strings.Split(fieldPath, ".")
+	current := data
+	for _, key := range keys[:len(keys)-1] {
+		next, ok := current[key].(map[string]interface{})
+		if !ok {
+			next = make(map[string]interface{})
+			current[key] = next
+		}
+		current = next
+	}
+	current[keys[len(keys)-1]] = value
+}
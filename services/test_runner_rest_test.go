@@ -0,0 +1,97 @@
+package services
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func decodeJSON(t *testing.T, raw string) interface{} {
+	t.Helper()
+	var data interface{}
+	if err := json.Unmarshal([]byte(raw), &data); err != nil {
+		t.Fatalf("json.Unmarshal(%s) error: %v", raw, err)
+	}
+	return data
+}
+
+func TestLookupFieldPath(t *testing.T) {
+	data := decodeJSON(t, `{
+		"id": 42,
+		"status": "done",
+		"result": {"passed": true, "cases": [{"name": "login"}, {"name": "logout"}], "empty": null}
+	}`)
+	tests := []struct {
+		path   string
+		want   interface{}
+		wantOK bool
+	}{
+		{"id", float64(42), true},
+		{"status", "done", true},
+		{"result.passed", true, true},
+		{"result.cases.1.name", "logout", true},
+		{"result.empty", nil, true},
+		{"missing", nil, false},
+		{"result.missing", nil, false},
+		{"result.cases.2.name", nil, false},
+		{"result.cases.-1", nil, false},
+		{"result.cases.first", nil, false},
+		{"status.value", nil, false},
+	}
+	for _, tt := range tests {
+		got, ok := lookupFieldPath(data, tt.path)
+		if ok != tt.wantOK || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("lookupFieldPath(%q) = %v, %v, want %v, %v", tt.path, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestFieldPathString(t *testing.T) {
+	data := decodeJSON(t, `{"id": 42, "ratio": 0.5, "big": 12345678901, "name": "run", "ok": false, "none": null}`)
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"id", "42"},
+		{"ratio", "0.5"},
+		{"big", "12345678901"},
+		{"name", "run"},
+		{"ok", "false"},
+		{"none", ""},
+		{"missing", ""},
+	}
+	for _, tt := range tests {
+		if got := fieldPathString(data, tt.path); got != tt.want {
+			t.Errorf("fieldPathString(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestSetFieldPath(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  string
+		path  string
+		value interface{}
+		want  string
+	}{
+		{"top level", `{}`, "package_url", "http://pkg/a.tgz", `{"package_url":"http://pkg/a.tgz"}`},
+		{"creates objects", `{}`, "deploy.package.url", "u", `{"deploy":{"package":{"url":"u"}}}`},
+		{"keeps siblings", `{"deploy":{"env":"qa"}}`, "deploy.version", "1.0", `{"deploy":{"env":"qa","version":"1.0"}}`},
+		{"overwrites value", `{"retries":1}`, "retries", 3, `{"retries":3}`},
+		{"replaces scalar parent", `{"deploy":"x"}`, "deploy.url", "u", `{"deploy":{"url":"u"}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := decodeJSON(t, tt.data).(map[string]interface{})
+			setFieldPath(data, tt.path, tt.value)
+			got, err := json.Marshal(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("setFieldPath(%q) = %s, want %s", tt.path, got, tt.want)
+			}
+		})
+	}
+}
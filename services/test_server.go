@@ -0,0 +1,363 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"crat/config"
+	"crat/models"
+
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrNoTestServerAvailable 没有启用的测试服务器具备测试项要求的标签
+	ErrNoTestServerAvailable = errors.New("no enabled test server matches the required labels")
+	// ErrTestServerBusy 等待空闲测试服务器超时
+	ErrTestServerBusy = errors.New("timed out waiting for a free test server")
+	// ErrTestServerInUse 测试服务器上仍有执行中的测试
+	ErrTestServerInUse = errors.New("test server has active runs")
+)
+
+const (
+	// testServerWaitInterval 没有空闲服务器时重新分配的间隔
+	testServerWaitInterval = 15 * time.Second
+	// defaultTestServerWait 等待空闲服务器的默认时长，可通过系统设置 test_server_wait_minutes 调整
+	defaultTestServerWait = 60 * time.Minute
+	// defaultTestServerHealthInterval 健康检查的默认间隔，可通过系统设置 test_server_health_interval_seconds 调整
+	defaultTestServerHealthInterval = 60 * time.Second
+)
+
+// testServerMutex 串行化测试服务器分配，所有 DeployTestService 实例共享
+var testServerMutex sync.Mutex
+
+// healthCheckOnce 保证健康检查只启动一次
+var healthCheckOnce sync.Once
+
+// TestServerService 测试服务器池
+type TestServerService struct {
+	systemUtils *SystemUtils
+}
+
+func NewTestServerService() *TestServerService {
+	return &TestServerService{
+		systemUtils: NewSystemUtils(),
+	}
+}
+
+// TestServerStatus 测试服务器及其当前负载
+type TestServerStatus struct {
+	models.TestServer
+	ActiveRuns int64 `json:"active_runs"`
+}
+
+// assignedTestServer 分配给运行的测试服务器
+type assignedTestServer struct {
+	ID           *uint  // 未配置服务器池时为空
+	URL          string // 以 / 结尾
+	TransferMode string // 为空时使用系统设置
+}
+
+// NormalizeTestServerURL 统一测试服务器地址格式，去掉末尾的 /
+func NormalizeTestServerURL(serverURL string) string {
+	return strings.TrimSuffix(strings.TrimSpace(serverURL), "/")
+}
+
+// ValidateTestServer 校验测试服务器配置
+func ValidateTestServer(server *models.TestServer) error {
+	if strings.TrimSpace(server.Name) == "" {
+		return fmt.Errorf("name is required")
+	}
+	parsed, err := url.Parse(server.URL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("url must be an absolute http or https URL")
+	}
+	if server.Capacity < 1 {
+		return fmt.Errorf("capacity must be at least 1")
+	}
+	if err := ValidateTransferMode(server.TransferMode); err != nil {
+		return err
+	}
+	if _, err := server.GetLabels(); err != nil {
+		return fmt.Errorf("labels must be a list of strings: %v", err)
+	}
+	return nil
+}
+
+// ValidateTestServerLabels 校验测试项要求的服务器标签，供创建和更新测试项时使用
+func ValidateTestServerLabels(raw json.RawMessage) error {
+	testItem := models.TestItem{TestServerLabels: raw}
+	if _, err := testItem.GetTestServerLabels(); err != nil {
+		return fmt.Errorf("test_server_labels must be a list of strings: %v", err)
+	}
+	return nil
+}
+
+// hasLabels 判断服务器是否具备全部要求的标签
+func hasLabels(server *models.TestServer, required []string) bool {
+	labels, err := server.GetLabels()
+	if err != nil {
+		log.Printf("Invalid labels for test server %s: %v", server.Name, err)
+		return len(required) == 0
+	}
+	set := make(map[string]bool, len(labels))
+	for _, label := range labels {
+		set[label] = true
+	}
+	for _, label := range required {
+		if !set[label] {
+			return false
+		}
+	}
+	return true
+}
+
+// activeRunCounts 统计各测试服务器上执行中的运行数，excludeRunID 不计入
+func activeRunCounts(excludeRunID uint) (map[uint]int64, error) {
+	var rows []struct {
+		TestServerID uint
+		Count        int64
+	}
+	if err := config.DB.Model(&models.DeployTestRun{}).
+		Select("test_server_id, COUNT(*) AS count").
+		Where("test_server_id IS NOT NULL AND status IN ? AND id <> ?", models.ActiveDeployTestStatuses, excludeRunID).
+		Group("test_server_id").
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	counts := make(map[uint]int64, len(rows))
+	for _, row := range rows {
+		counts[row.TestServerID] = row.Count
+	}
+	return counts, nil
+}
+
+// List 获取所有测试服务器及其负载
+func (t *TestServerService) List() ([]TestServerStatus, error) {
+	var servers []models.TestServer
+	if err := config.DB.Order("name ASC").Find(&servers).Error; err != nil {
+		return nil, err
+	}
+	counts, err := activeRunCounts(0)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]TestServerStatus, len(servers))
+	for i, server := range servers {
+		result[i] = TestServerStatus{TestServer: server, ActiveRuns: counts[server.ID]}
+	}
+	return result, nil
+}
+
+// selectTestServer 为运行选择服务器并记录到运行，调用方需持有 testServerMutex
+//
+// 在具备标签、可调度且有空闲容量的服务器中选择负载比例最低的。
+// 返回的 matched 为具备标签且已启用的服务器数，pool 为服务器池是否已配置。
+func selectTestServer(runID uint, required []string) (server *models.TestServer, matched int, pool bool, err error) {
+	var servers []models.TestServer
+	if err := config.DB.Order("id ASC").Find(&servers).Error; err != nil {
+		return nil, 0, false, fmt.Errorf("failed to load test servers: %v", err)
+	}
+	if len(servers) == 0 {
+		return nil, 0, false, nil
+	}
+
+	counts, err := activeRunCounts(runID)
+	if err != nil {
+		return nil, 0, true, fmt.Errorf("failed to count active runs: %v", err)
+	}
+
+	var candidates []models.TestServer
+	for i := range servers {
+		if !servers[i].Enabled || !hasLabels(&servers[i], required) {
+			continue
+		}
+		matched++
+		if servers[i].Schedulable() && counts[servers[i].ID] < int64(servers[i].Capacity) {
+			candidates = append(candidates, servers[i])
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, matched, true, nil
+	}
+
+	load := func(s *models.TestServer) float64 {
+		return float64(counts[s.ID]) / float64(s.Capacity)
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return load(&candidates[i]) < load(&candidates[j])
+	})
+	server = &candidates[0]
+
+	if err := config.DB.Model(&models.DeployTestRun{}).Where("id = ?", runID).Updates(map[string]interface{}{
+		"test_server_id":  server.ID,
+		"test_server_url": server.URL,
+	}).Error; err != nil {
+		return nil, matched, true, fmt.Errorf("failed to record test server: %v", err)
+	}
+	return server, matched, true, nil
+}
+
+// acquireTestServer 为运行分配测试服务器，没有空闲服务器时等待
+//
+// 未配置服务器池时使用系统设置 external_test_server_url。
+func (s *DeployTestService) acquireTestServer(ctx context.Context, runID uint, testItem *models.TestItem, settings map[string]string) (*assignedTestServer, error) {
+	required, err := testItem.GetTestServerLabels()
+	if err != nil {
+		return nil, fmt.Errorf("invalid test_server_labels: %v", err)
+	}
+
+	wait := defaultTestServerWait
+	if minutes, err := strconv.Atoi(settings["test_server_wait_minutes"]); err == nil && minutes >= 0 {
+		wait = time.Duration(minutes) * time.Minute
+	}
+	deadline := time.Now().Add(wait)
+
+	for waiting := false; ; waiting = true {
+		testServerMutex.Lock()
+		server, matched, pool, err := selectTestServer(runID, required)
+		testServerMutex.Unlock()
+		if err != nil {
+			return nil, err
+		}
+
+		if !pool {
+			serverURL := testServerURL(settings)
+			config.DB.Model(&models.DeployTestRun{}).Where("id = ?", runID).Updates(map[string]interface{}{
+				"test_server_id":  nil,
+				"test_server_url": NormalizeTestServerURL(serverURL),
+			})
+			return &assignedTestServer{URL: serverURL}, nil
+		}
+		if server != nil {
+			if waiting {
+				log.Printf("Test server %s assigned to run ID %d after waiting", server.Name, runID)
+			}
+			return &assignedTestServer{
+				ID:           &server.ID,
+				URL:          NormalizeTestServerURL(server.URL) + "/",
+				TransferMode: server.TransferMode,
+			}, nil
+		}
+		if matched == 0 {
+			return nil, fmt.Errorf("%w: %s", ErrNoTestServerAvailable, strings.Join(required, ", "))
+		}
+
+		if time.Now().After(deadline) {
+			return nil, classifyError(models.FailureClassTestServerUnavailable, ErrTestServerBusy)
+		}
+		if !waiting {
+			s.addStep(runID, models.StepTest, "RUNNING", fmt.Sprintf("Waiting for a free test server (%d matching)", matched), "")
+		}
+		if err := sleepWithContext(ctx, testServerWaitInterval); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// runTestServerURL 获取运行使用的测试服务器地址，以 / 结尾
+func runTestServerURL(run *models.DeployTestRun, settings map[string]string) string {
+	if run.TestServerURL != "" {
+		return NormalizeTestServerURL(run.TestServerURL) + "/"
+	}
+	return testServerURL(settings)
+}
+
+// markTestServerUnhealthy 提交失败时把服务器标记为不健康，由健康检查恢复
+func markTestServerUnhealthy(serverID uint, reason string) {
+	now := time.Now()
+	config.DB.Model(&models.TestServer{}).Where("id = ?", serverID).Updates(map[string]interface{}{
+		"healthy":    false,
+		"last_error": reason,
+		"checked_at": &now,
+	})
+}
+
+// StartHealthChecks 启动测试服务器的定期健康检查
+func (t *TestServerService) StartHealthChecks() {
+	healthCheckOnce.Do(func() {
+		go func() {
+			for {
+				interval := defaultTestServerHealthInterval
+				if settings, err := t.systemUtils.GetSystemSettings(); err == nil {
+					if seconds, err := strconv.Atoi(settings["test_server_health_interval_seconds"]); err == nil && seconds > 0 {
+						interval = time.Duration(seconds) * time.Second
+					}
+				}
+				t.CheckAll()
+				time.Sleep(interval)
+			}
+		}()
+	})
+}
+
+// CheckAll 检查所有启用的测试服务器，响应状态码小于 500 视为健康
+func (t *TestServerService) CheckAll() {
+	var servers []models.TestServer
+	if err := config.DB.Where("enabled = ?", true).Find(&servers).Error; err != nil {
+		log.Printf("Failed to load test servers for health check: %v", err)
+		return
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	for _, server := range servers {
+		checkURL := NormalizeTestServerURL(server.URL) + "/" + strings.TrimPrefix(server.HealthPath, "/")
+		lastError := ""
+		resp, err := client.Get(checkURL)
+		if err != nil {
+			lastError = err.Error()
+		} else {
+			resp.Body.Close()
+			if resp.StatusCode >= 500 {
+				lastError = fmt.Sprintf("health check returned status %d", resp.StatusCode)
+			}
+		}
+
+		healthy := lastError == ""
+		if healthy && !server.Healthy {
+			log.Printf("Test server %s recovered", server.Name)
+		} else if !healthy && server.Healthy {
+			log.Printf("Test server %s is unhealthy: %s", server.Name, lastError)
+		}
+		now := time.Now()
+		config.DB.Model(&models.TestServer{}).Where("id = ?", server.ID).Updates(map[string]interface{}{
+			"healthy":    healthy,
+			"last_error": lastError,
+			"checked_at": &now,
+		})
+	}
+}
+
+// Delete 删除测试服务器，服务器上仍有执行中的测试时返回 ErrTestServerInUse
+func (t *TestServerService) Delete(id uint) error {
+	testServerMutex.Lock()
+	defer testServerMutex.Unlock()
+
+	var active int64
+	if err := config.DB.Model(&models.DeployTestRun{}).
+		Where("test_server_id = ? AND status IN ?", id, models.ActiveDeployTestStatuses).
+		Count(&active).Error; err != nil {
+		return err
+	}
+	if active > 0 {
+		return ErrTestServerInUse
+	}
+	result := config.DB.Delete(&models.TestServer{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
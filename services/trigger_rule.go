@@ -0,0 +1,435 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"crat/config"
+	"crat/models"
+
+	"gorm.io/gorm"
+)
+
+// MaxTriggerDebounceSeconds 自动触发规则允许的最长防抖时长
+const MaxTriggerDebounceSeconds = 24 * 60 * 60
+
+// ErrTriggerRuleNotFound 自动触发规则不存在
+var ErrTriggerRuleNotFound = errors.New("trigger rule not found")
+
+// triggerRuleMutex 串行化规则的防抖状态更新，triggerTimers 为各规则等待中的防抖定时器
+var (
+	triggerRuleMutex sync.Mutex
+	triggerTimers    = make(map[uint]*time.Timer)
+)
+
+// TriggerRuleService 构建到达时按规则自动触发部署测试
+type TriggerRuleService struct {
+	deployTestService *DeployTestService
+}
+
+func NewTriggerRuleService() *TriggerRuleService {
+	return &TriggerRuleService{
+		deployTestService: NewDeployTestService(),
+	}
+}
+
+// compiledTriggerRule 编译后的匹配条件
+type compiledTriggerRule struct {
+	rawData     map[string]*regexp.Regexp
+	packagePath *regexp.Regexp
+}
+
+// compileTriggerRule 编译规则中的正则
+func compileTriggerRule(rule *models.TriggerRule) (*compiledTriggerRule, error) {
+	compiled := &compiledTriggerRule{rawData: make(map[string]*regexp.Regexp)}
+
+	match, err := rule.GetRawDataMatch()
+	if err != nil {
+		return nil, fmt.Errorf("raw_data_match must be an object of field patterns: %v", err)
+	}
+	for field, pattern := range match {
+		if strings.TrimSpace(field) == "" {
+			return nil, fmt.Errorf("raw_data_match field name is required")
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid raw_data_match pattern for %s: %v", field, err)
+		}
+		compiled.rawData[field] = re
+	}
+
+	if rule.PackagePathPattern != "" {
+		re, err := regexp.Compile(rule.PackagePathPattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid package_path_pattern: %v", err)
+		}
+		compiled.packagePath = re
+	}
+	return compiled, nil
+}
+
+// ValidateTriggerRule 校验自动触发规则，测试项和参数集必须存在
+func ValidateTriggerRule(rule *models.TriggerRule) error {
+	if strings.TrimSpace(rule.Name) == "" {
+		return fmt.Errorf("name is required")
+	}
+	compiled, err := compileTriggerRule(rule)
+	if err != nil {
+		return err
+	}
+	if rule.JobName == "" && len(compiled.rawData) == 0 && compiled.packagePath == nil {
+		return fmt.Errorf("at least one of job_name, raw_data_match or package_path_pattern is required")
+	}
+
+	testItemIDs, err := rule.GetTestItemIDs()
+	if err != nil {
+		return fmt.Errorf("test_item_ids must be a list of IDs: %v", err)
+	}
+	parameterSetIDs, err := rule.GetParameterSetIDs()
+	if err != nil {
+		return fmt.Errorf("parameter_set_ids must be a list of IDs: %v", err)
+	}
+	testItemIDs, parameterSetIDs = uniqueIDs(testItemIDs), uniqueIDs(parameterSetIDs)
+	if len(testItemIDs) == 0 {
+		return fmt.Errorf("test_item_ids is required")
+	}
+	if size := len(testItemIDs) * max(len(parameterSetIDs), 1); size > MaxRunGroupSize {
+		return fmt.Errorf("rule triggers %d runs, at most %d allowed", size, MaxRunGroupSize)
+	}
+
+	var count int64
+	config.DB.Model(&models.TestItem{}).Where("id IN ?", testItemIDs).Count(&count)
+	if int(count) != len(testItemIDs) {
+		return fmt.Errorf("some test items do not exist")
+	}
+	if len(parameterSetIDs) > 0 {
+		config.DB.Model(&models.ParameterSet{}).Where("id IN ?", parameterSetIDs).Count(&count)
+		if int(count) != len(parameterSetIDs) {
+			return fmt.Errorf("some parameter sets do not exist")
+		}
+	}
+
+	if rule.Priority < 0 || rule.Priority > MaxQueuePriority {
+		return fmt.Errorf("priority must be between 0 and %d", MaxQueuePriority)
+	}
+	if rule.Owner != "" && !isEmailAddress(rule.Owner) {
+		return fmt.Errorf("owner must be an email address")
+	}
+	if rule.DebounceSeconds < 0 || rule.DebounceSeconds > MaxTriggerDebounceSeconds {
+		return fmt.Errorf("debounce_seconds must be between 0 and %d", MaxTriggerDebounceSeconds)
+	}
+	return nil
+}
+
+// rawDataString 把原始数据中的字段值转换为字符串，数字不带多余的小数位
+func rawDataString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case nil:
+		return ""
+	default:
+		data, _ := json.Marshal(v)
+		return string(data)
+	}
+}
+
+// matchesBuild 判断构建是否满足规则的全部条件
+func (c *compiledTriggerRule) matchesBuild(rule *models.TriggerRule, build *models.BuildInfo, rawData map[string]interface{}) bool {
+	if rule.JobName != "" && rule.JobName != build.JobName {
+		return false
+	}
+	if c.packagePath != nil && !c.packagePath.MatchString(build.PackagePath) {
+		return false
+	}
+	for field, re := range c.rawData {
+		value, ok := rawData[field]
+		if !ok || !re.MatchString(rawDataString(value)) {
+			return false
+		}
+	}
+	return true
+}
+
+// triggerRuleOwner 规则触发的运行归属，必须是邮箱地址，用于发送通知和取消运行的权限检查
+//
+// 依次使用配置的负责人、原始数据中的 BUILD_USER_EMAIL、本身是邮箱的 BUILD_USER，都不可用时为规则创建人。
+func triggerRuleOwner(rule *models.TriggerRule, build *models.BuildInfo, rawData map[string]interface{}) string {
+	candidates := []string{rule.Owner, rawDataString(rawData["BUILD_USER_EMAIL"]), build.BuildUser}
+	for _, candidate := range candidates {
+		if candidate = strings.TrimSpace(candidate); isEmailAddress(candidate) {
+			return candidate
+		}
+	}
+	return rule.CreatedBy
+}
+
+// buildRawData 解析构建的 Webhook 原始数据
+func buildRawData(build *models.BuildInfo) map[string]interface{} {
+	var rawData map[string]interface{}
+	if len(build.RawData) > 0 {
+		if err := json.Unmarshal(build.RawData, &rawData); err != nil {
+			log.Printf("Invalid raw data for build %d: %v", build.ID, err)
+		}
+	}
+	return rawData
+}
+
+// HandleBuild 新构建到达时匹配启用的规则，返回匹配的规则名称
+//
+// 防抖时长内同一规则匹配的新构建替换等待中的构建并重新计时，计时结束后触发最后一个构建。
+func (t *TriggerRuleService) HandleBuild(build *models.BuildInfo) []string {
+	var rules []models.TriggerRule
+	if err := config.DB.Where("enabled = ?", true).Order("id ASC").Find(&rules).Error; err != nil {
+		log.Printf("Failed to load trigger rules for build %d: %v", build.ID, err)
+		return nil
+	}
+	if len(rules) == 0 {
+		return nil
+	}
+
+	rawData := buildRawData(build)
+
+	var matched []string
+	for i := range rules {
+		rule := &rules[i]
+		compiled, err := compileTriggerRule(rule)
+		if err != nil {
+			log.Printf("Invalid trigger rule %s: %v", rule.Name, err)
+			continue
+		}
+		if !compiled.matchesBuild(rule, build, rawData) {
+			continue
+		}
+		matched = append(matched, rule.Name)
+		t.schedule(rule.ID, build.ID, time.Duration(rule.DebounceSeconds)*time.Second)
+	}
+
+	if len(matched) > 0 {
+		log.Printf("Build %s #%d matched trigger rule(s): %s", build.JobName, build.BuildNumber, strings.Join(matched, ", "))
+	}
+	return matched
+}
+
+// schedule 记录规则等待中的构建，并在防抖时长后触发
+func (t *TriggerRuleService) schedule(ruleID, buildInfoID uint, debounce time.Duration) {
+	triggerRuleMutex.Lock()
+	defer triggerRuleMutex.Unlock()
+
+	fireAt := time.Now().Add(debounce)
+	if err := config.DB.Model(&models.TriggerRule{}).Where("id = ?", ruleID).Updates(map[string]interface{}{
+		"pending_build_info_id": buildInfoID,
+		"pending_fire_at":       &fireAt,
+	}).Error; err != nil {
+		log.Printf("Failed to record pending build %d for trigger rule %d: %v", buildInfoID, ruleID, err)
+		return
+	}
+	t.startTimerLocked(ruleID, debounce)
+}
+
+// startTimerLocked 为规则重新设置防抖定时器，调用方需持有 triggerRuleMutex
+func (t *TriggerRuleService) startTimerLocked(ruleID uint, delay time.Duration) {
+	if timer, ok := triggerTimers[ruleID]; ok {
+		timer.Stop()
+	}
+	triggerTimers[ruleID] = time.AfterFunc(delay, func() { t.fire(ruleID) })
+}
+
+// stopTriggerTimer 取消规则等待中的防抖定时器
+func stopTriggerTimer(ruleID uint) {
+	triggerRuleMutex.Lock()
+	defer triggerRuleMutex.Unlock()
+	stopTriggerTimerLocked(ruleID)
+}
+
+// stopTriggerTimerLocked 取消规则等待中的防抖定时器，调用方需持有 triggerRuleMutex
+func stopTriggerTimerLocked(ruleID uint) {
+	if timer, ok := triggerTimers[ruleID]; ok {
+		timer.Stop()
+		delete(triggerTimers, ruleID)
+	}
+}
+
+// fire 防抖计时结束，为等待中的构建触发规则配置的运行组
+func (t *TriggerRuleService) fire(ruleID uint) {
+	triggerRuleMutex.Lock()
+	var rule models.TriggerRule
+	if err := config.DB.First(&rule, ruleID).Error; err != nil {
+		delete(triggerTimers, ruleID)
+		triggerRuleMutex.Unlock()
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Printf("Failed to load trigger rule %d: %v", ruleID, err)
+		}
+		return
+	}
+	// 定时器停止前已开始执行时，新的构建会推迟触发时间
+	if rule.PendingBuildInfoID == nil || rule.PendingFireAt == nil || rule.PendingFireAt.After(time.Now()) {
+		triggerRuleMutex.Unlock()
+		return
+	}
+	delete(triggerTimers, ruleID)
+	buildInfoID := *rule.PendingBuildInfoID
+	config.DB.Model(&models.TriggerRule{}).Where("id = ?", ruleID).Updates(map[string]interface{}{
+		"pending_build_info_id": nil,
+		"pending_fire_at":       nil,
+	})
+	triggerRuleMutex.Unlock()
+
+	if !rule.Enabled {
+		return
+	}
+	t.trigger(&rule, buildInfoID)
+}
+
+// trigger 按规则为构建创建运行组，并记录触发结果
+func (t *TriggerRuleService) trigger(rule *models.TriggerRule, buildInfoID uint) {
+	now := time.Now()
+	updates := map[string]interface{}{
+		"last_build_info_id": buildInfoID,
+		"last_triggered_at":  &now,
+		"last_error":         "",
+	}
+	defer func() {
+		config.DB.Model(&models.TriggerRule{}).Where("id = ?", rule.ID).Updates(updates)
+	}()
+
+	build, err := t.deployTestService.buildService.GetBuildInfoByID(buildInfoID)
+	if err != nil {
+		updates["last_error"] = fmt.Sprintf("build info %d not found", buildInfoID)
+		log.Printf("Trigger rule %s: build info %d not found", rule.Name, buildInfoID)
+		return
+	}
+	testItemIDs, _ := rule.GetTestItemIDs()
+	parameterSetIDs, _ := rule.GetParameterSetIDs()
+	owner := triggerRuleOwner(rule, build, buildRawData(build))
+
+	result, err := t.deployTestService.TriggerRunGroup(&RunGroupRequest{
+		BuildInfoID:         buildInfoID,
+		TestItemIDs:         testItemIDs,
+		ParameterSetIDs:     parameterSetIDs,
+		TriggeredBy:         owner,
+		Priority:            rule.Priority,
+		NotificationEnabled: rule.NotificationEnabled,
+		TriggerRuleID:       &rule.ID,
+	})
+	if err != nil {
+		updates["last_error"] = err.Error()
+		log.Printf("Trigger rule %s failed for build %s #%d: %v", rule.Name, build.JobName, build.BuildNumber, err)
+		return
+	}
+	updates["last_run_group_id"] = result.Group.ID
+	log.Printf("Trigger rule %s triggered run group %d for build %s #%d on behalf of %s",
+		rule.Name, result.Group.ID, build.JobName, build.BuildNumber, owner)
+}
+
+// RecoverPendingTriggers 服务启动时恢复重启前等待中的防抖触发，已过期的立即触发
+func (t *TriggerRuleService) RecoverPendingTriggers() {
+	var rules []models.TriggerRule
+	if err := config.DB.Where("pending_build_info_id IS NOT NULL").Find(&rules).Error; err != nil {
+		log.Printf("Failed to load pending trigger rules: %v", err)
+		return
+	}
+
+	triggerRuleMutex.Lock()
+	defer triggerRuleMutex.Unlock()
+	for _, rule := range rules {
+		delay := time.Duration(0)
+		if rule.PendingFireAt != nil {
+			delay = max(time.Until(*rule.PendingFireAt), 0)
+		}
+		log.Printf("Recovering pending trigger of rule %s for build %d", rule.Name, *rule.PendingBuildInfoID)
+		t.startTimerLocked(rule.ID, delay)
+	}
+}
+
+// GetTriggerRules 获取所有自动触发规则
+func (t *TriggerRuleService) GetTriggerRules() ([]models.TriggerRule, error) {
+	var rules []models.TriggerRule
+	err := config.DB.Order("name ASC").Find(&rules).Error
+	return rules, err
+}
+
+// GetTriggerRuleByID 获取自动触发规则
+func (t *TriggerRuleService) GetTriggerRuleByID(id uint) (*models.TriggerRule, error) {
+	var rule models.TriggerRule
+	if err := config.DB.First(&rule, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrTriggerRuleNotFound
+		}
+		return nil, err
+	}
+	return &rule, nil
+}
+
+// triggerRuleConfigColumns 管理员可修改的规则字段，防抖状态和最近一次触发结果由调度维护
+var triggerRuleConfigColumns = []string{
+	"name", "enabled", "job_name", "raw_data_match", "package_path_pattern", "test_item_ids",
+	"parameter_set_ids", "priority", "notification_enabled", "debounce_seconds", "owner", "updated_at",
+}
+
+// SaveTriggerRule 保存自动触发规则的配置，停用的规则丢弃等待中的构建，修改防抖时长时重新计时
+//
+// 持有 triggerRuleMutex 并只更新配置字段，避免覆盖同时进行的防抖登记和触发结果。
+func (t *TriggerRuleService) SaveTriggerRule(rule *models.TriggerRule) error {
+	triggerRuleMutex.Lock()
+	defer triggerRuleMutex.Unlock()
+
+	if rule.ID == 0 {
+		return config.DB.Create(rule).Error
+	}
+
+	var current models.TriggerRule
+	if err := config.DB.First(&current, rule.ID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrTriggerRuleNotFound
+		}
+		return err
+	}
+	if err := config.DB.Model(&models.TriggerRule{}).Where("id = ?", rule.ID).
+		Select(triggerRuleConfigColumns).Updates(rule).Error; err != nil {
+		return err
+	}
+
+	switch {
+	case !rule.Enabled:
+		stopTriggerTimerLocked(rule.ID)
+		if err := config.DB.Model(&models.TriggerRule{}).Where("id = ?", rule.ID).Updates(map[string]interface{}{
+			"pending_build_info_id": nil,
+			"pending_fire_at":       nil,
+		}).Error; err != nil {
+			return err
+		}
+	case current.PendingBuildInfoID != nil && current.DebounceSeconds != rule.DebounceSeconds:
+		debounce := time.Duration(rule.DebounceSeconds) * time.Second
+		fireAt := time.Now().Add(debounce)
+		if err := config.DB.Model(&models.TriggerRule{}).Where("id = ?", rule.ID).
+			Update("pending_fire_at", &fireAt).Error; err != nil {
+			return err
+		}
+		t.startTimerLocked(rule.ID, debounce)
+	}
+
+	return config.DB.First(rule, rule.ID).Error
+}
+
+// DeleteTriggerRule 删除自动触发规则，已触发的运行组不受影响
+func (t *TriggerRuleService) DeleteTriggerRule(id uint) error {
+	result := config.DB.Delete(&models.TriggerRule{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrTriggerRuleNotFound
+	}
+	stopTriggerTimer(id)
+	return nil
+}